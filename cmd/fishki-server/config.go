@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/timhughes/fishki/internal/config"
+)
+
+// serverFlags maps the server's command line flags to the settings they
+// override
+var serverFlags = []struct {
+	name, key, usage string
+}{
+	{"bind", "server.bind", "Bind address"},
+	{"port", "server.port", "Port to listen on"},
+	{"tls-cert", "server.tls.certFile", "TLS certificate file, to serve HTTPS"},
+	{"tls-key", "server.tls.keyFile", "TLS key file, to serve HTTPS"},
+	{"read-only", "readOnly", "Let anyone read the wiki while only editors can change it"},
+}
+
+// addServerFlags adds the flags that override config settings to fs. The
+// returned function loads the config with the flags that were given
+func addServerFlags(fs *flag.FlagSet) func() (*config.Config, config.Sources, error) {
+	defaults := config.Defaults()
+	path := fs.String("config", "", "Config file, in JSON, YAML or TOML (default: the user's config directory)")
+	for _, f := range serverFlags {
+		switch f.key {
+		case "server.bind":
+			fs.String(f.name, defaults.Server.Bind, f.usage)
+		case "server.port":
+			fs.Int(f.name, defaults.Server.Port, f.usage)
+		case "readOnly":
+			fs.Bool(f.name, false, f.usage)
+		default:
+			fs.String(f.name, "", f.usage)
+		}
+	}
+
+	return func() (*config.Config, config.Sources, error) {
+		if *path != "" {
+			config.SetPath(*path)
+		}
+		given := map[string]string{}
+		fs.Visit(func(f *flag.Flag) { given[f.Name] = f.Value.String() })
+		var overrides []config.Override
+		for _, f := range serverFlags {
+			if value, ok := given[f.name]; ok {
+				overrides = append(overrides, config.Override{Key: f.key, Value: value, Source: "flag -" + f.name})
+			}
+		}
+		cfg, sources, err := config.Load(overrides...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load config: %v", err)
+		}
+		return cfg, sources, nil
+	}
+}
+
+// runConfig implements `fishki-server config`
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintf(os.Stderr, "Usage: fishki-server config print [flags]\n")
+		return fmt.Errorf("unknown config command")
+	}
+	return runConfigPrint(args[1:])
+}
+
+// runConfigPrint shows the effective config and where each setting came
+// from. It takes the server's flags, so it shows what the server would run
+// with
+func runConfigPrint(args []string) error {
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	load := addServerFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: fishki-server config print [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, sources, err := load()
+	if err != nil {
+		return err
+	}
+	configPath, err := config.GetConfigPath()
+	if err != nil {
+		return fmt.Errorf("failed to get config path: %v", err)
+	}
+
+	fmt.Fprintf(os.Stdout, "# Config file: %s\n", configPath)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE\tENVIRONMENT")
+	for _, s := range config.Settings(cfg, sources) {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Key, s.Value, s.Source, s.Env)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/timhughes/fishki/internal/export"
+	"github.com/timhughes/fishki/internal/markdown"
+)
+
+// runExport implements `fishki-server export`, writing the wiki out as a
+// static site
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "html", "Export format (html)")
+	out := fs.String("out", "", "Directory to write the site to")
+	wikiPath := fs.String("wiki", "", "Wiki to export (default: the configured wiki path)")
+	incremental := fs.Bool("incremental", false, "Only re-render pages changed since the last export")
+	load := addServerFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: fishki-server export --format=html --out=DIR [--incremental]\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *format != "html" {
+		return fmt.Errorf("unsupported export format %q, only html is supported", *format)
+	}
+	if *out == "" {
+		fs.Usage()
+		return fmt.Errorf("--out is required")
+	}
+
+	cfg, _, err := load()
+	if err != nil {
+		return err
+	}
+	if *wikiPath != "" {
+		cfg.WikiPath = *wikiPath
+	}
+	if cfg.WikiPath == "" {
+		return fmt.Errorf("wiki path not set, configure it in the web interface or pass --wiki")
+	}
+
+	result, err := export.Site(cfg, markdown.New(cfg.MarkdownOptions()), export.Options{
+		OutDir:      *out,
+		Incremental: *incremental,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "Exported %d pages to %s (%d rendered, %d unchanged), %d folder indexes, %d attachments copied, %d removed\n",
+		result.Pages, *out, result.Rendered, result.Unchanged, result.Folders, result.Attachments, result.Removed)
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/timhughes/fishki/internal/git"
+	"github.com/timhughes/fishki/internal/importer"
+)
+
+// runImport implements `fishki-server import`, bringing content from other
+// tools into the wiki
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "markdown", "Import format (markdown, obsidian, mediawiki, confluence)")
+	dest := fs.String("dest", "", "Folder in the wiki to import into (default: the wiki root)")
+	commit := fs.String("commit", string(importer.CommitSingle), "Commit mode (single, per-file)")
+	modTimes := fs.Bool("mtime", false, "Date commits with the files' modification times")
+	overwrite := fs.Bool("overwrite", false, "Replace files that already exist in the wiki")
+	wikiPath := fs.String("wiki", "", "Wiki to import into (default: the configured wiki path)")
+	load := addServerFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: fishki-server import [flags] SOURCE\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("a single source is required")
+	}
+
+	cfg, _, err := load()
+	if err != nil {
+		return err
+	}
+	if *wikiPath != "" {
+		cfg.WikiPath = *wikiPath
+	}
+	if cfg.WikiPath == "" {
+		return fmt.Errorf("wiki path not set, configure it in the web interface or pass --wiki")
+	}
+
+	opts := importer.Options{
+		Dest:        *dest,
+		Commit:      importer.CommitMode(*commit),
+		UseModTimes: *modTimes,
+		Overwrite:   *overwrite,
+	}
+	im := importer.New(cfg.WikiPath, git.New())
+
+	var report *importer.Report
+	switch *format {
+	case "markdown", "obsidian":
+		report, err = im.ImportDirectory(fs.Arg(0), opts)
+	case "mediawiki":
+		report, err = im.ImportMediaWikiFile(fs.Arg(0), opts)
+	case "confluence":
+		report, err = im.ImportConfluence(fs.Arg(0), opts)
+	default:
+		return fmt.Errorf("unsupported import format %q", *format)
+	}
+	if report != nil {
+		printReport(report)
+	}
+	return err
+}
+
+// printReport summarises an import, listing everything that was skipped
+func printReport(report *importer.Report) {
+	fmt.Fprintf(os.Stdout, "Imported %d pages and %d attachments in %d commits\n",
+		len(report.Pages), len(report.Attachments), report.Commits)
+	if len(report.Skipped) > 0 {
+		fmt.Fprintf(os.Stdout, "Skipped %d items:\n", len(report.Skipped))
+		for _, s := range report.Skipped {
+			fmt.Fprintf(os.Stdout, "  %s: %s\n", s.Path, s.Reason)
+		}
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	web "github.com/timhughes/fishki/frontend"
@@ -15,16 +17,12 @@ import (
 )
 
 // setupServer initializes and configures the HTTP server
-func setupServer() (*http.ServeMux, error, *config.Config) {
-	// Load configuration
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		return nil, fmt.Errorf("failed to load config: %v", err), nil
-	}
-
+func setupServer(cfg *config.Config) (*http.ServeMux, error) {
 	// Set up handlers
 	mux := http.NewServeMux()
-	handlers.SetupHandlers(mux, cfg)
+	if err := handlers.SetupHandlers(mux, cfg); err != nil {
+		return nil, fmt.Errorf("failed to set up handlers: %v", err)
+	}
 
 	// Serve static files from the build directory
 	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -104,22 +102,46 @@ func setupServer() (*http.ServeMux, error, *config.Config) {
 		w.Write(indexContent)
 	}))
 
-	return mux, nil, cfg
+	return mux, nil
 }
 
 func main() {
-	// Parse command line flags first, before any config loading
-	bind := flag.String("bind", "localhost", "Bind address")
-	port := flag.String("port", "8080", "Port to listen on")
-	flag.Parse()
+	// Subcommands have their own flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			if err := runExport(os.Args[2:]); err != nil {
+				log.Fatalf("Export failed: %v", err)
+			}
+			return
+		case "import":
+			if err := runImport(os.Args[2:]); err != nil {
+				log.Fatalf("Import failed: %v", err)
+			}
+			return
+		case "user":
+			if err := runUser(os.Args[2:]); err != nil {
+				log.Fatalf("User command failed: %v", err)
+			}
+			return
+		case "config":
+			if err := runConfig(os.Args[2:]); err != nil {
+				log.Fatalf("Config command failed: %v", err)
+			}
+			return
+		}
+	}
 
-	// Allow PORT env var to override flag for backward compatibility
-	if envPort := os.Getenv("PORT"); envPort != "" {
-		*port = envPort
+	// Parse command line flags first, then layer them over the config file
+	// and environment
+	load := addServerFlags(flag.CommandLine)
+	flag.Parse()
+	cfg, _, err := load()
+	if err != nil {
+		log.Fatalf("Failed to setup server: %v", err)
 	}
 
-	// Set up server after flags are parsed
-	mux, err, cfg := setupServer()
+	mux, err := setupServer(cfg)
 	if err != nil {
 		log.Fatalf("Failed to setup server: %v", err)
 	}
@@ -131,9 +153,16 @@ func main() {
 	}
 
 	// Print startup information
-	addr := fmt.Sprintf("%s:%s", *bind, *port)
-	log.Printf("Server starting on http://%s (mode: %s)", addr, os.Getenv("NODE_ENV"))
+	addr := net.JoinHostPort(cfg.Server.Bind, strconv.Itoa(cfg.Server.Port))
+	scheme := "http"
+	if cfg.Server.TLS.Enabled() {
+		scheme = "https"
+	}
+	log.Printf("Server starting on %s://%s (mode: %s)", scheme, addr, os.Getenv("NODE_ENV"))
 	log.Printf("Configuration file: %s", configPath)
+	if cfg.ReadOnly {
+		log.Printf("Read-only mode: anyone can read the wiki, only editors can change it")
+	}
 	
 	if cfg.WikiPath == "" {
 		log.Printf("Wiki path: Not configured yet. Please set up the wiki through the web interface.")
@@ -151,5 +180,8 @@ func main() {
 		}
 	}
 	
+	if cfg.Server.TLS.Enabled() {
+		log.Fatal(http.ListenAndServeTLS(addr, cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile, mux))
+	}
 	log.Fatal(http.ListenAndServe(addr, mux))
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/timhughes/fishki/internal/auth"
+	"github.com/timhughes/fishki/internal/config"
+)
+
+// runUser implements `fishki-server user`, managing local accounts
+func runUser(args []string) error {
+	if len(args) == 0 || args[0] != "add" {
+		fmt.Fprintf(os.Stderr, "Usage: fishki-server user add [flags] USERNAME\n")
+		return fmt.Errorf("unknown user command")
+	}
+	return runUserAdd(args[1:])
+}
+
+// runUserAdd creates a local account, prompting for its password
+func runUserAdd(args []string) error {
+	fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+	name := fs.String("name", "", "Display name, used as the commit author")
+	email := fs.String("email", "", "Email address, used as the commit author")
+	groups := fs.String("groups", "", "Comma-separated groups the user belongs to")
+	passwordStdin := fs.Bool("password-stdin", false, "Read the password from stdin instead of prompting")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: fishki-server user add [flags] USERNAME\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("a single username is required")
+	}
+
+	usersPath, err := config.GetUsersPath()
+	if err != nil {
+		return fmt.Errorf("failed to get users path: %v", err)
+	}
+	users, err := auth.LoadUsers(usersPath)
+	if err != nil {
+		return err
+	}
+	if _, ok := users.Get(fs.Arg(0)); ok {
+		return auth.ErrUserExists
+	}
+
+	password, err := readPassword(*passwordStdin)
+	if err != nil {
+		return err
+	}
+
+	user := auth.User{
+		Username: fs.Arg(0),
+		Name:     *name,
+		Email:    *email,
+	}
+	for _, g := range strings.Split(*groups, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			user.Groups = append(user.Groups, g)
+		}
+	}
+	if err := users.Add(user, password); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "Added user %s to %s\n", user.Username, usersPath)
+	return nil
+}
+
+// readPassword prompts for a password twice on a terminal, or reads a single
+// line when stdin is piped
+func readPassword(fromStdin bool) (string, error) {
+	if fromStdin || !term.IsTerminal(int(os.Stdin.Fd())) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read password: %v", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %v", err)
+	}
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirm, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %v", err)
+	}
+	if string(password) != string(confirm) {
+		return "", fmt.Errorf("passwords do not match")
+	}
+	return string(password), nil
+}
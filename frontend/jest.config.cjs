@@ -14,6 +14,6 @@ module.exports = {
   },
   transformIgnorePatterns: [
     // This is needed for React Router v7 and other ESM packages
-    '/node_modules/(?!(react-markdown|remark-gfm|rehype-raw|rehype-sanitize|rehype-highlight|micromark|mdast-util-.*|unist-util-.*|unified|bail|is-plain-obj|trough|vfile|vfile-message|devlop|decode-named-character-reference|character-entities|property-information|hast-util-whitespace|space-separated-tokens|comma-separated-tokens|ccount|escape-string-regexp|react-router|react-router-dom|@remix-run)/)',
+    '/node_modules/(?!(react-markdown|remark-gfm|rehype-raw|rehype-sanitize|rehype-highlight|remark-math|rehype-katex|micromark|mdast-util-.*|unist-util-.*|unified|bail|is-plain-obj|trough|vfile|vfile-message|devlop|decode-named-character-reference|character-entities|property-information|hast-util-whitespace|space-separated-tokens|comma-separated-tokens|ccount|escape-string-regexp|react-router|react-router-dom|@remix-run)/)',
   ],
 };
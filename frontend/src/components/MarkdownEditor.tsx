@@ -11,11 +11,15 @@ import IconButton from '@mui/material/IconButton';
 import Tooltip from '@mui/material/Tooltip';
 import ReactMarkdown from 'react-markdown';
 import remarkGfm from 'remark-gfm';
+import remarkMath from 'remark-math';
 import rehypeRaw from 'rehype-raw';
 import rehypeSanitize from 'rehype-sanitize';
 import rehypeHighlight from 'rehype-highlight';
+import rehypeKatex from 'rehype-katex';
+import 'katex/dist/katex.min.css';
 import 'highlight.js/styles/github.css';
 import { api } from '../api/client';
+import { remarkMathDollars } from '../utils/remarkMath';
 import { useNavigation } from '../contexts/NavigationContext';
 import { MarkdownToolbar } from './MarkdownToolbar';
 import { useMarkdownEditor } from '../hooks/useMarkdownEditor';
@@ -369,8 +373,8 @@ export const MarkdownEditor: React.FC<MarkdownEditorProps> = ({
           }}
         >
           <ReactMarkdown
-            remarkPlugins={[remarkGfm]}
-            rehypePlugins={[rehypeRaw, rehypeSanitize, rehypeHighlight]}
+            remarkPlugins={[remarkGfm, remarkMath, remarkMathDollars]}
+            rehypePlugins={[rehypeRaw, rehypeSanitize, rehypeKatex, rehypeHighlight]}
           >
             {content}
           </ReactMarkdown>
@@ -17,10 +17,14 @@ import ListItemText from '@mui/material/ListItemText';
 import MoreVertIcon from '@mui/icons-material/MoreVert';
 import ReactMarkdown from 'react-markdown';
 import remarkGfm from 'remark-gfm';
+import remarkMath from 'remark-math';
 import rehypeRaw from 'rehype-raw';
 import rehypeSanitize from 'rehype-sanitize';
 import rehypeHighlight from 'rehype-highlight';
+import rehypeKatex from 'rehype-katex';
+import 'katex/dist/katex.min.css';
 import { api } from '../api/client';
+import { remarkMathDollars } from '../utils/remarkMath';
 import { DeleteConfirmDialog } from './DeleteConfirmDialog';
 import { MoveDialog } from './MoveDialog';
 import { FileBreadcrumbs } from './Breadcrumbs';
@@ -326,8 +330,8 @@ export const MarkdownViewer: React.FC<MarkdownViewerProps> = ({
           }}
         >
           <ReactMarkdown
-            remarkPlugins={[remarkGfm]}
-            rehypePlugins={[rehypeRaw, rehypeSanitize, rehypeHighlight]}
+            remarkPlugins={[remarkGfm, remarkMath, remarkMathDollars]}
+            rehypePlugins={[rehypeRaw, rehypeSanitize, rehypeKatex, rehypeHighlight]}
           >
             {content}
           </ReactMarkdown>
@@ -39,6 +39,10 @@ jest.mock('react-markdown', () => ({
   }
 }));
 
+// Mock the math plugins, which are ESM only
+jest.mock('remark-math', () => () => null);
+jest.mock('rehype-katex', () => () => null);
+
 // Create a wrapper component that provides the necessary context
 const TestWrapper = ({ children }: { children: React.ReactNode }) => {
   return (
@@ -91,6 +91,8 @@ jest.mock('remark-gfm', () => () => null);
 jest.mock('rehype-raw', () => () => null);
 jest.mock('rehype-sanitize', () => () => null);
 jest.mock('rehype-highlight', () => () => null);
+jest.mock('remark-math', () => () => null);
+jest.mock('rehype-katex', () => () => null);
 
 describe('MarkdownViewer', () => {
   const mockOnEdit = jest.fn();
@@ -0,0 +1,87 @@
+import { remarkMathDollars } from '../remarkMath';
+
+type Node = { type: string; value?: string; position?: { start: { offset?: number }; end: { offset?: number } } };
+
+const at = (start: number, end: number) => ({ start: { offset: start }, end: { offset: end } });
+
+// text and inlineMath build the nodes remark-math gives for source[start:end]
+const text = (source: string, start: number, end: number): Node => ({
+  type: 'text',
+  value: source.slice(start, end),
+  position: at(start, end),
+});
+const inlineMath = (source: string, start: number, end: number): Node => ({
+  type: 'inlineMath',
+  value: source.slice(start + 1, end - 1),
+  position: at(start, end),
+});
+
+const transform = (source: string, children: Node[]) => {
+  const tree = { type: 'root', children: [{ type: 'paragraph', children }] };
+  remarkMathDollars()(tree, { value: source });
+  return tree.children[0].children.map(({ type, value }) => ({ type, value }));
+};
+
+describe('remarkMathDollars', () => {
+  it('keeps formulas', () => {
+    const source = 'Energy is $E = mc^2$ here';
+    const nodes = transform(source, [text(source, 0, 10), inlineMath(source, 10, 20), text(source, 20, 25)]);
+    expect(nodes).toEqual([
+      { type: 'text', value: 'Energy is ' },
+      { type: 'inlineMath', value: 'E = mc^2' },
+      { type: 'text', value: ' here' },
+    ]);
+  });
+
+  it('keeps display math', () => {
+    const source = '$$ x $$';
+    const nodes = transform(source, [{ type: 'inlineMath', value: ' x ', position: at(0, 7) }]);
+    expect(nodes[0].type).toBe('inlineMath');
+  });
+
+  it('turns prices back into text', () => {
+    const source = '$5 and $10';
+    const nodes = transform(source, [inlineMath(source, 0, 8), text(source, 8, 10)]);
+    expect(nodes).toEqual([{ type: 'text', value: '$5 and $10' }]);
+  });
+
+  it('turns dollars around spaces back into text', () => {
+    const source = 'or $ 3 $ each';
+    const nodes = transform(source, [text(source, 0, 3), inlineMath(source, 3, 8), text(source, 8, 13)]);
+    expect(nodes).toEqual([{ type: 'text', value: 'or $ 3 $ each' }]);
+  });
+
+  it('keeps a formula after prices', () => {
+    const source = 'Costs $5 and $10 today, $x_1$ here.';
+    const nodes = transform(source, [
+      text(source, 0, 6),
+      inlineMath(source, 6, 14),
+      text(source, 14, 24),
+      inlineMath(source, 24, 29),
+      text(source, 29, 35),
+    ]);
+    expect(nodes).toEqual([
+      { type: 'text', value: 'Costs $5 and $10 today, ' },
+      { type: 'inlineMath', value: 'x_1' },
+      { type: 'text', value: ' here.' },
+    ]);
+  });
+
+  it('pairs a rejected closing dollar with the next one', () => {
+    const source = '$x $y$';
+    const nodes = transform(source, [inlineMath(source, 0, 4), text(source, 4, 6)]);
+    expect(nodes).toEqual([
+      { type: 'text', value: '$x ' },
+      { type: 'inlineMath', value: 'y' },
+    ]);
+  });
+
+  it('leaves escaped dollars alone', () => {
+    const source = '\\$x $y$';
+    const nodes = transform(source, [{ type: 'text', value: '$x ', position: at(0, 4) }, inlineMath(source, 4, 7)]);
+    expect(nodes).toEqual([
+      { type: 'text', value: '$x ' },
+      { type: 'inlineMath', value: 'y' },
+    ]);
+  });
+});
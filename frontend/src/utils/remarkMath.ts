@@ -0,0 +1,152 @@
+// remark-math pairs any two single dollars as a formula, so "$5 and $10"
+// would be typeset. This re-pairs the dollars the way the server does: a
+// single dollar only opens a formula when it is followed by a non-space, and
+// the next single dollar must follow a non-space and not be followed by a
+// digit to close it. Otherwise the opening dollar is text and the next one
+// may open a formula instead. Runs of text and inline math are rescanned
+// from the source, so a rejected pair doesn't hide a formula after it.
+
+interface MarkdownNode {
+  type: string;
+  value?: string;
+  data?: Record<string, unknown>;
+  children?: MarkdownNode[];
+  position?: {
+    start: { offset?: number };
+    end: { offset?: number };
+  };
+}
+
+interface Span {
+  math: boolean;
+  start: number;
+  end: number;
+  // open is the length of the dollar runs around a formula
+  open: number;
+}
+
+const isSpace = (c: string) => c === '' || /\s/.test(c);
+const isDigit = (c: string) => /[0-9]/.test(c);
+const isPunctuation = (c: string) => /[!-/:-@[-`{-~]/.test(c);
+
+// findCloser returns where the run of n dollars closing a formula opened
+// before from starts, or -1 when there isn't one
+const findCloser = (source: string, from: number, to: number, n: number): number => {
+  let i = from;
+  while (i < to) {
+    if (source.charAt(i) !== '$') {
+      i++;
+      continue;
+    }
+    const start = i;
+    while (i < to && source.charAt(i) === '$') i++;
+    if (i - start !== n) continue;
+    if (n === 1 && (isSpace(source.charAt(start - 1)) || isDigit(source.charAt(i)))) return -1;
+    return start;
+  }
+  return -1;
+};
+
+// pairDollars splits source[from:to] into text and formulas
+const pairDollars = (source: string, from: number, to: number): Span[] => {
+  const spans: Span[] = [];
+  let text = from;
+  let i = from;
+  while (i < to) {
+    const c = source.charAt(i);
+    if (c === '\\' && i + 1 < to && isPunctuation(source.charAt(i + 1))) {
+      i += 2;
+      continue;
+    }
+    if (c !== '$') {
+      i++;
+      continue;
+    }
+    const open = i;
+    while (i < to && source.charAt(i) === '$') i++;
+    const n = i - open;
+    if (n === 1 && (i >= to || isSpace(source.charAt(i)))) continue;
+    const close = findCloser(source, i, to, n);
+    if (close < 0) continue;
+    if (open > text) spans.push({ math: false, start: text, end: open, open: 0 });
+    spans.push({ math: true, start: open, end: close + n, open: n });
+    text = close + n;
+    i = text;
+  }
+  if (to > text) spans.push({ math: false, start: text, end: to, open: 0 });
+  return spans;
+};
+
+const unescape = (text: string) => text.replace(/\\([!-/:-@[-`{-~])/g, '$1');
+
+// inlineMath builds the node remark-math would for a formula
+const inlineMath = (value: string, span: Span): MarkdownNode => ({
+  type: 'inlineMath',
+  value,
+  data: {
+    hName: 'code',
+    hProperties: { className: ['language-math', 'math-inline'] },
+    hChildren: [{ type: 'text', value }],
+  },
+  position: { start: { offset: span.start }, end: { offset: span.end } },
+});
+
+// repair rescans a run of text and inline math nodes, returning them
+// unchanged when remark-math already paired the dollars the same way
+const repair = (source: string, run: MarkdownNode[]): MarkdownNode[] => {
+  const from = run[0].position?.start.offset ?? 0;
+  const to = run[run.length - 1].position?.end.offset ?? 0;
+  const spans = pairDollars(source, from, to);
+
+  const found = spans.filter((s) => s.math).map((s) => `${s.start}-${s.end}`);
+  const had = run
+    .filter((node) => node.type === 'inlineMath')
+    .map((node) => `${node.position?.start.offset}-${node.position?.end.offset}`);
+  if (found.join() === had.join()) return run;
+
+  return spans.map((span) => {
+    if (span.math) return inlineMath(source.slice(span.start + span.open, span.end - span.open), span);
+    return {
+      type: 'text',
+      value: unescape(source.slice(span.start, span.end)),
+      position: { start: { offset: span.start }, end: { offset: span.end } },
+    };
+  });
+};
+
+const inRun = (node: MarkdownNode, source: string) => {
+  const start = node.position?.start.offset;
+  const end = node.position?.end.offset;
+  if (start === undefined || end === undefined) return false;
+  return node.type === 'text' || (node.type === 'inlineMath' && source.charAt(start) === '$');
+};
+
+export const remarkMathDollars = () => (tree: MarkdownNode, file: { value: unknown }) => {
+  const source = String(file.value);
+  const visit = (node: MarkdownNode) => {
+    if (!node.children) return;
+    const children: MarkdownNode[] = [];
+    let run: MarkdownNode[] = [];
+    const flush = () => {
+      if (run.length > 0) children.push(...repair(source, run));
+      run = [];
+    };
+    for (const child of node.children) {
+      const last = run[run.length - 1];
+      if (inRun(child, source) && (!last || last.position?.end.offset === child.position?.start.offset)) {
+        run.push(child);
+        continue;
+      }
+      flush();
+      if (inRun(child, source)) {
+        run.push(child);
+        continue;
+      }
+      visit(child);
+      children.push(child);
+    }
+    flush();
+    node.children = children;
+  };
+  visit(tree);
+};
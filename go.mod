@@ -3,11 +3,22 @@ module github.com/timhughes/fishki
 go 1.24.1
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/alecthomas/chroma v0.10.0
-	github.com/russross/blackfriday/v2 v2.1.0
+	github.com/coreos/go-oidc/v3 v3.14.1
+	github.com/go-jose/go-jose/v4 v4.0.5
+	github.com/microcosm-cc/bluemonday v1.0.27
+	github.com/yuin/goldmark v1.8.6
+	golang.org/x/crypto v0.36.0
+	golang.org/x/net v0.37.0
+	golang.org/x/oauth2 v0.28.0
+	golang.org/x/term v0.30.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
+	github.com/aymerick/douceur v0.2.0 // indirect
 	github.com/dlclark/regexp2 v1.11.5 // indirect
-	github.com/stretchr/testify v1.10.0 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
+	golang.org/x/sys v0.31.0 // indirect
 )
@@ -0,0 +1,173 @@
+// Package acl decides who may read and change which folders of the wiki
+package acl
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/timhughes/fishki/internal/auth"
+)
+
+// Level is how much access a user has to a path. Each level includes the
+// ones below it
+type Level int
+
+const (
+	// None hides a path completely
+	None Level = iota
+
+	// Read allows viewing pages
+	Read
+
+	// Write allows changing and deleting pages
+	Write
+
+	// Admin allows everything, including changing the server's setup when
+	// granted at the wiki root
+	Admin
+)
+
+var levelNames = []string{"none", "read", "write", "admin"}
+
+func (l Level) String() string {
+	if l < None || l > Admin {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// MarshalText writes a level by name, so policies read naturally in JSON
+// and YAML
+func (l Level) MarshalText() ([]byte, error) {
+	if l < None || l > Admin {
+		return nil, fmt.Errorf("invalid access level %d", int(l))
+	}
+	return []byte(levelNames[l]), nil
+}
+
+// UnmarshalText parses a level name
+func (l *Level) UnmarshalText(text []byte) error {
+	for i, name := range levelNames {
+		if strings.EqualFold(string(text), name) {
+			*l = Level(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown access level %q", text)
+}
+
+// Rules maps subjects to the level they get. A subject is "user:<name>",
+// "group:<name>" or "*" for everyone, including anonymous visitors
+type Rules map[string]Level
+
+// Policy grants access by path prefix. The most specific prefix with rules
+// decides: anyone it doesn't list gets no access, so listing a folder
+// restricts it. Paths no prefix covers get the default
+type Policy struct {
+	// Default is the access to paths without rules, admin when unset so
+	// that a wiki without a policy behaves as before
+	Default *Level `json:"default,omitempty" yaml:"default,omitempty"`
+
+	// Paths maps folders or pages, relative to the wiki root, to their
+	// rules. "/" is the root
+	Paths map[string]Rules `json:"paths,omitempty" yaml:"paths,omitempty"`
+}
+
+// Clean normalises a wiki path for matching against a policy
+func Clean(p string) string {
+	p = path.Clean("/" + strings.ReplaceAll(p, "\\", "/"))
+	return strings.TrimPrefix(p, "/")
+}
+
+// covers reports whether the prefix, a cleaned path, applies to p
+func covers(prefix, p string) bool {
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// Access returns the level an identity has to a path. A nil identity is an
+// anonymous visitor
+func (p Policy) Access(id *auth.Identity, target string) Level {
+	target = Clean(target)
+
+	best := -1
+	var rules Rules
+	for prefix, r := range p.Paths {
+		prefix = Clean(prefix)
+		if covers(prefix, target) && len(prefix) > best {
+			best = len(prefix)
+			rules = r
+		}
+	}
+	if best < 0 {
+		if p.Default != nil {
+			return *p.Default
+		}
+		return Admin
+	}
+
+	level := None
+	for subject, l := range rules {
+		if Matches(subject, id) && l > level {
+			level = l
+		}
+	}
+	return level
+}
+
+// Matches reports whether a subject such as "group:hr" names an identity
+func Matches(subject string, id *auth.Identity) bool {
+	if subject == "*" {
+		return true
+	}
+	if id == nil {
+		return false
+	}
+	kind, name, _ := strings.Cut(subject, ":")
+	switch kind {
+	case "user":
+		return name == id.Username
+	case "group":
+		for _, g := range id.Groups {
+			if g == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Validate checks that a policy's subjects and levels make sense
+func (p Policy) Validate() error {
+	if p.Default != nil && (*p.Default < None || *p.Default > Admin) {
+		return fmt.Errorf("invalid default access level %d", int(*p.Default))
+	}
+	for prefix, rules := range p.Paths {
+		for subject, level := range rules {
+			kind, name, _ := strings.Cut(subject, ":")
+			if subject != "*" && ((kind != "user" && kind != "group") || name == "") {
+				return fmt.Errorf("invalid subject %q for %q: use user:<name>, group:<name> or *", subject, prefix)
+			}
+			if level < None || level > Admin {
+				return fmt.Errorf("invalid access level %d for %q", int(level), prefix)
+			}
+		}
+	}
+	return nil
+}
+
+// Merge returns p with the rules in over laid on top. A path in both takes
+// over's rules, and over's default wins when it has one
+func (p Policy) Merge(over Policy) Policy {
+	merged := Policy{Default: p.Default, Paths: make(map[string]Rules)}
+	for prefix, rules := range p.Paths {
+		merged.Paths[Clean(prefix)] = rules
+	}
+	for prefix, rules := range over.Paths {
+		merged.Paths[Clean(prefix)] = rules
+	}
+	if over.Default != nil {
+		merged.Default = over.Default
+	}
+	return merged
+}
@@ -0,0 +1,155 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/timhughes/fishki/internal/auth"
+)
+
+func TestPolicyAccess(t *testing.T) {
+	read := Read
+	policy := Policy{
+		Default: &read,
+		Paths: map[string]Rules{
+			"/":                {"group:editors": Write, "user:root": Admin},
+			"hr":               {"group:hr": Write, "user:root": Admin},
+			"hr/handbook":      {"*": Read, "group:hr": Write},
+			"security/":        {"user:alice": Admin},
+			"notes/private.md": {"user:bob": Write},
+		},
+	}
+
+	alice := &auth.Identity{Username: "alice", Groups: []string{"editors"}}
+	bob := &auth.Identity{Username: "bob", Groups: []string{"hr"}}
+	root := &auth.Identity{Username: "root"}
+
+	tests := []struct {
+		name string
+		id   *auth.Identity
+		path string
+		want Level
+	}{
+		{"root rules apply everywhere", alice, "notes/todo.md", Write},
+		{"anonymous not listed at root", nil, "index.md", None},
+		{"restricted folder", alice, "hr/salaries.md", None},
+		{"group member", bob, "hr/salaries.md", Write},
+		{"folder itself", bob, "hr", Write},
+		{"prefix is a whole segment", bob, "hrm/page.md", None},
+		{"admin listed again", root, "hr/salaries.md", Admin},
+		{"admin not listed", root, "security/keys.md", None},
+		{"more specific prefix", alice, "hr/handbook/leave.md", Read},
+		{"anonymous through wildcard", nil, "hr/handbook/leave.md", Read},
+		{"single page", bob, "notes/private.md", Write},
+		{"trailing slash in prefix", alice, "security/keys.md", Admin},
+		{"leading slash and dots", alice, "/hr/../security/keys.md", Admin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Access(tt.id, tt.path); got != tt.want {
+				t.Errorf("Access(%v, %q) = %v, want %v", tt.id, tt.path, got, tt.want)
+			}
+		})
+	}
+
+	// Paths without rules get the default, which is admin when unset
+	if got := (Policy{Default: &read}).Access(nil, "page.md"); got != Read {
+		t.Errorf("Expected the default level, got %v", got)
+	}
+	if got := (Policy{}).Access(nil, "page.md"); got != Admin {
+		t.Errorf("Expected an empty policy to allow everything, got %v", got)
+	}
+}
+
+func TestPolicyMerge(t *testing.T) {
+	write := Write
+	repo := Policy{Paths: map[string]Rules{
+		"hr":       {"group:hr": Write},
+		"security": {"*": Admin},
+	}}
+	cfg := Policy{Default: &write, Paths: map[string]Rules{
+		"/security/": {"user:alice": Admin},
+	}}
+
+	merged := repo.Merge(cfg)
+	if merged.Default == nil || *merged.Default != Write {
+		t.Errorf("Expected the config's default, got %v", merged.Default)
+	}
+	bob := &auth.Identity{Username: "bob", Groups: []string{"hr"}}
+	if got := merged.Access(bob, "security/keys.md"); got != None {
+		t.Errorf("Expected the config to override the repo's rules, got %v", got)
+	}
+	if got := merged.Access(bob, "hr/page.md"); got != Write {
+		t.Errorf("Expected the repo's rules to be kept, got %v", got)
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	policy, err := ParseFile([]byte(`
+default: read
+paths:
+  hr:
+    group:hr: write
+  security:
+    user:alice: admin
+    "*": none
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	if policy.Default == nil || *policy.Default != Read {
+		t.Errorf("Unexpected default %v", policy.Default)
+	}
+	if policy.Paths["hr"]["group:hr"] != Write || policy.Paths["security"]["user:alice"] != Admin {
+		t.Errorf("Unexpected rules %+v", policy.Paths)
+	}
+
+	for _, bad := range []string{
+		"default: everything",
+		"paths:\n  hr:\n    group:hr: owner",
+		"paths:\n  hr:\n    alice: read",
+		"paths:\n  hr:\n    user:: read",
+		"paths: [hr]",
+	} {
+		if _, err := ParseFile([]byte(bad)); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	root := t.TempDir()
+	var cache FileCache
+
+	policy, err := cache.Load(root)
+	if err != nil || len(policy.Paths) != 0 {
+		t.Fatalf("Expected an empty policy without a file, got %+v, %v", policy, err)
+	}
+
+	path := filepath.Join(root, RepoDir, "acl.yaml")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("paths:\n  hr:\n    group:hr: write\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policy, err = cache.Load(root)
+	if err != nil || policy.Paths["hr"]["group:hr"] != Write {
+		t.Fatalf("Unexpected policy %+v, %v", policy, err)
+	}
+
+	// Changes are picked up, and a broken file is an error rather than
+	// falling back to no restrictions
+	if err := os.WriteFile(path, []byte("paths:\n  hr:\n    group:hr: sometimes\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Load(root); err == nil {
+		t.Error("Expected a broken policy file to be an error")
+	}
+}
@@ -0,0 +1,77 @@
+package acl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RepoDir is the folder in the wiki holding its settings. Changing anything
+// in it needs admin access to the wiki root, so the policy can't be used
+// to widen itself
+const RepoDir = ".fishki"
+
+// RepoFile is where a wiki keeps its own policy, relative to its root
+const RepoFile = RepoDir + "/acl.yaml"
+
+// ParseFile parses a YAML policy such as
+//
+//	default: read
+//	paths:
+//	  hr:
+//	    group:hr: write
+//	  security:
+//	    user:alice: admin
+func ParseFile(data []byte) (Policy, error) {
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return Policy{}, err
+	}
+	if err := p.Validate(); err != nil {
+		return Policy{}, err
+	}
+	return p, nil
+}
+
+// FileCache loads a wiki's policy file, parsing it again only when it changes
+type FileCache struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
+	policy  Policy
+}
+
+// Load returns the policy in the wiki at root. A wiki without a policy file
+// has an empty policy
+func (c *FileCache) Load(root string) (Policy, error) {
+	path := filepath.Join(root, filepath.FromSlash(RepoFile))
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return Policy{}, nil
+	}
+	if err != nil {
+		return Policy{}, fmt.Errorf("failed to read %s: %v", RepoFile, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if path == c.path && info.ModTime().Equal(c.modTime) && info.Size() == c.size {
+		return c.policy, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, fmt.Errorf("failed to read %s: %v", RepoFile, err)
+	}
+	policy, err := ParseFile(data)
+	if err != nil {
+		return Policy{}, fmt.Errorf("failed to parse %s: %v", RepoFile, err)
+	}
+	c.path, c.modTime, c.size, c.policy = path, info.ModTime(), info.Size(), policy
+	return policy, nil
+}
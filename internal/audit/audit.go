@@ -0,0 +1,291 @@
+// Package audit keeps an append-only record of who changed what in the
+// wiki, as JSON lines
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxSize is how large the log grows before it is rotated
+	DefaultMaxSize = 10 << 20
+
+	// DefaultMaxFiles is how many rotated logs are kept besides the
+	// current one
+	DefaultMaxFiles = 5
+)
+
+// Event is one entry in the audit log
+type Event struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Success bool      `json:"success"`
+
+	// User is who made the request, or who tried to log in
+	User string `json:"user,omitempty"`
+
+	// Remote is the address the request came from, and ForwardedFor the
+	// X-Forwarded-For header when it passed through a proxy
+	Remote       string `json:"remote,omitempty"`
+	ForwardedFor string `json:"forwardedFor,omitempty"`
+
+	// Path is the page or folder acted on, relative to the wiki root
+	Path string `json:"path,omitempty"`
+
+	Detail string `json:"detail,omitempty"`
+}
+
+// Filter picks events out of the log. Empty fields match everything
+type Filter struct {
+	User   string
+	Action string
+
+	// Path matches events on the path and anything below it
+	Path string
+
+	Since time.Time
+	Until time.Time
+
+	// Limit caps how many events are returned, newest first
+	Limit int
+}
+
+func (f Filter) matches(e Event) bool {
+	if f.User != "" && e.User != f.User {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if f.Path != "" {
+		prefix := strings.Trim(f.Path, "/")
+		if e.Path != prefix && !strings.HasPrefix(e.Path, prefix+"/") {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Log appends events to a file, rotating it to path.1, path.2 and so on
+// once it grows past its maximum size
+type Log struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+// Open opens or creates the audit log at path. Zero limits use the defaults
+func Open(path string, maxSize int64, maxFiles int) (*Log, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultMaxFiles
+	}
+	l := &Log{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %v", err)
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the current log file for appending. The caller must hold l.mu
+// or be the only user of l
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// rotated returns the name of the nth rotated log
+func (l *Log) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// rotate moves the current log aside and starts a new one, dropping the
+// oldest. If the log can't be moved aside it is reopened, so events keep
+// being recorded and rotating is tried again next time. The caller must
+// hold l.mu
+func (l *Log) rotate() error {
+	err := l.file.Close()
+	l.file = nil
+	if err == nil {
+		os.Remove(l.rotated(l.maxFiles))
+		for n := l.maxFiles - 1; n >= 1; n-- {
+			os.Rename(l.rotated(n), l.rotated(n+1))
+		}
+		err = os.Rename(l.path, l.rotated(1))
+	}
+	if openErr := l.open(); err == nil {
+		err = openErr
+	}
+	return err
+}
+
+// Record appends an event, stamping it with the current time if it has none
+func (l *Log) Record(e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return fmt.Errorf("audit log is closed")
+	}
+
+	// A log that couldn't be reopened after rotating is tried again
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	var rotateErr error
+	if l.size > 0 && l.size+int64(len(data)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			rotateErr = fmt.Errorf("failed to rotate audit log: %v", err)
+			if l.file == nil {
+				return rotateErr
+			}
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+// Query returns the events matching a filter, newest first, searching the
+// rotated logs too
+func (l *Log) Query(f Filter) ([]Event, error) {
+	logs, closeLogs, err := l.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer closeLogs()
+
+	events := []Event{}
+	for _, r := range logs {
+		found, err := readEvents(r, f)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, found...)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.After(events[j].Time) })
+	if f.Limit > 0 && len(events) > f.Limit {
+		events = events[:f.Limit]
+	}
+	return events, nil
+}
+
+// snapshot opens the current and rotated logs while holding l.mu, so they
+// can be read without holding it and without a rotation moving events
+// between them. The current log is cut off at what had been written, leaving
+// out any event written since
+func (l *Log) snapshot() ([]io.Reader, func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var logs []io.Reader
+	var files []*os.File
+	closeLogs := func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+	for n := 0; n <= l.maxFiles; n++ {
+		name := l.path
+		if n > 0 {
+			name = l.rotated(n)
+		}
+		file, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			closeLogs()
+			return nil, nil, fmt.Errorf("failed to read audit log: %v", err)
+		}
+		files = append(files, file)
+		if n == 0 {
+			logs = append(logs, io.LimitReader(file, l.size))
+		} else {
+			logs = append(logs, file)
+		}
+	}
+	return logs, closeLogs, nil
+}
+
+// readEvents reads the events in one log that match a filter. Lines that
+// don't parse are skipped
+func readEvents(r io.Reader, f Filter) ([]Event, error) {
+	var events []Event
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if f.matches(e) {
+			events = append(events, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	return events, nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,194 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.log")
+	log, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer log.Close()
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	events := []Event{
+		{Time: start, Action: "save", Success: true, User: "alice", Path: "hr/salaries.md"},
+		{Time: start.Add(time.Minute), Action: "delete", Success: true, User: "bob", Path: "notes/old.md"},
+		{Time: start.Add(2 * time.Minute), Action: "login", User: "mallory", Remote: "192.0.2.1:1234"},
+		{Time: start.Add(3 * time.Minute), Action: "save", Success: true, User: "alice", Path: "hr"},
+	}
+	for _, e := range events {
+		if err := log.Record(e); err != nil {
+			t.Fatalf("Failed to record event: %v", err)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat audit log: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the audit log to be private, got %v", info.Mode().Perm())
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"everything newest first", Filter{}, []string{"save hr", "login ", "delete notes/old.md", "save hr/salaries.md"}},
+		{"by user", Filter{User: "alice"}, []string{"save hr", "save hr/salaries.md"}},
+		{"by action", Filter{Action: "delete"}, []string{"delete notes/old.md"}},
+		{"by folder", Filter{Path: "/hr/"}, []string{"save hr", "save hr/salaries.md"}},
+		{"folder prefix is a whole segment", Filter{Path: "note"}, nil},
+		{"by time", Filter{Since: start.Add(time.Minute), Until: start.Add(2 * time.Minute)}, []string{"login ", "delete notes/old.md"}},
+		{"limited", Filter{Limit: 1}, []string{"save hr"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := log.Query(tt.filter)
+			if err != nil {
+				t.Fatalf("Failed to query audit log: %v", err)
+			}
+			var got []string
+			for _, e := range found {
+				got = append(got, e.Action+" "+e.Path)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path, 300, 2)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := log.Record(Event{Action: "save", Success: true, User: "alice", Path: strings.Repeat("x", 50)}); err != nil {
+			t.Fatalf("Failed to record event: %v", err)
+		}
+	}
+
+	// Only the current log and two rotated ones are kept, none too large
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", name, err)
+		}
+		if info.Size() > 300 {
+			t.Errorf("Expected %s to be rotated before growing past the limit, got %d bytes", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected the oldest log to be dropped, got %v", err)
+	}
+
+	found, err := log.Query(Filter{})
+	if err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	if len(found) == 0 || len(found) >= 20 {
+		t.Errorf("Expected the events in the kept logs, got %d", len(found))
+	}
+
+	// Reopening appends to the existing log
+	log.Close()
+	if err := log.Record(Event{Action: "save"}); err == nil {
+		t.Error("Expected recording to a closed log to fail")
+	}
+	reopened, err := Open(path, 300, 2)
+	if err != nil {
+		t.Fatalf("Failed to reopen audit log: %v", err)
+	}
+	defer reopened.Close()
+	again, err := reopened.Query(Filter{})
+	if err != nil || len(again) != len(found) {
+		t.Errorf("Expected %d events after reopening, got %d, %v", len(found), len(again), err)
+	}
+}
+
+func TestLogRotationFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path, 100, 1)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer log.Close()
+
+	// A folder in the way of the rotated log stops rotation
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocked"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		log.Record(Event{Action: "save", Path: strings.Repeat("x", 50)})
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || strings.Count(string(data), "\n") != 5 {
+		t.Fatalf("Expected events to keep being recorded, got %q, %v", data, err)
+	}
+
+	// Once it is out of the way rotation carries on
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Record(Event{Action: "save", Path: strings.Repeat("x", 50)}); err != nil {
+		t.Fatalf("Expected rotation to succeed, got %v", err)
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Errorf("Expected the log to be rotated: %v", err)
+	}
+	if found, err := log.Query(Filter{}); err != nil || len(found) != 6 {
+		t.Errorf("Expected all 6 events, got %d, %v", len(found), err)
+	}
+}
+
+func TestLogQueryWhileRecording(t *testing.T) {
+	log, err := Open(filepath.Join(t.TempDir(), "audit.log"), 500, 100)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer log.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			log.Record(Event{Action: "save", Path: fmt.Sprintf("page-%d.md", i)})
+		}
+	}()
+
+	// Every query sees each event at most once, however rotation falls
+	for {
+		found, err := log.Query(Filter{})
+		if err != nil {
+			t.Fatalf("Failed to query audit log: %v", err)
+		}
+		seen := make(map[string]bool)
+		for _, e := range found {
+			if seen[e.Path] {
+				t.Fatalf("Expected %s once, got it twice", e.Path)
+			}
+			seen[e.Path] = true
+		}
+		select {
+		case <-done:
+			if found, _ := log.Query(Filter{}); len(found) != 200 {
+				t.Errorf("Expected all 200 events, got %d", len(found))
+			}
+			return
+		default:
+		}
+	}
+}
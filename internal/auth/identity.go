@@ -0,0 +1,46 @@
+// Package auth holds the accounts, sessions and identities used to decide
+// who is making a request
+package auth
+
+import "context"
+
+// Identity is the user a request is made on behalf of
+type Identity struct {
+	Username string   `json:"username"`
+	Name     string   `json:"name,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+
+	// TokenID and Scopes are set when the request was made with an API
+	// token, which only allows what its scopes cover
+	TokenID string  `json:"tokenId,omitempty"`
+	Scopes  []Scope `json:"scopes,omitempty"`
+}
+
+// Allows reports whether the identity may do something needing scope.
+// Identities from logging in have every scope
+func (id *Identity) Allows(scope Scope) bool {
+	if id.TokenID == "" {
+		return true
+	}
+	for _, s := range id.Scopes {
+		if s.covers(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// WithIdentity returns a context carrying an identity
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity in a context, or nil for anonymous
+// requests
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(contextKey{}).(*Identity)
+	return id
+}
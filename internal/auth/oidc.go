@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// oidcLoginTimeout is how long a user has to finish logging in at the
+// provider
+const oidcLoginTimeout = 10 * time.Minute
+
+// maxPendingLogins bounds the logins waiting for the provider, so requests
+// that start logins and never finish them can't use up memory. Past it the
+// oldest are dropped
+const maxPendingLogins = 10000
+
+// OIDCUserPrefix starts the username of everyone who logs in with single
+// sign-on. Local usernames can't contain a colon, so a provider can't hand
+// out the name of a local account. In access rules they are written as
+// "user:oidc:<name>"
+const OIDCUserPrefix = "oidc:"
+
+// ErrInvalidState is returned when a login callback doesn't match a login
+// started here, or took too long
+var ErrInvalidState = errors.New("invalid or expired login state")
+
+// OIDCConfig configures single sign-on with an OpenID Connect provider
+type OIDCConfig struct {
+	// Issuer is the provider's URL, where its discovery document lives
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+
+	// RedirectURL is where the provider sends users back to, Fishki's
+	// /api/auth/oidc/callback
+	RedirectURL string `json:"redirectUrl"`
+
+	// Scopes defaults to openid, profile and email
+	Scopes []string `json:"scopes,omitempty"`
+
+	// UsernameClaim names the claim used as the username, sub by default.
+	// Names like preferred_username can often be changed by the user, and
+	// email is only used when the token says it is verified
+	UsernameClaim string `json:"usernameClaim,omitempty"`
+
+	// GroupsClaim names the claim listing the user's groups, "groups" by
+	// default. A dotted path such as realm_access.roles reaches into
+	// nested claims
+	GroupsClaim string `json:"groupsClaim,omitempty"`
+}
+
+// OIDC runs the authorization code flow with PKCE against a provider. The
+// provider is discovered on first use, so it being down doesn't stop the
+// server starting
+type OIDC struct {
+	cfg OIDCConfig
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	pending  map[string]pendingLogin
+}
+
+// pendingLogin is a login sent to the provider and not yet come back
+type pendingLogin struct {
+	verifier string
+	nonce    string
+	redirect string
+	expires  time.Time
+}
+
+// NewOIDC checks a provider's configuration
+func NewOIDC(cfg OIDCConfig) (*OIDC, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("oidc needs an issuer, clientId and redirectUrl")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	return &OIDC{cfg: cfg, pending: map[string]pendingLogin{}}, nil
+}
+
+// discover fetches the provider's discovery document once
+func (o *OIDC) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.oauth != nil {
+		return o.oauth, o.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, o.cfg.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to discover oidc provider: %v", err)
+	}
+	o.oauth = &oauth2.Config{
+		ClientID:     o.cfg.ClientID,
+		ClientSecret: o.cfg.ClientSecret,
+		RedirectURL:  o.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       o.cfg.Scopes,
+	}
+	o.verifier = provider.Verifier(&oidc.Config{ClientID: o.cfg.ClientID})
+	return o.oauth, o.verifier, nil
+}
+
+// Begin starts a login, returning its state, which the caller should tie
+// to the browser, and the provider URL to send the user to. redirect is
+// handed back by Finish
+func (o *OIDC) Begin(ctx context.Context, redirect string) (string, string, error) {
+	oauth, _, err := o.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	login := pendingLogin{
+		verifier: oauth2.GenerateVerifier(),
+		nonce:    nonce,
+		redirect: redirect,
+		expires:  time.Now().Add(oidcLoginTimeout),
+	}
+
+	o.mu.Lock()
+	now := time.Now()
+	for key, old := range o.pending {
+		if now.After(old.expires) {
+			delete(o.pending, key)
+		}
+	}
+	for len(o.pending) >= maxPendingLogins {
+		o.dropOldest()
+	}
+	o.pending[state] = login
+	o.mu.Unlock()
+
+	url := oauth.AuthCodeURL(state, oauth2.S256ChallengeOption(login.verifier), oidc.Nonce(nonce))
+	return state, url, nil
+}
+
+// dropOldest forgets the pending login closest to expiring. The caller
+// holds o.mu
+func (o *OIDC) dropOldest() {
+	oldest := ""
+	var expires time.Time
+	for key, login := range o.pending {
+		if oldest == "" || login.expires.Before(expires) {
+			oldest, expires = key, login.expires
+		}
+	}
+	delete(o.pending, oldest)
+}
+
+// Finish completes a login with the code the provider sent back, verifying
+// the ID token and mapping its claims to an identity
+func (o *OIDC) Finish(ctx context.Context, state, code string) (*Identity, string, error) {
+	o.mu.Lock()
+	login, ok := o.pending[state]
+	delete(o.pending, state)
+	o.mu.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		return nil, "", ErrInvalidState
+	}
+
+	oauth, verifier, err := o.discover(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to exchange code: %v", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", fmt.Errorf("no id_token in token response")
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to verify id token: %v", err)
+	}
+	if idToken.Nonce != login.nonce {
+		return nil, "", fmt.Errorf("id token nonce does not match")
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, "", fmt.Errorf("failed to read id token claims: %v", err)
+	}
+	id, err := o.identity(claims)
+	if err != nil {
+		return nil, "", err
+	}
+	return id, login.redirect, nil
+}
+
+// identity maps ID token claims to a Fishki identity
+func (o *OIDC) identity(claims map[string]any) (*Identity, error) {
+	id := &Identity{
+		Name:  claimString(claims, "name"),
+		Email: claimString(claims, "email"),
+	}
+
+	claim := o.cfg.UsernameClaim
+	if claim == "" {
+		claim = "sub"
+	}
+	username := claimString(claims, claim)
+	if username == "" {
+		return nil, fmt.Errorf("id token has no %s claim", claim)
+	}
+	if claim == "email" && !emailVerified(claims) {
+		return nil, fmt.Errorf("id token's email is not verified")
+	}
+	id.Username = OIDCUserPrefix + username
+
+	switch groups := claimValue(claims, o.cfg.GroupsClaim).(type) {
+	case string:
+		id.Groups = []string{groups}
+	case []any:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	}
+	return id, nil
+}
+
+// emailVerified reports whether the token vouches for its email claim. Some
+// providers send the flag as a string
+func emailVerified(claims map[string]any) bool {
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true"
+	}
+	return false
+}
+
+// claimValue looks up a claim, following dots into nested objects
+func claimValue(claims map[string]any, path string) any {
+	var value any = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+// claimString returns a claim if it is a string
+func claimString(claims map[string]any, path string) string {
+	s, _ := claimValue(claims, path).(string)
+	return s
+}
+
+// randomString returns a URL-safe random string for states and nonces
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/timhughes/fishki/internal/test_utils"
+)
+
+func TestOIDC(t *testing.T) {
+	provider := test_utils.NewMockOIDCProvider(t)
+
+	tests := []struct {
+		name          string
+		usernameClaim string
+		groupsClaim   string
+		claims        map[string]any
+		want          Identity
+		wantErr       bool
+	}{
+		{
+			name: "default claims",
+			claims: map[string]any{
+				"sub":                "f3a9",
+				"preferred_username": "alice",
+				"name":               "Alice Example",
+				"email":              "alice@example.com",
+				"groups":             []string{"editors", "hr"},
+			},
+			want: Identity{Username: "oidc:f3a9", Name: "Alice Example", Email: "alice@example.com", Groups: []string{"editors", "hr"}},
+		},
+		{
+			name:          "verified email",
+			usernameClaim: "email",
+			claims:        map[string]any{"email": "bob@example.com", "email_verified": true, "groups": "editors"},
+			want:          Identity{Username: "oidc:bob@example.com", Email: "bob@example.com", Groups: []string{"editors"}},
+		},
+		{
+			name:          "unverified email",
+			usernameClaim: "email",
+			claims:        map[string]any{"email": "bob@example.com", "email_verified": false},
+			wantErr:       true,
+		},
+		{
+			name:          "missing claim",
+			usernameClaim: "preferred_username",
+			claims:        map[string]any{"email": "bob@example.com"},
+			wantErr:       true,
+		},
+		{
+			name:          "configured claims",
+			usernameClaim: "upn",
+			groupsClaim:   "realm_access.roles",
+			claims: map[string]any{
+				"upn":          "carol",
+				"groups":       []string{"ignored"},
+				"realm_access": map[string]any{"roles": []string{"admin"}},
+			},
+			want: Identity{Username: "oidc:carol", Groups: []string{"admin"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider.Claims = tt.claims
+			o, err := NewOIDC(OIDCConfig{
+				Issuer:        provider.URL,
+				ClientID:      provider.ClientID,
+				ClientSecret:  provider.ClientSecret,
+				RedirectURL:   "http://fishki.test/api/auth/oidc/callback",
+				UsernameClaim: tt.usernameClaim,
+				GroupsClaim:   tt.groupsClaim,
+			})
+			if err != nil {
+				t.Fatalf("Failed to configure OIDC: %v", err)
+			}
+
+			state, authURL, err := o.Begin(context.Background(), "/page/notes")
+			if err != nil {
+				t.Fatalf("Failed to begin login: %v", err)
+			}
+			callback := provider.Login(t, authURL)
+			if callback.Query().Get("state") != state {
+				t.Fatalf("Expected state %q in callback, got %q", state, callback.Query().Get("state"))
+			}
+
+			id, redirect, err := o.Finish(context.Background(), state, callback.Query().Get("code"))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected the login to be refused, got %+v", *id)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to finish login: %v", err)
+			}
+			if !reflect.DeepEqual(*id, tt.want) {
+				t.Errorf("Expected identity %+v, got %+v", tt.want, *id)
+			}
+			if redirect != "/page/notes" {
+				t.Errorf("Expected redirect /page/notes, got %q", redirect)
+			}
+
+			// A state can only be used once
+			if _, _, err := o.Finish(context.Background(), state, callback.Query().Get("code")); err != ErrInvalidState {
+				t.Errorf("Expected a replayed state to fail, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOIDCRejectsBadLogins(t *testing.T) {
+	provider := test_utils.NewMockOIDCProvider(t)
+	provider.Claims = map[string]any{"preferred_username": "alice"}
+
+	newOIDC := func(clientSecret string) *OIDC {
+		o, err := NewOIDC(OIDCConfig{
+			Issuer:       provider.URL,
+			ClientID:     provider.ClientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  "http://fishki.test/api/auth/oidc/callback",
+		})
+		if err != nil {
+			t.Fatalf("Failed to configure OIDC: %v", err)
+		}
+		return o
+	}
+
+	if _, err := NewOIDC(OIDCConfig{Issuer: provider.URL}); err == nil {
+		t.Error("Expected an incomplete config to be rejected")
+	}
+
+	// An unknown state never reaches the provider
+	o := newOIDC(provider.ClientSecret)
+	if _, _, err := o.Finish(context.Background(), "unknown", "code"); err != ErrInvalidState {
+		t.Errorf("Expected an unknown state to fail, got %v", err)
+	}
+
+	// The code is bound to the PKCE verifier of the login that asked for it
+	stateA, urlA, err := o.Begin(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to begin login: %v", err)
+	}
+	stateB, _, err := o.Begin(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to begin login: %v", err)
+	}
+	codeA := provider.Login(t, urlA).Query().Get("code")
+	if _, _, err := o.Finish(context.Background(), stateB, codeA); err == nil {
+		t.Error("Expected a code from another login to fail")
+	}
+	if _, _, err := o.Finish(context.Background(), stateA, codeA); err == nil {
+		t.Error("Expected a code to only be exchanged once")
+	}
+
+	// The client must authenticate to the provider
+	bad := newOIDC("wrong")
+	state, authURL, err := bad.Begin(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to begin login: %v", err)
+	}
+	code := provider.Login(t, authURL).Query().Get("code")
+	if _, _, err := bad.Finish(context.Background(), state, code); err == nil {
+		t.Error("Expected a wrong client secret to fail")
+	}
+
+	// The auth URL asks for PKCE and a nonce
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Invalid auth URL: %v", err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("nonce") == "" || q.Get("scope") != "openid profile email" {
+		t.Errorf("Unexpected auth URL parameters %v", q)
+	}
+}
+
+func TestOIDCPendingLoginsAreBounded(t *testing.T) {
+	provider := test_utils.NewMockOIDCProvider(t)
+	o, err := NewOIDC(OIDCConfig{
+		Issuer:      provider.URL,
+		ClientID:    provider.ClientID,
+		RedirectURL: "http://fishki.test/api/auth/oidc/callback",
+	})
+	if err != nil {
+		t.Fatalf("Failed to configure OIDC: %v", err)
+	}
+
+	first, _, err := o.Begin(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Failed to begin login: %v", err)
+	}
+	var last string
+	for i := 0; i < maxPendingLogins; i++ {
+		if last, _, err = o.Begin(context.Background(), "/"); err != nil {
+			t.Fatalf("Failed to begin login: %v", err)
+		}
+	}
+
+	o.mu.Lock()
+	pending := len(o.pending)
+	_, kept := o.pending[last]
+	_, dropped := o.pending[first]
+	o.mu.Unlock()
+	if pending != maxPendingLogins {
+		t.Errorf("Expected %d pending logins, got %d", maxPendingLogins, pending)
+	}
+	if !kept || dropped {
+		t.Error("Expected the oldest login to be dropped and the newest kept")
+	}
+}
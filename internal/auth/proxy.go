@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ErrUntrustedProxy is returned when a request carries identity headers but
+// doesn't come from a trusted proxy
+var ErrUntrustedProxy = errors.New("identity headers from an untrusted source")
+
+// ProxyConfig configures taking the user from headers set by an
+// authenticating reverse proxy such as oauth2-proxy
+type ProxyConfig struct {
+	// TrustedProxies lists the addresses, in CIDR notation, whose identity
+	// headers are believed
+	TrustedProxies []string `json:"trustedProxies"`
+
+	// UserHeader holds the username, X-Forwarded-User by default
+	UserHeader string `json:"userHeader,omitempty"`
+
+	// EmailHeader holds the email address, X-Forwarded-Email by default
+	EmailHeader string `json:"emailHeader,omitempty"`
+
+	// NameHeader holds the display name; there is none by default
+	NameHeader string `json:"nameHeader,omitempty"`
+
+	// GroupsHeader holds comma-separated groups, X-Forwarded-Groups by
+	// default
+	GroupsHeader string `json:"groupsHeader,omitempty"`
+}
+
+// ProxyAuth identifies users from a trusted proxy's headers
+type ProxyAuth struct {
+	cfg      ProxyConfig
+	prefixes []netip.Prefix
+}
+
+// NewProxyAuth checks a proxy configuration
+func NewProxyAuth(cfg ProxyConfig) (*ProxyAuth, error) {
+	if len(cfg.TrustedProxies) == 0 {
+		return nil, fmt.Errorf("proxy authentication needs at least one trusted proxy")
+	}
+	p := &ProxyAuth{cfg: cfg}
+	for _, cidr := range cfg.TrustedProxies {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			// A bare address trusts just that host
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %v", cidr, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		p.prefixes = append(p.prefixes, prefix.Masked())
+	}
+	if p.cfg.UserHeader == "" {
+		p.cfg.UserHeader = "X-Forwarded-User"
+	}
+	if p.cfg.EmailHeader == "" {
+		p.cfg.EmailHeader = "X-Forwarded-Email"
+	}
+	if p.cfg.GroupsHeader == "" {
+		p.cfg.GroupsHeader = "X-Forwarded-Groups"
+	}
+	return p, nil
+}
+
+// headers returns the identity headers that are configured
+func (p *ProxyAuth) headers() []string {
+	headers := []string{p.cfg.UserHeader, p.cfg.EmailHeader, p.cfg.GroupsHeader}
+	if p.cfg.NameHeader != "" {
+		headers = append(headers, p.cfg.NameHeader)
+	}
+	return headers
+}
+
+// trusted reports whether a request comes straight from a trusted proxy
+func (p *ProxyAuth) trusted(r *http.Request) bool {
+	return p.trustedAddr(remoteHost(r))
+}
+
+// trustedAddr reports whether an address is one of the trusted proxies
+func (p *ProxyAuth) trustedAddr(host string) bool {
+	addr, err := netip.ParseAddr(strings.TrimSpace(host))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHost returns the address of the peer a request came from
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientIP returns the address a request comes from, for rate limiting.
+// X-Forwarded-For is only believed when the request comes straight from a
+// trusted proxy, and then only back to the nearest address that isn't a
+// trusted proxy: anything before that could have been sent by the client.
+// A nil ProxyAuth trusts no proxies
+func (p *ProxyAuth) ClientIP(r *http.Request) string {
+	host := remoteHost(r)
+	if p == nil || !p.trustedAddr(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		host = hop
+		if !p.trustedAddr(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// Identify returns the identity in a request's headers. It returns nil when
+// there are no identity headers, and ErrUntrustedProxy when they come from
+// anywhere but a trusted proxy
+func (p *ProxyAuth) Identify(r *http.Request) (*Identity, error) {
+	present := false
+	for _, header := range p.headers() {
+		if _, ok := r.Header[http.CanonicalHeaderKey(header)]; ok {
+			present = true
+			break
+		}
+	}
+	if !present {
+		return nil, nil
+	}
+	if !p.trusted(r) {
+		return nil, ErrUntrustedProxy
+	}
+
+	id := &Identity{
+		Username: strings.TrimSpace(r.Header.Get(p.cfg.UserHeader)),
+		Email:    strings.TrimSpace(r.Header.Get(p.cfg.EmailHeader)),
+	}
+	if p.cfg.NameHeader != "" {
+		id.Name = strings.TrimSpace(r.Header.Get(p.cfg.NameHeader))
+	}
+	if id.Username == "" {
+		id.Username = id.Email
+	}
+	if id.Username == "" {
+		return nil, nil
+	}
+	for _, g := range strings.Split(r.Header.Get(p.cfg.GroupsHeader), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			id.Groups = append(id.Groups, g)
+		}
+	}
+	return id, nil
+}
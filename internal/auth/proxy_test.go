@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestProxyAuth(t *testing.T) {
+	proxy, err := NewProxyAuth(ProxyConfig{
+		TrustedProxies: []string{"10.0.0.0/8", "::1", "fd00::/8"},
+		NameHeader:     "X-Forwarded-Preferred-Username",
+	})
+	if err != nil {
+		t.Fatalf("Failed to configure proxy auth: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       *Identity
+		wantErr    error
+	}{
+		{
+			name:       "no headers",
+			remoteAddr: "192.0.2.1:1234",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.1.2.3:1234",
+			headers: map[string]string{
+				"X-Forwarded-User":               "alice",
+				"X-Forwarded-Email":              "alice@example.com",
+				"X-Forwarded-Preferred-Username": "Alice Example",
+				"X-Forwarded-Groups":             "editors, hr",
+			},
+			want: &Identity{Username: "alice", Name: "Alice Example", Email: "alice@example.com", Groups: []string{"editors", "hr"}},
+		},
+		{
+			name:       "email only",
+			remoteAddr: "[::1]:1234",
+			headers:    map[string]string{"X-Forwarded-Email": "bob@example.com"},
+			want:       &Identity{Username: "bob@example.com", Email: "bob@example.com"},
+		},
+		{
+			name:       "IPv4-mapped address",
+			remoteAddr: "[::ffff:10.0.0.1]:1234",
+			headers:    map[string]string{"X-Forwarded-User": "carol"},
+			want:       &Identity{Username: "carol"},
+		},
+		{
+			name:       "empty user from trusted proxy",
+			remoteAddr: "10.1.2.3:1234",
+			headers:    map[string]string{"X-Forwarded-User": ""},
+		},
+		{
+			name:       "untrusted source",
+			remoteAddr: "192.0.2.1:1234",
+			headers:    map[string]string{"X-Forwarded-User": "alice"},
+			wantErr:    ErrUntrustedProxy,
+		},
+		{
+			name:       "untrusted source with groups only",
+			remoteAddr: "192.0.2.1:1234",
+			headers:    map[string]string{"X-Forwarded-Groups": "admins"},
+			wantErr:    ErrUntrustedProxy,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/files", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			got, err := proxy.Identify(req)
+			if err != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected identity %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	for _, cfg := range []ProxyConfig{{}, {TrustedProxies: []string{"not-an-address"}}} {
+		if _, err := NewProxyAuth(cfg); err == nil {
+			t.Errorf("Expected config %+v to be rejected", cfg)
+		}
+	}
+}
+
+func TestProxyClientIP(t *testing.T) {
+	proxy, err := NewProxyAuth(ProxyConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatalf("Failed to configure proxy auth: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		proxy      *ProxyAuth
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"direct", proxy, "192.0.2.1:1234", "", "192.0.2.1"},
+		{"spoofed header", proxy, "192.0.2.1:1234", "203.0.113.9", "192.0.2.1"},
+		{"trusted proxy", proxy, "10.0.0.1:1234", "203.0.113.9", "203.0.113.9"},
+		{"client prepends an address", proxy, "10.0.0.1:1234", "198.51.100.7, 203.0.113.9", "203.0.113.9"},
+		{"chain of proxies", proxy, "10.0.0.1:1234", "203.0.113.9, 10.0.0.2", "203.0.113.9"},
+		{"trusted proxy without header", proxy, "10.0.0.1:1234", "", "10.0.0.1"},
+		{"no proxy configured", nil, "192.0.2.1:1234", "203.0.113.9", "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := tt.proxy.ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+const (
+	// SessionCookieName is the cookie holding a logged in user's session
+	SessionCookieName = "fishki_session"
+
+	// DefaultSessionTTL is how long a session lasts after logging in
+	DefaultSessionTTL = 7 * 24 * time.Hour
+
+	sessionIDLength = 32
+)
+
+// Session is a logged in user
+type Session struct {
+	ID       string
+	Identity Identity
+	Expires  time.Time
+}
+
+// SessionStore keeps sessions in memory, so restarting the server logs
+// everyone out
+type SessionStore struct {
+	ttl      time.Duration
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewSessionStore creates a store whose sessions last for ttl
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	return &SessionStore{ttl: ttl, sessions: map[string]*Session{}}
+}
+
+// TTL returns how long new sessions last
+func (s *SessionStore) TTL() time.Duration {
+	return s.ttl
+}
+
+// Create starts a session for an identity with a new random ID
+func (s *SessionStore) Create(id Identity) (*Session, error) {
+	b := make([]byte, sessionIDLength)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	session := &Session{
+		ID:       base64.RawURLEncoding.EncodeToString(b),
+		Identity: id,
+		Expires:  time.Now().Add(s.ttl),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, old := range s.sessions {
+		if now.After(old.Expires) {
+			delete(s.sessions, key)
+		}
+	}
+	s.sessions[session.ID] = session
+	return session, nil
+}
+
+// Get returns the session with an ID unless it has expired
+func (s *SessionStore) Get(id string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(session.Expires) {
+		delete(s.sessions, id)
+		return nil, false
+	}
+	return session, true
+}
+
+// Delete ends a session
+func (s *SessionStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope is what an API token is allowed to do
+type Scope string
+
+const (
+	// ScopeRead allows reading pages and history
+	ScopeRead Scope = "read"
+
+	// ScopeWrite allows changing pages, and includes read
+	ScopeWrite Scope = "write"
+
+	// ScopeAdmin allows changing the server's setup, and includes write
+	ScopeAdmin Scope = "admin"
+)
+
+// tokenPrefix starts every API token, so they are easy to spot in logs and
+// secret scanners
+const tokenPrefix = "fishki_"
+
+// tokenUsageInterval is how stale a token's recorded last use may get
+// before it is written to disk again
+const tokenUsageInterval = time.Minute
+
+var (
+	// ErrInvalidToken is returned for a token that doesn't exist, has been
+	// revoked or has expired
+	ErrInvalidToken = errors.New("invalid API token")
+
+	// ErrTokenNotFound is returned when revoking a token the user doesn't have
+	ErrTokenNotFound = errors.New("token not found")
+)
+
+// rank orders scopes so a wider one covers the narrower ones
+func (s Scope) rank() int {
+	switch s {
+	case ScopeRead:
+		return 1
+	case ScopeWrite:
+		return 2
+	case ScopeAdmin:
+		return 3
+	}
+	return 0
+}
+
+// covers reports whether a token with scope s may do something needing other
+func (s Scope) covers(other Scope) bool {
+	return s.rank() > 0 && s.rank() >= other.rank()
+}
+
+// Token is a personal API token. Only a hash of the secret is kept
+type Token struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	Scopes   []Scope    `json:"scopes"`
+	Owner    Identity   `json:"owner"`
+	Hash     string     `json:"hash"`
+	Created  time.Time  `json:"created"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	LastUsed *time.Time `json:"lastUsed,omitempty"`
+}
+
+// Identity returns the identity requests made with the token carry
+func (t Token) Identity() *Identity {
+	id := t.Owner
+	id.TokenID = t.ID
+	id.Scopes = t.Scopes
+	return &id
+}
+
+// TokenStore keeps API tokens in a JSON file
+type TokenStore struct {
+	path   string
+	mu     sync.Mutex
+	tokens []Token
+}
+
+// LoadTokens reads the tokens in path. A missing file is an empty store
+func LoadTokens(path string) (*TokenStore, error) {
+	s := &TokenStore{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %v", err)
+	}
+	if err := json.Unmarshal(data, &s.tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse tokens file: %v", err)
+	}
+	return s, nil
+}
+
+// save writes the tokens to the file. The caller must hold s.mu
+func (s *TokenStore) save() error {
+	data, err := json.MarshalIndent(s.tokens, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal tokens: %v", err)
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to write tokens file: %v", err)
+	}
+	return nil
+}
+
+// hashToken returns the stored form of a token's secret
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create issues a token for owner, returning the secret, which can't be
+// recovered later. A zero ttl never expires
+func (s *TokenStore) Create(owner Identity, name string, scopes []Scope, ttl time.Duration) (string, Token, error) {
+	if len(scopes) == 0 {
+		return "", Token{}, fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if scope.rank() == 0 {
+			return "", Token{}, fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", Token{}, err
+	}
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", Token{}, err
+	}
+
+	owner.TokenID = ""
+	owner.Scopes = nil
+	token := Token{
+		ID:      hex.EncodeToString(id),
+		Name:    name,
+		Scopes:  scopes,
+		Owner:   owner,
+		Hash:    hashToken(secret),
+		Created: time.Now().UTC(),
+	}
+	if ttl > 0 {
+		expires := token.Created.Add(ttl)
+		token.Expires = &expires
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = append(s.tokens, token)
+	if err := s.save(); err != nil {
+		s.tokens = s.tokens[:len(s.tokens)-1]
+		return "", Token{}, err
+	}
+	return secret, token, nil
+}
+
+// List returns a user's tokens, newest first
+func (s *TokenStore) List(username string) []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := []Token{}
+	for _, t := range s.tokens {
+		if t.Owner.Username == username {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.SliceStable(tokens, func(i, j int) bool { return tokens[i].Created.After(tokens[j].Created) })
+	return tokens
+}
+
+// Revoke deletes one of a user's tokens
+func (s *TokenStore) Revoke(username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, t := range s.tokens {
+		if t.ID == id && t.Owner.Username == username {
+			s.tokens = append(s.tokens[:i:i], s.tokens[i+1:]...)
+			return s.save()
+		}
+	}
+	return ErrTokenNotFound
+}
+
+// Authenticate looks up a token by its secret and records that it was used
+func (s *TokenStore) Authenticate(secret string) (Token, error) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return Token{}, ErrInvalidToken
+	}
+	hash := hashToken(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	for i := range s.tokens {
+		t := &s.tokens[i]
+		if t.Hash != hash {
+			continue
+		}
+		if t.Expires != nil && now.After(*t.Expires) {
+			return Token{}, ErrInvalidToken
+		}
+
+		stale := t.LastUsed == nil || now.Sub(*t.LastUsed) >= tokenUsageInterval
+		t.LastUsed = &now
+		if stale {
+			// A failure to record the use shouldn't lock the token out
+			s.save()
+		}
+		return *t, nil
+	}
+	return Token{}, ErrInvalidToken
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	tokens, err := LoadTokens(path)
+	if err != nil {
+		t.Fatalf("Failed to load missing tokens file: %v", err)
+	}
+
+	alice := Identity{Username: "alice", Email: "alice@example.com", Groups: []string{"editors"}}
+	secret, token, err := tokens.Create(alice, "CI", []Scope{ScopeWrite}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if !strings.HasPrefix(secret, "fishki_") || token.Hash == secret || token.Expires != nil {
+		t.Errorf("Unexpected token %q %+v", secret, token)
+	}
+
+	for _, scopes := range [][]Scope{nil, {"superuser"}} {
+		if _, _, err := tokens.Create(alice, "bad", scopes, 0); err == nil {
+			t.Errorf("Expected scopes %v to be rejected", scopes)
+		}
+	}
+
+	// Tokens survive a restart, and only the hash is written down
+	reloaded, err := LoadTokens(path)
+	if err != nil {
+		t.Fatalf("Failed to reload tokens: %v", err)
+	}
+	got, err := reloaded.Authenticate(secret)
+	if err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	if got.LastUsed == nil {
+		t.Error("Expected the token's last use to be recorded")
+	}
+	id := got.Identity()
+	if id.Username != "alice" || id.TokenID != token.ID || !id.Allows(ScopeRead) || !id.Allows(ScopeWrite) || id.Allows(ScopeAdmin) {
+		t.Errorf("Unexpected token identity %+v", id)
+	}
+	if list := reloaded.List("alice"); len(list) != 1 || list[0].LastUsed == nil {
+		t.Errorf("Expected alice's token with its last use, got %+v", list)
+	}
+	if list := reloaded.List("bob"); len(list) != 0 {
+		t.Errorf("Expected bob to have no tokens, got %+v", list)
+	}
+
+	for _, bad := range []string{"", "fishki_wrong", secret + "x", strings.TrimPrefix(secret, "fishki_")} {
+		if _, err := reloaded.Authenticate(bad); err != ErrInvalidToken {
+			t.Errorf("Expected %q to be rejected, got %v", bad, err)
+		}
+	}
+
+	// Only the owner can revoke a token
+	if err := reloaded.Revoke("bob", token.ID); err != ErrTokenNotFound {
+		t.Errorf("Expected bob to be unable to revoke alice's token, got %v", err)
+	}
+	if err := reloaded.Revoke("alice", token.ID); err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+	if _, err := reloaded.Authenticate(secret); err != ErrInvalidToken {
+		t.Errorf("Expected a revoked token to be rejected, got %v", err)
+	}
+
+	expiring, _, err := tokens.Create(alice, "short", []Scope{ScopeRead}, time.Nanosecond)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := tokens.Authenticate(expiring); err != ErrInvalidToken {
+		t.Errorf("Expected an expired token to be rejected, got %v", err)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	tests := []struct {
+		scopes []Scope
+		need   Scope
+		want   bool
+	}{
+		{[]Scope{ScopeRead}, ScopeRead, true},
+		{[]Scope{ScopeRead}, ScopeWrite, false},
+		{[]Scope{ScopeWrite}, ScopeRead, true},
+		{[]Scope{ScopeWrite}, ScopeAdmin, false},
+		{[]Scope{ScopeAdmin}, ScopeWrite, true},
+		{[]Scope{ScopeRead, ScopeAdmin}, ScopeAdmin, true},
+		{nil, ScopeRead, false},
+	}
+	for _, tt := range tests {
+		id := &Identity{Username: "alice", TokenID: "t", Scopes: tt.scopes}
+		if got := id.Allows(tt.need); got != tt.want {
+			t.Errorf("Scopes %v allowing %s: expected %v, got %v", tt.scopes, tt.need, tt.want, got)
+		}
+	}
+
+	if !(&Identity{Username: "alice"}).Allows(ScopeAdmin) {
+		t.Error("Expected a logged in user to have every scope")
+	}
+}
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the shortest password accepted for a local account
+const MinPasswordLength = 8
+
+var (
+	// ErrInvalidCredentials is returned when a username or password is wrong
+	ErrInvalidCredentials = errors.New("invalid username or password")
+
+	// ErrUserExists is returned when adding an account that already exists
+	ErrUserExists = errors.New("user already exists")
+
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$`)
+
+	// dummyHash is compared against when a user doesn't exist, so a login
+	// takes as long for unknown users as for wrong passwords
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// User is a local account
+type User struct {
+	Username     string    `json:"username"`
+	Name         string    `json:"name,omitempty"`
+	Email        string    `json:"email,omitempty"`
+	Groups       []string  `json:"groups,omitempty"`
+	PasswordHash string    `json:"passwordHash"`
+	Created      time.Time `json:"created"`
+}
+
+// Identity returns the identity a request made by the user carries
+func (u User) Identity() *Identity {
+	return &Identity{
+		Username: u.Username,
+		Name:     u.Name,
+		Email:    u.Email,
+		Groups:   u.Groups,
+	}
+}
+
+// UserStore keeps local accounts in a JSON file. The file is reloaded when
+// it changes, so accounts added with `fishki-server user add` take effect
+// without a restart
+type UserStore struct {
+	path    string
+	mu      sync.Mutex
+	users   map[string]User
+	modTime time.Time
+}
+
+// LoadUsers reads the accounts in path. A missing file is an empty store
+func LoadUsers(path string) (*UserStore, error) {
+	s := &UserStore{path: path, users: map[string]User{}}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the file again if it changed since it was last read. The
+// caller must hold s.mu, except while the store is being created
+func (s *UserStore) reload() error {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		s.users = map[string]User{}
+		s.modTime = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read users file: %v", err)
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read users file: %v", err)
+	}
+	var list []User
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("failed to parse users file: %v", err)
+	}
+	users := make(map[string]User, len(list))
+	for _, u := range list {
+		users[u.Username] = u
+	}
+	s.users = users
+	s.modTime = info.ModTime()
+	return nil
+}
+
+// save writes the accounts to the file
+func (s *UserStore) save() error {
+	list := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal users: %v", err)
+	}
+
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to write users file: %v", err)
+	}
+
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// Len returns the number of accounts. Authentication is required once there
+// is at least one
+func (s *UserStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Keep the accounts already loaded if the file has become unreadable
+	s.reload()
+	return len(s.users)
+}
+
+// Get returns the account for a username
+func (s *UserStore) Get(username string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reload()
+	u, ok := s.users[username]
+	return u, ok
+}
+
+// Add creates an account with a bcrypt hash of its password
+func (s *UserStore) Add(user User, password string) error {
+	if !usernamePattern.MatchString(user.Username) {
+		return fmt.Errorf("invalid username %q", user.Username)
+	}
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if _, ok := s.users[user.Username]; ok {
+		return ErrUserExists
+	}
+
+	user.PasswordHash = string(hash)
+	if user.Created.IsZero() {
+		user.Created = time.Now().UTC()
+	}
+	s.users[user.Username] = user
+	if err := s.save(); err != nil {
+		delete(s.users, user.Username)
+		return err
+	}
+	return nil
+}
+
+// Authenticate checks a username and password, returning the account
+func (s *UserStore) Authenticate(username, password string) (User, error) {
+	s.mu.Lock()
+	s.reload()
+	u, ok := s.users[username]
+	s.mu.Unlock()
+
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("fishki-dummy-password"), bcrypt.DefaultCost)
+	})
+	hash := dummyHash
+	if ok {
+		hash = []byte(u.PasswordHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !ok {
+		return User{}, ErrInvalidCredentials
+	}
+	return u, nil
+}
+
+// writeFileAtomic writes a private file through a temporary file renamed
+// into place, so a crash can't leave a truncated file behind
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
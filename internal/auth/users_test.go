@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fishki", "users.json")
+	users, err := LoadUsers(path)
+	if err != nil {
+		t.Fatalf("Failed to load missing users file: %v", err)
+	}
+	if users.Len() != 0 {
+		t.Fatalf("Expected no users, got %d", users.Len())
+	}
+
+	alice := User{Username: "alice", Name: "Alice", Email: "alice@example.com", Groups: []string{"editors"}}
+	if err := users.Add(alice, "correct horse"); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		user     User
+		password string
+		wantErr  string
+	}{
+		{"duplicate", User{Username: "alice"}, "another password", "already exists"},
+		{"short password", User{Username: "bob"}, "short", "at least"},
+		{"invalid username", User{Username: "../bob"}, "long enough", "invalid username"},
+		{"empty username", User{}, "long enough", "invalid username"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := users.Add(tt.user, tt.password)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	// Passwords are only stored hashed, and the file is private
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read users file: %v", err)
+	}
+	if strings.Contains(string(data), "correct horse") {
+		t.Error("Users file contains the plain text password")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm()&0077 != 0 {
+		t.Errorf("Expected a private users file, got %v (%v)", info.Mode(), err)
+	}
+
+	user, err := users.Authenticate("alice", "correct horse")
+	if err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	if id := user.Identity(); id.Username != "alice" || id.Email != "alice@example.com" || len(id.Groups) != 1 {
+		t.Errorf("Unexpected identity %+v", id)
+	}
+	if _, err := users.Authenticate("alice", "wrong horse"); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials for a wrong password, got %v", err)
+	}
+	if _, err := users.Authenticate("mallory", "correct horse"); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials for an unknown user, got %v", err)
+	}
+
+	// Accounts added by another process, such as `fishki-server user add`,
+	// are picked up without reloading by hand
+	other, err := LoadUsers(path)
+	if err != nil {
+		t.Fatalf("Failed to load users: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := other.Add(User{Username: "bob"}, "bobs password"); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+	if _, ok := users.Get("bob"); !ok {
+		t.Error("Expected the first store to see the new account")
+	}
+	if users.Len() != 2 {
+		t.Errorf("Expected 2 users, got %d", users.Len())
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	sessions := NewSessionStore(time.Hour)
+
+	session, err := sessions.Create(Identity{Username: "alice"})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	other, err := sessions.Create(Identity{Username: "alice"})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if session.ID == other.ID || len(session.ID) < 40 {
+		t.Errorf("Expected long unique session IDs, got %q and %q", session.ID, other.ID)
+	}
+
+	if got, ok := sessions.Get(session.ID); !ok || got.Identity.Username != "alice" {
+		t.Errorf("Expected the session for alice, got %+v", got)
+	}
+	if _, ok := sessions.Get("unknown"); ok {
+		t.Error("Expected no session for an unknown ID")
+	}
+
+	sessions.Delete(session.ID)
+	if _, ok := sessions.Get(session.ID); ok {
+		t.Error("Expected a deleted session to be gone")
+	}
+
+	expired := NewSessionStore(time.Nanosecond)
+	session, err = expired.Create(Identity{Username: "alice"})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, ok := expired.Get(session.ID); ok {
+		t.Error("Expected an expired session to be gone")
+	}
+}
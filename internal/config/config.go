@@ -1,67 +1,380 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/timhughes/fishki/internal/acl"
+	"github.com/timhughes/fishki/internal/audit"
+	"github.com/timhughes/fishki/internal/auth"
+	"github.com/timhughes/fishki/internal/markdown"
 )
 
 type Config struct {
-	WikiPath string `json:"wikiPath"`
+	WikiPath string       `json:"wikiPath"`
+	Server   ServerConfig `json:"server"`
+	Render   RenderConfig `json:"render"`
+	Auth     AuthConfig   `json:"auth"`
+
+	// RateLimit bounds how many requests each client can make
+	RateLimit RateLimitConfig `json:"rateLimit"`
+
+	// Git controls keeping the wiki in step with its remote
+	Git GitConfig `json:"git"`
+
+	// ACL restricts folders to some users or groups. It is combined with
+	// the wiki's own .fishki/acl.yaml, and wins where both name a path
+	ACL *acl.Policy `json:"acl,omitempty"`
+
+	// ReadOnly lets anyone read the wiki without logging in, while only
+	// editors can change it
+	ReadOnly bool `json:"readOnly,omitempty"`
+
+	// Editors lists who can change the wiki in read-only mode, as
+	// "user:<name>" or "group:<name>". Empty means anyone logged in
+	Editors []string `json:"editors,omitempty"`
+
+	// Audit sets where the record of changes is kept and how much of it
+	Audit AuditConfig `json:"audit"`
+
+	// ImportRoot is the folder on the server that imports through the API
+	// can read from. Without it the API refuses to import
+	ImportRoot string `json:"importRoot,omitempty"`
 }
 
-func LoadConfig() (*Config, error) {
-	configPath, err := getConfigPath()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get config path: %v", err)
+// ServerConfig is where the server listens
+type ServerConfig struct {
+	Bind string `json:"bind,omitempty"`
+	Port int    `json:"port,omitempty"`
+
+	// TLS serves HTTPS when both files are set
+	TLS TLSConfig `json:"tls"`
+}
+
+// TLSConfig names the certificate and key to serve HTTPS with
+type TLSConfig struct {
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+}
+
+// Enabled reports whether HTTPS is configured
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// RateLimitConfig caps requests per client per minute. A negative limit
+// turns it off
+type RateLimitConfig struct {
+	// Requests applies to every API request
+	Requests int `json:"requests,omitempty"`
+
+	// Login applies to logging in, to slow down password guessing
+	Login int `json:"login,omitempty"`
+}
+
+// GitConfig controls syncing the wiki with its git remote
+type GitConfig struct {
+	// AutoPush pushes after every change made through the wiki
+	AutoPush bool `json:"autoPush,omitempty"`
+
+	// SyncMinutes pulls and pushes on this interval. Zero turns it off
+	SyncMinutes int `json:"syncMinutes,omitempty"`
+}
+
+// AuditConfig controls the audit log
+type AuditConfig struct {
+	// Path is the log file, audit.log next to the config file by default
+	Path string `json:"path,omitempty"`
+
+	// MaxSizeMB is how large the log grows before it is rotated
+	MaxSizeMB int `json:"maxSizeMB,omitempty"`
+
+	// MaxFiles is how many rotated logs are kept
+	MaxFiles int `json:"maxFiles,omitempty"`
+}
+
+// AuthConfig holds the ways users can log in besides local accounts
+type AuthConfig struct {
+	// SessionHours is how long a login lasts
+	SessionHours int `json:"sessionHours,omitempty"`
+
+	// OIDC turns on single sign-on with an OpenID Connect provider
+	OIDC *auth.OIDCConfig `json:"oidc,omitempty"`
+
+	// Proxy takes the user from headers set by a trusted reverse proxy
+	Proxy *auth.ProxyConfig `json:"proxy,omitempty"`
+}
+
+// RenderConfig holds settings for the server-side markdown renderer
+type RenderConfig struct {
+	// Sanitizer extends the HTML allowlist applied to rendered pages
+	Sanitizer markdown.Allowlist `json:"sanitizer"`
+
+	// Highlight picks the syntax highlighting styles for each UI theme
+	Highlight HighlightConfig `json:"highlight"`
+
+	// Diagrams maps fenced code block languages such as "dot" or "mermaid"
+	// to the local command that renders them to SVG
+	Diagrams map[string]DiagramConfig `json:"diagrams,omitempty"`
+
+	// Admonitions adds callout types such as "example" to the built-in
+	// note, tip, warning and friends, mapping each to its default title
+	Admonitions map[string]string `json:"admonitions,omitempty"`
+
+	// CacheSize is how many bytes of rendered pages to keep in memory.
+	// Zero uses the default and a negative size turns the cache off
+	CacheSize int64 `json:"cacheSize,omitempty"`
+}
+
+// DiagramConfig is a local command that turns a fenced block into SVG, for
+// example ["dot", "-Tsvg"] or ["mmdc", "-i", "{input}", "-o", "{output}"]
+type DiagramConfig struct {
+	Command        []string `json:"command"`
+	TimeoutSeconds int      `json:"timeoutSeconds,omitempty"`
+}
+
+// HighlightConfig names the chroma styles served for the light and dark themes
+type HighlightConfig struct {
+	Light string `json:"light,omitempty"`
+	Dark  string `json:"dark,omitempty"`
+}
+
+// Defaults returns the settings used where the config file, environment and
+// flags leave a value unset
+func Defaults() *Config {
+	return &Config{
+		Server:    ServerConfig{Bind: "localhost", Port: 8080},
+		Auth:      AuthConfig{SessionHours: int(auth.DefaultSessionTTL / time.Hour)},
+		RateLimit: RateLimitConfig{Requests: 100, Login: 10},
+		Audit:     AuditConfig{MaxSizeMB: audit.DefaultMaxSize >> 20, MaxFiles: audit.DefaultMaxFiles},
+	}
+}
+
+// HighlightStyle returns the chroma style for a UI theme ("light" or "dark"),
+// falling back to the defaults when none is configured
+func (c *Config) HighlightStyle(theme string) string {
+	if theme == "dark" {
+		if c.Render.Highlight.Dark != "" {
+			return c.Render.Highlight.Dark
+		}
+		return markdown.DefaultDarkStyle
 	}
+	if c.Render.Highlight.Light != "" {
+		return c.Render.Highlight.Light
+	}
+	return markdown.DefaultLightStyle
+}
 
+// RenderCacheSize returns the size of the render cache in bytes, or zero
+// when caching is turned off
+func (c *Config) RenderCacheSize() int64 {
+	switch {
+	case c.Render.CacheSize < 0:
+		return 0
+	case c.Render.CacheSize == 0:
+		return markdown.DefaultCacheSize
+	}
+	return c.Render.CacheSize
+}
+
+// MarkdownOptions returns the renderer options for this config. The render
+// cache is left to the caller, so it can outlive the options
+func (c *Config) MarkdownOptions() markdown.Options {
+	opts := markdown.Options{
+		Allowlist:   c.Render.Sanitizer,
+		Admonitions: c.Render.Admonitions,
+	}
+
+	if len(c.Render.Diagrams) > 0 {
+		opts.Blocks = markdown.NewBlockRegistry()
+		for lang, diagram := range c.Render.Diagrams {
+			timeout := time.Duration(diagram.TimeoutSeconds) * time.Second
+			opts.Blocks.Register(lang, markdown.NewCommandRenderer(diagram.Command, timeout))
+		}
+	}
+
+	return opts
+}
+
+// Validate checks a config for mistakes that would otherwise only show up
+// once requests are served
+func (c *Config) Validate() error {
+	if c.ACL != nil {
+		if err := c.ACL.Validate(); err != nil {
+			return fmt.Errorf("invalid acl: %v", err)
+		}
+	}
+	for _, editor := range c.Editors {
+		if kind, name, _ := strings.Cut(editor, ":"); (kind != "user" && kind != "group") || name == "" {
+			return fmt.Errorf("invalid editor %q: use user:<name> or group:<name>", editor)
+		}
+	}
+	if c.Audit.MaxSizeMB < 0 || c.Audit.MaxFiles < 0 {
+		return fmt.Errorf("audit log limits can't be negative")
+	}
+	if c.Server.Port < 0 || c.Server.Port > 65535 {
+		return fmt.Errorf("invalid port %d", c.Server.Port)
+	}
+	if (c.Server.TLS.CertFile == "") != (c.Server.TLS.KeyFile == "") {
+		return fmt.Errorf("tls needs both a certificate and a key file")
+	}
+	if c.Auth.SessionHours < 0 {
+		return fmt.Errorf("session length can't be negative")
+	}
+	if c.Git.SyncMinutes < 0 {
+		return fmt.Errorf("git sync interval can't be negative")
+	}
+	return nil
+}
+
+// saveMu serialises writes to the config file, so concurrent updates can't
+// lose each other's changes
+var saveMu sync.Mutex
+
+// LoadConfig returns the effective config from the defaults, the config
+// file and the environment
+func LoadConfig() (*Config, error) {
+	cfg, _, err := Load()
+	return cfg, err
+}
+
+// readConfig reads and checks the config file at path. A missing file is
+// returned as is, so callers can tell it apart with os.IsNotExist
+func readConfig(configPath string) (*Config, error) {
 	data, err := os.ReadFile(configPath)
 	if err != nil {
-		// If the file doesn't exist, create a default config
 		if os.IsNotExist(err) {
-			cfg := &Config{WikiPath: ""}
-			if err := SaveConfig(cfg); err != nil {
-				return nil, fmt.Errorf("failed to save default config: %v", err)
-			}
-			return cfg, nil
+			return nil, err
 		}
 		return nil, fmt.Errorf("failed to read config file: %v", err)
 	}
 
 	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
+	if err := unmarshal(configPath, data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %v", err)
 	}
-
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file: %v", err)
+	}
 	return &cfg, nil
 }
 
+// SaveConfig validates cfg and writes it to the config file. The file is
+// replaced in one step, so a crash can't leave it half written
 func SaveConfig(cfg *Config) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	return saveConfig(cfg)
+}
+
+// saveConfig writes the config file. The caller must hold saveMu
+func saveConfig(cfg *Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
 	configPath, err := getConfigPath()
 	if err != nil {
 		return fmt.Errorf("failed to get config path: %v", err)
 	}
 
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	data, err := marshal(configPath, cfg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %v", err)
 	}
 
-	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+	// The file can hold secrets, so it is private unless it already exists
+	// with a mode of its own
+	if err := os.MkdirAll(filepath.Dir(configPath), 0700); err != nil {
 		return fmt.Errorf("failed to create config directory: %v", err)
 	}
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(configPath); err == nil {
+		perm = info.Mode().Perm()
+	}
 
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
+	if err := writeFileAtomic(configPath, data, perm); err != nil {
 		return fmt.Errorf("failed to write config file: %v", err)
 	}
 
 	return nil
 }
 
+// Update applies a change to the config file as it is on disk, leaving out
+// anything only set for this run such as command line flags. The change is
+// validated before the file is replaced
+func Update(change func(*Config)) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	configPath, err := getConfigPath()
+	if err != nil {
+		return fmt.Errorf("failed to get config path: %v", err)
+	}
+	cfg, err := readConfig(configPath)
+	if os.IsNotExist(err) {
+		cfg, err = &Config{}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	change(cfg)
+	return saveConfig(cfg)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// configFile is the config file chosen on the command line, if any
+var configFile string
+
+// SetPath makes the config file at path the one loaded and saved, instead
+// of looking for one in the user's config directory
+func SetPath(path string) {
+	configFile = path
+}
+
+// getConfigPath returns the config file: the one set with SetPath or
+// FISHKI_CONFIG, or else the first of config.json, config.yaml, config.yml
+// and config.toml in the user's config directory
 func getConfigPath() (string, error) {
+	if configFile != "" {
+		return configFile, nil
+	}
+	if path := os.Getenv("FISHKI_CONFIG"); path != "" {
+		return path, nil
+	}
+
 	var configDir string
 	switch runtime.GOOS {
 	case "linux":
@@ -73,6 +386,11 @@ func getConfigPath() (string, error) {
 	default:
 		return "", fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
 	}
+	for _, name := range []string{"config.json", "config.yaml", "config.yml", "config.toml"} {
+		if _, err := os.Stat(filepath.Join(configDir, name)); err == nil {
+			return filepath.Join(configDir, name), nil
+		}
+	}
 	return filepath.Join(configDir, "config.json"), nil
 }
 
@@ -80,3 +398,36 @@ func getConfigPath() (string, error) {
 func GetConfigPath() (string, error) {
 	return getConfigPath()
 }
+
+// GetUsersPath returns the path to the local accounts file, kept next to
+// the config file
+func GetUsersPath() (string, error) {
+	configPath, err := getConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(configPath), "users.json"), nil
+}
+
+// GetAuditPath returns the path to the audit log, kept next to the config
+// file unless the config names another
+func (c *Config) GetAuditPath() (string, error) {
+	if c.Audit.Path != "" {
+		return c.Audit.Path, nil
+	}
+	configPath, err := getConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(configPath), "audit.log"), nil
+}
+
+// GetTokensPath returns the path to the API tokens file, kept next to the
+// config file
+func GetTokensPath() (string, error) {
+	configPath, err := getConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(configPath), "tokens.json"), nil
+}
@@ -6,6 +6,8 @@ import (
 	"path/filepath"
 	"runtime"
 	"testing"
+
+	"github.com/timhughes/fishki/internal/markdown"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -133,6 +135,78 @@ func TestSaveConfig(t *testing.T) {
 	if savedCfg.WikiPath != cfg.WikiPath {
 		t.Errorf("expected WikiPath %q, got %q", cfg.WikiPath, savedCfg.WikiPath)
 	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	// New files are private, and existing files keep their mode
+	for path, want := range map[string]os.FileMode{configPath: 0600, filepath.Dir(configPath): 0700} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("expected %s to have mode %v, got %v", path, want, info.Mode().Perm())
+		}
+	}
+	if err := os.Chmod(configPath, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatalf("SaveConfig() error = %v", err)
+	}
+	info, err := os.Stat(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("expected the file to keep mode 0640, got %v", info.Mode().Perm())
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("APPDATA", t.TempDir())
+
+	if err := SaveConfig(&Config{Render: RenderConfig{CacheSize: 1024}}); err != nil {
+		t.Fatalf("SaveConfig() error = %v", err)
+	}
+	if err := Update(func(c *Config) { c.WikiPath = "/new/wiki" }); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.WikiPath != "/new/wiki" || cfg.Render.CacheSize != 1024 {
+		t.Errorf("expected the change with the other settings kept, got %+v", cfg)
+	}
+
+	// Invalid changes leave the file alone
+	if err := Update(func(c *Config) { c.Editors = []string{"alice"} }); err == nil {
+		t.Error("expected an invalid editor to be rejected")
+	}
+	if err := SaveConfig(&Config{Audit: AuditConfig{MaxFiles: -1}}); err == nil {
+		t.Error("expected negative audit limits to be rejected")
+	}
+	if cfg, err := LoadConfig(); err != nil || len(cfg.Editors) != 0 || cfg.WikiPath != "/new/wiki" {
+		t.Errorf("expected the config file to be unchanged, got %+v, %v", cfg, err)
+	}
+
+	// The file is replaced in one step, leaving no temporary files behind
+	configPath, err := getConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(configPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the config file, got %v", entries)
+	}
 }
 
 func TestGetConfigPath(t *testing.T) {
@@ -170,3 +244,45 @@ func TestGetConfigPath(t *testing.T) {
 		})
 	}
 }
+
+func TestMarkdownOptions(t *testing.T) {
+	data := []byte(`{"wikiPath": "/wiki", "render": {"sanitizer": {"elements": ["kbd"], "urlSchemes": ["ssh"]}, "diagrams": {"dot": {"command": ["dot", "-Tsvg"]}}, "admonitions": {"example": "Example"}}}`)
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := cfg.MarkdownOptions()
+	if len(opts.Allowlist.Elements) != 1 || opts.Allowlist.Elements[0] != "kbd" {
+		t.Errorf("expected kbd in allowed elements, got %v", opts.Allowlist.Elements)
+	}
+	if len(opts.Allowlist.URLSchemes) != 1 || opts.Allowlist.URLSchemes[0] != "ssh" {
+		t.Errorf("expected ssh in allowed URL schemes, got %v", opts.Allowlist.URLSchemes)
+	}
+
+	if _, ok := opts.Blocks.Lookup("dot"); !ok {
+		t.Error("expected a block renderer for dot diagrams")
+	}
+
+	if opts.Admonitions["example"] != "Example" {
+		t.Errorf("expected example admonition, got %v", opts.Admonitions)
+	}
+}
+
+func TestRenderCacheSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int64
+	}{
+		{0, markdown.DefaultCacheSize},
+		{1024, 1024},
+		{-1, 0},
+	}
+	for _, tt := range tests {
+		cfg := Config{Render: RenderConfig{CacheSize: tt.size}}
+		if got := cfg.RenderCacheSize(); got != tt.want {
+			t.Errorf("RenderCacheSize() with %d = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// format returns the syntax of a config file from its extension: "yaml",
+// "toml" or, for anything else, "json"
+func format(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return "json"
+}
+
+// unmarshal parses a config file into v. YAML and TOML are decoded to plain
+// values and then go through JSON, so every format uses the same names
+func unmarshal(path string, data []byte, v any) error {
+	var raw any
+	switch format(path) {
+	case "yaml":
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+	case "toml":
+		var table map[string]any
+		if err := toml.Unmarshal(data, &table); err != nil {
+			return err
+		}
+		raw = table
+	default:
+		return json.Unmarshal(data, v)
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// marshal writes a config in the format of the file at path
+func marshal(path string, cfg *Config) ([]byte, error) {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil || format(path) == "json" {
+		return data, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw map[string]any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	raw = plain(raw).(map[string]any)
+
+	if format(path) == "yaml" {
+		return yaml.Marshal(raw)
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(raw); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// plain turns JSON numbers back into ints and floats. It drops nulls, which
+// TOML has no way to write, and empty sections
+func plain(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			value = plain(value)
+			if table, ok := value.(map[string]any); value == nil || (ok && len(table) == 0) {
+				delete(v, key)
+				continue
+			}
+			v[key] = value
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = plain(value)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
@@ -0,0 +1,302 @@
+package config
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Override sets one setting from outside the config file, such as a
+// command line flag
+type Override struct {
+	// Key names the setting, such as "server.port"
+	Key   string
+	Value string
+
+	// Source says where the value came from, such as "flag -port"
+	Source string
+}
+
+// Sources records where each setting's effective value came from:
+// "default", "file", "env FISHKI_..." or an override's source
+type Sources map[string]string
+
+// Setting is one effective setting, for showing the config to a user
+type Setting struct {
+	Key    string
+	Value  string
+	Source string
+
+	// Env is the environment variable that sets it, or empty for settings
+	// that only the config file can set
+	Env string
+}
+
+// legacyEnv maps environment variables from before FISHKI_* to the
+// settings they set. The FISHKI_* names win over them
+var legacyEnv = []struct{ name, key string }{
+	{"PORT", "server.port"},
+}
+
+// Load returns the effective config and where each setting came from. It
+// starts from the defaults, then applies the config file, then FISHKI_*
+// environment variables such as FISHKI_SERVER_PORT, then the overrides.
+// Empty environment variables are ignored. A missing config file is
+// created empty
+func Load(overrides ...Override) (*Config, Sources, error) {
+	configPath, err := getConfigPath()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get config path: %v", err)
+	}
+
+	cfg := Defaults()
+	sources := Sources{}
+	for _, f := range schema() {
+		sources[f.key] = "default"
+	}
+
+	data, err := os.ReadFile(configPath)
+	switch {
+	case os.IsNotExist(err):
+		if err := SaveConfig(&Config{}); err != nil {
+			return nil, nil, fmt.Errorf("failed to save default config: %v", err)
+		}
+	case err != nil:
+		return nil, nil, fmt.Errorf("failed to read config file: %v", err)
+	default:
+		var raw any
+		if err := unmarshal(configPath, data, cfg); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse config file: %v", err)
+		}
+		if err := unmarshal(configPath, data, &raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse config file: %v", err)
+		}
+		for _, f := range schema() {
+			if inFile(raw, f.key) {
+				sources[f.key] = "file"
+			}
+		}
+	}
+
+	var env []Override
+	for _, legacy := range legacyEnv {
+		if value := os.Getenv(legacy.name); value != "" {
+			env = append(env, Override{Key: legacy.key, Value: value, Source: "env " + legacy.name})
+		}
+	}
+	for _, f := range schema() {
+		name := EnvName(f.key)
+		if value := os.Getenv(name); value != "" && f.scalar {
+			env = append(env, Override{Key: f.key, Value: value, Source: "env " + name})
+		}
+	}
+
+	for _, o := range append(env, overrides...) {
+		if err := set(cfg, o.Key, o.Value); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %v", o.Source, err)
+		}
+		sources[o.Key] = o.Source
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid config: %v", err)
+	}
+	return cfg, sources, nil
+}
+
+// Settings lists every setting in cfg with where it came from. Secrets
+// are hidden
+func Settings(cfg *Config, sources Sources) []Setting {
+	var settings []Setting
+	for _, f := range schema() {
+		value := "null"
+		if v, ok := f.get(reflect.ValueOf(cfg).Elem()); ok {
+			if f.secret && !v.IsZero() {
+				value = `"<hidden>"`
+			} else if data, err := json.Marshal(v.Interface()); err == nil {
+				value = string(data)
+			}
+		}
+		source := sources[f.key]
+		if source == "" {
+			source = "default"
+		}
+		setting := Setting{Key: f.key, Value: value, Source: source}
+		if f.scalar {
+			setting.Env = EnvName(f.key)
+		}
+		settings = append(settings, setting)
+	}
+	return settings
+}
+
+// EnvName returns the environment variable that sets a setting, such as
+// FISHKI_SERVER_TLS_CERT_FILE for "server.tls.certFile"
+func EnvName(key string) string {
+	var name strings.Builder
+	name.WriteString("FISHKI")
+	for _, part := range strings.Split(key, ".") {
+		name.WriteByte('_')
+		runes := []rune(part)
+		for i, r := range runes {
+			if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				name.WriteByte('_')
+			}
+			name.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return name.String()
+}
+
+// field is one setting in the config, found by its JSON names
+type field struct {
+	key    string
+	index  []int
+	secret bool
+
+	// scalar fields can be set from a string, by flags and the environment
+	scalar bool
+}
+
+// get returns the field's value, or false when it sits under a nil pointer
+func (f field) get(v reflect.Value) (reflect.Value, bool) {
+	v, err := v.FieldByIndexErr(f.index)
+	return v, err == nil
+}
+
+// schema lists the settings in Config. Structs and pointers to structs are
+// walked into; everything else, maps and lists included, is one setting
+func schema() []field {
+	var fields []field
+	var walk func(t reflect.Type, prefix string, index []int)
+	walk = func(t reflect.Type, prefix string, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+			if !sf.IsExported() || name == "-" || name == "" {
+				continue
+			}
+			key := prefix + name
+			idx := append(append([]int{}, index...), i)
+
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && !reflect.PointerTo(ft).Implements(textUnmarshaler) {
+				walk(ft, key+".", idx)
+				continue
+			}
+			lower := strings.ToLower(name)
+			fields = append(fields, field{
+				key:    key,
+				index:  idx,
+				secret: strings.Contains(lower, "secret") || strings.Contains(lower, "password"),
+				scalar: scalar(ft),
+			})
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "", nil)
+	return fields
+}
+
+var textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// scalar reports whether set can parse a value of type t from a string
+func scalar(t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(textUnmarshaler) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// inFile reports whether a decoded config file sets a key
+func inFile(raw any, key string) bool {
+	for _, part := range strings.Split(key, ".") {
+		table, ok := raw.(map[string]any)
+		if !ok {
+			return false
+		}
+		if raw, ok = table[part]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// set sets a setting from a string. Lists are comma-separated; maps and
+// other structured settings can only be set in the config file
+func set(cfg *Config, key, value string) error {
+	var f *field
+	for _, candidate := range schema() {
+		if candidate.key == key {
+			f = &candidate
+			break
+		}
+	}
+	if f == nil {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	if !f.scalar {
+		return fmt.Errorf("%s can only be set in the config file", key)
+	}
+
+	// Walk down to the field, creating structs behind nil pointers
+	v := reflect.ValueOf(cfg).Elem()
+	for i, n := range f.index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(n)
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false", key)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a whole number", key)
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	}
+	return nil
+}
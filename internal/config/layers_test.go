@@ -0,0 +1,151 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/timhughes/fishki/internal/acl"
+)
+
+func TestLoadLayers(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("APPDATA", t.TempDir())
+
+	files := map[string]string{
+		"config.json": `{"wikiPath": "/wiki", "server": {"port": 9000, "bind": "0.0.0.0"}, "auth": {"oidc": {"clientSecret": "s3cret"}}}`,
+		"config.yaml": "wikiPath: /wiki\nserver:\n  port: 9000\n  bind: 0.0.0.0\nauth:\n  oidc:\n    clientSecret: s3cret\n",
+		"config.toml": "wikiPath = \"/wiki\"\n[server]\nport = 9000\nbind = \"0.0.0.0\"\n[auth.oidc]\nclientSecret = \"s3cret\"\n",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			SetPath(path)
+			defer SetPath("")
+
+			t.Setenv("PORT", "7000")
+			t.Setenv("FISHKI_SERVER_PORT", "9100")
+			t.Setenv("FISHKI_RATE_LIMIT_LOGIN", "5")
+			t.Setenv("FISHKI_EDITORS", "group:editors, user:alice")
+			t.Setenv("FISHKI_ACL_DEFAULT", "read")
+			t.Setenv("FISHKI_READ_ONLY", "")
+
+			cfg, sources, err := Load(
+				Override{Key: "server.bind", Value: "127.0.0.1", Source: "flag -bind"},
+				Override{Key: "readOnly", Value: "true", Source: "flag -read-only"},
+			)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			tests := []struct {
+				key    string
+				got    any
+				want   any
+				source string
+			}{
+				{"wikiPath", cfg.WikiPath, "/wiki", "file"},
+				{"server.port", cfg.Server.Port, 9100, "env FISHKI_SERVER_PORT"},
+				{"server.bind", cfg.Server.Bind, "127.0.0.1", "flag -bind"},
+				{"readOnly", cfg.ReadOnly, true, "flag -read-only"},
+				{"rateLimit.requests", cfg.RateLimit.Requests, 100, "default"},
+				{"rateLimit.login", cfg.RateLimit.Login, 5, "env FISHKI_RATE_LIMIT_LOGIN"},
+				{"editors", strings.Join(cfg.Editors, ","), "group:editors,user:alice", "env FISHKI_EDITORS"},
+				{"acl.default", *cfg.ACL.Default, acl.Read, "env FISHKI_ACL_DEFAULT"},
+				{"auth.oidc.clientSecret", cfg.Auth.OIDC.ClientSecret, "s3cret", "file"},
+			}
+			for _, tt := range tests {
+				if tt.got != tt.want || sources[tt.key] != tt.source {
+					t.Errorf("%s = %v from %q, want %v from %q", tt.key, tt.got, sources[tt.key], tt.want, tt.source)
+				}
+			}
+
+			for _, s := range Settings(cfg, sources) {
+				if strings.Contains(s.Value, "s3cret") {
+					t.Errorf("Expected %s to be hidden, got %s", s.Key, s.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("APPDATA", t.TempDir())
+
+	tests := []struct {
+		name      string
+		env       map[string]string
+		overrides []Override
+	}{
+		{"bad number", map[string]string{"FISHKI_SERVER_PORT": "http"}, nil},
+		{"bad bool", map[string]string{"FISHKI_READ_ONLY": "maybe"}, nil},
+		{"invalid result", map[string]string{"FISHKI_SERVER_TLS_CERT_FILE": "cert.pem"}, nil},
+		{"unknown setting", nil, []Override{{Key: "server.host", Value: "x", Source: "flag -host"}}},
+		{"file only setting", nil, []Override{{Key: "render.diagrams", Value: "dot", Source: "flag -diagrams"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			if _, _, err := Load(tt.overrides...); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestUpdateKeepsFormat(t *testing.T) {
+	for _, name := range []string{"config.yaml", "config.toml"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			SetPath(path)
+			defer SetPath("")
+
+			if err := SaveConfig(&Config{Render: RenderConfig{CacheSize: 1024}, Git: GitConfig{SyncMinutes: 15}}); err != nil {
+				t.Fatalf("SaveConfig() error = %v", err)
+			}
+			if err := Update(func(c *Config) { c.WikiPath = "/new/wiki" }); err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(data), "{") {
+				t.Errorf("Expected %s to be written as %s, got:\n%s", name, format(path), data)
+			}
+			cfg, sources, err := Load()
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if cfg.WikiPath != "/new/wiki" || cfg.Render.CacheSize != 1024 || cfg.Git.SyncMinutes != 15 {
+				t.Errorf("Expected the change with the other settings kept, got %+v", cfg)
+			}
+			if cfg.Server.Port != 8080 || sources["server.port"] != "default" {
+				t.Errorf("Expected unset settings to keep their defaults, got %d from %q", cfg.Server.Port, sources["server.port"])
+			}
+		})
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"wikiPath":            "FISHKI_WIKI_PATH",
+		"server.tls.certFile": "FISHKI_SERVER_TLS_CERT_FILE",
+		"audit.maxSizeMB":     "FISHKI_AUDIT_MAX_SIZE_MB",
+		"auth.oidc.clientId":  "FISHKI_AUTH_OIDC_CLIENT_ID",
+		"render.cacheSize":    "FISHKI_RENDER_CACHE_SIZE",
+	}
+	for key, want := range tests {
+		if got := EnvName(key); got != want {
+			t.Errorf("EnvName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
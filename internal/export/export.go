@@ -0,0 +1,704 @@
+package export
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+
+	"github.com/timhughes/fishki/internal/acl"
+	"github.com/timhughes/fishki/internal/config"
+	"github.com/timhughes/fishki/internal/markdown"
+)
+
+const (
+	// manifestName records what the last export wrote, for incremental exports
+	manifestName = ".fishki-export.json"
+
+	// assetsDir holds the stylesheets shared by every exported page
+	assetsDir = "_fishki"
+
+	// manifestVersion changes whenever the exported HTML changes shape,
+	// so an upgrade re-renders everything
+	manifestVersion = 1
+)
+
+// Options controls a static site export
+type Options struct {
+	// OutDir is where the site is written. It must be outside the wiki
+	OutDir string
+
+	// Incremental only re-renders pages whose content changed since the
+	// last export to OutDir
+	Incremental bool
+}
+
+// Result summarises what an export did
+type Result struct {
+	Pages       int `json:"pages"`
+	Rendered    int `json:"rendered"`
+	Unchanged   int `json:"unchanged"`
+	Folders     int `json:"folders"`
+	Attachments int `json:"attachments"`
+	Removed     int `json:"removed"`
+}
+
+// siteManifest is stored in the output directory after each export
+type siteManifest struct {
+	Version     int               `json:"version"`
+	Settings    string            `json:"settings"`
+	Tree        string            `json:"tree"`
+	Pages       map[string]string `json:"pages"`
+	Attachments map[string]string `json:"attachments"`
+}
+
+// Site writes the wiki at cfg.WikiPath as a static HTML site. Every page is
+// rendered with renderer, so it matches what the server shows, internal
+// links point at the exported .html files, folders get an index page and
+// every page carries a navigation sidebar of the whole wiki
+func Site(cfg *config.Config, renderer *markdown.Renderer, opts Options) (*Result, error) {
+	if cfg.WikiPath == "" {
+		return nil, fmt.Errorf("wiki path not set")
+	}
+	if opts.OutDir == "" {
+		return nil, fmt.Errorf("output directory is required")
+	}
+
+	root, err := filepath.Abs(cfg.WikiPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve wiki path: %v", err)
+	}
+	outDir, err := filepath.Abs(opts.OutDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve output directory: %v", err)
+	}
+	if rel, err := filepath.Rel(root, outDir); err == nil && !strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("output directory must be outside the wiki")
+	}
+
+	w, err := openWiki(cfg, root)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := w.readTree("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wiki: %v", err)
+	}
+	site := newSite(tree)
+
+	settings, err := json.Marshal(cfg.Render)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash render settings: %v", err)
+	}
+	treeJSON, _ := json.Marshal(tree)
+	manifest := &siteManifest{
+		Version:     manifestVersion,
+		Settings:    hashHex(settings),
+		Tree:        hashHex(treeJSON),
+		Pages:       make(map[string]string),
+		Attachments: make(map[string]string),
+	}
+
+	old, err := readManifest(outDir)
+	if err != nil {
+		old = &siteManifest{}
+	}
+
+	// Only reuse pages from the previous export when nothing that affects
+	// every page, such as the sidebar or render settings, has changed.
+	// Attachments are copied as they are, so only their own changes matter
+	previous := &siteManifest{}
+	if opts.Incremental {
+		previous.Attachments = old.Attachments
+		if old.Version == manifest.Version && old.Settings == manifest.Settings && old.Tree == manifest.Tree {
+			previous.Pages = old.Pages
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(outDir, assetsDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %v", err)
+	}
+	if err := writeAssets(cfg, outDir); err != nil {
+		return nil, err
+	}
+
+	// Drop pages that no longer exist in the wiki first, so a deleted
+	// index.md doesn't take its folder's generated index with it
+	result := &Result{}
+	for page := range old.Pages {
+		if !site.isPage[page] {
+			os.Remove(filepath.Join(outDir, filepath.FromSlash(pagePath(page))))
+			result.Removed++
+		}
+	}
+
+	for _, page := range site.pages {
+		content, err := w.loadPage(page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", page, err)
+		}
+		content = markdown.ExpandIncludes(content, page, w.loadPage)
+		result.Pages++
+
+		hash := hashHex(content)
+		manifest.Pages[page] = hash
+		target := filepath.Join(outDir, filepath.FromSlash(pagePath(page)))
+		if previous.Pages[page] == hash && fileExists(target) {
+			result.Unchanged++
+			continue
+		}
+
+		rendered := renderer.RenderPage(content)
+		title := rendered.Title
+		if title == "" {
+			title = strings.TrimSuffix(path.Base(page), ".md")
+		}
+		body := rewriteLinks(rendered.HTML, page, site)
+		if err := writeFile(target, site.renderShell(page, title, body)); err != nil {
+			return nil, err
+		}
+		result.Rendered++
+	}
+
+	// Folders without their own index.md get a generated listing
+	for _, folder := range site.folders {
+		if site.isPage[path.Join(folder, "index.md")] {
+			continue
+		}
+		result.Folders++
+		page := path.Join(folder, "index.md")
+		title := path.Base(folder)
+		if folder == "" {
+			title = filepath.Base(root)
+		}
+		target := filepath.Join(outDir, filepath.FromSlash(pagePath(page)))
+		if err := writeFile(target, site.renderShell(page, title, site.renderListing(folder))); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.copyAttachments(outDir, previous, manifest, result); err != nil {
+		return nil, err
+	}
+
+	// Drop attachments that no longer exist in the wiki
+	for file := range old.Attachments {
+		if _, ok := manifest.Attachments[file]; !ok {
+			os.Remove(filepath.Join(outDir, filepath.FromSlash(file)))
+			result.Removed++
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode export manifest: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outDir, manifestName), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write export manifest: %v", err)
+	}
+
+	return result, nil
+}
+
+// entry is a page or folder in the wiki
+type entry struct {
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Path     string  `json:"path"`
+	Children []entry `json:"children,omitempty"`
+}
+
+// wiki is the part of a wiki an export publishes: what anonymous visitors
+// can read under its access policy
+type wiki struct {
+	root     string
+	realRoot string
+	policy   acl.Policy
+}
+
+// openWiki loads the access policy of the wiki at root, the policy file in
+// the wiki with the config's rules laid on top, as the server applies it
+func openWiki(cfg *config.Config, root string) (*wiki, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve wiki path: %v", err)
+	}
+	policy, err := (&acl.FileCache{}).Load(root)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ACL != nil {
+		policy = policy.Merge(*cfg.ACL)
+	}
+	return &wiki{root: root, realRoot: realRoot, policy: policy}, nil
+}
+
+// canRead reports whether anonymous visitors may read a path
+func (w *wiki) canRead(p string) bool {
+	return w.policy.Access(nil, p) >= acl.Read
+}
+
+// resolve returns the wiki path a page leads to once symlinks are
+// followed, or "" when it or the page it leads to can't be published:
+// outside the wiki, hidden or not readable by anonymous visitors
+func (w *wiki) resolve(page string) string {
+	page = acl.Clean(page)
+	if !w.canRead(page) {
+		return ""
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(w.realRoot, filepath.FromSlash(page)))
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(w.realRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+	if hidden(rel) || !w.canRead(rel) {
+		return ""
+	}
+	return rel
+}
+
+// hidden reports whether a wiki path is in, or is, a hidden file or folder
+// such as .git
+func hidden(rel string) bool {
+	for _, part := range strings.Split(rel, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// readTree lists the pages and folders under dir, a path relative to the
+// root, with folders first. Hidden files and folders, such as .git, and
+// pages that can't be published are left out, as are folders left empty
+// that anonymous visitors can't read
+func (w *wiki) readTree(dir string) ([]entry, error) {
+	items, err := os.ReadDir(filepath.Join(w.root, filepath.FromSlash(dir)))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	for _, item := range items {
+		name := item.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		p := path.Join(dir, name)
+		switch {
+		case item.IsDir():
+			children, err := w.readTree(p)
+			if err != nil {
+				return nil, err
+			}
+			if len(children) == 0 && !w.canRead(p) {
+				continue
+			}
+			entries = append(entries, entry{Name: name, Type: "folder", Path: p, Children: children})
+		case path.Ext(name) == ".md" && w.resolve(p) != "":
+			entries = append(entries, entry{Name: name, Type: "file", Path: p})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Type != entries[j].Type {
+			return entries[i].Type == "folder"
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// loadPage reads a page, treating pages that can't be published as missing
+func (w *wiki) loadPage(page string) ([]byte, error) {
+	rel := w.resolve(page)
+	if rel == "" {
+		return nil, fmt.Errorf("page not found")
+	}
+	content, err := os.ReadFile(filepath.Join(w.realRoot, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page")
+	}
+	return content, nil
+}
+
+// wikiSite is the wiki's structure as seen by the exporter
+type wikiSite struct {
+	tree     []entry
+	pages    []string
+	folders  []string
+	isPage   map[string]bool
+	isFolder map[string]bool
+}
+
+func newSite(tree []entry) *wikiSite {
+	site := &wikiSite{
+		tree:     tree,
+		folders:  []string{""},
+		isPage:   make(map[string]bool),
+		isFolder: map[string]bool{"": true},
+	}
+	var walk func(entries []entry)
+	walk = func(entries []entry) {
+		for _, entry := range entries {
+			p := filepath.ToSlash(entry.Path)
+			if entry.Type == "folder" {
+				site.folders = append(site.folders, p)
+				site.isFolder[p] = true
+				walk(entry.Children)
+			} else {
+				site.pages = append(site.pages, p)
+				site.isPage[p] = true
+			}
+		}
+	}
+	walk(tree)
+	return site
+}
+
+// pagePath returns where a page is written in the exported site
+func pagePath(page string) string {
+	return strings.TrimSuffix(page, ".md") + ".html"
+}
+
+// relativeTo returns target as a link relative to the page at from
+func relativeTo(from, target string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(path.Dir("/"+from)), filepath.FromSlash("/"+target))
+	if err != nil {
+		return target
+	}
+	return filepath.ToSlash(rel)
+}
+
+// resolveLink maps a link in a page to its target in the exported site. ok
+// is false for links that should be left alone, such as external URLs
+func (s *wikiSite) resolveLink(page, link string) (string, bool) {
+	if link == "" || strings.HasPrefix(link, "#") {
+		return "", false
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+
+	var target string
+	switch {
+	case strings.HasPrefix(u.Path, "/page/"):
+		// The frontend's route for a page
+		target = strings.TrimPrefix(u.Path, "/page/")
+	case strings.HasPrefix(u.Path, "/"):
+		target = strings.TrimPrefix(u.Path, "/")
+	default:
+		target = path.Join(path.Dir(page), u.Path)
+	}
+	if target == ".." || strings.HasPrefix(target, "../") {
+		return "", false
+	}
+	target = strings.TrimSuffix(path.Clean("/" + target)[1:], "/")
+
+	switch {
+	case s.isFolder[target]:
+		target = pagePath(path.Join(target, "index.md"))
+	case s.isPage[target]:
+		target = pagePath(target)
+	case s.isPage[target+".md"]:
+		target = pagePath(target + ".md")
+	}
+
+	u.Path = relativeTo(page, target)
+	if u.Path == "" {
+		u.Path = "."
+	}
+	return u.String(), true
+}
+
+// rewriteLinks points links and images in rendered HTML at the
+// exported site
+func rewriteLinks(body, page string, site *wikiSite) string {
+	var out strings.Builder
+	z := xhtml.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+		raw := z.Raw()
+		if tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken {
+			out.Write(raw)
+			continue
+		}
+
+		token := z.Token()
+		changed := false
+		for i, attr := range token.Attr {
+			if (token.Data == "a" && attr.Key == "href") || (token.Data == "img" && attr.Key == "src") {
+				if link, ok := site.resolveLink(page, attr.Val); ok {
+					token.Attr[i].Val = link
+					changed = true
+				}
+			}
+		}
+		if changed {
+			out.WriteString(token.String())
+		} else {
+			out.Write(raw)
+		}
+	}
+	return out.String()
+}
+
+// renderShell wraps a page body in the site layout with its sidebar
+func (s *wikiSite) renderShell(page, title, body string) []byte {
+	assets := relativeTo(page, assetsDir)
+
+	var b bytes.Buffer
+	b.WriteString("<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n")
+	b.WriteString("<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(title))
+	fmt.Fprintf(&b, "<link rel=\"stylesheet\" href=\"%s/site.css\">\n", assets)
+	fmt.Fprintf(&b, "<link rel=\"stylesheet\" href=\"%s/highlight.css\">\n", assets)
+	b.WriteString("</head>\n<body>\n<nav class=\"sidebar\">\n")
+	fmt.Fprintf(&b, "<a class=\"home\" href=\"%s\">Home</a>\n", html.EscapeString(relativeTo(page, "index.html")))
+	s.writeSidebar(&b, page, s.tree)
+	b.WriteString("</nav>\n<main class=\"content\">\n")
+	b.WriteString(body)
+	b.WriteString("</main>\n</body>\n</html>\n")
+	return b.Bytes()
+}
+
+// writeSidebar writes the navigation tree with links relative to page
+func (s *wikiSite) writeSidebar(b *bytes.Buffer, page string, entries []entry) {
+	b.WriteString("<ul>\n")
+	for _, entry := range entries {
+		p := filepath.ToSlash(entry.Path)
+		if entry.Type == "folder" {
+			link := relativeTo(page, pagePath(path.Join(p, "index.md")))
+			fmt.Fprintf(b, "<li class=\"folder\"><a href=\"%s\">%s</a>\n", html.EscapeString(link), html.EscapeString(entry.Name))
+			s.writeSidebar(b, page, entry.Children)
+			b.WriteString("</li>\n")
+			continue
+		}
+		if path.Base(p) == "index.md" {
+			// Reached through its folder
+			continue
+		}
+		class := "page"
+		if p == page {
+			class += " current"
+		}
+		link := relativeTo(page, pagePath(p))
+		fmt.Fprintf(b, "<li class=\"%s\"><a href=\"%s\">%s</a></li>\n", class, html.EscapeString(link), html.EscapeString(strings.TrimSuffix(entry.Name, ".md")))
+	}
+	b.WriteString("</ul>\n")
+}
+
+// renderListing returns the body of a generated folder index page
+func (s *wikiSite) renderListing(folder string) string {
+	page := path.Join(folder, "index.md")
+	title := path.Base(folder)
+	if folder == "" {
+		title = "Pages"
+	}
+
+	entries := s.tree
+	if folder != "" {
+		entries = findFolder(s.tree, folder)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<h1>%s</h1>\n<ul class=\"listing\">\n", html.EscapeString(title))
+	for _, entry := range entries {
+		p := filepath.ToSlash(entry.Path)
+		name := entry.Name
+		target := pagePath(p)
+		if entry.Type == "folder" {
+			target = pagePath(path.Join(p, "index.md"))
+			name += "/"
+		} else {
+			name = strings.TrimSuffix(name, ".md")
+		}
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(relativeTo(page, target)), html.EscapeString(name))
+	}
+	b.WriteString("</ul>\n")
+	return b.String()
+}
+
+// findFolder returns the children of the folder at p
+func findFolder(entries []entry, p string) []entry {
+	for _, entry := range entries {
+		if entry.Type != "folder" {
+			continue
+		}
+		entryPath := filepath.ToSlash(entry.Path)
+		if entryPath == p {
+			return entry.Children
+		}
+		if strings.HasPrefix(p, entryPath+"/") {
+			return findFolder(entry.Children, p)
+		}
+	}
+	return nil
+}
+
+// siteCSS lays out the exported pages
+const siteCSS = `body { margin: 0; display: flex; font-family: system-ui, sans-serif; line-height: 1.6; color: #1f2328; }
+.sidebar { width: 16rem; flex-shrink: 0; padding: 1rem; border-right: 1px solid #d0d7de; min-height: 100vh; box-sizing: border-box; }
+.sidebar ul { list-style: none; padding-left: 1rem; margin: 0; }
+.sidebar > ul { padding-left: 0; }
+.sidebar .current > a { font-weight: bold; }
+.content { flex: 1; max-width: 50rem; padding: 1rem 2rem; }
+pre { overflow-x: auto; padding: 0.75rem; background: #f6f8fa; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #d0d7de; padding: 0.25rem 0.5rem; }
+.admonition { border-left: 4px solid #0969da; padding: 0 1rem; margin: 1rem 0; }
+.admonition-title { font-weight: bold; }
+@media (prefers-color-scheme: dark) {
+  body { background: #0d1117; color: #e6edf3; }
+  a { color: #4493f8; }
+  pre { background: #161b22; }
+}
+`
+
+// writeAssets writes the site and syntax highlighting stylesheets
+func writeAssets(cfg *config.Config, outDir string) error {
+	light, err := markdown.HighlightCSS(cfg.HighlightStyle("light"))
+	if err != nil {
+		return err
+	}
+	dark, err := markdown.HighlightCSS(cfg.HighlightStyle("dark"))
+	if err != nil {
+		return err
+	}
+
+	var css bytes.Buffer
+	css.Write(light)
+	css.WriteString("@media (prefers-color-scheme: dark) {\n")
+	css.Write(dark)
+	css.WriteString("}\n")
+
+	dir := filepath.Join(outDir, assetsDir)
+	if err := os.WriteFile(filepath.Join(dir, "highlight.css"), css.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write stylesheet: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "site.css"), []byte(siteCSS), 0644); err != nil {
+		return fmt.Errorf("failed to write stylesheet: %v", err)
+	}
+	return nil
+}
+
+// copyAttachments copies every file that isn't a page, such as images,
+// keeping its path. Files anonymous visitors can't read and symlinks are
+// left out, and files whose size and modification time match the last
+// export are skipped
+func (w *wiki) copyAttachments(outDir string, previous, manifest *siteManifest, result *Result) error {
+	var files []string
+	root := w.realRoot
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") && p != root {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !info.Mode().IsRegular() || filepath.Ext(p) == ".md" {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == assetsDir || strings.HasPrefix(rel, assetsDir+"/") || !w.canRead(rel) {
+			return nil
+		}
+		manifest.Attachments[rel] = fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list attachments: %v", err)
+	}
+
+	sort.Strings(files)
+	for _, rel := range files {
+		target := filepath.Join(outDir, filepath.FromSlash(rel))
+		if previous.Attachments[rel] == manifest.Attachments[rel] && fileExists(target) {
+			continue
+		}
+		if err := copyFile(filepath.Join(root, filepath.FromSlash(rel)), target); err != nil {
+			return err
+		}
+		result.Attachments++
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %s: %v", src, err)
+	}
+	return out.Close()
+}
+
+func writeFile(target string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(target, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", target, err)
+	}
+	return nil
+}
+
+func readManifest(outDir string) (*siteManifest, error) {
+	data, err := os.ReadFile(filepath.Join(outDir, manifestName))
+	if err != nil {
+		return nil, err
+	}
+	var manifest siteManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,245 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/timhughes/fishki/internal/acl"
+	"github.com/timhughes/fishki/internal/config"
+	"github.com/timhughes/fishki/internal/markdown"
+)
+
+// writeWikiFiles creates files in the wiki from a path to content map
+func writeWikiFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+}
+
+// newWiki returns the config of an empty wiki and a renderer for it
+func newWiki(t *testing.T) (*config.Config, *markdown.Renderer) {
+	t.Helper()
+	cfg := config.Defaults()
+	cfg.WikiPath = t.TempDir()
+	return cfg, markdown.New(cfg.MarkdownOptions())
+}
+
+func readExported(t *testing.T, outDir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(outDir, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatalf("Expected %s to be exported: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestSite(t *testing.T) {
+	cfg, renderer := newWiki(t)
+
+	writeWikiFiles(t, cfg.WikiPath, map[string]string{
+		"home.md":          "# Home\n\n[Guide](docs/guide.md#intro) [Deep](/page/docs/sub/deep) [Docs](docs/) [Web](https://example.com/a.md)\n\n![Logo](images/logo.png)\n",
+		"docs/guide.md":    "# Guide\n\n## Intro\n\n[Home](../home) [Missing](nope.md)\n\n![[shared]]\n",
+		"docs/sub/deep.md": "Deep page ![Logo](/images/logo.png)\n",
+		"shared.md":        "Shared text\n",
+		"images/logo.png":  "PNG",
+		".hidden/x.png":    "secret",
+	})
+	outDir := t.TempDir()
+
+	result, err := Site(cfg, renderer, Options{OutDir: outDir})
+	if err != nil {
+		t.Fatalf("Site() failed: %v", err)
+	}
+	if result.Pages != 4 || result.Rendered != 4 || result.Attachments != 1 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	// The root, docs, docs/sub and images folders have no index.md
+	if result.Folders != 4 {
+		t.Errorf("Expected 4 folder indexes, got %d", result.Folders)
+	}
+
+	home := readExported(t, outDir, "home.html")
+	for _, want := range []string{
+		`<title>Home</title>`,
+		`href="_fishki/site.css"`,
+		`<a href="docs/guide.html#intro">Guide</a>`,
+		`<a href="docs/sub/deep.html">Deep</a>`,
+		`<a href="docs/index.html">Docs</a>`,
+		`<a href="https://example.com/a.md">Web</a>`,
+		`src="images/logo.png"`,
+		`<li class="page current"><a href="home.html">home</a></li>`,
+	} {
+		if !strings.Contains(home, want) {
+			t.Errorf("Expected %q in home.html:\n%s", want, home)
+		}
+	}
+
+	guide := readExported(t, outDir, "docs/guide.html")
+	for _, want := range []string{
+		`href="../_fishki/site.css"`,
+		`<a href="../home.html">Home</a>`,
+		`<a href="nope.md">Missing</a>`,
+		`Shared text`,
+		`<a href="sub/deep.html">deep</a>`,
+	} {
+		if !strings.Contains(guide, want) {
+			t.Errorf("Expected %q in docs/guide.html:\n%s", want, guide)
+		}
+	}
+
+	if deep := readExported(t, outDir, "docs/sub/deep.html"); !strings.Contains(deep, `src="../../images/logo.png"`) {
+		t.Errorf("Expected image path relative to the page:\n%s", deep)
+	}
+	if index := readExported(t, outDir, "docs/index.html"); !strings.Contains(index, `<a href="guide.html">guide</a>`) {
+		t.Errorf("Expected folder listing:\n%s", index)
+	}
+	readExported(t, outDir, "index.html")
+	readExported(t, outDir, "images/logo.png")
+	readExported(t, outDir, "_fishki/highlight.css")
+	if _, err := os.Stat(filepath.Join(outDir, ".hidden")); !os.IsNotExist(err) {
+		t.Error("Hidden files should not be exported")
+	}
+}
+
+func TestSiteIncremental(t *testing.T) {
+	cfg, renderer := newWiki(t)
+
+	writeWikiFiles(t, cfg.WikiPath, map[string]string{
+		"a.md":       "# A\n",
+		"b.md":       "# B\n",
+		"docs/c.md":  "# C\n",
+		"docs/c.png": "PNG",
+	})
+	outDir := t.TempDir()
+	opts := Options{OutDir: outDir, Incremental: true}
+
+	if _, err := Site(cfg, renderer, opts); err != nil {
+		t.Fatalf("Site() failed: %v", err)
+	}
+
+	// Nothing changed
+	result, err := Site(cfg, renderer, opts)
+	if err != nil {
+		t.Fatalf("Site() failed: %v", err)
+	}
+	if result.Rendered != 0 || result.Unchanged != 3 || result.Attachments != 0 {
+		t.Errorf("Expected nothing to be re-rendered, got %+v", result)
+	}
+
+	// One page changed
+	writeWikiFiles(t, cfg.WikiPath, map[string]string{"b.md": "# B changed\n"})
+	result, err = Site(cfg, renderer, opts)
+	if err != nil {
+		t.Fatalf("Site() failed: %v", err)
+	}
+	if result.Rendered != 1 || result.Unchanged != 2 {
+		t.Errorf("Expected only b.md to be re-rendered, got %+v", result)
+	}
+	if b := readExported(t, outDir, "b.html"); !strings.Contains(b, "B changed") {
+		t.Errorf("Expected updated content in b.html:\n%s", b)
+	}
+
+	// A page was deleted, which changes every sidebar
+	if err := os.Remove(filepath.Join(cfg.WikiPath, "a.md")); err != nil {
+		t.Fatal(err)
+	}
+	result, err = Site(cfg, renderer, opts)
+	if err != nil {
+		t.Fatalf("Site() failed: %v", err)
+	}
+	if result.Rendered != 2 || result.Removed != 1 || result.Attachments != 0 {
+		t.Errorf("Expected a full re-render with a.html removed, got %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "a.html")); !os.IsNotExist(err) {
+		t.Error("Expected a.html to be removed")
+	}
+
+	// A full export ignores the previous one
+	result, err = Site(cfg, renderer, Options{OutDir: outDir})
+	if err != nil {
+		t.Fatalf("Site() failed: %v", err)
+	}
+	if result.Rendered != 2 || result.Attachments != 1 {
+		t.Errorf("Expected everything to be exported again, got %+v", result)
+	}
+}
+
+func TestSiteRejectsOutputInsideWiki(t *testing.T) {
+	cfg, renderer := newWiki(t)
+
+	if _, err := Site(cfg, renderer, Options{OutDir: filepath.Join(cfg.WikiPath, "site")}); err == nil {
+		t.Error("Expected an error for an output directory inside the wiki")
+	}
+	if _, err := Site(cfg, renderer, Options{}); err == nil {
+		t.Error("Expected an error without an output directory")
+	}
+}
+
+func TestSiteIncludeOutsideWiki(t *testing.T) {
+	cfg, renderer := newWiki(t)
+
+	outside := t.TempDir()
+	writeWikiFiles(t, outside, map[string]string{"secret.md": "Secret text\n"})
+	if err := os.Symlink(filepath.Join(outside, "secret.md"), filepath.Join(cfg.WikiPath, "link.md")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	writeWikiFiles(t, cfg.WikiPath, map[string]string{"home.md": "# Home\n\n![[link]]\n"})
+	outDir := t.TempDir()
+
+	if _, err := Site(cfg, renderer, Options{OutDir: outDir}); err != nil {
+		t.Fatalf("Site() failed: %v", err)
+	}
+	if home := readExported(t, outDir, "home.html"); strings.Contains(home, "Secret text") {
+		t.Errorf("Expected the include outside the wiki to be refused:\n%s", home)
+	}
+}
+
+func TestSiteAccessPolicy(t *testing.T) {
+	cfg, renderer := newWiki(t)
+	cfg.ACL = &acl.Policy{Paths: map[string]acl.Rules{"hr": {"group:hr": acl.Read}}}
+
+	writeWikiFiles(t, cfg.WikiPath, map[string]string{
+		".fishki/acl.yaml":        "paths:\n  private:\n    group:staff: write\n  private/notes/public.md:\n    \"*\": read\n",
+		"home.md":                 "# Home\n\n![[private/secret]]\n\n![[hr/pay]]\n",
+		"private/secret.md":       "Secret text\n",
+		"private/logo.png":        "PNG",
+		"private/notes/public.md": "Public note\n",
+		"hr/pay.md":               "Pay text\n",
+	})
+	if err := os.Symlink(filepath.Join(cfg.WikiPath, "private", "secret.md"), filepath.Join(cfg.WikiPath, "leak.md")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	outDir := t.TempDir()
+
+	result, err := Site(cfg, renderer, Options{OutDir: outDir})
+	if err != nil {
+		t.Fatalf("Site() failed: %v", err)
+	}
+	// home.md and private/notes/public.md, with indexes for the root and
+	// the two folders leading to the public note
+	if result.Pages != 2 || result.Attachments != 0 || result.Folders != 3 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	home := readExported(t, outDir, "home.html")
+	for _, leaked := range []string{"Secret text", "Pay text", "secret.html", "hr/index.html", "leak"} {
+		if strings.Contains(home, leaked) {
+			t.Errorf("Expected %q to be left out of home.html:\n%s", leaked, home)
+		}
+	}
+	readExported(t, outDir, "private/notes/public.html")
+	for _, name := range []string{"private/secret.html", "private/logo.png", "hr/pay.html", "hr/index.html", "leak.html"} {
+		if _, err := os.Stat(filepath.Join(outDir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("Expected %s not to be exported", name)
+		}
+	}
+}
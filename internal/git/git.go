@@ -7,17 +7,53 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type GitClient interface {
 	Init(path string) error
 	Commit(path, message string) error
+	CommitWith(path string, opts CommitOptions) error
 	Pull(path string) error
 	Push(path string) error
 	Fetch(path string) error
 	Status(path string) (string, error)
 	HasRemote(path string) bool
 	IsRepository(path string) bool
+	DeletedFiles(path string) ([]DeletedFile, error)
+	FileAt(path, rev, file string) ([]byte, error)
+}
+
+// Signature identifies the author of a commit
+type Signature struct {
+	Name  string
+	Email string
+}
+
+// CommitOptions describes a commit made with CommitWith
+type CommitOptions struct {
+	Message string
+
+	// Paths limits the commit to these paths, relative to the repository.
+	// All changes are committed when it is empty
+	Paths []string
+
+	// Author overrides the configured git user as the commit's author
+	Author *Signature
+
+	// Date sets the author and committer dates instead of the current time
+	Date time.Time
+}
+
+// DeletedFile is a file removed from the repository, with the commit that
+// removed it
+type DeletedFile struct {
+	Path    string    `json:"path"`
+	Commit  string    `json:"commit"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Date    time.Time `json:"date"`
+	Message string    `json:"message"`
 }
 
 type DefaultGitClient struct{}
@@ -50,6 +86,47 @@ func (g *DefaultGitClient) Commit(path, message string) error {
 	return commitCmd.Run()
 }
 
+// CommitWith stages and commits changes with an explicit author, date or
+// set of paths. It does nothing when there is nothing to commit
+func (g *DefaultGitClient) CommitWith(path string, opts CommitOptions) error {
+	if !g.IsRepository(path) {
+		return &ErrNotRepository{Path: path}
+	}
+
+	pathspec := append([]string{"--"}, opts.Paths...)
+	if len(opts.Paths) == 0 {
+		pathspec = []string{"--", "."}
+	}
+
+	addCmd := exec.Command("git", append([]string{"add", "-A"}, pathspec...)...)
+	addCmd.Dir = path
+	if out, err := addCmd.CombinedOutput(); err != nil {
+		return &ErrGitOperation{Op: "add", Err: err, Out: string(out)}
+	}
+
+	// Exit status 0 means nothing is staged for these paths
+	diffCmd := exec.Command("git", append([]string{"diff", "--cached", "--quiet"}, pathspec...)...)
+	diffCmd.Dir = path
+	if err := diffCmd.Run(); err == nil {
+		return nil
+	}
+
+	args := []string{"commit", "-m", opts.Message}
+	if opts.Author != nil {
+		args = append(args, "--author", fmt.Sprintf("%s <%s>", opts.Author.Name, opts.Author.Email))
+	}
+	commitCmd := exec.Command("git", append(args, pathspec...)...)
+	commitCmd.Dir = path
+	if !opts.Date.IsZero() {
+		date := opts.Date.Format(time.RFC3339)
+		commitCmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+	}
+	if out, err := commitCmd.CombinedOutput(); err != nil {
+		return &ErrGitOperation{Op: "commit", Err: err, Out: string(out)}
+	}
+	return nil
+}
+
 func (g *DefaultGitClient) Pull(path string) error {
 	// First check if there's a remote configured
 	if !g.HasRemote(path) {
@@ -168,3 +245,72 @@ func (g *DefaultGitClient) IsRepository(path string) bool {
 	}
 	return info.IsDir()
 }
+
+// DeletedFiles lists the files deleted in the repository's history, newest
+// first, with only the latest deletion of each path. Renamed files aren't
+// included
+func (g *DefaultGitClient) DeletedFiles(path string) ([]DeletedFile, error) {
+	if !g.IsRepository(path) {
+		return nil, &ErrNotRepository{Path: path}
+	}
+
+	// A repository without commits has nothing deleted
+	headCmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "HEAD")
+	headCmd.Dir = path
+	if err := headCmd.Run(); err != nil {
+		return nil, nil
+	}
+
+	cmd := exec.Command("git", "-c", "core.quotePath=false", "log", "--diff-filter=D", "--name-only",
+		"--format=%x1e%H%x1f%an%x1f%ae%x1f%aI%x1f%s")
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, &ErrGitOperation{Op: "log", Err: err}
+	}
+
+	var deleted []DeletedFile
+	seen := make(map[string]bool)
+	for _, record := range strings.Split(string(output), "\x1e") {
+		lines := strings.Split(strings.TrimSpace(record), "\n")
+		fields := strings.Split(lines[0], "\x1f")
+		if len(fields) != 5 {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339, fields[3])
+		for _, file := range lines[1:] {
+			file = strings.TrimSpace(file)
+			if file == "" || seen[file] {
+				continue
+			}
+			seen[file] = true
+			deleted = append(deleted, DeletedFile{
+				Path:    file,
+				Commit:  fields[0],
+				Author:  fields[1],
+				Email:   fields[2],
+				Date:    date,
+				Message: fields[4],
+			})
+		}
+	}
+	return deleted, nil
+}
+
+// FileAt returns a file's content at a revision, e.g. "abc123^" for the
+// version before a commit
+func (g *DefaultGitClient) FileAt(path, rev, file string) ([]byte, error) {
+	if !g.IsRepository(path) {
+		return nil, &ErrNotRepository{Path: path}
+	}
+
+	cmd := exec.Command("git", "show", rev+":"+filepath.ToSlash(file))
+	cmd.Dir = path
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, &ErrGitOperation{Op: "show", Err: err, Out: stderr.String()}
+	}
+	return output, nil
+}
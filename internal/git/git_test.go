@@ -5,7 +5,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGitClientMock(t *testing.T) {
@@ -172,3 +174,113 @@ func TestDefaultGitClient(t *testing.T) {
 		t.Errorf("Expected ErrNotRepository, got: %v", err)
 	}
 }
+
+func TestCommitWith(t *testing.T) {
+	client := New()
+	tempDir := t.TempDir()
+	if err := client.Init(tempDir); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	setupGitConfig(t, tempDir)
+
+	for _, name := range []string{"a.md", "b.md"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	date := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+	err := client.CommitWith(tempDir, CommitOptions{
+		Message: "Import a",
+		Paths:   []string{"a.md"},
+		Author:  &Signature{Name: "Ada Lovelace", Email: "ada@example.com"},
+		Date:    date,
+	})
+	if err != nil {
+		t.Fatalf("CommitWith failed: %v", err)
+	}
+
+	cmd := exec.Command("git", "log", "--format=%an|%ae|%aI|%cI|%s", "--name-only")
+	cmd.Dir = tempDir
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git log failed: %v", err)
+	}
+	want := "Ada Lovelace|ada@example.com|2019-03-04T05:06:07+00:00|2019-03-04T05:06:07+00:00|Import a\n\na.md\n"
+	if string(out) != want {
+		t.Errorf("Unexpected log:\n%s\nwant:\n%s", out, want)
+	}
+
+	// b.md was not part of the commit
+	status, err := client.Status(tempDir)
+	if err != nil || !strings.Contains(status, "b.md") {
+		t.Errorf("Expected b.md to stay uncommitted, got %q (%v)", status, err)
+	}
+
+	// Nothing staged for a.md, so nothing to do
+	if err := client.CommitWith(tempDir, CommitOptions{Message: "Again", Paths: []string{"a.md"}}); err != nil {
+		t.Errorf("Expected an empty commit to be skipped, got %v", err)
+	}
+
+	if err := client.CommitWith(t.TempDir(), CommitOptions{Message: "Nope"}); err == nil {
+		t.Error("Expected an error outside a repository")
+	}
+}
+
+func TestDeletedFiles(t *testing.T) {
+	client := New()
+	tempDir := t.TempDir()
+	if err := client.Init(tempDir); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	setupGitConfig(t, tempDir)
+
+	// No commits yet
+	deleted, err := client.DeletedFiles(tempDir)
+	if err != nil || len(deleted) != 0 {
+		t.Fatalf("Expected no deleted files, got %v (%v)", deleted, err)
+	}
+
+	commit := func(message string, author *Signature) {
+		t.Helper()
+		if err := client.CommitWith(tempDir, CommitOptions{Message: message, Author: author}); err != nil {
+			t.Fatalf("CommitWith failed: %v", err)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		p := filepath.Join(tempDir, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("notes/old page.md", "first")
+	write("keep.md", "keep")
+	commit("Add pages", nil)
+	write("notes/old page.md", "second")
+	commit("Edit page", nil)
+	os.Remove(filepath.Join(tempDir, "notes", "old page.md"))
+	commit("Delete notes/old page.md", &Signature{Name: "Ada", Email: "ada@example.com"})
+
+	deleted, err = client.DeletedFiles(tempDir)
+	if err != nil {
+		t.Fatalf("DeletedFiles failed: %v", err)
+	}
+	if len(deleted) != 1 {
+		t.Fatalf("Expected 1 deleted file, got %+v", deleted)
+	}
+	d := deleted[0]
+	if d.Path != "notes/old page.md" || d.Author != "Ada" || d.Email != "ada@example.com" || d.Message != "Delete notes/old page.md" || d.Date.IsZero() {
+		t.Errorf("Unexpected deleted file: %+v", d)
+	}
+
+	content, err := client.FileAt(tempDir, d.Commit+"^", d.Path)
+	if err != nil || string(content) != "second" {
+		t.Errorf("Expected the last version before deletion, got %q (%v)", content, err)
+	}
+	if _, err := client.FileAt(tempDir, d.Commit, d.Path); err == nil {
+		t.Error("Expected an error for a file missing at a revision")
+	}
+}
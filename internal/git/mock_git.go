@@ -14,6 +14,10 @@ func (m *MockGitClient) Commit(path, message string) error {
 	return nil
 }
 
+func (m *MockGitClient) CommitWith(path string, opts CommitOptions) error {
+	return nil
+}
+
 func (m *MockGitClient) Pull(path string) error {
 	return nil
 }
@@ -37,3 +41,11 @@ func (m *MockGitClient) HasRemote(path string) bool {
 func (m *MockGitClient) IsRepository(path string) bool {
 	return true
 }
+
+func (m *MockGitClient) DeletedFiles(path string) ([]DeletedFile, error) {
+	return nil, nil
+}
+
+func (m *MockGitClient) FileAt(path, rev, file string) ([]byte, error) {
+	return []byte("mock content"), nil
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/timhughes/fishki/internal/acl"
+	"github.com/timhughes/fishki/internal/audit"
+	"github.com/timhughes/fishki/internal/auth"
+	"github.com/timhughes/fishki/internal/config"
+	"github.com/timhughes/fishki/internal/markdown"
+)
+
+// policy returns the access policy of the current wiki: its own
+// .fishki/acl.yaml with the config's rules on top
+func (h *Handler) policy(cfg *config.Config) (acl.Policy, error) {
+	var policy acl.Policy
+	if cfg.WikiPath != "" {
+		p, err := h.aclFile.Load(cfg.WikiPath)
+		if err != nil {
+			return acl.Policy{}, err
+		}
+		policy = p
+	}
+	if cfg.ACL != nil {
+		policy = policy.Merge(*cfg.ACL)
+	}
+	return policy, nil
+}
+
+// wikiFile resolves a file named in a request to the wiki-relative path
+// access is checked on and the full path to use, so both always name the
+// same file. Symlinks in the part of the path that exists are followed,
+// so a link can't lead outside the wiki or to a page the caller can't
+// reach by name. The .git folder is refused outright: writing its hooks
+// would run code on the server
+func wikiFile(root, p string) (string, string, error) {
+	fullPath, err := ValidatePath(root, strings.TrimLeft(filepath.FromSlash(p), string(filepath.Separator)))
+	if err != nil {
+		return "", "", err
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", "", err
+	}
+
+	existing, rest := fullPath, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			fullPath = filepath.Join(resolved, rest)
+			break
+		}
+		if !os.IsNotExist(err) {
+			return "", "", err
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+	}
+
+	rel, err := filepath.Rel(realRoot, fullPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", "", ErrInvalidPath
+	}
+	rel = filepath.ToSlash(rel)
+	if first, _, _ := strings.Cut(rel, "/"); strings.EqualFold(first, ".git") {
+		return "", "", ErrInvalidPath
+	}
+	return rel, filepath.Join(root, filepath.FromSlash(rel)), nil
+}
+
+// inRepoDir reports whether a wiki path is in the folder holding the
+// wiki's settings
+func inRepoDir(p string) bool {
+	p = acl.Clean(p)
+	return p == acl.RepoDir || strings.HasPrefix(p, acl.RepoDir+"/")
+}
+
+// levelFor returns the access an identity has to a path under a policy
+func levelFor(policy acl.Policy, id *auth.Identity, p string) acl.Level {
+	level := policy.Access(id, p)
+	// Only admins of the whole wiki may change its settings, so nobody can
+	// grant themselves more by editing the policy file
+	if inRepoDir(p) && level > acl.Read && policy.Access(id, "") < acl.Admin {
+		level = acl.Read
+	}
+	return level
+}
+
+// access returns the level the caller has to a path in the wiki
+func (h *Handler) access(r *http.Request, cfg *config.Config, p string) (acl.Level, error) {
+	policy, err := h.policy(cfg)
+	if err != nil {
+		return acl.None, err
+	}
+	return levelFor(policy, auth.FromContext(r.Context()), p), nil
+}
+
+// authorize checks that the caller has at least the level needed on a
+// path, writing an error when not. Paths the caller can't read are
+// reported as missing, so their names don't leak
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, cfg *config.Config, p string, need acl.Level) bool {
+	level, err := h.access(r, cfg, p)
+	if err != nil {
+		log.Printf("Failed to load access policy: %v", err)
+		http.Error(w, "Failed to load access policy", http.StatusInternalServerError)
+		return false
+	}
+	if level >= need {
+		return true
+	}
+	h.record(r, audit.Event{Action: "access", Path: p, Detail: "needs " + need.String()})
+	if level < acl.Read {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return false
+	}
+	http.Error(w, "Access denied", http.StatusForbidden)
+	return false
+}
+
+// readFilter returns a check for whether the caller may read a path. Any
+// handler listing pages, such as the file tree, the trash or an export,
+// passes its results through one
+func (h *Handler) readFilter(r *http.Request, cfg *config.Config) (func(p string) bool, error) {
+	policy, err := h.policy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	id := auth.FromContext(r.Context())
+	return func(p string) bool {
+		return levelFor(policy, id, p) >= acl.Read
+	}, nil
+}
+
+// filterTree drops the files the caller can't read. A folder is kept when
+// it is readable or still has readable entries inside
+func filterTree(entries []FileInfo, canRead func(string) bool) []FileInfo {
+	var filtered []FileInfo
+	for _, entry := range entries {
+		if entry.Type == "folder" {
+			entry.Children = filterTree(entry.Children, canRead)
+			if len(entry.Children) == 0 && !canRead(entry.Path) {
+				continue
+			}
+		} else if !canRead(entry.Path) {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered
+}
+
+// includeLoader loads included pages from the wiki at root for a request,
+// treating pages the caller can't read as missing. Access is checked on
+// the file a symlink leads to, not the link
+func includeLoader(root string, canRead func(string) bool) markdown.IncludeLoader {
+	return func(page string) ([]byte, error) {
+		rel, _, err := wikiFile(root, page)
+		if err != nil || !canRead(rel) {
+			return nil, fmt.Errorf("page not found")
+		}
+		return loadInclude(root, rel)
+	}
+}
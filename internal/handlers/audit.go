@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/timhughes/fishki/internal/audit"
+	"github.com/timhughes/fishki/internal/auth"
+)
+
+const (
+	// defaultAuditLimit is how many events GET /api/audit returns by default
+	defaultAuditLimit = 100
+
+	// maxAuditLimit caps how many events one request can ask for
+	maxAuditLimit = 1000
+)
+
+// record adds an event to the audit log, filling in who made the request
+// and where from. A failure to write the log doesn't fail the request
+func (h *Handler) record(r *http.Request, e audit.Event) {
+	if h.auditLog == nil {
+		return
+	}
+	if e.User == "" {
+		if id := auth.FromContext(r.Context()); id != nil {
+			e.User = id.Username
+		}
+	}
+	e.Remote = r.RemoteAddr
+	e.ForwardedFor = r.Header.Get("X-Forwarded-For")
+	if err := h.auditLog.Record(e); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
+
+// auditHandler lists audit log events, newest first. They can be filtered
+// by user, action, path and a since/until time range
+func (h *Handler) auditHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cfg := h.cfg()
+		if !h.canConfigure(r, cfg) {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+
+		if h.auditLog == nil {
+			http.Error(w, "Audit log is not available", http.StatusNotFound)
+			return
+		}
+
+		query := r.URL.Query()
+		filter := audit.Filter{
+			User:   query.Get("user"),
+			Action: query.Get("action"),
+			Path:   query.Get("path"),
+			Limit:  defaultAuditLimit,
+		}
+		for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if value := query.Get(name); value != "" {
+				parsed, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					http.Error(w, "Invalid "+name+" time, use RFC 3339", http.StatusBadRequest)
+					return
+				}
+				*t = parsed
+			}
+		}
+		if value := query.Get("limit"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			filter.Limit = min(limit, maxAuditLimit)
+		}
+
+		events, err := h.auditLog.Query(filter)
+		if err != nil {
+			http.Error(w, "Failed to read audit log", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events)
+	}
+}
@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/timhughes/fishki/internal/acl"
+	"github.com/timhughes/fishki/internal/audit"
+	"github.com/timhughes/fishki/internal/auth"
+	"github.com/timhughes/fishki/internal/config"
+	"github.com/timhughes/fishki/internal/git"
+)
+
+// oidcStateCookieName ties a single sign-on login to the browser that
+// started it
+const oidcStateCookieName = "fishki_oidc_state"
+
+// authRequired reports whether requests must be authenticated, which is
+// the case once a local account exists or another way of logging in is
+// configured
+func (h *Handler) authRequired() bool {
+	return h.oidc != nil || h.proxy != nil || (h.users != nil && h.users.Len() > 0)
+}
+
+// commitAuthor returns the git author for changes made by a request, or nil
+// to use the repository's configured user
+func commitAuthor(r *http.Request) *git.Signature {
+	id := auth.FromContext(r.Context())
+	if id == nil {
+		return nil
+	}
+	sig := &git.Signature{Name: id.Name, Email: id.Email}
+	if sig.Name == "" {
+		sig.Name = id.Username
+	}
+	if sig.Email == "" {
+		sig.Email = id.Username + "@fishki.invalid"
+	}
+	return sig
+}
+
+// identify returns the identity behind a request, taken from an API token,
+// a trusted proxy's headers or the session cookie. An unknown token, or
+// identity headers from anywhere but the proxy, are an error
+func (h *Handler) identify(r *http.Request) (*auth.Identity, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		secret, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || h.tokens == nil {
+			return nil, auth.ErrInvalidToken
+		}
+		token, err := h.tokens.Authenticate(strings.TrimSpace(secret))
+		if err != nil {
+			return nil, err
+		}
+		return token.Identity(), nil
+	}
+
+	if h.proxy != nil {
+		id, err := h.proxy.Identify(r)
+		if err != nil || id != nil {
+			return id, err
+		}
+	}
+
+	cookie, err := r.Cookie(auth.SessionCookieName)
+	if err != nil || h.sessions == nil {
+		return nil, nil
+	}
+	session, ok := h.sessions.Get(cookie.Value)
+	if !ok {
+		return nil, nil
+	}
+	id := session.Identity
+	return &id, nil
+}
+
+// identifyError reports why a request's identity couldn't be established
+func identifyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, auth.ErrUntrustedProxy) {
+		http.Error(w, "Identity headers not allowed from this address", http.StatusForbidden)
+		return
+	}
+	http.Error(w, "Invalid API token", http.StatusUnauthorized)
+}
+
+// isEditor reports whether an identity can change the wiki in read-only
+// mode
+func isEditor(cfg *config.Config, id *auth.Identity) bool {
+	if id == nil {
+		return false
+	}
+	if len(cfg.Editors) == 0 {
+		return true
+	}
+	for _, subject := range cfg.Editors {
+		if acl.Matches(subject, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeMethod reports whether a request only reads
+func safeMethod(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+}
+
+// AuthMiddleware attaches the caller's identity to the request context and
+// rejects anonymous requests when authentication is required. API tokens
+// must also have the scope the endpoint needs. In read-only mode anyone
+// can read, and only editors can do anything more
+func (h *Handler) AuthMiddleware(scope auth.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg := h.cfg()
+			id, err := h.identify(r)
+			if err != nil {
+				h.record(r, audit.Event{Action: "auth", Detail: err.Error()})
+				identifyError(w, err)
+				return
+			}
+			if cfg.ReadOnly && scope != auth.ScopeRead && !safeMethod(r) && !isEditor(cfg, id) {
+				e := audit.Event{Action: "auth", Detail: "read-only: " + r.Method + " " + r.URL.Path}
+				if id != nil {
+					e.User = id.Username
+				}
+				h.record(r, e)
+				http.Error(w, "The wiki is read-only", http.StatusForbidden)
+				return
+			}
+			if id == nil && h.authRequired() && !(cfg.ReadOnly && safeMethod(r)) {
+				h.record(r, audit.Event{Action: "auth", Detail: "authentication required: " + r.Method + " " + r.URL.Path})
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+			if id != nil {
+				if !id.Allows(scope) {
+					h.record(r, audit.Event{Action: "auth", User: id.Username, Detail: "token lacks the " + string(scope) + " scope: " + r.Method + " " + r.URL.Path})
+					http.Error(w, "API token lacks the "+string(scope)+" scope", http.StatusForbidden)
+					return
+				}
+				r = r.WithContext(auth.WithIdentity(r.Context(), id))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setSessionCookie starts a session for an identity and hands it to the
+// browser
+func (h *Handler) setSessionCookie(w http.ResponseWriter, r *http.Request, id auth.Identity) error {
+	// Drop any session the browser already had, so a login always gets a
+	// fresh ID
+	if cookie, err := r.Cookie(auth.SessionCookieName); err == nil {
+		h.sessions.Delete(cookie.Value)
+	}
+
+	session, err := h.sessions.Create(id)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookieName,
+		Value:    session.ID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		MaxAge:   int(h.sessions.TTL().Seconds()),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// loginHandler checks a local account's password and starts a session
+func (h *Handler) loginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var request struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if h.users == nil {
+			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+			return
+		}
+		user, err := h.users.Authenticate(request.Username, request.Password)
+		if err != nil {
+			h.record(r, audit.Event{Action: "login", User: request.Username, Detail: "password"})
+			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+			return
+		}
+
+		id := user.Identity()
+		if err := h.setSessionCookie(w, r, *id); err != nil {
+			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			return
+		}
+		h.record(r, audit.Event{Action: "login", Success: true, User: id.Username, Detail: "password"})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"user": id,
+		})
+	}
+}
+
+// logoutHandler ends the caller's session
+func (h *Handler) logoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if cookie, err := r.Cookie(auth.SessionCookieName); err == nil {
+			h.sessions.Delete(cookie.Value)
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     auth.SessionCookieName,
+			Value:    "",
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			MaxAge:   -1,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	}
+}
+
+// meHandler returns the caller's identity and whether logging in is
+// required
+func (h *Handler) meHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := h.identify(r)
+		if err != nil {
+			identifyError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"user":         id,
+			"authRequired": h.authRequired(),
+			"oidc":         h.oidc != nil,
+			"readOnly":     cfg.ReadOnly,
+			"canEdit":      !cfg.ReadOnly || isEditor(cfg, id),
+		})
+	}
+}
+
+// oidcLoginHandler sends the browser to the single sign-on provider. The
+// optional redirect parameter is where to go once logged in
+func (h *Handler) oidcLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if h.oidc == nil {
+			http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+			return
+		}
+
+		state, url, err := h.oidc.Begin(r.Context(), localRedirect(r.URL.Query().Get("redirect")))
+		if err != nil {
+			http.Error(w, "Single sign-on provider unavailable", http.StatusBadGateway)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookieName,
+			Value:    state,
+			Path:     "/api/auth/oidc",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			MaxAge:   600,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, url, http.StatusFound)
+	}
+}
+
+// oidcCallbackHandler finishes a single sign-on login and starts a session
+func (h *Handler) oidcCallbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if h.oidc == nil {
+			http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+			return
+		}
+
+		query := r.URL.Query()
+		if errCode := query.Get("error"); errCode != "" {
+			h.record(r, audit.Event{Action: "login", Detail: "oidc: " + errCode})
+			http.Error(w, "Login failed: "+errCode, http.StatusUnauthorized)
+			return
+		}
+
+		cookie, err := r.Cookie(oidcStateCookieName)
+		if err != nil || cookie.Value == "" || cookie.Value != query.Get("state") {
+			h.record(r, audit.Event{Action: "login", Detail: "oidc: invalid state"})
+			http.Error(w, "Invalid login state", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookieName,
+			Value:    "",
+			Path:     "/api/auth/oidc",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			MaxAge:   -1,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		id, redirect, err := h.oidc.Finish(r.Context(), query.Get("state"), query.Get("code"))
+		if err != nil {
+			h.record(r, audit.Event{Action: "login", Detail: "oidc: " + err.Error()})
+			http.Error(w, "Login failed", http.StatusUnauthorized)
+			return
+		}
+
+		if err := h.setSessionCookie(w, r, *id); err != nil {
+			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			return
+		}
+		h.record(r, audit.Event{Action: "login", Success: true, User: id.Username, Detail: "oidc"})
+		http.Redirect(w, r, redirect, http.StatusFound)
+	}
+}
+
+// localRedirect keeps redirects after logging in on this site, defaulting
+// to the front page
+func localRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
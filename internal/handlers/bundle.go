@@ -0,0 +1,697 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/timhughes/fishki/internal/config"
+	"github.com/timhughes/fishki/internal/markdown"
+)
+
+// maxBundleImageSize bounds the images packed into an export bundle; larger
+// ones are left as links
+const maxBundleImageSize = 10 << 20 // 10 MiB
+
+// bundlePage is one page of an export bundle
+type bundlePage struct {
+	path   string
+	title  string
+	toc    []*markdown.TOCEntry
+	anchor string // element ID of the page in single-file HTML
+	file   string // chapter file name in EPUB
+}
+
+// bundle is a page or folder exported as a single document. Pages are
+// rendered once up front for the table of contents and again as they are
+// written, so only one page is held in memory at a time
+type bundle struct {
+	h      *Handler
+	cfg    *config.Config
+	root   string
+	title  string
+	pages  []*bundlePage
+	byPath map[string]*bundlePage
+
+	// canRead leaves out the pages and includes the user can't read
+	canRead func(string) bool
+}
+
+// exportHandler exports a page or folder as a single HTML file, an EPUB or
+// a zip of HTML and images, streaming it as it is rendered
+func (h *Handler) exportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if cfg.WikiPath == "" {
+			http.Error(w, "Wiki path not set", http.StatusBadRequest)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "html"
+		}
+		if format != "html" && format != "epub" && format != "zip" {
+			http.Error(w, "Format must be html, epub or zip", http.StatusBadRequest)
+			return
+		}
+
+		canRead, err := h.readFilter(r, cfg)
+		if err != nil {
+			http.Error(w, "Failed to load access policy", http.StatusInternalServerError)
+			return
+		}
+
+		b, err := h.newBundle(cfg, r.URL.Query().Get("path"), canRead)
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "Path not found", http.StatusNotFound)
+				return
+			}
+			if err == ErrInvalidPath {
+				http.Error(w, "Invalid path", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(b.pages) == 0 {
+			http.Error(w, "No pages to export", http.StatusNotFound)
+			return
+		}
+
+		name := markdown.Slugify(b.title)
+		if name == "" {
+			name = "wiki"
+		}
+		switch format {
+		case "html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		case "epub":
+			w.Header().Set("Content-Type", "application/epub+zip")
+		case "zip":
+			w.Header().Set("Content-Type", "application/zip")
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+
+		switch format {
+		case "html":
+			err = b.writeHTML(w)
+		case "epub":
+			err = b.writeEPUB(w)
+		case "zip":
+			err = b.writeZip(w)
+		}
+		if err != nil {
+			// Headers are already sent, so all we can do is log it
+			log.Printf("Export of %q failed: %v", r.URL.Query().Get("path"), err)
+		}
+	}
+}
+
+// newBundle collects the pages under p, a page or folder relative to the
+// wiki root, in the same order as the file tree: a folder's index.md first,
+// then its subfolders and then its other pages. Pages canRead rejects are
+// left out
+func (h *Handler) newBundle(cfg *config.Config, p string, canRead func(string) bool) (*bundle, error) {
+	root, err := filepath.Abs(cfg.WikiPath)
+	if err != nil {
+		return nil, err
+	}
+	b := &bundle{h: h, cfg: cfg, root: root, canRead: canRead, byPath: make(map[string]*bundlePage)}
+
+	p = strings.Trim(filepath.ToSlash(p), "/")
+	fullPath := root
+	if p != "" {
+		if p, fullPath, err = wikiFile(root, p); err != nil {
+			return nil, err
+		}
+	}
+	info, err := os.Stat(fullPath)
+	if os.IsNotExist(err) && p != "" && filepath.Ext(p) == "" {
+		if p, fullPath, err = wikiFile(root, p+".md"); err != nil {
+			return nil, err
+		}
+		info, err = os.Stat(fullPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		if !canRead(p) {
+			return nil, os.ErrNotExist
+		}
+		b.add(p)
+	} else {
+		tree, err := buildDirectoryTree(root)
+		if err != nil {
+			return nil, err
+		}
+		tree = filterTree(tree, canRead)
+		if p != "" {
+			tree = findFolder(tree, p)
+		}
+		b.addTree(p, tree)
+	}
+
+	// Render each page once for its title and headings
+	for _, page := range b.pages {
+		rendered, err := b.render(page)
+		if err != nil {
+			return nil, err
+		}
+		page.title = rendered.Title
+		if page.title == "" {
+			page.title = strings.TrimSuffix(path.Base(page.path), ".md")
+		}
+		page.toc = rendered.TOC
+		// A single h1 is the page title, so list its subheadings instead
+		if len(page.toc) == 1 && page.toc[0].Level == 1 {
+			page.toc = page.toc[0].Children
+		}
+	}
+
+	switch {
+	case p == "":
+		b.title = filepath.Base(root)
+	case info.IsDir():
+		b.title = path.Base(p)
+	case len(b.pages) > 0:
+		b.title = b.pages[0].title
+	}
+	return b, nil
+}
+
+func (b *bundle) add(p string) {
+	// A page that is a symlink is left out unless the file it leads to is
+	// readable
+	if rel, _, err := wikiFile(b.root, p); err != nil || !b.canRead(rel) {
+		return
+	}
+	page := &bundlePage{
+		path:   p,
+		anchor: fmt.Sprintf("page-%d", len(b.pages)+1),
+		file:   fmt.Sprintf("chapter-%d.xhtml", len(b.pages)+1),
+	}
+	b.pages = append(b.pages, page)
+	b.byPath[p] = page
+}
+
+func (b *bundle) addTree(folder string, entries []FileInfo) {
+	index := path.Join(folder, "index.md")
+	for _, entry := range entries {
+		if filepath.ToSlash(entry.Path) == index {
+			b.add(index)
+		}
+	}
+	for _, entry := range entries {
+		p := filepath.ToSlash(entry.Path)
+		if entry.Type == "folder" {
+			b.addTree(p, entry.Children)
+		} else if p != index {
+			b.add(p)
+		}
+	}
+}
+
+// render reads and renders one page of the bundle
+func (b *bundle) render(page *bundlePage) (*markdown.Page, error) {
+	content, err := os.ReadFile(filepath.Join(b.root, filepath.FromSlash(page.path)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", page.path, err)
+	}
+	return b.h.renderer.RenderPage(markdown.ExpandIncludes(content, page.path, includeLoader(b.root, b.canRead))), nil
+}
+
+// resolve maps a link or image source in a page to a wiki path. ok is false
+// for external URLs and fragments
+func (b *bundle) resolve(page *bundlePage, link string) (target, fragment string, ok bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", "", false
+	}
+	switch {
+	case strings.HasPrefix(u.Path, "/page/"):
+		target = strings.TrimPrefix(u.Path, "/page/")
+	case strings.HasPrefix(u.Path, "/"):
+		target = strings.TrimPrefix(u.Path, "/")
+	default:
+		target = path.Join(path.Dir(page.path), u.Path)
+	}
+	if target == ".." || strings.HasPrefix(target, "../") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(target, "/"), u.Fragment, true
+}
+
+// linkedPage returns the bundled page a link points at, if any
+func (b *bundle) linkedPage(page *bundlePage, link string) (*bundlePage, string, bool) {
+	target, fragment, ok := b.resolve(page, link)
+	if !ok {
+		return nil, "", false
+	}
+	for _, candidate := range []string{target, target + ".md", path.Join(target, "index.md")} {
+		if linked, ok := b.byPath[candidate]; ok {
+			return linked, fragment, true
+		}
+	}
+	return nil, "", false
+}
+
+// imageFile finds a local image referenced by a page, returning its name in
+// the bundle, where it is on disk and its content type. Access is checked
+// on the file a symlink leads to, not the link
+func (b *bundle) imageFile(page *bundlePage, src string) (string, string, string, bool) {
+	target, _, ok := b.resolve(page, src)
+	if !ok {
+		return "", "", "", false
+	}
+	rel, fullPath, err := wikiFile(b.root, target)
+	if err != nil || !b.canRead(rel) {
+		return "", "", "", false
+	}
+	contentType := mime.TypeByExtension(path.Ext(rel))
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", "", "", false
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxBundleImageSize {
+		return "", "", "", false
+	}
+	return target, fullPath, contentType, true
+}
+
+// readImage loads a local image referenced by a page
+func (b *bundle) readImage(page *bundlePage, src string) (string, []byte, string, bool) {
+	target, fullPath, contentType, ok := b.imageFile(page, src)
+	if !ok {
+		return "", nil, "", false
+	}
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		return "", nil, "", false
+	}
+	return target, data, contentType, true
+}
+
+// copyToZip adds the file at src to a zip as name
+func copyToZip(zw *zip.Writer, name, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// rewriteBody rewrites the links, images and IDs of a rendered page using
+// the given callback for each element
+func rewriteBody(body string, rewrite func(token *xhtml.Token)) string {
+	var out strings.Builder
+	z := xhtml.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+		if tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken {
+			out.Write(z.Raw())
+			continue
+		}
+		token := z.Token()
+		rewrite(&token)
+		out.WriteString(token.String())
+	}
+	return out.String()
+}
+
+// writeHTML writes the bundle as one HTML document with images inlined as
+// data URIs
+func (b *bundle) writeHTML(w io.Writer) error {
+	return b.writeDocument(w, func(page *bundlePage, src string) (string, bool) {
+		_, data, contentType, ok := b.readImage(page, src)
+		if !ok {
+			return "", false
+		}
+		return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data), true
+	})
+}
+
+// writeZip writes the bundle as index.html with its images alongside it
+func (b *bundle) writeZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	// Only one file in a zip can be written at a time, so index.html is
+	// spooled to disk while the images it uses are copied in as they are
+	// found, and added once the document is done
+	doc, err := os.CreateTemp("", "fishki-bundle-*.html")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(doc.Name())
+	defer doc.Close()
+
+	written := make(map[string]bool)
+	var imageErr error
+	buf := bufio.NewWriter(doc)
+	err = b.writeDocument(buf, func(page *bundlePage, src string) (string, bool) {
+		target, fullPath, _, ok := b.imageFile(page, src)
+		if !ok || imageErr != nil {
+			return "", false
+		}
+		if !written[target] {
+			if err := copyToZip(zw, "images/"+target, fullPath); err != nil {
+				imageErr = err
+				return "", false
+			}
+			written[target] = true
+		}
+		return "images/" + target, true
+	})
+	if err != nil {
+		return err
+	}
+	if imageErr != nil {
+		return imageErr
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := doc.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	index, err := zw.Create("index.html")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(index, doc); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeDocument writes the bundle as one HTML document, using image to
+// rewrite image sources. IDs are prefixed per page so headings in different
+// pages can't clash
+func (b *bundle) writeDocument(w io.Writer, image func(page *bundlePage, src string) (string, bool)) error {
+	css, err := b.css()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>\n%s</style>\n</head>\n<body>\n",
+		html.EscapeString(b.title), css)
+	fmt.Fprintf(w, "<h1 class=\"bundle-title\">%s</h1>\n<nav class=\"toc\">\n<h2>Contents</h2>\n", html.EscapeString(b.title))
+	b.writeTOC(w, func(page *bundlePage, slug string) string {
+		if slug == "" {
+			return "#" + page.anchor
+		}
+		return "#" + page.anchor + "-" + slug
+	})
+	io.WriteString(w, "</nav>\n")
+
+	for _, page := range b.pages {
+		rendered, err := b.render(page)
+		if err != nil {
+			return err
+		}
+		body := rewriteBody(rendered.HTML, func(token *xhtml.Token) {
+			for i, attr := range token.Attr {
+				switch {
+				case attr.Key == "id":
+					token.Attr[i].Val = page.anchor + "-" + attr.Val
+				case token.Data == "a" && attr.Key == "href" && strings.HasPrefix(attr.Val, "#"):
+					token.Attr[i].Val = "#" + page.anchor + "-" + attr.Val[1:]
+				case token.Data == "a" && attr.Key == "href":
+					if linked, fragment, ok := b.linkedPage(page, attr.Val); ok {
+						token.Attr[i].Val = "#" + linked.anchor
+						if fragment != "" {
+							token.Attr[i].Val += "-" + fragment
+						}
+					}
+				case token.Data == "img" && attr.Key == "src":
+					if src, ok := image(page, attr.Val); ok {
+						token.Attr[i].Val = src
+					}
+				}
+			}
+		})
+		if _, err := fmt.Fprintf(w, "<section class=\"page\" id=\"%s\">\n%s</section>\n", page.anchor, body); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "</body>\n</html>\n")
+	return err
+}
+
+// writeEPUB writes the bundle as an EPUB 3 book with a chapter per page
+func (b *bundle) writeEPUB(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	// The mimetype must come first and be stored uncompressed
+	mimetype, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	io.WriteString(mimetype, "application/epub+zip")
+
+	container, err := zw.Create("META-INF/container.xml")
+	if err != nil {
+		return err
+	}
+	io.WriteString(container, `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles>
+<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+</rootfiles>
+</container>
+`)
+
+	css, err := b.css()
+	if err != nil {
+		return err
+	}
+	style, err := zw.Create("OEBPS/style.css")
+	if err != nil {
+		return err
+	}
+	style.Write(css)
+
+	type epubImage struct {
+		id, href, contentType string
+	}
+	var images []epubImage
+	packed := make(map[string]string)
+	for _, page := range b.pages {
+		rendered, err := b.render(page)
+		if err != nil {
+			return err
+		}
+
+		// Images are added to the book as they are found, so note them now
+		// and write them once the chapter is done
+		pending := make(map[string]string)
+		body := rewriteBody(rendered.HTML, func(token *xhtml.Token) {
+			for i, attr := range token.Attr {
+				switch {
+				case token.Data == "a" && attr.Key == "href" && !strings.HasPrefix(attr.Val, "#"):
+					if linked, fragment, ok := b.linkedPage(page, attr.Val); ok {
+						token.Attr[i].Val = linked.file
+						if fragment != "" {
+							token.Attr[i].Val += "#" + fragment
+						}
+					}
+				case token.Data == "img" && attr.Key == "src":
+					if target, fullPath, contentType, ok := b.imageFile(page, attr.Val); ok {
+						href, seen := packed[target]
+						if !seen {
+							href = fmt.Sprintf("images/image-%d%s", len(images)+1, path.Ext(target))
+							packed[target] = href
+							images = append(images, epubImage{id: fmt.Sprintf("image-%d", len(images)+1), href: href, contentType: contentType})
+							pending[href] = fullPath
+						}
+						token.Attr[i].Val = href
+					}
+				}
+			}
+		})
+		chapter, err := toXHTML(body)
+		if err != nil {
+			return fmt.Errorf("failed to convert %s to XHTML: %v", page.path, err)
+		}
+
+		f, err := zw.Create("OEBPS/" + page.file)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(f, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<!DOCTYPE html>\n<html xmlns=\"http://www.w3.org/1999/xhtml\" xmlns:epub=\"http://www.idpf.org/2007/ops\">\n<head>\n<title>%s</title>\n<link rel=\"stylesheet\" href=\"style.css\"/>\n</head>\n<body>\n",
+			html.EscapeString(page.title))
+		f.Write(chapter)
+		if _, err := io.WriteString(f, "</body>\n</html>\n"); err != nil {
+			return err
+		}
+
+		for href, fullPath := range pending {
+			if err := copyToZip(zw, "OEBPS/"+href, fullPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	nav, err := zw.Create("OEBPS/nav.xhtml")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(nav, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<!DOCTYPE html>\n<html xmlns=\"http://www.w3.org/1999/xhtml\" xmlns:epub=\"http://www.idpf.org/2007/ops\">\n<head>\n<title>%s</title>\n</head>\n<body>\n<nav epub:type=\"toc\" id=\"toc\">\n<h1>Contents</h1>\n",
+		html.EscapeString(b.title))
+	b.writeTOC(nav, func(page *bundlePage, slug string) string {
+		if slug == "" {
+			return page.file
+		}
+		return page.file + "#" + slug
+	})
+	io.WriteString(nav, "</nav>\n</body>\n</html>\n")
+
+	opf, err := zw.Create("OEBPS/content.opf")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(opf, `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="book-id">urn:fishki:%s</dc:identifier>
+<dc:title>%s</dc:title>
+<dc:language>en</dc:language>
+<meta property="dcterms:modified">%s</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="style" href="style.css" media-type="text/css"/>
+`, html.EscapeString(hashHex([]byte(b.title))[:16]), html.EscapeString(b.title), time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	for _, page := range b.pages {
+		fmt.Fprintf(opf, "<item id=\"%s\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", page.anchor, page.file)
+	}
+	for _, image := range images {
+		fmt.Fprintf(opf, "<item id=\"%s\" href=\"%s\" media-type=\"%s\"/>\n", image.id, image.href, html.EscapeString(image.contentType))
+	}
+	io.WriteString(opf, "</manifest>\n<spine>\n")
+	for _, page := range b.pages {
+		fmt.Fprintf(opf, "<itemref idref=\"%s\"/>\n", page.anchor)
+	}
+	io.WriteString(opf, "</spine>\n</package>\n")
+
+	return zw.Close()
+}
+
+// writeTOC writes the nested table of contents, using link to build the
+// target of each page (slug "") and heading
+func (b *bundle) writeTOC(w io.Writer, link func(page *bundlePage, slug string) string) {
+	var writeEntries func(page *bundlePage, entries []*markdown.TOCEntry)
+	writeEntries = func(page *bundlePage, entries []*markdown.TOCEntry) {
+		if len(entries) == 0 {
+			return
+		}
+		io.WriteString(w, "<ol>\n")
+		for _, entry := range entries {
+			fmt.Fprintf(w, "<li><a href=\"%s\">%s</a>", html.EscapeString(link(page, entry.Slug)), html.EscapeString(entry.Text))
+			writeEntries(page, entry.Children)
+			io.WriteString(w, "</li>\n")
+		}
+		io.WriteString(w, "</ol>\n")
+	}
+
+	io.WriteString(w, "<ol>\n")
+	for _, page := range b.pages {
+		fmt.Fprintf(w, "<li><a href=\"%s\">%s</a>", html.EscapeString(link(page, "")), html.EscapeString(page.title))
+		writeEntries(page, page.toc)
+		io.WriteString(w, "</li>\n")
+	}
+	io.WriteString(w, "</ol>\n")
+}
+
+// css returns the stylesheet embedded in bundles: the static site export's
+// typography plus light syntax highlighting
+func (b *bundle) css() ([]byte, error) {
+	highlight, err := markdown.HighlightCSS(b.cfg.HighlightStyle("light"))
+	if err != nil {
+		return nil, err
+	}
+	var css bytes.Buffer
+	css.WriteString("body { font-family: system-ui, sans-serif; line-height: 1.6; max-width: 50rem; margin: 0 auto; padding: 1rem; }\n")
+	css.WriteString("section.page { page-break-before: always; }\n")
+	css.WriteString("pre { overflow-x: auto; padding: 0.75rem; background: #f6f8fa; }\n")
+	css.WriteString("table { border-collapse: collapse; }\nth, td { border: 1px solid #d0d7de; padding: 0.25rem 0.5rem; }\n")
+	css.Write(highlight)
+	return css.Bytes(), nil
+}
+
+// toXHTML reserializes rendered HTML as well-formed XHTML for EPUB readers
+func toXHTML(body string) ([]byte, error) {
+	context := &xhtml.Node{Type: xhtml.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := xhtml.ParseFragment(strings.NewReader(body), context)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, node := range nodes {
+		if err := xhtml.Render(&buf, node); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// findFolder returns the children of the folder at p
+func findFolder(entries []FileInfo, p string) []FileInfo {
+	for _, entry := range entries {
+		if entry.Type != "folder" {
+			continue
+		}
+		entryPath := filepath.ToSlash(entry.Path)
+		if entryPath == p {
+			return entry.Children
+		}
+		if strings.HasPrefix(p, entryPath+"/") {
+			return findFolder(entry.Children, p)
+		}
+	}
+	return nil
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/timhughes/fishki/internal/acl"
+	"github.com/timhughes/fishki/internal/config"
+)
+
+func setupBundleWiki(t *testing.T) (*Handler, func()) {
+	handler, cleanup := setupUnitTestHandler(t)
+	writeWikiFiles(t, handler.cfg().WikiPath, map[string]string{
+		"docs/index.md":     "# Docs\n\nStart with the [guide](guide.md#install).\n",
+		"docs/guide.md":     "# Guide\n\n## Install\n\nRun it[^1] & see [top](#install).\n\n[^1]: Really.\n\n![Logo](../images/logo.png)\n",
+		"docs/sub/faq.md":   "# FAQ\n\n## Why\n\nBecause.\n",
+		"images/logo.png":   "\x89PNG\r\n",
+		"other.md":          "# Other\n",
+		"docs/zz-last.md":   "Last page without a heading\n",
+		"docs/sub/index.md": "# Sub\n",
+	})
+	return handler, cleanup
+}
+
+func TestExportHandler(t *testing.T) {
+	handler, cleanup := setupBundleWiki(t)
+	defer cleanup()
+
+	tests := []struct {
+		name           string
+		method         string
+		query          string
+		expectedStatus int
+		expectedType   string
+	}{
+		{
+			name:           "Folder As HTML",
+			method:         "GET",
+			query:          "path=docs&format=html",
+			expectedStatus: http.StatusOK,
+			expectedType:   "text/html; charset=utf-8",
+		},
+		{
+			name:           "Page Without Extension",
+			method:         "GET",
+			query:          "path=other",
+			expectedStatus: http.StatusOK,
+			expectedType:   "text/html; charset=utf-8",
+		},
+		{
+			name:           "Whole Wiki As EPUB",
+			method:         "GET",
+			query:          "format=epub",
+			expectedStatus: http.StatusOK,
+			expectedType:   "application/epub+zip",
+		},
+		{
+			name:           "Zip",
+			method:         "GET",
+			query:          "path=docs&format=zip",
+			expectedStatus: http.StatusOK,
+			expectedType:   "application/zip",
+		},
+		{
+			name:           "Invalid Format",
+			method:         "GET",
+			query:          "path=docs&format=pdf",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Directory Traversal",
+			method:         "GET",
+			query:          "path=../etc",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Not Found",
+			method:         "GET",
+			query:          "path=nope",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid Method",
+			method:         "POST",
+			query:          "path=docs",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/api/export?"+tc.query, nil)
+			rr := httptest.NewRecorder()
+
+			handler.exportHandler()(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tc.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tc.expectedType != "" && rr.Header().Get("Content-Type") != tc.expectedType {
+				t.Errorf("Expected content type %q, got %q", tc.expectedType, rr.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestExportHandlerHTML(t *testing.T) {
+	handler, cleanup := setupBundleWiki(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("GET", "/api/export?path=docs", nil)
+	rr := httptest.NewRecorder()
+	handler.exportHandler()(rr, req)
+	body := rr.Body.String()
+
+	if got := rr.Header().Get("Content-Disposition"); got != `attachment; filename="docs.html"` {
+		t.Errorf("Unexpected Content-Disposition %q", got)
+	}
+
+	// Tree order: the folder's index, subfolders, then the other pages
+	order := []string{`id="page-1"`, "<h1 id=\"page-1-docs\">Docs</h1>", `id="page-2"`, ">Sub</h1>", ">FAQ</h1>", ">Guide</h1>", "Last page without a heading"}
+	last := -1
+	for _, want := range order {
+		i := strings.Index(body, want)
+		if i < 0 {
+			t.Fatalf("Expected %q in export:\n%s", want, body)
+		}
+		if i < last {
+			t.Errorf("Expected %q to come later in the export", want)
+		}
+		last = i
+	}
+
+	for _, want := range []string{
+		`<nav class="toc">`,
+		`<li><a href="#page-4">Guide</a><ol>`,
+		`<a href="#page-4-install">Install</a>`,
+		`<li><a href="#page-5">zz-last</a></li>`,
+		// Links between bundled pages and within a page
+		`<a href="#page-4-install">guide</a>`,
+		`<a href="#page-4-install">top</a>`,
+		`src="data:image/png;base64,iVBORw0K"`,
+		`.chroma`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in export:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, ">Other</h1>") {
+		t.Error("Pages outside the folder should not be exported")
+	}
+}
+
+func TestExportHandlerEPUB(t *testing.T) {
+	handler, cleanup := setupBundleWiki(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("GET", "/api/export?path=docs&format=epub", nil)
+	rr := httptest.NewRecorder()
+	handler.exportHandler()(rr, req)
+
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("Export is not a zip: %v", err)
+	}
+	if zr.File[0].Name != "mimetype" || zr.File[0].Method != zip.Store {
+		t.Errorf("Expected an uncompressed mimetype first, got %s", zr.File[0].Name)
+	}
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+
+		// Every XML file in the book must be well formed
+		if strings.HasSuffix(f.Name, ".xhtml") || strings.HasSuffix(f.Name, ".opf") || strings.HasSuffix(f.Name, ".xml") {
+			decoder := xml.NewDecoder(bytes.NewReader(data))
+			for {
+				if _, err := decoder.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Errorf("%s is not well formed: %v\n%s", f.Name, err, data)
+					break
+				}
+			}
+		}
+	}
+
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/content.opf", "OEBPS/nav.xhtml", "OEBPS/chapter-1.xhtml", "OEBPS/chapter-5.xhtml", "OEBPS/images/image-1.png"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("Expected %s in EPUB", name)
+		}
+	}
+	if !strings.Contains(files["OEBPS/content.opf"], `href="images/image-1.png" media-type="image/png"`) {
+		t.Errorf("Expected the image in the manifest:\n%s", files["OEBPS/content.opf"])
+	}
+	if !strings.Contains(files["OEBPS/nav.xhtml"], `<a href="chapter-4.xhtml#install">Install</a>`) {
+		t.Errorf("Expected headings in the nav:\n%s", files["OEBPS/nav.xhtml"])
+	}
+	if !strings.Contains(files["OEBPS/chapter-1.xhtml"], `href="chapter-4.xhtml#install"`) {
+		t.Errorf("Expected links between chapters:\n%s", files["OEBPS/chapter-1.xhtml"])
+	}
+}
+
+func TestExportHandlerZip(t *testing.T) {
+	handler, cleanup := setupBundleWiki(t)
+	defer cleanup()
+
+	// A symlinked image pointing outside the wiki must not be packed
+	outside := filepath.Join(t.TempDir(), "secret.png")
+	if err := os.WriteFile(outside, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(handler.cfg().WikiPath, "images", "link.png")); err != nil {
+		t.Fatal(err)
+	}
+	writeWikiFiles(t, handler.cfg().WikiPath, map[string]string{"docs/zz-last.md": "![Secret](/images/link.png)\n"})
+
+	req := httptest.NewRequest("GET", "/api/export?path=docs&format=zip", nil)
+	rr := httptest.NewRecorder()
+	handler.exportHandler()(rr, req)
+
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("Export is not a zip: %v", err)
+	}
+	var names []string
+	var index string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if f.Name == "index.html" {
+			rc, _ := f.Open()
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			index = string(data)
+		}
+	}
+	// Images are written as they are found, before the document
+	if strings.Join(names, ",") != "images/images/logo.png,index.html" {
+		t.Errorf("Unexpected zip contents %v", names)
+	}
+	if !strings.Contains(index, `src="images/images/logo.png"`) || !strings.Contains(index, `src="/images/link.png"`) {
+		t.Errorf("Unexpected image sources:\n%s", index)
+	}
+}
+
+func TestExportHandlerImageSymlinkToRestricted(t *testing.T) {
+	handler, cleanup := setupBundleWiki(t)
+	defer cleanup()
+
+	root := handler.cfg().WikiPath
+	writeWikiFiles(t, root, map[string]string{
+		"private/secret.png": "secret",
+		"docs/zz-last.md":    "![Secret](/images/leak.png)\n",
+	})
+	if err := os.Symlink(filepath.Join(root, "private", "secret.png"), filepath.Join(root, "images", "leak.png")); err != nil {
+		t.Fatal(err)
+	}
+	setConfig(handler, func(c *config.Config) {
+		c.ACL = &acl.Policy{Paths: map[string]acl.Rules{"private": {"group:staff": acl.Read}}}
+	})
+
+	req := httptest.NewRequest("GET", "/api/export?path=docs&format=zip", nil)
+	rr := httptest.NewRecorder()
+	handler.exportHandler()(rr, req)
+
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("Export is not a zip: %v", err)
+	}
+	for _, f := range zr.File {
+		if strings.Contains(f.Name, "leak") || strings.Contains(f.Name, "secret") {
+			t.Errorf("Expected the restricted image to be left out, got %s", f.Name)
+		}
+	}
+}
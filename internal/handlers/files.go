@@ -30,20 +30,29 @@ func (h *Handler) handleFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if h.config.WikiPath == "" {
+	cfg := h.cfg()
+	if cfg.WikiPath == "" {
 		http.Error(w, "Wiki path not set", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Getting file tree for wiki path: %s", h.config.WikiPath)
-	files, err := buildDirectoryTree(h.config.WikiPath)
+	log.Printf("Getting file tree for wiki path: %s", cfg.WikiPath)
+	files, err := buildDirectoryTree(cfg.WikiPath)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Leave out everything the user isn't allowed to read
+	canRead, err := h.readFilter(r, cfg)
+	if err != nil {
+		http.Error(w, "Failed to load access policy", http.StatusInternalServerError)
+		return
+	}
+	files = filterTree(files, canRead)
+
 	// Get the repository directory name to use as the root node
-	repoName := filepath.Base(h.config.WikiPath)
+	repoName := filepath.Base(cfg.WikiPath)
 	
 	// Create a root node with the repository name
 	rootNode := []FileInfo{
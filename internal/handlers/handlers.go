@@ -2,46 +2,190 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/timhughes/fishki/internal/acl"
+	"github.com/timhughes/fishki/internal/audit"
+	"github.com/timhughes/fishki/internal/auth"
 	"github.com/timhughes/fishki/internal/config"
 	"github.com/timhughes/fishki/internal/git"
 	"github.com/timhughes/fishki/internal/markdown"
 )
 
 type Handler struct {
-	config *config.Config
-	git    git.GitClient
+	// config is the running config. It is never changed in place: updates
+	// store a new copy, so a request that took the old one finishes
+	// against the wiki it started with
+	config atomic.Pointer[config.Config]
+
+	// configMu serialises changes to the config, so the file and the
+	// running config always agree
+	configMu sync.Mutex
+
+	// syncMu keeps background pushes and pulls from overlapping
+	syncMu sync.Mutex
+
+	git      git.GitClient
+	renderer *markdown.Renderer
+
+	// renderCache is shared by every renderer the handler creates
+	renderCache *markdown.Cache
+
+	// users holds local accounts; logging in is required once one exists
+	users    *auth.UserStore
+	sessions *auth.SessionStore
+
+	// oidc is the single sign-on provider, if one is configured
+	oidc *auth.OIDC
+
+	// proxy identifies users from a trusted reverse proxy's headers
+	proxy *auth.ProxyAuth
+
+	// tokens are the personal API tokens scripts authenticate with
+	tokens *auth.TokenStore
+
+	// aclFile caches the wiki's own access policy
+	aclFile *acl.FileCache
+
+	// auditLog records changes and failed logins
+	auditLog *audit.Log
 }
 
 func NewHandler(cfg *config.Config) *Handler {
-	return &Handler{
-		config: cfg,
+	var cache *markdown.Cache
+	if size := cfg.RenderCacheSize(); size > 0 {
+		cache = markdown.NewCache(size)
+	}
+
+	opts := cfg.MarkdownOptions()
+	opts.Cache = cache
+	h := &Handler{
+		renderer:    markdown.New(opts),
+		renderCache: cache,
+		sessions:    auth.NewSessionStore(time.Duration(cfg.Auth.SessionHours) * time.Hour),
+		aclFile:     &acl.FileCache{},
 	}
+	h.config.Store(cfg)
+	return h
+}
+
+// cfg returns the running config. Requests take it once and use that
+// snapshot throughout, and must not change it
+func (h *Handler) cfg() *config.Config {
+	return h.config.Load()
 }
 
 func (h *Handler) SetGitClient(client git.GitClient) {
 	h.git = client
 }
 
-func SetupHandlers(mux *http.ServeMux, cfg *config.Config) {
+// SetUserStore sets the local accounts requests are authenticated against
+func (h *Handler) SetUserStore(users *auth.UserStore) {
+	h.users = users
+}
+
+// SetOIDC sets the single sign-on provider users can log in with
+func (h *Handler) SetOIDC(provider *auth.OIDC) {
+	h.oidc = provider
+}
+
+// SetProxyAuth sets the reverse proxy whose identity headers are trusted
+func (h *Handler) SetProxyAuth(proxy *auth.ProxyAuth) {
+	h.proxy = proxy
+}
+
+// SetAuditLog sets where changes and failed logins are recorded
+func (h *Handler) SetAuditLog(l *audit.Log) {
+	h.auditLog = l
+}
+
+// SetTokenStore sets the API tokens requests can authenticate with
+func (h *Handler) SetTokenStore(tokens *auth.TokenStore) {
+	h.tokens = tokens
+}
+
+func SetupHandlers(mux *http.ServeMux, cfg *config.Config) error {
 	h := NewHandler(cfg)
 	
 	// Initialize Git client
 	h.SetGitClient(git.New())
 
-	// Create a rate limiter for API endpoints (100 requests per minute)
-	rateLimiter := NewRateLimiter(60*time.Second, 100)
+	usersPath, err := config.GetUsersPath()
+	if err != nil {
+		return fmt.Errorf("failed to get users path: %v", err)
+	}
+	users, err := auth.LoadUsers(usersPath)
+	if err != nil {
+		return err
+	}
+	h.SetUserStore(users)
+
+	tokensPath, err := config.GetTokensPath()
+	if err != nil {
+		return fmt.Errorf("failed to get tokens path: %v", err)
+	}
+	tokens, err := auth.LoadTokens(tokensPath)
+	if err != nil {
+		return err
+	}
+	h.SetTokenStore(tokens)
+
+	auditPath, err := cfg.GetAuditPath()
+	if err != nil {
+		return fmt.Errorf("failed to get audit log path: %v", err)
+	}
+	auditLog, err := audit.Open(auditPath, int64(cfg.Audit.MaxSizeMB)<<20, cfg.Audit.MaxFiles)
+	if err != nil {
+		return err
+	}
+	h.SetAuditLog(auditLog)
+
+	if cfg.Auth.OIDC != nil {
+		provider, err := auth.NewOIDC(*cfg.Auth.OIDC)
+		if err != nil {
+			return err
+		}
+		h.SetOIDC(provider)
+	}
+	if cfg.Auth.Proxy != nil {
+		proxy, err := auth.NewProxyAuth(*cfg.Auth.Proxy)
+		if err != nil {
+			return err
+		}
+		h.SetProxyAuth(proxy)
+	}
+	if !h.authRequired() {
+		if cfg.ReadOnly {
+			log.Printf("Warning: Read-only mode without user accounts, so nobody can edit the wiki. Add one with `fishki-server user add`.")
+		} else {
+			log.Printf("Warning: No user accounts, so the wiki is open to anyone who can reach it. Add one with `fishki-server user add`.")
+		}
+	}
+
+	if cfg.Git.SyncMinutes > 0 {
+		go h.syncLoop(time.Duration(cfg.Git.SyncMinutes) * time.Minute)
+	}
+
+	// Rate limit API endpoints, and logging in much more tightly to slow
+	// down password guessing
+	apiLimit := rateLimit(cfg.RateLimit.Requests, h.proxy.ClientIP)
+	loginLimit := rateLimit(cfg.RateLimit.Login, h.proxy.ClientIP)
 
 	// Add security middleware
 	securityChain := func(handler http.Handler) http.Handler {
 		return AccessLoggerMiddleware(
 			SecurityHeadersMiddleware(
-				RateLimitMiddleware(rateLimiter)(
-					handler,
+				apiLimit(
+					h.AuthMiddleware(auth.ScopeRead)(
+						handler,
+					),
 				),
 			),
 		)
@@ -51,28 +195,120 @@ func SetupHandlers(mux *http.ServeMux, cfg *config.Config) {
 	writeSecurityChain := func(handler http.Handler) http.Handler {
 		return AccessLoggerMiddleware(
 			SecurityHeadersMiddleware(
-				RateLimitMiddleware(rateLimiter)(
-					CSRFMiddleware(
-						handler,
+				apiLimit(
+					h.AuthMiddleware(auth.ScopeWrite)(
+						CSRFMiddleware(
+							handler,
+						),
+					),
+				),
+			),
+		)
+	}
+
+	// Changes to the server's setup need an admin API token
+	adminSecurityChain := func(handler http.Handler) http.Handler {
+		return AccessLoggerMiddleware(
+			SecurityHeadersMiddleware(
+				apiLimit(
+					h.AuthMiddleware(auth.ScopeAdmin)(
+						CSRFMiddleware(
+							handler,
+						),
 					),
 				),
 			),
 		)
 	}
 
+	// Endpoints needed before logging in skip authentication
+	publicChain := func(handler http.Handler) http.Handler {
+		return AccessLoggerMiddleware(
+			SecurityHeadersMiddleware(
+				apiLimit(
+					handler,
+				),
+			),
+		)
+	}
+
 	// Set up API routes
 	mux.Handle("/api/files", securityChain(http.HandlerFunc(h.handleFiles)))
 	mux.Handle("/api/load", securityChain(http.HandlerFunc(h.loadHandler())))
 	mux.Handle("/api/save", writeSecurityChain(http.HandlerFunc(h.saveHandler())))
 	mux.Handle("/api/delete", writeSecurityChain(http.HandlerFunc(h.deleteHandler())))
+	mux.Handle("/api/trash", securityChain(http.HandlerFunc(h.trashHandler())))
+	mux.Handle("/api/trash/restore", writeSecurityChain(http.HandlerFunc(h.trashRestoreHandler())))
 	mux.Handle("/api/render", securityChain(http.HandlerFunc(h.renderHandler())))
-	mux.Handle("/api/init", writeSecurityChain(http.HandlerFunc(h.initHandler())))
+	mux.Handle("/api/page", securityChain(http.HandlerFunc(h.pageHandler())))
+	mux.Handle("/api/export", securityChain(http.HandlerFunc(h.exportHandler())))
+	mux.Handle("/api/import", adminSecurityChain(http.HandlerFunc(h.importHandler())))
+	mux.Handle("/api/highlight.css", securityChain(http.HandlerFunc(h.highlightCSSHandler())))
+	mux.Handle("/api/init", adminSecurityChain(http.HandlerFunc(h.initHandler())))
 	mux.Handle("/api/pull", writeSecurityChain(http.HandlerFunc(h.pullHandler())))
 	mux.Handle("/api/push", writeSecurityChain(http.HandlerFunc(h.pushHandler())))
 	mux.Handle("/api/fetch", writeSecurityChain(http.HandlerFunc(h.fetchHandler())))
 	mux.Handle("/api/status", securityChain(http.HandlerFunc(h.statusHandler())))
-	mux.Handle("/api/config", securityChain(http.HandlerFunc(h.configHandler())))
-	mux.Handle("/api/csrf-token", securityChain(http.HandlerFunc(CSRFTokenHandler)))
+	mux.Handle("/api/metrics", securityChain(http.HandlerFunc(h.metricsHandler())))
+	mux.Handle("/api/config", adminSecurityChain(http.HandlerFunc(h.configHandler())))
+	mux.Handle("/api/tokens", adminSecurityChain(http.HandlerFunc(h.tokensHandler())))
+	mux.Handle("/api/audit", adminSecurityChain(http.HandlerFunc(h.auditHandler())))
+	mux.Handle("/api/csrf-token", publicChain(http.HandlerFunc(CSRFTokenHandler)))
+	mux.Handle("/api/auth/login", publicChain(loginLimit(CSRFMiddleware(http.HandlerFunc(h.loginHandler())))))
+	mux.Handle("/api/auth/logout", publicChain(CSRFMiddleware(http.HandlerFunc(h.logoutHandler()))))
+	mux.Handle("/api/auth/me", publicChain(http.HandlerFunc(h.meHandler())))
+	mux.Handle("/api/auth/oidc/login", publicChain(loginLimit(http.HandlerFunc(h.oidcLoginHandler()))))
+	mux.Handle("/api/auth/oidc/callback", publicChain(http.HandlerFunc(h.oidcCallbackHandler())))
+
+	return nil
+}
+
+// rateLimit returns middleware allowing each client limit requests a
+// minute, or none at all when the limit isn't positive. Clients are told
+// apart by clientIP, which must not believe headers from just anyone
+func rateLimit(limit int, clientIP func(*http.Request) string) func(http.Handler) http.Handler {
+	if limit <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return RateLimitMiddleware(NewRateLimiter(time.Minute, limit), clientIP)
+}
+
+// updateConfig applies a change to the config file and then to the running
+// config. The change is validated first, so a bad one touches neither
+func (h *Handler) updateConfig(change func(*config.Config)) error {
+	h.configMu.Lock()
+	defer h.configMu.Unlock()
+
+	next := *h.cfg()
+	change(&next)
+	if err := next.Validate(); err != nil {
+		return err
+	}
+	if err := config.Update(change); err != nil {
+		return err
+	}
+	h.config.Store(&next)
+	return nil
+}
+
+// wikiDir checks that a path from a request is an existing directory and
+// returns it as an absolute path
+func wikiDir(p string) (string, int, string) {
+	absPath, err := filepath.Abs(p)
+	if err != nil {
+		return "", http.StatusBadRequest, "Invalid path"
+	}
+	info, err := os.Stat(absPath)
+	if os.IsNotExist(err) {
+		return "", http.StatusBadRequest, "Directory does not exist"
+	}
+	if err != nil {
+		return "", http.StatusInternalServerError, "Failed to check directory"
+	}
+	if !info.IsDir() {
+		return "", http.StatusBadRequest, "Path is not a directory"
+	}
+	return absPath, http.StatusOK, ""
 }
 
 func (h *Handler) initHandler() http.HandlerFunc {
@@ -82,6 +318,11 @@ func (h *Handler) initHandler() http.HandlerFunc {
 			return
 		}
 
+		cfg := h.cfg()
+		if !h.authorize(w, r, cfg, "", acl.Admin) {
+			return
+		}
+
 		var request struct {
 			Path string `json:"path"`
 		}
@@ -97,15 +338,9 @@ func (h *Handler) initHandler() http.HandlerFunc {
 		}
 
 		// Validate the path is safe
-		absPath, err := filepath.Abs(request.Path)
-		if err != nil {
-			http.Error(w, "Invalid path", http.StatusBadRequest)
-			return
-		}
-
-		// Check if the directory exists
-		if _, err := os.Stat(absPath); os.IsNotExist(err) {
-			http.Error(w, "Directory does not exist", http.StatusBadRequest)
+		absPath, status, message := wikiDir(request.Path)
+		if status != http.StatusOK {
+			http.Error(w, message, status)
 			return
 		}
 
@@ -115,8 +350,12 @@ func (h *Handler) initHandler() http.HandlerFunc {
 			return
 		}
 
-		// Update the config
-		h.config.WikiPath = absPath
+		// Update the config, keeping it across restarts
+		if err := h.updateConfig(func(c *config.Config) { c.WikiPath = absPath }); err != nil {
+			http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.record(r, audit.Event{Action: "init", Success: true, Detail: absPath})
 
 		// Return success
 		w.Header().Set("Content-Type", "application/json")
@@ -126,18 +365,45 @@ func (h *Handler) initHandler() http.HandlerFunc {
 	}
 }
 
+// canConfigure reports whether the caller may see and change the server's
+// setup
+func (h *Handler) canConfigure(r *http.Request, cfg *config.Config) bool {
+	id := auth.FromContext(r.Context())
+	if cfg.ReadOnly && !isEditor(cfg, id) {
+		return false
+	}
+	if id != nil && !id.Allows(auth.ScopeAdmin) {
+		return false
+	}
+	level, err := h.access(r, cfg, "")
+	return err == nil && level >= acl.Admin
+}
+
 func (h *Handler) configHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
 		if r.Method == http.MethodGet {
-			// Return current config
+			// Only admins see where the wiki is on the server. Everyone else
+			// gets its folder name, enough to tell that it is set up
+			wikiPath := cfg.WikiPath
+			if wikiPath != "" && !h.canConfigure(r, cfg) {
+				wikiPath = filepath.Base(wikiPath)
+			}
 			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]string{
-				"wikiPath": h.config.WikiPath,
+			json.NewEncoder(w).Encode(map[string]any{
+				"wikiPath":   wikiPath,
+				"configured": cfg.WikiPath != "",
+				"readOnly":   cfg.ReadOnly,
 			})
 			return
 		}
 
 		if r.Method == http.MethodPost {
+			if !h.authorize(w, r, cfg, "", acl.Admin) {
+				return
+			}
+
 			var request struct {
 				WikiPath string `json:"wikiPath"`
 			}
@@ -153,20 +419,18 @@ func (h *Handler) configHandler() http.HandlerFunc {
 			}
 
 			// Validate the path is safe
-			absPath, err := filepath.Abs(request.WikiPath)
-			if err != nil {
-				http.Error(w, "Invalid path", http.StatusBadRequest)
+			absPath, status, message := wikiDir(request.WikiPath)
+			if status != http.StatusOK {
+				http.Error(w, message, status)
 				return
 			}
 
-			// Check if the directory exists
-			if _, err := os.Stat(absPath); os.IsNotExist(err) {
-				http.Error(w, "Directory does not exist", http.StatusBadRequest)
+			// Update the config, keeping it across restarts
+			if err := h.updateConfig(func(c *config.Config) { c.WikiPath = absPath }); err != nil {
+				http.Error(w, "Failed to save config: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
-
-			// Update the config
-			h.config.WikiPath = absPath
+			h.record(r, audit.Event{Action: "config", Success: true, Detail: "wikiPath=" + absPath})
 
 			// Return success
 			w.Header().Set("Content-Type", "application/json")
@@ -182,25 +446,33 @@ func (h *Handler) configHandler() http.HandlerFunc {
 
 func (h *Handler) pullHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		if h.config.WikiPath == "" {
+		if cfg.WikiPath == "" {
 			http.Error(w, "Wiki path not set", http.StatusBadRequest)
 			return
 		}
 
+		if !h.authorize(w, r, cfg, "", acl.Write) {
+			return
+		}
+
 		if h.git == nil {
 			http.Error(w, "Git client not initialized", http.StatusInternalServerError)
 			return
 		}
 
-		if err := h.git.Pull(h.config.WikiPath); err != nil {
+		if err := h.git.Pull(cfg.WikiPath); err != nil {
+			h.record(r, audit.Event{Action: "pull", Detail: err.Error()})
 			http.Error(w, "Failed to pull changes: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		h.record(r, audit.Event{Action: "pull", Success: true})
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]bool{"success": true})
@@ -209,26 +481,34 @@ func (h *Handler) pullHandler() http.HandlerFunc {
 
 func (h *Handler) pushHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		if h.config.WikiPath == "" {
+		if cfg.WikiPath == "" {
 			http.Error(w, "Wiki path not set", http.StatusBadRequest)
 			return
 		}
 
+		if !h.authorize(w, r, cfg, "", acl.Write) {
+			return
+		}
+
 		if h.git == nil {
 			http.Error(w, "Git client not initialized", http.StatusInternalServerError)
 			return
 		}
 
-		if err := h.git.Push(h.config.WikiPath); err != nil {
+		if err := h.git.Push(cfg.WikiPath); err != nil {
+			h.record(r, audit.Event{Action: "push", Detail: err.Error()})
 			errMsg := "Failed to push changes: " + err.Error()
 			http.Error(w, errMsg, http.StatusInternalServerError)
 			return
 		}
+		h.record(r, audit.Event{Action: "push", Success: true})
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]bool{"success": true})
@@ -237,22 +517,28 @@ func (h *Handler) pushHandler() http.HandlerFunc {
 
 func (h *Handler) fetchHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		if h.config.WikiPath == "" {
+		if cfg.WikiPath == "" {
 			http.Error(w, "Wiki path not set", http.StatusBadRequest)
 			return
 		}
 
+		if !h.authorize(w, r, cfg, "", acl.Write) {
+			return
+		}
+
 		if h.git == nil {
 			http.Error(w, "Git client not initialized", http.StatusInternalServerError)
 			return
 		}
 
-		if err := h.git.Fetch(h.config.WikiPath); err != nil {
+		if err := h.git.Fetch(cfg.WikiPath); err != nil {
 			http.Error(w, "Failed to fetch changes: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -264,12 +550,14 @@ func (h *Handler) fetchHandler() http.HandlerFunc {
 
 func (h *Handler) loadHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		if h.config.WikiPath == "" {
+		if cfg.WikiPath == "" {
 			http.Error(w, "Wiki path not set", http.StatusBadRequest)
 			return
 		}
@@ -280,14 +568,17 @@ func (h *Handler) loadHandler() http.HandlerFunc {
 			return
 		}
 
-		// Sanitize the filename to prevent directory traversal
-		filename = filepath.Clean(filename)
-		if filepath.IsAbs(filename) {
-			filename = filename[1:] // Remove leading slash
+		// Resolve the filename inside the wiki, so access is checked on the
+		// file that is actually read
+		filename, fullPath, err := wikiFile(cfg.WikiPath, filename)
+		if err != nil {
+			http.Error(w, "Invalid filename", http.StatusBadRequest)
+			return
 		}
 
-		// Construct the full path
-		fullPath := filepath.Join(h.config.WikiPath, filename)
+		if !h.authorize(w, r, cfg, filename, acl.Read) {
+			return
+		}
 
 		// Check if the file exists
 		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
@@ -310,12 +601,14 @@ func (h *Handler) loadHandler() http.HandlerFunc {
 
 func (h *Handler) saveHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		if h.config.WikiPath == "" {
+		if cfg.WikiPath == "" {
 			http.Error(w, "Wiki path not set", http.StatusBadRequest)
 			return
 		}
@@ -335,38 +628,46 @@ func (h *Handler) saveHandler() http.HandlerFunc {
 			return
 		}
 
-		// Sanitize the filename to prevent directory traversal
-		filename := filepath.Clean(request.Filename)
-		if filepath.IsAbs(filename) {
-			filename = filename[1:] // Remove leading slash
+		// Resolve the filename inside the wiki, so access is checked on the
+		// file that is actually changed
+		filename, fullPath, err := wikiFile(cfg.WikiPath, request.Filename)
+		if err != nil {
+			h.record(r, audit.Event{Action: "save", Path: request.Filename, Detail: err.Error()})
+			http.Error(w, "Invalid filename", http.StatusBadRequest)
+			return
 		}
 
-		// Construct the full path
-		fullPath := filepath.Join(h.config.WikiPath, filename)
+		if !h.authorize(w, r, cfg, filename, acl.Write) {
+			return
+		}
 
 		// Create parent directories if they don't exist
 		dir := filepath.Dir(fullPath)
 		if err := os.MkdirAll(dir, 0755); err != nil {
+			h.record(r, audit.Event{Action: "save", Path: filename, Detail: err.Error()})
 			http.Error(w, "Failed to create directories", http.StatusInternalServerError)
 			return
 		}
 
 		// Write the file
 		if err := os.WriteFile(fullPath, []byte(request.Content), 0644); err != nil {
+			h.record(r, audit.Event{Action: "save", Path: filename, Detail: err.Error()})
 			http.Error(w, "Failed to write file", http.StatusInternalServerError)
 			return
 		}
 
 		// Commit the changes
-		if h.git != nil && h.git.IsRepository(h.config.WikiPath) {
-			if err := h.git.Commit(h.config.WikiPath, "Update "+filename); err != nil {
+		if h.git != nil && h.git.IsRepository(cfg.WikiPath) {
+			if err := h.git.CommitWith(cfg.WikiPath, git.CommitOptions{Message: "Update " + filename, Author: commitAuthor(r)}); err != nil {
 				// Log the error but don't fail the request
 				// This allows the file to be saved even if Git operations fail
 				// For example, if the user hasn't configured Git
 				// TODO: Add proper logging
 				// fmt.Println("Failed to commit changes:", err)
 			}
+			h.autoPush(cfg)
 		}
+		h.record(r, audit.Event{Action: "save", Success: true, Path: filename})
 
 		// Return success
 		w.Header().Set("Content-Type", "application/json")
@@ -378,12 +679,14 @@ func (h *Handler) saveHandler() http.HandlerFunc {
 
 func (h *Handler) deleteHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
 		if r.Method != http.MethodDelete {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		if h.config.WikiPath == "" {
+		if cfg.WikiPath == "" {
 			http.Error(w, "Wiki path not set", http.StatusBadRequest)
 			return
 		}
@@ -402,30 +705,36 @@ func (h *Handler) deleteHandler() http.HandlerFunc {
 			return
 		}
 
-		// Sanitize the filename to prevent directory traversal
-		filename := filepath.Clean(request.Filename)
-		if filepath.IsAbs(filename) {
-			filename = filename[1:] // Remove leading slash
+		// Resolve the filename inside the wiki, so access is checked on the
+		// file that is actually changed
+		filename, fullPath, err := wikiFile(cfg.WikiPath, request.Filename)
+		if err != nil {
+			h.record(r, audit.Event{Action: "delete", Path: request.Filename, Detail: err.Error()})
+			http.Error(w, "Invalid filename", http.StatusBadRequest)
+			return
 		}
 
-		// Construct the full path
-		fullPath := filepath.Join(h.config.WikiPath, filename)
+		if !h.authorize(w, r, cfg, filename, acl.Write) {
+			return
+		}
 
 		// Check if the file exists
 		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+			h.record(r, audit.Event{Action: "delete", Path: filename, Detail: "not found"})
 			http.Error(w, "File not found", http.StatusNotFound)
 			return
 		}
 
 		// Delete the file
 		if err := os.Remove(fullPath); err != nil {
+			h.record(r, audit.Event{Action: "delete", Path: filename, Detail: err.Error()})
 			http.Error(w, "Failed to delete file", http.StatusInternalServerError)
 			return
 		}
 
 		// Clean up empty parent directories
 		parentDir := filepath.Dir(fullPath)
-		for parentDir != h.config.WikiPath {
+		for parentDir != cfg.WikiPath {
 			// Check if directory is empty
 			entries, err := os.ReadDir(parentDir)
 			if err != nil {
@@ -446,14 +755,16 @@ func (h *Handler) deleteHandler() http.HandlerFunc {
 		}
 
 		// Commit the changes
-		if h.git != nil && h.git.IsRepository(h.config.WikiPath) {
-			if err := h.git.Commit(h.config.WikiPath, "Delete "+filename); err != nil {
+		if h.git != nil && h.git.IsRepository(cfg.WikiPath) {
+			if err := h.git.CommitWith(cfg.WikiPath, git.CommitOptions{Message: "Delete " + filename, Author: commitAuthor(r)}); err != nil {
 				// Log the error but don't fail the request
 				// This allows the file to be deleted even if Git operations fail
 				// TODO: Add proper logging
 				// fmt.Println("Failed to commit changes:", err)
 			}
+			h.autoPush(cfg)
 		}
+		h.record(r, audit.Event{Action: "delete", Success: true, Path: filename})
 
 		// Return success
 		w.Header().Set("Content-Type", "application/json")
@@ -472,6 +783,7 @@ func (h *Handler) renderHandler() http.HandlerFunc {
 
 		var request struct {
 			Markdown string `json:"markdown"`
+			Filename string `json:"filename,omitempty"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -484,9 +796,16 @@ func (h *Handler) renderHandler() http.HandlerFunc {
 			return
 		}
 
+		cfg := h.cfg()
+		canRead, err := h.readFilter(r, cfg)
+		if err != nil {
+			http.Error(w, "Failed to load access policy", http.StatusInternalServerError)
+			return
+		}
+
 		// Note: This endpoint is kept for backward compatibility
 		// but rendering is now done client-side
-		rendered := markdown.Render([]byte(request.Markdown))
+		rendered := h.renderer.Render(markdown.ExpandIncludes([]byte(request.Markdown), request.Filename, includeLoader(cfg.WikiPath, canRead)))
 
 		w.Header().Set("Content-Type", "text/html")
 		w.Write(rendered)
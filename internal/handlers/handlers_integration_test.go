@@ -6,7 +6,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/timhughes/fishki/internal/config"
@@ -20,6 +22,10 @@ func setupIntegrationTest(t *testing.T) (*Handler, func()) {
 		t.Fatalf("Failed to create temp dir: %v", err)
 	}
 
+	// Keep config changes away from the real config file
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("APPDATA", t.TempDir())
+
 	cfg := &config.Config{WikiPath: tmpDir}
 	handler := NewHandler(cfg)
 	handler.SetGitClient(git.NewMock())
@@ -38,7 +44,7 @@ func TestDeleteHandlerIntegration(t *testing.T) {
 	// Create test file and subdirectory structure
 	testContent := "This is a test file."
 	testPath := filepath.Join("subdir", "nested", "test.md")
-	fullPath := filepath.Join(handler.config.WikiPath, testPath)
+	fullPath := filepath.Join(handler.cfg().WikiPath, testPath)
 
 	// Create directories and file
 	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
@@ -66,7 +72,7 @@ func TestDeleteHandlerIntegration(t *testing.T) {
 	}
 
 	// Verify directory cleanup
-	parentDir := filepath.Join(handler.config.WikiPath, "subdir", "nested")
+	parentDir := filepath.Join(handler.cfg().WikiPath, "subdir", "nested")
 	if _, err := os.Stat(parentDir); !os.IsNotExist(err) {
 		t.Error("empty parent directory was not deleted")
 	}
@@ -77,7 +83,7 @@ func TestGitIntegration(t *testing.T) {
 	defer cleanup()
 
 	// Test Git init
-	initBody := map[string]string{"path": handler.config.WikiPath}
+	initBody := map[string]string{"path": handler.cfg().WikiPath}
 	bodyBytes, _ := json.Marshal(initBody)
 	req := httptest.NewRequest(http.MethodPost, "/api/init", bytes.NewBuffer(bodyBytes))
 	w := httptest.NewRecorder()
@@ -143,7 +149,7 @@ func TestSaveHandlerIntegration(t *testing.T) {
 	}
 
 	// Verify file creation and content
-	filePath := filepath.Join(handler.config.WikiPath, reqBody["filename"])
+	filePath := filepath.Join(handler.cfg().WikiPath, reqBody["filename"])
 	content, err := os.ReadFile(filePath)
 	if err != nil {
 		t.Errorf("failed to read created file: %v", err)
@@ -158,7 +164,7 @@ func TestLoadHandlerIntegration(t *testing.T) {
 	defer cleanup()
 
 	testContent := "# Test Content"
-	testFile := filepath.Join(handler.config.WikiPath, "test.md")
+	testFile := filepath.Join(handler.cfg().WikiPath, "test.md")
 
 	// Create test file
 	if err := os.WriteFile(testFile, []byte(testContent), 0644); err != nil {
@@ -189,3 +195,122 @@ func TestLoadHandlerIntegration(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v", w.Code, http.StatusNotFound)
 	}
 }
+
+func TestTrashHandlerIntegration(t *testing.T) {
+	handler, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	wiki := handler.cfg().WikiPath
+	client := git.New()
+	handler.SetGitClient(client)
+	if err := client.Init(wiki); err != nil {
+		t.Fatalf("Failed to init wiki: %v", err)
+	}
+	for _, args := range [][]string{
+		{"config", "user.name", "Test User"},
+		{"config", "user.email", "test@example.com"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = wiki
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("git %v failed: %v", args, err)
+		}
+	}
+
+	// Create two pages and an attachment, then delete them all
+	writeWikiFiles(t, wiki, map[string]string{
+		"old.md":          "# Old page\n",
+		"notes/gone.md":   "# Gone\n",
+		"files/image.png": "png",
+	})
+	if err := client.Commit(wiki, "Add pages"); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	for _, name := range []string{"old.md", "notes/gone.md", "files/image.png"} {
+		if err := os.Remove(filepath.Join(wiki, name)); err != nil {
+			t.Fatalf("Failed to remove %s: %v", name, err)
+		}
+	}
+	if err := client.Commit(wiki, "Remove pages"); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	listTrash := func() []git.DeletedFile {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.trashHandler()(rr, httptest.NewRequest(http.MethodGet, "/api/trash", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var pages []git.DeletedFile
+		if err := json.Unmarshal(rr.Body.Bytes(), &pages); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		return pages
+	}
+
+	pages := listTrash()
+	if len(pages) != 2 {
+		t.Fatalf("Expected 2 deleted pages, got %+v", pages)
+	}
+	for _, p := range pages {
+		if p.Author != "Test User" || p.Message != "Remove pages" || p.Commit == "" {
+			t.Errorf("Unexpected deleted page %+v", p)
+		}
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+		expectedFile   string
+	}{
+		{"wrong method", "GET", "", http.StatusMethodNotAllowed, ""},
+		{"invalid body", "POST", "{", http.StatusBadRequest, ""},
+		{"missing path", "POST", `{}`, http.StatusBadRequest, ""},
+		{"unknown page", "POST", `{"path": "never.md"}`, http.StatusNotFound, ""},
+		{"wrong commit", "POST", `{"path": "old.md", "commit": "deadbeef"}`, http.StatusNotFound, ""},
+		{"invalid target", "POST", `{"path": "old.md", "target": "../out"}`, http.StatusBadRequest, ""},
+		{"restore", "POST", `{"path": "old.md"}`, http.StatusOK, "old.md"},
+		{"already restored", "POST", `{"path": "old.md"}`, http.StatusNotFound, ""},
+		{"existing target", "POST", `{"path": "notes/gone.md", "target": "old"}`, http.StatusConflict, ""},
+		{"restore as", "POST", `{"path": "notes/gone.md", "target": "archive/gone"}`, http.StatusOK, "archive/gone.md"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/trash/restore", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			handler.trashRestoreHandler()(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tt.expectedFile == "" {
+				return
+			}
+
+			var response map[string]string
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if response["filename"] != tt.expectedFile {
+				t.Errorf("Expected filename %q, got %q", tt.expectedFile, response["filename"])
+			}
+			if _, err := os.Stat(filepath.Join(wiki, tt.expectedFile)); err != nil {
+				t.Errorf("Expected restored page: %v", err)
+			}
+		})
+	}
+
+	// Restored pages are committed and leave the trash
+	if pages := listTrash(); len(pages) != 1 || pages[0].Path != "notes/gone.md" {
+		t.Errorf("Expected only notes/gone.md left in the trash, got %+v", pages)
+	}
+	status := exec.Command("git", "status", "--porcelain")
+	status.Dir = wiki
+	out, err := status.Output()
+	if err != nil || len(out) != 0 {
+		t.Errorf("Expected a clean working tree, got %q (%v)", out, err)
+	}
+}
@@ -3,14 +3,22 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/timhughes/fishki/internal/acl"
+	"github.com/timhughes/fishki/internal/audit"
+	"github.com/timhughes/fishki/internal/auth"
 	"github.com/timhughes/fishki/internal/config"
 	"github.com/timhughes/fishki/internal/git"
+	"github.com/timhughes/fishki/internal/test_utils"
 )
 
 func setupUnitTestHandler(t *testing.T) (*Handler, func()) {
@@ -20,6 +28,10 @@ func setupUnitTestHandler(t *testing.T) (*Handler, func()) {
 		t.Fatalf("Failed to create temp dir: %v", err)
 	}
 
+	// Keep config changes away from the real config file
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("APPDATA", t.TempDir())
+
 	cfg := &config.Config{WikiPath: tmpDir}
 	handler := NewHandler(cfg)
 	handler.SetGitClient(git.NewMock())
@@ -31,17 +43,38 @@ func setupUnitTestHandler(t *testing.T) (*Handler, func()) {
 	return handler, cleanup
 }
 
+// setConfig changes a test handler's running config
+func setConfig(h *Handler, change func(*config.Config)) {
+	next := *h.cfg()
+	change(&next)
+	h.config.Store(&next)
+}
+
+// writeWikiFiles creates files in the test wiki from a path to content map
+func writeWikiFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+}
+
 func TestHandleFiles(t *testing.T) {
 	handler, cleanup := setupUnitTestHandler(t)
 	defer cleanup()
 
 	// Create test files
-	testDir := filepath.Join(handler.config.WikiPath, "testdir")
+	testDir := filepath.Join(handler.cfg().WikiPath, "testdir")
 	if err := os.MkdirAll(testDir, 0755); err != nil {
 		t.Fatalf("Failed to create test directory: %v", err)
 	}
-	
-	testFile := filepath.Join(handler.config.WikiPath, "test.md")
+
+	testFile := filepath.Join(handler.cfg().WikiPath, "test.md")
 	if err := os.WriteFile(testFile, []byte("# Test"), 0644); err != nil {
 		t.Fatalf("Failed to create test file: %v", err)
 	}
@@ -81,7 +114,7 @@ func TestHandleFiles(t *testing.T) {
 	}
 
 	// Check that root node has the repository name
-	repoName := filepath.Base(handler.config.WikiPath)
+	repoName := filepath.Base(handler.cfg().WikiPath)
 	if rootNode["name"] != repoName {
 		t.Errorf("Expected root node name to be %q, got %q", repoName, rootNode["name"])
 	}
@@ -116,13 +149,13 @@ func TestInitHandler(t *testing.T) {
 		{
 			name:           "Success",
 			method:         "POST",
-			body:           map[string]interface{}{"path": handler.config.WikiPath},
+			body:           map[string]interface{}{"path": handler.cfg().WikiPath},
 			expectedStatus: http.StatusOK,
 		},
 		{
 			name:           "Invalid Method",
 			method:         "GET",
-			body:           map[string]interface{}{"path": handler.config.WikiPath},
+			body:           map[string]interface{}{"path": handler.cfg().WikiPath},
 			expectedStatus: http.StatusMethodNotAllowed,
 		},
 		{
@@ -181,9 +214,9 @@ func TestPullHandler(t *testing.T) {
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			if !tc.setWikiPath {
-				savedPath := handler.config.WikiPath
-				handler.config.WikiPath = ""
-				defer func() { handler.config.WikiPath = savedPath }()
+				savedPath := handler.cfg().WikiPath
+				setConfig(handler, func(c *config.Config) { c.WikiPath = "" })
+				defer setConfig(handler, func(c *config.Config) { c.WikiPath = savedPath })
 			}
 
 			req := httptest.NewRequest(tc.method, "/api/pull", nil)
@@ -231,9 +264,9 @@ func TestPushHandler(t *testing.T) {
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			if !tc.setWikiPath {
-				savedPath := handler.config.WikiPath
-				handler.config.WikiPath = ""
-				defer func() { handler.config.WikiPath = savedPath }()
+				savedPath := handler.cfg().WikiPath
+				setConfig(handler, func(c *config.Config) { c.WikiPath = "" })
+				defer setConfig(handler, func(c *config.Config) { c.WikiPath = savedPath })
 			}
 
 			req := httptest.NewRequest(tc.method, "/api/push", nil)
@@ -300,7 +333,7 @@ func TestLoadHandler(t *testing.T) {
 	// Create a test file
 	testContent := "# Test Content"
 	testFilename := "test.md"
-	err := os.WriteFile(filepath.Join(handler.config.WikiPath, testFilename), []byte(testContent), 0644)
+	err := os.WriteFile(filepath.Join(handler.cfg().WikiPath, testFilename), []byte(testContent), 0644)
 	if err != nil {
 		t.Fatalf("Failed to create test file: %v", err)
 	}
@@ -365,7 +398,7 @@ func TestDeleteHandler(t *testing.T) {
 	// Create test file and subdirectory structure
 	testContent := "This is a test file."
 	testPath := filepath.Join("subdir", "nested", "test.md")
-	fullPath := filepath.Join(handler.config.WikiPath, testPath)
+	fullPath := filepath.Join(handler.cfg().WikiPath, testPath)
 
 	// Create directories
 	err := os.MkdirAll(filepath.Dir(fullPath), 0755)
@@ -437,7 +470,7 @@ func TestDeleteHandler(t *testing.T) {
 					t.Error("File was not deleted")
 				}
 				// Verify directory cleanup
-				parentDir := filepath.Join(handler.config.WikiPath, "subdir", "nested")
+				parentDir := filepath.Join(handler.cfg().WikiPath, "subdir", "nested")
 				if _, err := os.Stat(parentDir); !os.IsNotExist(err) {
 					t.Error("Empty parent directory was not deleted")
 				}
@@ -490,3 +523,1255 @@ func TestRenderHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestPageHandler(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	content := "# Runbook\n\n## On Call\n\nPage the team.\n"
+	if err := os.WriteFile(filepath.Join(handler.cfg().WikiPath, "runbook.md"), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(handler.cfg().WikiPath, "untitled.md"), []byte("Just text."), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		filename       string
+		expectedStatus int
+		expectedTitle  string
+	}{
+		{
+			name:           "Success",
+			method:         "GET",
+			filename:       "runbook.md",
+			expectedStatus: http.StatusOK,
+			expectedTitle:  "Runbook",
+		},
+		{
+			name:           "Title From Filename",
+			method:         "GET",
+			filename:       "untitled.md",
+			expectedStatus: http.StatusOK,
+			expectedTitle:  "untitled",
+		},
+		{
+			name:           "Invalid Method",
+			method:         "POST",
+			filename:       "runbook.md",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Missing Filename",
+			method:         "GET",
+			filename:       "",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Directory Traversal",
+			method:         "GET",
+			filename:       "../outside.md",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "File Not Found",
+			method:         "GET",
+			filename:       "nonexistent.md",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/api/page?filename="+tc.filename, nil)
+			rr := httptest.NewRecorder()
+
+			handler.pageHandler()(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %v, got %v", tc.expectedStatus, rr.Code)
+			}
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var page struct {
+				HTML        string `json:"html"`
+				Title       string `json:"title"`
+				WordCount   int    `json:"wordCount"`
+				ReadingTime int    `json:"readingTime"`
+				TOC         []struct {
+					Slug     string `json:"slug"`
+					Children []struct {
+						Slug string `json:"slug"`
+					} `json:"children"`
+				} `json:"toc"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if page.Title != tc.expectedTitle {
+				t.Errorf("Expected title %q, got %q", tc.expectedTitle, page.Title)
+			}
+			if page.WordCount == 0 || page.ReadingTime != 1 {
+				t.Errorf("Unexpected reading metadata: %d words, %d minutes", page.WordCount, page.ReadingTime)
+			}
+			if tc.filename == "runbook.md" {
+				if len(page.TOC) != 1 || page.TOC[0].Slug != "runbook" || len(page.TOC[0].Children) != 1 || page.TOC[0].Children[0].Slug != "on-call" {
+					t.Errorf("Unexpected TOC: %+v", page.TOC)
+				}
+				if !strings.Contains(page.HTML, `<h2 id="on-call">`) {
+					t.Errorf("Expected heading anchor in HTML, got %s", page.HTML)
+				}
+			}
+		})
+	}
+}
+
+func TestPageHandlerIncludes(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.md"), []byte("TOP SECRET"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.md"), filepath.Join(handler.cfg().WikiPath, "link.md")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(handler.cfg().WikiPath, "shared"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(handler.cfg().WikiPath, "shared", "oncall.md"), []byte("# On call\n\n## Contacts\n\nCall Sam.\n\n## Other\n\nIgnore me.\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	content := "# Runbook\n\n![[shared/oncall#Contacts]]\n\n![[link]]\n\n{{< include \"../secret\" >}}\n"
+	if err := os.WriteFile(filepath.Join(handler.cfg().WikiPath, "runbook.md"), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/api/page?filename=runbook.md", nil)
+	rr := httptest.NewRecorder()
+	handler.pageHandler()(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %v, got %v", http.StatusOK, rr.Code)
+	}
+	var page struct {
+		HTML string `json:"html"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if !strings.Contains(page.HTML, "Call Sam.") || strings.Contains(page.HTML, "Ignore me.") {
+		t.Errorf("Expected only the Contacts section to be included, got %s", page.HTML)
+	}
+	if strings.Contains(page.HTML, "TOP SECRET") {
+		t.Errorf("Include escaped the wiki root: %s", page.HTML)
+	}
+	if strings.Count(page.HTML, `class="include-error"`) != 2 {
+		t.Errorf("Expected two include errors, got %s", page.HTML)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	// Render the same markdown twice so the second is a cache hit
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/api/render", strings.NewReader(`{"markdown": "# Hello"}`))
+		handler.renderHandler()(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest("POST", "/api/metrics", nil)
+	rr := httptest.NewRecorder()
+	handler.metricsHandler()(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %v, got %v", http.StatusMethodNotAllowed, rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/metrics", nil)
+	rr = httptest.NewRecorder()
+	handler.metricsHandler()(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %v, got %v", http.StatusOK, rr.Code)
+	}
+
+	var metrics struct {
+		RenderCache struct {
+			Hits    int `json:"hits"`
+			Misses  int `json:"misses"`
+			Entries int `json:"entries"`
+		} `json:"renderCache"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &metrics); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if metrics.RenderCache.Hits != 1 || metrics.RenderCache.Misses != 1 || metrics.RenderCache.Entries != 1 {
+		t.Errorf("Unexpected render cache metrics: %+v", metrics.RenderCache)
+	}
+}
+
+func TestHighlightCSSHandler(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+	handler.cfg().Render.Highlight.Dark = "dracula"
+
+	tests := []struct {
+		name           string
+		method         string
+		query          string
+		expectedStatus int
+	}{
+		{
+			name:           "Default Theme",
+			method:         "GET",
+			query:          "",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Dark Theme",
+			method:         "GET",
+			query:          "?theme=dark",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Unknown Theme",
+			method:         "GET",
+			query:          "?theme=sepia",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid Method",
+			method:         "POST",
+			query:          "",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/api/highlight.css"+tc.query, nil)
+			rr := httptest.NewRecorder()
+
+			handler.highlightCSSHandler()(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %v, got %v", tc.expectedStatus, rr.Code)
+			}
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+			if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/css") {
+				t.Errorf("Expected text/css content type, got %q", ct)
+			}
+			if rr.Header().Get("Cache-Control") == "" || rr.Header().Get("ETag") == "" {
+				t.Error("Expected caching headers")
+			}
+
+			// A conditional request with the same ETag is not modified
+			req = httptest.NewRequest(tc.method, "/api/highlight.css"+tc.query, nil)
+			req.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+			cached := httptest.NewRecorder()
+			handler.highlightCSSHandler()(cached, req)
+			if cached.Code != http.StatusNotModified {
+				t.Errorf("Expected status %v, got %v", http.StatusNotModified, cached.Code)
+			}
+		})
+	}
+
+	// Light and dark themes serve different stylesheets
+	light := httptest.NewRecorder()
+	handler.highlightCSSHandler()(light, httptest.NewRequest("GET", "/api/highlight.css?theme=light", nil))
+	dark := httptest.NewRecorder()
+	handler.highlightCSSHandler()(dark, httptest.NewRequest("GET", "/api/highlight.css?theme=dark", nil))
+	if light.Body.String() == dark.Body.String() {
+		t.Error("Expected different CSS for light and dark themes")
+	}
+
+	// An unknown configured style is a server error
+	handler.cfg().Render.Highlight.Light = "no-such-style"
+	rr := httptest.NewRecorder()
+	handler.highlightCSSHandler()(rr, httptest.NewRequest("GET", "/api/highlight.css", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %v, got %v", http.StatusInternalServerError, rr.Code)
+	}
+}
+
+func TestImportHandler(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	root := t.TempDir()
+	src := filepath.Join(root, "vault")
+	writeWikiFiles(t, src, map[string]string{
+		"My Note.md":         "See [[Other Note]]\n",
+		"Other Note.md":      "# Other\n",
+		".obsidian/app.json": "{}",
+	})
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "elsewhere")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an import root nothing can be imported through the API
+	rr := httptest.NewRecorder()
+	handler.importHandler()(rr, httptest.NewRequest("POST", "/api/import", strings.NewReader(`{"source": `+jsonString(src)+`}`)))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %v without an import root, got %v", http.StatusForbidden, rr.Code)
+	}
+	setConfig(handler, func(c *config.Config) { c.ImportRoot = root })
+
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+	}{
+		{"wrong method", "GET", "", http.StatusMethodNotAllowed},
+		{"invalid body", "POST", "{", http.StatusBadRequest},
+		{"missing source", "POST", `{"dest": "notes"}`, http.StatusBadRequest},
+		{"unknown format", "POST", `{"format": "wordperfect", "source": "/tmp"}`, http.StatusBadRequest},
+		{"bad destination", "POST", `{"source": ` + jsonString(src) + `, "dest": "../out"}`, http.StatusBadRequest},
+		{"outside import root", "POST", `{"source": ` + jsonString(outside) + `}`, http.StatusBadRequest},
+		{"parent of import root", "POST", `{"source": "vault/../.."}`, http.StatusBadRequest},
+		{"symlink out of import root", "POST", `{"source": "elsewhere"}`, http.StatusBadRequest},
+		{"missing source directory", "POST", `{"source": "nothing"}`, http.StatusBadRequest},
+		{"import", "POST", `{"source": "vault", "dest": "notes", "commit": "per-file"}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/import", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			handler.importHandler()(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var report struct {
+				Pages   []string `json:"pages"`
+				Skipped []struct {
+					Path string `json:"path"`
+				} `json:"skipped"`
+				Commits int `json:"commits"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if len(report.Pages) != 2 || report.Commits != 2 {
+				t.Errorf("Expected 2 pages in 2 commits, got %+v", report)
+			}
+			if len(report.Skipped) != 1 || report.Skipped[0].Path != ".obsidian" {
+				t.Errorf("Expected .obsidian to be skipped, got %+v", report.Skipped)
+			}
+
+			content, err := os.ReadFile(filepath.Join(handler.cfg().WikiPath, "notes", "my-note.md"))
+			if err != nil {
+				t.Fatalf("Expected imported page: %v", err)
+			}
+			if string(content) != "See [Other Note](other-note.md)\n" {
+				t.Errorf("Unexpected imported content: %q", content)
+			}
+		})
+	}
+}
+
+func jsonString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+func TestAuthHandlers(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	// Without accounts the wiki stays open
+	protected := handler.AuthMiddleware(auth.ScopeRead)(http.HandlerFunc(handler.handleFiles))
+	rr := httptest.NewRecorder()
+	protected.ServeHTTP(rr, httptest.NewRequest("GET", "/api/files", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected an open wiki without accounts, got %v", rr.Code)
+	}
+
+	users, err := auth.LoadUsers(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatalf("Failed to load users: %v", err)
+	}
+	if err := users.Add(auth.User{Username: "alice", Email: "alice@example.com"}, "correct horse"); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+	handler.SetUserStore(users)
+
+	rr = httptest.NewRecorder()
+	protected.ServeHTTP(rr, httptest.NewRequest("GET", "/api/files", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %v without a session, got %v", http.StatusUnauthorized, rr.Code)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+	}{
+		{"wrong method", "GET", "", http.StatusMethodNotAllowed},
+		{"invalid body", "POST", "{", http.StatusBadRequest},
+		{"wrong password", "POST", `{"username": "alice", "password": "wrong horse"}`, http.StatusUnauthorized},
+		{"unknown user", "POST", `{"username": "bob", "password": "correct horse"}`, http.StatusUnauthorized},
+		{"login", "POST", `{"username": "alice", "password": "correct horse"}`, http.StatusOK},
+	}
+
+	var session *http.Cookie
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/auth/login", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			handler.loginHandler()(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			for _, c := range rr.Result().Cookies() {
+				if c.Name == auth.SessionCookieName {
+					session = c
+				}
+			}
+			if tt.expectedStatus != http.StatusOK && session != nil {
+				t.Fatal("Expected no session cookie for a failed login")
+			}
+		})
+	}
+	if session == nil || !session.HttpOnly || session.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("Expected an HttpOnly session cookie, got %+v", session)
+	}
+
+	// The session authenticates later requests and carries the identity
+	var seen *auth.Identity
+	whoami := handler.AuthMiddleware(auth.ScopeWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = auth.FromContext(r.Context())
+	}))
+	req := httptest.NewRequest("GET", "/api/files", nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	whoami.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || seen == nil || seen.Username != "alice" {
+		t.Fatalf("Expected a request as alice, got %v %+v", rr.Code, seen)
+	}
+
+	req = httptest.NewRequest("GET", "/api/auth/me", nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	handler.meHandler()(rr, req)
+	var me struct {
+		User         *auth.Identity `json:"user"`
+		AuthRequired bool           `json:"authRequired"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &me); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if me.User == nil || me.User.Email != "alice@example.com" || !me.AuthRequired {
+		t.Errorf("Unexpected /api/auth/me response %s", rr.Body.String())
+	}
+
+	// Logging out ends the session
+	req = httptest.NewRequest("POST", "/api/auth/logout", nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	handler.logoutHandler()(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %v, got %v", http.StatusOK, rr.Code)
+	}
+	req = httptest.NewRequest("GET", "/api/files", nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	protected.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %v after logging out, got %v", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestOIDCHandlers(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	// Without a provider single sign-on is unavailable
+	rr := httptest.NewRecorder()
+	handler.oidcLoginHandler()(rr, httptest.NewRequest("GET", "/api/auth/oidc/login", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %v without a provider, got %v", http.StatusNotFound, rr.Code)
+	}
+
+	provider := test_utils.NewMockOIDCProvider(t)
+	provider.Claims = map[string]any{
+		"preferred_username": "alice",
+		"name":               "Alice Example",
+		"email":              "alice@example.com",
+		"groups":             []string{"editors"},
+	}
+	oidc, err := auth.NewOIDC(auth.OIDCConfig{
+		Issuer:       provider.URL,
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  "http://fishki.test/api/auth/oidc/callback",
+	})
+	if err != nil {
+		t.Fatalf("Failed to configure OIDC: %v", err)
+	}
+	handler.SetOIDC(oidc)
+	if !handler.authRequired() {
+		t.Error("Expected single sign-on to require authentication")
+	}
+
+	// Logging in redirects to the provider and ties the state to the browser
+	rr = httptest.NewRecorder()
+	handler.oidcLoginHandler()(rr, httptest.NewRequest("GET", "/api/auth/oidc/login?redirect=//evil.example", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected status %v, got %v: %s", http.StatusFound, rr.Code, rr.Body.String())
+	}
+	var stateCookie *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == oidcStateCookieName {
+			stateCookie = c
+		}
+	}
+	if stateCookie == nil {
+		t.Fatal("Expected a login state cookie")
+	}
+	callback := provider.Login(t, rr.Header().Get("Location"))
+
+	tests := []struct {
+		name           string
+		query          string
+		cookie         *http.Cookie
+		expectedStatus int
+	}{
+		{"provider error", "error=access_denied", stateCookie, http.StatusUnauthorized},
+		{"missing state cookie", callback.RawQuery, nil, http.StatusBadRequest},
+		{"mismatched state", callback.RawQuery, &http.Cookie{Name: oidcStateCookieName, Value: "other"}, http.StatusBadRequest},
+		{"login", callback.RawQuery, stateCookie, http.StatusFound},
+	}
+
+	var session *http.Cookie
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/auth/oidc/callback?"+tt.query, nil)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			rr := httptest.NewRecorder()
+			handler.oidcCallbackHandler()(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if tt.expectedStatus != http.StatusFound {
+				return
+			}
+			if location := rr.Header().Get("Location"); location != "/" {
+				t.Errorf("Expected a redirect to the front page, got %q", location)
+			}
+			for _, c := range rr.Result().Cookies() {
+				if c.Name == auth.SessionCookieName {
+					session = c
+				}
+			}
+		})
+	}
+	if session == nil {
+		t.Fatal("Expected a session cookie")
+	}
+
+	// The session carries the identity, which becomes the commit author
+	var author *git.Signature
+	whoami := handler.AuthMiddleware(auth.ScopeWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		author = commitAuthor(r)
+	}))
+	req := httptest.NewRequest("POST", "/api/save", nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	whoami.ServeHTTP(rr, req)
+	if author == nil || author.Name != "Alice Example" || author.Email != "alice@example.com" {
+		t.Errorf("Expected Alice as the commit author, got %+v", author)
+	}
+}
+
+func TestLoginRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	limited := rateLimit(2, handler.proxy.ClientIP)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	status := func(forwarded string) int {
+		req := httptest.NewRequest("POST", "/api/auth/login", nil)
+		req.RemoteAddr = "192.0.2.1:5000"
+		req.Header.Set("X-Forwarded-For", forwarded)
+		rr := httptest.NewRecorder()
+		limited.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for i, forwarded := range []string{"198.51.100.1", "198.51.100.2"} {
+		if got := status(forwarded); got != http.StatusOK {
+			t.Fatalf("Request %d: expected 200, got %d", i+1, got)
+		}
+	}
+	if got := status("198.51.100.3"); got != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 despite a new X-Forwarded-For, got %d", got)
+	}
+}
+
+func TestProxyAuthMiddleware(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	proxy, err := auth.NewProxyAuth(auth.ProxyConfig{TrustedProxies: []string{"127.0.0.1/32"}})
+	if err != nil {
+		t.Fatalf("Failed to configure proxy auth: %v", err)
+	}
+	handler.SetProxyAuth(proxy)
+
+	var author *git.Signature
+	protected := handler.AuthMiddleware(auth.ScopeWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		author = commitAuthor(r)
+	}))
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		user           string
+		expectedStatus int
+		expectedAuthor string
+	}{
+		{"trusted proxy", "127.0.0.1:5000", "alice", http.StatusOK, "alice <alice@example.com>"},
+		{"untrusted source", "192.0.2.1:5000", "alice", http.StatusForbidden, ""},
+		{"no identity", "127.0.0.1:5000", "", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			author = nil
+			req := httptest.NewRequest("POST", "/api/save", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.user != "" {
+				req.Header.Set("X-Forwarded-User", tt.user)
+				req.Header.Set("X-Forwarded-Email", tt.user+"@example.com")
+			}
+			rr := httptest.NewRecorder()
+			protected.ServeHTTP(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %v, got %v", tt.expectedStatus, rr.Code)
+			}
+			if tt.expectedAuthor == "" {
+				return
+			}
+			if author == nil || author.Name+" <"+author.Email+">" != tt.expectedAuthor {
+				t.Errorf("Expected commit author %q, got %+v", tt.expectedAuthor, author)
+			}
+		})
+	}
+}
+
+func TestTokensHandler(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	tokens, err := auth.LoadTokens(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatalf("Failed to load tokens: %v", err)
+	}
+	handler.SetTokenStore(tokens)
+	alice := &auth.Identity{Username: "alice"}
+
+	// Tokens are managed by a logged in user
+	call := func(method, body string, id *auth.Identity) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/tokens", strings.NewReader(body))
+		if id != nil {
+			req = req.WithContext(auth.WithIdentity(req.Context(), id))
+		}
+		rr := httptest.NewRecorder()
+		handler.tokensHandler()(rr, req)
+		return rr
+	}
+	if rr := call("GET", "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %v when anonymous, got %v", http.StatusUnauthorized, rr.Code)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		expectedStatus int
+	}{
+		{"wrong method", "PUT", "", http.StatusMethodNotAllowed},
+		{"invalid body", "POST", "{", http.StatusBadRequest},
+		{"missing name", "POST", `{"scopes": ["read"]}`, http.StatusBadRequest},
+		{"unknown scope", "POST", `{"name": "CI", "scopes": ["root"]}`, http.StatusBadRequest},
+		{"negative expiry", "POST", `{"name": "CI", "scopes": ["read"], "expiresInDays": -1}`, http.StatusBadRequest},
+		{"unknown token", "DELETE", `{"id": "nope"}`, http.StatusNotFound},
+		{"create", "POST", `{"name": "CI", "scopes": ["write"], "expiresInDays": 30}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := call(tt.method, tt.body, alice); rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %v, got %v: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	list := call("GET", "", alice)
+	var listed []map[string]any
+	if err := json.Unmarshal(list.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(listed) != 1 || listed[0]["name"] != "CI" || listed[0]["hash"] != nil || listed[0]["token"] != nil {
+		t.Fatalf("Expected the CI token without its secret, got %s", list.Body.String())
+	}
+
+	// Bearer tokens skip CSRF checks but are limited to their scopes
+	writeSecret, _, err := tokens.Create(*alice, "writer", []auth.Scope{auth.ScopeWrite}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	readSecret, _, err := tokens.Create(*alice, "reader", []auth.Scope{auth.ScopeRead}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	save := handler.AuthMiddleware(auth.ScopeWrite)(CSRFMiddleware(http.HandlerFunc(handler.saveHandler())))
+
+	requests := []struct {
+		name           string
+		authorization  string
+		expectedStatus int
+	}{
+		{"write token", "Bearer " + writeSecret, http.StatusOK},
+		{"read token", "Bearer " + readSecret, http.StatusForbidden},
+		{"unknown token", "Bearer fishki_unknown", http.StatusUnauthorized},
+		{"not a bearer token", "Basic YWxpY2U6c2VjcmV0", http.StatusUnauthorized},
+		{"no token needs CSRF", "", http.StatusForbidden},
+	}
+	for _, tt := range requests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/save", strings.NewReader(`{"filename": "ci.md", "content": "# Built"}`))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			save.ServeHTTP(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %v, got %v: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAccessControl(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	writeWikiFiles(t, handler.cfg().WikiPath, map[string]string{
+		"index.md":          "# Home\n\n![[hr/salaries]]\n",
+		"hr/salaries.md":    "Top secret\n",
+		"hr/handbook.md":    "# Handbook\n",
+		"security/keys.md":  "# Keys\n",
+		".fishki/acl.yaml":  "paths:\n  hr:\n    group:hr: write\n  hr/handbook.md:\n    \"*\": read\n",
+		"notes/shopping.md": "# Shopping\n",
+	})
+	editors := acl.Write
+	setConfig(handler, func(c *config.Config) {
+		c.ACL = &acl.Policy{
+			Default: &editors,
+			Paths: map[string]acl.Rules{
+				"security": {"user:root": acl.Admin},
+			},
+		}
+	})
+
+	// Paths that climb out of the wiki and back in, or follow a link, are
+	// checked as the file they end up at
+	wiki := handler.cfg().WikiPath
+	escape := "../" + filepath.Base(wiki) + "/hr/salaries.md"
+	if err := os.Symlink(filepath.Join("..", "hr", "salaries.md"), filepath.Join(wiki, "notes", "link.md")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	alice := &auth.Identity{Username: "alice"}
+	bob := &auth.Identity{Username: "bob", Groups: []string{"hr"}}
+	call := func(h http.HandlerFunc, method, target, body string, id *auth.Identity) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if id != nil {
+			req = req.WithContext(auth.WithIdentity(req.Context(), id))
+		}
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		method         string
+		target         string
+		body           string
+		id             *auth.Identity
+		expectedStatus int
+	}{
+		{"read unrestricted", handler.loadHandler(), "GET", "/api/load?filename=notes/shopping.md", "", alice, http.StatusOK},
+		{"read restricted", handler.loadHandler(), "GET", "/api/load?filename=hr/salaries.md", "", alice, http.StatusNotFound},
+		{"read as member", handler.loadHandler(), "GET", "/api/load?filename=hr/salaries.md", "", bob, http.StatusOK},
+		{"read shared page", handler.pageHandler(), "GET", "/api/page?filename=hr/handbook.md", "", alice, http.StatusOK},
+		{"write shared page", handler.saveHandler(), "POST", "/api/save", `{"filename": "hr/handbook.md", "content": "x"}`, alice, http.StatusForbidden},
+		{"write restricted", handler.saveHandler(), "POST", "/api/save", `{"filename": "hr/new.md", "content": "x"}`, alice, http.StatusNotFound},
+		{"write as member", handler.saveHandler(), "POST", "/api/save", `{"filename": "hr/new.md", "content": "x"}`, bob, http.StatusOK},
+		{"delete restricted", handler.deleteHandler(), "DELETE", "/api/delete", `{"filename": "security/keys.md"}`, bob, http.StatusNotFound},
+		{"edit policy without admin", handler.saveHandler(), "POST", "/api/save", `{"filename": ".fishki/acl.yaml", "content": "default: admin"}`, alice, http.StatusForbidden},
+		{"config needs admin", handler.configHandler(), "POST", "/api/config", `{"wikiPath": "/tmp"}`, alice, http.StatusForbidden},
+		{"import needs admin of the wiki", handler.importHandler(), "POST", "/api/import", `{"source": "vault", "dest": "notes"}`, alice, http.StatusForbidden},
+		{"export restricted", handler.exportHandler(), "GET", "/api/export?path=security", "", alice, http.StatusNotFound},
+		{"read through parent", handler.loadHandler(), "GET", "/api/load?filename=" + escape, "", alice, http.StatusBadRequest},
+		{"render through parent", handler.pageHandler(), "GET", "/api/page?filename=" + escape, "", alice, http.StatusBadRequest},
+		{"write through parent", handler.saveHandler(), "POST", "/api/save", `{"filename": "` + escape + `", "content": "x"}`, alice, http.StatusBadRequest},
+		{"delete through parent", handler.deleteHandler(), "DELETE", "/api/delete", `{"filename": "` + escape + `"}`, alice, http.StatusBadRequest},
+		{"read through link", handler.loadHandler(), "GET", "/api/load?filename=notes/link.md", "", alice, http.StatusNotFound},
+		{"write through link", handler.saveHandler(), "POST", "/api/save", `{"filename": "notes/link.md", "content": "x"}`, alice, http.StatusNotFound},
+		{"write git hook", handler.saveHandler(), "POST", "/api/save", `{"filename": ".git/hooks/pre-commit", "content": "x"}`, bob, http.StatusBadRequest},
+		{"read git folder", handler.loadHandler(), "GET", "/api/load?filename=.GIT/config", "", bob, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := call(tt.handler, tt.method, tt.target, tt.body, tt.id); rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %v, got %v: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	// The tree only shows what the user can read
+	rr := call(handler.handleFiles, "GET", "/api/files", "", alice)
+	tree := rr.Body.String()
+	if !strings.Contains(tree, "hr/handbook.md") || strings.Contains(tree, "salaries") || strings.Contains(tree, "security") {
+		t.Errorf("Expected only readable pages in the tree, got %s", tree)
+	}
+	if rr := call(handler.handleFiles, "GET", "/api/files", "", bob); !strings.Contains(rr.Body.String(), "hr/salaries.md") {
+		t.Errorf("Expected members to see restricted pages, got %s", rr.Body.String())
+	}
+
+	// Includes of pages the user can't read are treated as missing
+	rr = call(handler.pageHandler(), "GET", "/api/page?filename=index.md", "", alice)
+	if strings.Contains(rr.Body.String(), "Top secret") || !strings.Contains(rr.Body.String(), "include-error") {
+		t.Errorf("Expected the restricted include to be left out, got %s", rr.Body.String())
+	}
+
+	// Exports leave out links to pages the user can't read
+	rr = call(handler.exportHandler(), "GET", "/api/export?path=notes", "", alice)
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "Top secret") {
+		t.Errorf("Expected the linked page to be left out of the export, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	// A broken policy file locks everything rather than opening it up
+	writeWikiFiles(t, handler.cfg().WikiPath, map[string]string{".fishki/acl.yaml": "paths: [hr"})
+	if rr := call(handler.loadHandler(), "GET", "/api/load?filename=notes/shopping.md", "", alice); rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %v with a broken policy, got %v", http.StatusInternalServerError, rr.Code)
+	}
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	writeWikiFiles(t, handler.cfg().WikiPath, map[string]string{"index.md": "# Home\n"})
+	users, err := auth.LoadUsers(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatalf("Failed to load users: %v", err)
+	}
+	if err := users.Add(auth.User{Username: "alice", Groups: []string{"editors"}}, "correct horse"); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+	handler.SetUserStore(users)
+	setConfig(handler, func(c *config.Config) {
+		c.ReadOnly = true
+		c.Editors = []string{"group:editors"}
+	})
+
+	sessionFor := func(id auth.Identity) *http.Cookie {
+		session, err := handler.sessions.Create(id)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		return &http.Cookie{Name: auth.SessionCookieName, Value: session.ID}
+	}
+	alice := sessionFor(auth.Identity{Username: "alice", Groups: []string{"editors"}})
+	bob := sessionFor(auth.Identity{Username: "bob"})
+
+	load := handler.AuthMiddleware(auth.ScopeRead)(http.HandlerFunc(handler.loadHandler()))
+	save := handler.AuthMiddleware(auth.ScopeWrite)(http.HandlerFunc(handler.saveHandler()))
+	config := handler.AuthMiddleware(auth.ScopeAdmin)(http.HandlerFunc(handler.configHandler()))
+
+	tests := []struct {
+		name           string
+		handler        http.Handler
+		method         string
+		target         string
+		body           string
+		cookie         *http.Cookie
+		expectedStatus int
+	}{
+		{"anonymous read", load, "GET", "/api/load?filename=index.md", "", nil, http.StatusOK},
+		{"anonymous write", save, "POST", "/api/save", `{"filename": "index.md", "content": "x"}`, nil, http.StatusForbidden},
+		{"non-editor write", save, "POST", "/api/save", `{"filename": "index.md", "content": "x"}`, bob, http.StatusForbidden},
+		{"editor write", save, "POST", "/api/save", `{"filename": "index.md", "content": "x"}`, alice, http.StatusOK},
+		{"anonymous config change", config, "POST", "/api/config", `{"wikiPath": "/tmp"}`, nil, http.StatusForbidden},
+		{"anonymous config", config, "GET", "/api/config", "", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			rr := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %v, got %v: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	// Only editors who can administer the wiki see where it is on disk
+	wikiPath := func(cookie *http.Cookie) string {
+		req := httptest.NewRequest("GET", "/api/config", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		config.ServeHTTP(rr, req)
+		var response map[string]any
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		if response["configured"] != true || response["readOnly"] != true {
+			t.Errorf("Unexpected config %v", response)
+		}
+		return response["wikiPath"].(string)
+	}
+	if got := wikiPath(nil); got != filepath.Base(handler.cfg().WikiPath) {
+		t.Errorf("Expected anonymous users to see only the folder name, got %q", got)
+	}
+	if got := wikiPath(bob); filepath.IsAbs(got) {
+		t.Errorf("Expected non-editors not to see the wiki path, got %q", got)
+	}
+	if got := wikiPath(alice); got != handler.cfg().WikiPath {
+		t.Errorf("Expected editors to see the wiki path, got %q", got)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.meHandler()(rr, httptest.NewRequest("GET", "/api/auth/me", nil))
+	if body := rr.Body.String(); !strings.Contains(body, `"readOnly":true`) || !strings.Contains(body, `"canEdit":false`) {
+		t.Errorf("Expected read-only mode to be reported, got %s", body)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	auditLog, err := audit.Open(filepath.Join(t.TempDir(), "audit.log"), 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer auditLog.Close()
+	handler.SetAuditLog(auditLog)
+
+	users, err := auth.LoadUsers(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatalf("Failed to load users: %v", err)
+	}
+	if err := users.Add(auth.User{Username: "alice"}, "correct horse"); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+	handler.SetUserStore(users)
+
+	alice := &auth.Identity{Username: "alice"}
+	call := func(h http.HandlerFunc, method, target, body string, id *auth.Identity) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.RemoteAddr = "192.0.2.10:4321"
+		if id != nil {
+			req = req.WithContext(auth.WithIdentity(req.Context(), id))
+		}
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	call(handler.saveHandler(), "POST", "/api/save", `{"filename": "docs/a.md", "content": "# A"}`, alice)
+	call(handler.deleteHandler(), "DELETE", "/api/delete", `{"filename": "docs/a.md"}`, alice)
+	call(handler.loginHandler(), "POST", "/api/auth/login", `{"username": "alice", "password": "wrong horse"}`, nil)
+	// Reads aren't recorded
+	call(handler.handleFiles, "GET", "/api/files", "", alice)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		want           []string
+	}{
+		{"everything", "", http.StatusOK, []string{"login alice false", "delete alice true", "save alice true"}},
+		{"by action", "?action=save", http.StatusOK, []string{"save alice true"}},
+		{"by path", "?path=docs", http.StatusOK, []string{"delete alice true", "save alice true"}},
+		{"by user and limit", "?user=alice&limit=1", http.StatusOK, []string{"login alice false"}},
+		{"by time", "?until=2000-01-01T00:00:00Z", http.StatusOK, nil},
+		{"invalid time", "?since=yesterday", http.StatusBadRequest, nil},
+		{"invalid limit", "?limit=0", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := call(handler.auditHandler(), "GET", "/api/audit"+tt.query, "", alice)
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var events []audit.Event
+			if err := json.Unmarshal(rr.Body.Bytes(), &events); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			var got []string
+			for _, e := range events {
+				got = append(got, fmt.Sprintf("%s %s %v", e.Action, e.User, e.Success))
+				if e.Remote != "192.0.2.10:4321" || e.Time.IsZero() {
+					t.Errorf("Expected the event's address and time, got %+v", e)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	// Only those who can change the configuration can read the log
+	setConfig(handler, func(c *config.Config) {
+		c.ReadOnly = true
+		c.Editors = []string{"group:admins"}
+	})
+	if rr := call(handler.auditHandler(), "GET", "/api/audit", "", alice); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status %v for a non-editor, got %v", http.StatusForbidden, rr.Code)
+	}
+
+	// Requests that are refused or fail are recorded with what they were for
+	start := time.Now()
+	protected := handler.AuthMiddleware(auth.ScopeWrite)(handler.saveHandler()).ServeHTTP
+	call(protected, "POST", "/api/save", `{"filename": "docs/b.md"}`, nil)
+	editors := acl.Write
+	setConfig(handler, func(c *config.Config) {
+		c.ReadOnly = false
+		c.ACL = &acl.Policy{Default: &editors, Paths: map[string]acl.Rules{"hr": {"user:bob": acl.Write}}}
+	})
+	call(protected, "POST", "/api/save", `{"filename": "docs/b.md"}`, nil)
+	call(handler.saveHandler(), "POST", "/api/save", `{"filename": "hr/pay.md", "content": "x"}`, alice)
+	call(handler.saveHandler(), "POST", "/api/save", `{"filename": "../b.md", "content": "x"}`, alice)
+	call(handler.deleteHandler(), "DELETE", "/api/delete", `{"filename": "docs/missing.md"}`, alice)
+	call(handler.trashRestoreHandler(), "POST", "/api/trash/restore", `{"path": "docs/gone.md"}`, alice)
+
+	events, err := auditLog.Query(audit.Filter{Since: start})
+	if err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	var got []string
+	for _, e := range events {
+		if e.Success {
+			t.Errorf("Expected only failures, got %+v", e)
+		}
+		got = append(got, strings.TrimSpace(fmt.Sprintf("%s %s %s", e.Action, e.User, e.Path)))
+	}
+	want := []string{"restore alice docs/gone.md", "delete alice docs/missing.md", "save alice ../b.md", "access alice hr/pay.md", "auth", "auth"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestConfigPersistence(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	// Settings only made for this run, such as -read-only, stay out of the
+	// file
+	setConfig(handler, func(c *config.Config) { c.ReadOnly = true })
+	newWiki := t.TempDir()
+	notADir := filepath.Join(newWiki, "page.md")
+	writeWikiFiles(t, newWiki, map[string]string{"page.md": "# Page\n"})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.configHandler()(rr, httptest.NewRequest("POST", "/api/config", strings.NewReader(body)))
+		return rr
+	}
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{"missing directory", `{"wikiPath": "/does/not/exist"}`, http.StatusBadRequest},
+		{"not a directory", `{"wikiPath": ` + jsonString(notADir) + `}`, http.StatusBadRequest},
+		{"valid", `{"wikiPath": ` + jsonString(newWiki) + `}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := post(tt.body); rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %v, got %v: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	if handler.cfg().WikiPath != newWiki || !handler.cfg().ReadOnly {
+		t.Errorf("Expected the running config to change, got %+v", handler.cfg())
+	}
+	saved, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %v", err)
+	}
+	if saved.WikiPath != newWiki || saved.ReadOnly {
+		t.Errorf("Expected only the wiki path to be saved, got %+v", saved)
+	}
+
+	// A config file that can't be read is left alone, and so is the
+	// running config
+	configPath, err := config.GetConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if rr := post(`{"wikiPath": ` + jsonString(t.TempDir()) + `}`); rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %v with a broken config file, got %v", http.StatusInternalServerError, rr.Code)
+	}
+	if handler.cfg().WikiPath != newWiki {
+		t.Errorf("Expected the running config to be unchanged, got %q", handler.cfg().WikiPath)
+	}
+}
+
+func TestConfigSwapRace(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	wikis := []string{handler.cfg().WikiPath, t.TempDir()}
+	call := func(h http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rr
+	}
+
+	// Switch between two wikis while pages are saved, loaded and listed.
+	// Every request must finish against the wiki it started with
+	const writers, saves = 4, 25
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < saves; i++ {
+			body := `{"wikiPath": ` + jsonString(wikis[i%2]) + `}`
+			if rr := call(handler.configHandler(), "POST", "/api/config", body); rr.Code != http.StatusOK {
+				t.Errorf("Expected status %v switching wikis, got %v: %s", http.StatusOK, rr.Code, rr.Body.String())
+			}
+		}
+	}()
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < saves; i++ {
+				name := fmt.Sprintf("page-%d-%d.md", w, i)
+				body := `{"filename": "` + name + `", "content": "# ` + name + `"}`
+				if rr := call(handler.saveHandler(), "POST", "/api/save", body); rr.Code != http.StatusOK {
+					t.Errorf("Expected status %v saving %s, got %v: %s", http.StatusOK, name, rr.Code, rr.Body.String())
+				}
+				call(handler.loadHandler(), "GET", "/api/load?filename="+name, "")
+				call(handler.handleFiles, "GET", "/api/files", "")
+			}
+		}()
+	}
+	wg.Wait()
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < saves; i++ {
+			name := fmt.Sprintf("page-%d-%d.md", w, i)
+			found := 0
+			for _, wiki := range wikis {
+				content, err := os.ReadFile(filepath.Join(wiki, name))
+				if err == nil {
+					found++
+					if string(content) != "# "+name {
+						t.Errorf("Expected %s to be saved whole, got %q", name, content)
+					}
+				}
+			}
+			if found != 1 {
+				t.Errorf("Expected %s in exactly one wiki, found it in %d", name, found)
+			}
+		}
+	}
+}
+
+// syncRecorder is a git client that reports pushes and pulls
+type syncRecorder struct {
+	git.MockGitClient
+	ops chan string
+}
+
+func (s *syncRecorder) Pull(path string) error {
+	s.ops <- "pull " + path
+	return nil
+}
+
+func (s *syncRecorder) Push(path string) error {
+	s.ops <- "push " + path
+	return nil
+}
+
+func TestGitSync(t *testing.T) {
+	handler, cleanup := setupUnitTestHandler(t)
+	defer cleanup()
+
+	recorder := &syncRecorder{ops: make(chan string, 10)}
+	handler.SetGitClient(recorder)
+	wiki := handler.cfg().WikiPath
+	save := func() {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.saveHandler()(rr, httptest.NewRequest("POST", "/api/save", strings.NewReader(`{"filename": "page.md", "content": "# Page"}`)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Without auto-push, saving only commits
+	save()
+	select {
+	case op := <-recorder.ops:
+		t.Errorf("Expected no push, got %q", op)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	setConfig(handler, func(c *config.Config) { c.Git.AutoPush = true })
+	save()
+	select {
+	case op := <-recorder.ops:
+		if op != "push "+wiki {
+			t.Errorf("Expected a push of the wiki, got %q", op)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected saving to push")
+	}
+
+	// Syncing pulls before it pushes
+	handler.sync()
+	for _, want := range []string{"pull " + wiki, "push " + wiki} {
+		if op := <-recorder.ops; op != want {
+			t.Errorf("Expected %q, got %q", want, op)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/timhughes/fishki/internal/markdown"
+)
+
+// highlightCSSMaxAge is how long browsers may cache the highlight stylesheet
+const highlightCSSMaxAge = "86400" // 24 hours in seconds
+
+// highlightCSSHandler serves the syntax highlighting stylesheet for the
+// light or dark UI theme
+func (h *Handler) highlightCSSHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		theme := r.URL.Query().Get("theme")
+		if theme == "" {
+			theme = "light"
+		}
+		if theme != "light" && theme != "dark" {
+			http.Error(w, "Theme must be light or dark", http.StatusBadRequest)
+			return
+		}
+
+		css, err := markdown.HighlightCSS(cfg.HighlightStyle(theme))
+		if err != nil {
+			http.Error(w, "Failed to generate stylesheet: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		sum := sha256.Sum256(css)
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+		w.Header().Set("Cache-Control", "public, max-age="+highlightCSSMaxAge)
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		w.Write(css)
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/timhughes/fishki/internal/acl"
+	"github.com/timhughes/fishki/internal/audit"
+	"github.com/timhughes/fishki/internal/importer"
+)
+
+// importHandler imports a directory or export file from the server's import
+// root into the wiki and reports what was imported and skipped
+func (h *Handler) importHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if cfg.WikiPath == "" {
+			http.Error(w, "Wiki path not set", http.StatusBadRequest)
+			return
+		}
+
+		if h.git == nil {
+			http.Error(w, "Git client not initialized", http.StatusInternalServerError)
+			return
+		}
+
+		var request struct {
+			Format string `json:"format"`
+			Source string `json:"source"`
+			importer.Options
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// Imports read files from the server and can write anywhere through
+		// links and includes, so they are for admins of the whole wiki
+		if !h.authorize(w, r, cfg, "", acl.Admin) {
+			return
+		}
+
+		if request.Source == "" {
+			http.Error(w, "Source is required", http.StatusBadRequest)
+			return
+		}
+
+		if cfg.ImportRoot == "" {
+			http.Error(w, "Import root not set", http.StatusForbidden)
+			return
+		}
+		source, err := importSource(cfg.ImportRoot, request.Source)
+		if err != nil {
+			http.Error(w, "Source must be inside the import root", http.StatusBadRequest)
+			return
+		}
+
+		request.Options.Author = commitAuthor(r)
+		im := importer.New(cfg.WikiPath, h.git)
+		var report *importer.Report
+		switch request.Format {
+		case "", "markdown", "obsidian":
+			report, err = im.ImportDirectory(source, request.Options)
+		case "mediawiki":
+			report, err = im.ImportMediaWikiFile(source, request.Options)
+		case "confluence":
+			report, err = im.ImportConfluence(source, request.Options)
+		default:
+			http.Error(w, "Unsupported import format", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			// Without a report nothing was written, so the request was at fault
+			if report == nil {
+				http.Error(w, "Import failed: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Import failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.record(r, audit.Event{
+			Action:  "import",
+			Success: true,
+			Path:    request.Options.Dest,
+			Detail:  fmt.Sprintf("%d pages and %d attachments from %s", len(report.Pages), len(report.Attachments), request.Source),
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// importSource resolves source, taken relative to root unless it is
+// absolute, and makes sure it leads to something inside root once symlinks
+// are followed
+func importSource(root, source string) (string, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(source) {
+		source = filepath.Join(root, source)
+	}
+	resolved, err := filepath.EvalSymlinks(source)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(realRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidPath
+	}
+	return resolved, nil
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/timhughes/fishki/internal/acl"
+	"github.com/timhughes/fishki/internal/markdown"
+)
+
+// pageHandler renders a stored page server-side and returns it with its
+// table of contents and reading metadata
+func (h *Handler) pageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if cfg.WikiPath == "" {
+			http.Error(w, "Wiki path not set", http.StatusBadRequest)
+			return
+		}
+
+		filename := r.URL.Query().Get("filename")
+		if filename == "" {
+			http.Error(w, "Filename is required", http.StatusBadRequest)
+			return
+		}
+
+		filename, fullPath, err := wikiFile(cfg.WikiPath, filename)
+		if err != nil {
+			http.Error(w, "Invalid filename", http.StatusBadRequest)
+			return
+		}
+
+		if !h.authorize(w, r, cfg, filename, acl.Read) {
+			return
+		}
+		canRead, err := h.readFilter(r, cfg)
+		if err != nil {
+			http.Error(w, "Failed to load access policy", http.StatusInternalServerError)
+			return
+		}
+
+		content, err := os.ReadFile(fullPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "File not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+			return
+		}
+
+		page := h.renderer.RenderPage(markdown.ExpandIncludes(content, filename, includeLoader(cfg.WikiPath, canRead)))
+
+		// Fall back to the file name when the page has no top-level heading
+		if page.Title == "" {
+			page.Title = strings.TrimSuffix(filepath.Base(fullPath), filepath.Ext(fullPath))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+// loadInclude reads an included page from the wiki at root, refusing paths
+// outside it
+func loadInclude(root, page string) ([]byte, error) {
+	if root == "" {
+		return nil, fmt.Errorf("wiki path not set")
+	}
+	resolved, err := ResolvePath(root, page)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("page not found")
+		}
+		if err == ErrInvalidPath {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to resolve page")
+	}
+
+	content, err := os.ReadFile(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page")
+	}
+	return content, nil
+}
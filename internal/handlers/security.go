@@ -9,6 +9,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/timhughes/fishki/internal/auth"
 )
 
 // CSRF token configuration
@@ -36,6 +38,13 @@ func CSRFMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Requests made with an API token don't rely on cookies, so they
+		// can't be forged by another site
+		if id := auth.FromContext(r.Context()); id != nil && id.TokenID != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Get token from cookie
 		cookie, err := r.Cookie(csrfCookieName)
 		if err != nil {
@@ -147,6 +156,31 @@ func ValidatePath(basePath, requestedPath string) (string, error) {
 	return fullPath, nil
 }
 
+// ResolvePath validates a path like ValidatePath and also follows symlinks,
+// so a link inside the base directory can't lead to a file outside it. The
+// file must exist
+func ResolvePath(basePath, requestedPath string) (string, error) {
+	fullPath, err := ValidatePath(basePath, requestedPath)
+	if err != nil {
+		return "", err
+	}
+
+	root, err := filepath.EvalSymlinks(basePath)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(fullPath)
+	if err != nil {
+		return "", err
+	}
+	relPath, err := filepath.Rel(root, resolved)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidPath
+	}
+
+	return resolved, nil
+}
+
 // RateLimiter implements a simple rate limiting mechanism
 type RateLimiter struct {
 	requests     map[string][]time.Time
@@ -223,19 +257,12 @@ func (rl *RateLimiter) cleanup() {
 	rl.cleanupTimer.Reset(rl.windowSize)
 }
 
-// RateLimitMiddleware adds rate limiting to handlers
-func RateLimitMiddleware(rl *RateLimiter) func(http.Handler) http.Handler {
+// RateLimitMiddleware adds rate limiting to handlers, counting requests by
+// the address clientIP returns
+func RateLimitMiddleware(rl *RateLimiter, clientIP func(*http.Request) string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			clientIP := r.RemoteAddr
-			
-			// Use X-Forwarded-For if behind a proxy
-			if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-				// Use the first IP in the list
-				clientIP = strings.Split(forwardedFor, ",")[0]
-			}
-			
-			if !rl.Allow(clientIP) {
+			if !rl.Allow(clientIP(r)) {
 				w.Header().Set("Retry-After", "60")
 				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 				return
@@ -6,20 +6,28 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+
+	"github.com/timhughes/fishki/internal/acl"
 )
 
 func (h *Handler) statusHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		if h.config.WikiPath == "" {
+		if cfg.WikiPath == "" {
 			http.Error(w, "Wiki path not set", http.StatusBadRequest)
 			return
 		}
 
+		if !h.authorize(w, r, cfg, "", acl.Read) {
+			return
+		}
+
 		if h.git == nil {
 			http.Error(w, "Git client not initialized", http.StatusInternalServerError)
 			return
@@ -27,7 +35,7 @@ func (h *Handler) statusHandler() http.HandlerFunc {
 
 		// Get the current branch name
 		cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
-		cmd.Dir = h.config.WikiPath
+		cmd.Dir = cfg.WikiPath
 		branchBytes, err := cmd.Output()
 		if err != nil {
 			http.Error(w, "Failed to get branch name", http.StatusInternalServerError)
@@ -37,15 +45,15 @@ func (h *Handler) statusHandler() http.HandlerFunc {
 
 		// Get ahead/behind counts
 		var ahead, behind int
-		if h.git.HasRemote(h.config.WikiPath) {
+		if h.git.HasRemote(cfg.WikiPath) {
 			// Fetch from remote to get accurate counts
 			fetchCmd := exec.Command("git", "fetch")
-			fetchCmd.Dir = h.config.WikiPath
+			fetchCmd.Dir = cfg.WikiPath
 			_ = fetchCmd.Run() // Ignore errors, we'll continue anyway
 
 			// Get ahead count
 			aheadCmd := exec.Command("git", "rev-list", "--count", "@{u}..")
-			aheadCmd.Dir = h.config.WikiPath
+			aheadCmd.Dir = cfg.WikiPath
 			aheadBytes, err := aheadCmd.Output()
 			if err == nil {
 				ahead, _ = strconv.Atoi(strings.TrimSpace(string(aheadBytes)))
@@ -53,7 +61,7 @@ func (h *Handler) statusHandler() http.HandlerFunc {
 
 			// Get behind count
 			behindCmd := exec.Command("git", "rev-list", "--count", "..@{u}")
-			behindCmd.Dir = h.config.WikiPath
+			behindCmd.Dir = cfg.WikiPath
 			behindBytes, err := behindCmd.Output()
 			if err == nil {
 				behind, _ = strconv.Atoi(strings.TrimSpace(string(behindBytes)))
@@ -61,7 +69,7 @@ func (h *Handler) statusHandler() http.HandlerFunc {
 		}
 
 		// Get modified and untracked counts
-		status, err := h.git.Status(h.config.WikiPath)
+		status, err := h.git.Status(cfg.WikiPath)
 		if err != nil {
 			http.Error(w, "Failed to get status", http.StatusInternalServerError)
 			return
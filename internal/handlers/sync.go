@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/timhughes/fishki/internal/config"
+)
+
+// autoPush pushes a change made through the wiki when auto-push is on. It
+// runs in the background, so a slow remote doesn't hold up the request
+func (h *Handler) autoPush(cfg *config.Config) {
+	if !cfg.Git.AutoPush || h.git == nil || !h.git.HasRemote(cfg.WikiPath) {
+		return
+	}
+	go func() {
+		h.syncMu.Lock()
+		defer h.syncMu.Unlock()
+		if err := h.git.Push(cfg.WikiPath); err != nil {
+			log.Printf("Auto-push failed: %v", err)
+		}
+	}()
+}
+
+// syncLoop pulls and pushes the wiki on every interval, for as long as the
+// server runs
+func (h *Handler) syncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.sync()
+	}
+}
+
+// sync pulls the wiki's remote changes and pushes its own. Wikis without a
+// remote are left alone
+func (h *Handler) sync() {
+	cfg := h.cfg()
+	if cfg.WikiPath == "" || h.git == nil || !h.git.IsRepository(cfg.WikiPath) || !h.git.HasRemote(cfg.WikiPath) {
+		return
+	}
+
+	h.syncMu.Lock()
+	defer h.syncMu.Unlock()
+	if err := h.git.Pull(cfg.WikiPath); err != nil {
+		log.Printf("Sync failed to pull: %v", err)
+		return
+	}
+	if err := h.git.Push(cfg.WikiPath); err != nil {
+		log.Printf("Sync failed to push: %v", err)
+	}
+}
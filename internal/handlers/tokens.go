@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/timhughes/fishki/internal/audit"
+	"github.com/timhughes/fishki/internal/auth"
+)
+
+// tokenResponse describes an API token without its hash
+type tokenResponse struct {
+	ID       string       `json:"id"`
+	Name     string       `json:"name"`
+	Scopes   []auth.Scope `json:"scopes"`
+	Created  time.Time    `json:"created"`
+	Expires  *time.Time   `json:"expires,omitempty"`
+	LastUsed *time.Time   `json:"lastUsed,omitempty"`
+
+	// Token is the secret, only returned when the token is created
+	Token string `json:"token,omitempty"`
+}
+
+func newTokenResponse(t auth.Token) tokenResponse {
+	return tokenResponse{
+		ID:       t.ID,
+		Name:     t.Name,
+		Scopes:   t.Scopes,
+		Created:  t.Created,
+		Expires:  t.Expires,
+		LastUsed: t.LastUsed,
+	}
+}
+
+// tokensHandler lists, creates and revokes the caller's API tokens
+func (h *Handler) tokensHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := auth.FromContext(r.Context())
+		if id == nil {
+			http.Error(w, "Log in to manage API tokens", http.StatusUnauthorized)
+			return
+		}
+		if h.tokens == nil {
+			http.Error(w, "API tokens are not available", http.StatusInternalServerError)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			tokens := []tokenResponse{}
+			for _, t := range h.tokens.List(id.Username) {
+				tokens = append(tokens, newTokenResponse(t))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(tokens)
+
+		case http.MethodPost:
+			var request struct {
+				Name          string       `json:"name"`
+				Scopes        []auth.Scope `json:"scopes"`
+				ExpiresInDays int          `json:"expiresInDays"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if request.Name == "" {
+				http.Error(w, "Name is required", http.StatusBadRequest)
+				return
+			}
+			if request.ExpiresInDays < 0 {
+				http.Error(w, "Invalid expiry", http.StatusBadRequest)
+				return
+			}
+
+			ttl := time.Duration(request.ExpiresInDays) * 24 * time.Hour
+			secret, token, err := h.tokens.Create(*id, request.Name, request.Scopes, ttl)
+			if err != nil {
+				http.Error(w, "Failed to create token: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			h.record(r, audit.Event{Action: "token.create", Success: true, Detail: token.ID + " " + token.Name})
+
+			response := newTokenResponse(token)
+			response.Token = secret
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+
+		case http.MethodDelete:
+			var request struct {
+				ID string `json:"id"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if err := h.tokens.Revoke(id.Username, request.ID); err != nil {
+				if err == auth.ErrTokenNotFound {
+					http.Error(w, "Token not found", http.StatusNotFound)
+					return
+				}
+				http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+				return
+			}
+			h.record(r, audit.Event{Action: "token.revoke", Success: true, Detail: request.ID})
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/timhughes/fishki/internal/acl"
+	"github.com/timhughes/fishki/internal/audit"
+	"github.com/timhughes/fishki/internal/git"
+)
+
+// deletedPages lists the pages deleted in the wiki's history that haven't
+// been recreated since, newest first
+func (h *Handler) deletedPages(wikiPath string) ([]git.DeletedFile, error) {
+	if h.git == nil || !h.git.IsRepository(wikiPath) {
+		return []git.DeletedFile{}, nil
+	}
+
+	deleted, err := h.git.DeletedFiles(wikiPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := []git.DeletedFile{}
+	for _, d := range deleted {
+		if filepath.Ext(d.Path) != ".md" {
+			continue
+		}
+		fullPath, err := ValidatePath(wikiPath, d.Path)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Lstat(fullPath); err == nil {
+			continue
+		}
+		pages = append(pages, d)
+	}
+	return pages, nil
+}
+
+// trashHandler lists deleted pages with who deleted them and when
+func (h *Handler) trashHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if cfg.WikiPath == "" {
+			http.Error(w, "Wiki path not set", http.StatusBadRequest)
+			return
+		}
+
+		pages, err := h.deletedPages(cfg.WikiPath)
+		if err != nil {
+			http.Error(w, "Failed to read history", http.StatusInternalServerError)
+			return
+		}
+
+		canRead, err := h.readFilter(r, cfg)
+		if err != nil {
+			http.Error(w, "Failed to load access policy", http.StatusInternalServerError)
+			return
+		}
+		visible := []git.DeletedFile{}
+		for _, page := range pages {
+			if canRead(page.Path) {
+				visible = append(visible, page)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(visible)
+	}
+}
+
+// trashRestoreHandler brings back the last version of a deleted page, at
+// its original path or a new one, and commits it
+func (h *Handler) trashRestoreHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.cfg()
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if cfg.WikiPath == "" {
+			http.Error(w, "Wiki path not set", http.StatusBadRequest)
+			return
+		}
+
+		var request struct {
+			Path   string `json:"path"`
+			Commit string `json:"commit"`
+			Target string `json:"target"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if request.Path == "" {
+			http.Error(w, "Path is required", http.StatusBadRequest)
+			return
+		}
+
+		pages, err := h.deletedPages(cfg.WikiPath)
+		if err != nil {
+			http.Error(w, "Failed to read history", http.StatusInternalServerError)
+			return
+		}
+
+		// Only pages in the trash can be restored, which also keeps
+		// arbitrary revisions out of the git command line
+		path := filepath.ToSlash(filepath.Clean(request.Path))
+		var deleted *git.DeletedFile
+		for i := range pages {
+			if pages[i].Path == path && (request.Commit == "" || pages[i].Commit == request.Commit) {
+				deleted = &pages[i]
+				break
+			}
+		}
+		if deleted == nil {
+			h.record(r, audit.Event{Action: "restore", Path: path, Detail: "not in the trash"})
+			http.Error(w, "Deleted page not found", http.StatusNotFound)
+			return
+		}
+		if !h.authorize(w, r, cfg, deleted.Path, acl.Read) {
+			return
+		}
+
+		target := request.Target
+		if target == "" {
+			target = deleted.Path
+		}
+		if filepath.Ext(target) != ".md" {
+			target += ".md"
+		}
+		target, fullPath, err := wikiFile(cfg.WikiPath, target)
+		if err != nil {
+			h.record(r, audit.Event{Action: "restore", Path: deleted.Path, Detail: "invalid target " + request.Target})
+			http.Error(w, "Invalid target path", http.StatusBadRequest)
+			return
+		}
+		if !h.authorize(w, r, cfg, target, acl.Write) {
+			return
+		}
+		if _, err := os.Lstat(fullPath); err == nil {
+			h.record(r, audit.Event{Action: "restore", Path: target, Detail: "a page already exists"})
+			http.Error(w, "A page already exists at the target path", http.StatusConflict)
+			return
+		}
+
+		content, err := h.git.FileAt(cfg.WikiPath, deleted.Commit+"^", deleted.Path)
+		if err != nil {
+			h.record(r, audit.Event{Action: "restore", Path: target, Detail: err.Error()})
+			http.Error(w, "Failed to read deleted page", http.StatusInternalServerError)
+			return
+		}
+
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			h.record(r, audit.Event{Action: "restore", Path: target, Detail: err.Error()})
+			http.Error(w, "Failed to create directory", http.StatusInternalServerError)
+			return
+		}
+		if err := os.WriteFile(fullPath, content, 0644); err != nil {
+			h.record(r, audit.Event{Action: "restore", Path: target, Detail: err.Error()})
+			http.Error(w, "Failed to restore page", http.StatusInternalServerError)
+			return
+		}
+
+		relPath := target
+		message := "Restore " + deleted.Path
+		if relPath != deleted.Path {
+			message += " as " + relPath
+		}
+		if err := h.git.CommitWith(cfg.WikiPath, git.CommitOptions{Message: message, Paths: []string{relPath}, Author: commitAuthor(r)}); err != nil {
+			h.record(r, audit.Event{Action: "restore", Path: target, Detail: err.Error()})
+			http.Error(w, "Failed to commit restored page", http.StatusInternalServerError)
+			return
+		}
+		h.autoPush(cfg)
+
+		h.record(r, audit.Event{Action: "restore", Success: true, Path: relPath, Detail: "from " + deleted.Path + " at " + deleted.Commit})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"filename": relPath,
+		})
+	}
+}
@@ -0,0 +1,631 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"github.com/timhughes/fishki/internal/git"
+)
+
+// cfPage is a page found in a Confluence space export
+type cfPage struct {
+	id       string
+	parent   string
+	title    string
+	position int
+	body     string
+	source   string // file in the export the page came from, for HTML exports
+	modTime  time.Time
+	author   string
+
+	attachments []*cfAttachment
+	children    []*cfPage
+	target      string
+}
+
+// cfAttachment is a file attached to a Confluence page
+type cfAttachment struct {
+	name   string
+	file   *zip.File
+	target string
+}
+
+// cfSpace is a Confluence space read from an export
+type cfSpace struct {
+	pages  []*cfPage
+	byZip  map[string]string // export file to wiki path, for HTML exports
+	report *Report
+}
+
+// ImportConfluence imports a Confluence space export zip into the wiki.
+// Both XML exports, whose pages are in Confluence's storage format, and HTML
+// exports are understood. The page hierarchy becomes folders, with a parent
+// page stored as its folder's index.md, and attachments are moved into the
+// folder of the page they belong to. Macros that have no Markdown
+// equivalent are left as placeholders and listed in the report
+func (im *Importer) ImportConfluence(name string, opts Options) (*Report, error) {
+	dest, err := im.destDir(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export: %v", err)
+	}
+	defer archive.Close()
+
+	report := &Report{}
+	space := &cfSpace{byZip: make(map[string]string), report: report}
+	if entities := findZipFile(archive.File, "entities.xml"); entities != nil {
+		err = space.readXML(archive.File, entities)
+	} else if index := findZipFile(archive.File, "index.html"); index != nil {
+		err = space.readHTML(archive.File, index)
+	} else {
+		err = fmt.Errorf("not a Confluence space export: no entities.xml or index.html")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	roots := space.tree()
+	taken := make(map[string]bool)
+	space.assign(roots, dest, taken, opts, im)
+
+	var imported []importedFile
+	var walk func(pages []*cfPage) error
+	walk = func(pages []*cfPage) error {
+		for _, page := range pages {
+			if err := im.writeConfluencePage(space, page, report, &imported); err != nil {
+				return err
+			}
+			if err := walk(page.children); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(roots); err != nil {
+		return report, err
+	}
+
+	summary := fmt.Sprintf("Import %d pages from Confluence", len(report.Pages))
+	if err := im.commit(imported, opts, summary, report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// writeConfluencePage converts a page and writes it with its attachments
+func (im *Importer) writeConfluencePage(space *cfSpace, page *cfPage, report *Report, imported *[]importedFile) error {
+	var author *git.Signature
+	if page.author != "" {
+		author = &git.Signature{Name: page.author, Email: NormalizeName(page.author) + "@confluence.invalid"}
+	}
+
+	for _, a := range page.attachments {
+		if a.target == "" {
+			continue
+		}
+		data, err := readZipFile(a.file)
+		if err != nil {
+			report.skip(a.file.Name, "%v", err)
+			continue
+		}
+		if err := im.writeFile(a.target, data, a.file.Modified); err != nil {
+			return err
+		}
+		report.Attachments = append(report.Attachments, a.target)
+		*imported = append(*imported, importedFile{
+			path:    a.target,
+			source:  a.name,
+			modTime: a.file.Modified,
+			author:  author,
+			message: "Import " + a.name + " attached to " + page.title,
+		})
+	}
+
+	if page.target == "" {
+		return nil
+	}
+	content, macros := convertStorage(page.body, page.target, &cfLinks{space: space, current: page})
+	content = "# " + page.title + "\n\n" + content
+	if len(macros) > 0 {
+		report.skip(page.target, "macros not converted: %s", strings.Join(macros, ", "))
+	}
+
+	if err := im.writeFile(page.target, []byte(content), page.modTime); err != nil {
+		return err
+	}
+	report.Pages = append(report.Pages, page.target)
+	*imported = append(*imported, importedFile{
+		path:    page.target,
+		source:  page.title,
+		modTime: page.modTime,
+		author:  author,
+		message: "Import " + page.title,
+	})
+	return nil
+}
+
+// tree links pages to their parents, returning the top-level pages. Pages
+// are ordered as they were in Confluence
+func (s *cfSpace) tree() []*cfPage {
+	byID := make(map[string]*cfPage)
+	for _, page := range s.pages {
+		byID[page.id] = page
+	}
+
+	var roots []*cfPage
+	for _, page := range s.pages {
+		if parent, ok := byID[page.parent]; ok && page.parent != page.id {
+			parent.children = append(parent.children, page)
+		} else {
+			roots = append(roots, page)
+		}
+	}
+
+	var order func(pages []*cfPage)
+	order = func(pages []*cfPage) {
+		sort.SliceStable(pages, func(i, j int) bool {
+			if pages[i].position != pages[j].position {
+				return pages[i].position < pages[j].position
+			}
+			return strings.ToLower(pages[i].title) < strings.ToLower(pages[j].title)
+		})
+		for _, page := range pages {
+			order(page.children)
+		}
+	}
+	order(roots)
+	return roots
+}
+
+// assign chooses where pages and attachments go in the wiki. Pages with
+// children or attachments become folders holding an index.md
+func (s *cfSpace) assign(pages []*cfPage, dir string, taken map[string]bool, opts Options, im *Importer) {
+	for _, page := range pages {
+		name := NormalizeName(page.title)
+		folder := ""
+		if len(page.children) > 0 || len(page.attachments) > 0 {
+			folder = uniquePath(path.Join(dir, name), func(p string) bool { return taken[p] || taken[p+".md"] })
+			taken[folder] = true
+			page.target = path.Join(folder, "index.md")
+		} else {
+			page.target = uniquePath(path.Join(dir, name+".md"), func(p string) bool {
+				return taken[p] || taken[strings.TrimSuffix(p, ".md")]
+			})
+		}
+
+		if !opts.Overwrite && im.exists(page.target) {
+			s.report.skip(page.title, "%s already exists in the wiki", page.target)
+			page.target = ""
+		} else {
+			taken[page.target] = true
+			s.byZip[page.source] = page.target
+		}
+
+		for _, a := range page.attachments {
+			target := path.Join(folder, attachmentName(a.name))
+			if !opts.Overwrite && im.exists(target) {
+				s.report.skip(a.file.Name, "%s already exists in the wiki", target)
+				continue
+			}
+			a.target = uniquePath(target, func(p string) bool { return taken[p] })
+			taken[a.target] = true
+			s.byZip[a.file.Name] = a.target
+		}
+
+		if folder != "" {
+			s.assign(page.children, folder, taken, opts, im)
+		}
+	}
+}
+
+// cfLinks resolves the links in one page of a Confluence export
+type cfLinks struct {
+	space   *cfSpace
+	current *cfPage
+}
+
+func (l *cfLinks) page(title string) (string, bool) {
+	if title == "" {
+		return l.current.target, l.current.target != ""
+	}
+	for _, page := range l.space.pages {
+		if page.title == title && page.target != "" {
+			return page.target, true
+		}
+	}
+	return "", false
+}
+
+func (l *cfLinks) attachment(filename string) (string, bool) {
+	for _, a := range l.current.attachments {
+		if a.name == filename && a.target != "" {
+			return a.target, true
+		}
+	}
+	return "", false
+}
+
+func (l *cfLinks) href(ref string) (string, bool) {
+	if l.current.source == "" || ref == "" || strings.Contains(ref, ":") {
+		return "", false
+	}
+	target, ok := l.space.byZip[path.Join(path.Dir(l.current.source), ref)]
+	return target, ok && target != ""
+}
+
+func (l *cfLinks) children() [][2]string {
+	var links [][2]string
+	for _, child := range l.current.children {
+		if child.target != "" {
+			links = append(links, [2]string{child.target, child.title})
+		}
+	}
+	return links
+}
+
+// cfProperty is a property of an object in entities.xml, either a value or
+// a reference to another object
+type cfProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+	ID    string `xml:"id"`
+}
+
+// cfObject is an object in entities.xml, the Hibernate dump in XML exports
+type cfObject struct {
+	Class      string       `xml:"class,attr"`
+	ID         string       `xml:"id"`
+	Properties []cfProperty `xml:"property"`
+}
+
+// property returns a property's value, or the ID it refers to
+func (o *cfObject) property(name string) string {
+	for _, p := range o.Properties {
+		if p.Name == name {
+			if p.ID != "" {
+				return strings.TrimSpace(p.ID)
+			}
+			return strings.TrimSpace(p.Value)
+		}
+	}
+	return ""
+}
+
+// readXML reads the pages, bodies, attachments and users in an XML export
+func (s *cfSpace) readXML(files []*zip.File, entities *zip.File) error {
+	rc, err := entities.Open()
+	if err != nil {
+		return fmt.Errorf("failed to read entities.xml: %v", err)
+	}
+	defer rc.Close()
+
+	pages := make(map[string]*cfPage)
+	bodies := make(map[string]string)
+	users := make(map[string]string)
+	modifiers := make(map[string]string)
+	var attachments []cfObject
+
+	decoder := xml.NewDecoder(rc)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid entities.xml: %v", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "object" {
+			continue
+		}
+
+		var obj cfObject
+		if err := decoder.DecodeElement(&obj, &start); err != nil {
+			return fmt.Errorf("invalid entities.xml: %v", err)
+		}
+		obj.ID = strings.TrimSpace(obj.ID)
+
+		// Older versions of pages and attachments point at the current one
+		if obj.property("originalVersion") != "" {
+			continue
+		}
+		if status := obj.property("contentStatus"); status != "" && status != "current" {
+			continue
+		}
+
+		switch obj.Class {
+		case "Page":
+			position, _ := strconv.Atoi(obj.property("position"))
+			page := &cfPage{
+				id:       obj.ID,
+				parent:   obj.property("parent"),
+				title:    obj.property("title"),
+				position: position,
+				modTime:  parseConfluenceTime(obj.property("lastModificationDate")),
+			}
+			pages[obj.ID] = page
+			modifiers[obj.ID] = obj.property("lastModifier")
+		case "BlogPost":
+			s.report.skip(obj.property("title"), "blog posts are not imported")
+		case "BodyContent":
+			bodies[obj.property("content")] = obj.property("body")
+		case "Attachment":
+			attachments = append(attachments, obj)
+		case "ConfluenceUserImpl":
+			users[obj.ID] = obj.property("name")
+		}
+	}
+
+	for id, modifier := range modifiers {
+		pages[id].author = users[modifier]
+	}
+
+	prefix := path.Dir(entities.Name)
+	byName := make(map[string]*zip.File)
+	for _, f := range files {
+		byName[f.Name] = f
+	}
+	for _, obj := range attachments {
+		pageID := obj.property("containerContent")
+		if pageID == "" {
+			pageID = obj.property("content")
+		}
+		page, ok := pages[pageID]
+		if !ok {
+			continue
+		}
+		name := obj.property("title")
+		if name == "" {
+			name = obj.property("fileName")
+		}
+
+		dir := path.Join(prefix, "attachments", pageID, obj.ID)
+		file := byName[path.Join(dir, obj.property("version"))]
+		if file == nil {
+			file = latestVersion(files, dir)
+		}
+		if file == nil {
+			s.report.skip(name, "attachment of %s is missing from the export", page.title)
+			continue
+		}
+		page.attachments = append(page.attachments, &cfAttachment{name: name, file: file})
+	}
+
+	for id, page := range pages {
+		page.body = bodies[id]
+		s.pages = append(s.pages, page)
+	}
+	sortPages(s.pages)
+	return nil
+}
+
+// latestVersion finds the highest numbered version of an attachment
+func latestVersion(files []*zip.File, dir string) *zip.File {
+	var latest *zip.File
+	best := -1
+	for _, f := range files {
+		if path.Dir(f.Name) != dir {
+			continue
+		}
+		if v, err := strconv.Atoi(path.Base(f.Name)); err == nil && v > best {
+			latest, best = f, v
+		}
+	}
+	return latest
+}
+
+// cfPageID matches the page ID in an HTML export's file names, such as
+// Page-Title_12345.html or 12345.html
+var cfPageID = regexp.MustCompile(`(?:^|_)(\d+)\.html$`)
+
+// readHTML reads the pages and attachments in an HTML export, taking the
+// hierarchy from the page tree in its index.html
+func (s *cfSpace) readHTML(files []*zip.File, index *zip.File) error {
+	prefix := path.Dir(index.Name)
+	byName := make(map[string]*zip.File)
+	for _, f := range files {
+		byName[f.Name] = f
+	}
+
+	data, err := readZipFile(index)
+	if err != nil {
+		return fmt.Errorf("failed to read index.html: %v", err)
+	}
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid index.html: %v", err)
+	}
+
+	// The page tree is nested lists of links to the pages
+	position := 0
+	var walk func(n *html.Node, parent string)
+	walk = func(n *html.Node, parent string) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if c.Data != "li" {
+				walk(c, parent)
+				continue
+			}
+			link := findElement(c, "a")
+			if link == nil {
+				continue
+			}
+			source := path.Join(prefix, attr(link, "href"))
+			file := byName[source]
+			if file == nil || !strings.HasSuffix(source, ".html") || path.Base(source) == "index.html" {
+				walk(c, parent)
+				continue
+			}
+			page := &cfPage{
+				id:       source,
+				parent:   parent,
+				title:    strings.TrimSpace(textContent(link)),
+				position: position,
+				source:   source,
+				modTime:  file.Modified,
+			}
+			position++
+			if err := s.readHTMLPage(page, file, files, prefix); err != nil {
+				s.report.skip(source, "%v", err)
+				continue
+			}
+			s.pages = append(s.pages, page)
+			walk(c, source)
+		}
+	}
+	walk(doc, "")
+	return nil
+}
+
+// readHTMLPage reads a page's content and attachments from an HTML export
+func (s *cfSpace) readHTMLPage(page *cfPage, file *zip.File, files []*zip.File, prefix string) error {
+	data, err := readZipFile(file)
+	if err != nil {
+		return err
+	}
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid page: %v", err)
+	}
+
+	if main := findByID(doc, "main-content"); main != nil {
+		var b bytes.Buffer
+		for c := main.FirstChild; c != nil; c = c.NextSibling {
+			html.Render(&b, c)
+		}
+		page.body = b.String()
+	}
+
+	// Attachment files are named by ID; the page's attachment list has
+	// their real names
+	names := make(map[string]string)
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			if href := attr(n, "href"); strings.HasPrefix(href, "attachments/") {
+				if name := strings.TrimSpace(textContent(n)); name != "" {
+					names[path.Join(prefix, href)] = name
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(doc)
+
+	m := cfPageID.FindStringSubmatch(path.Base(page.source))
+	if m == nil {
+		return nil
+	}
+	dir := path.Join(prefix, "attachments", m[1])
+	for _, f := range files {
+		if path.Dir(f.Name) != dir || strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		name := names[f.Name]
+		if name == "" {
+			name = path.Base(f.Name)
+		}
+		page.attachments = append(page.attachments, &cfAttachment{name: name, file: f})
+	}
+	return nil
+}
+
+// findZipFile finds the shallowest file in a zip with the given name
+func findZipFile(files []*zip.File, name string) *zip.File {
+	var found *zip.File
+	for _, f := range files {
+		if path.Base(f.Name) != name {
+			continue
+		}
+		if found == nil || strings.Count(f.Name, "/") < strings.Count(found.Name, "/") {
+			found = f
+		}
+	}
+	return found
+}
+
+// readZipFile reads a file from a zip, refusing files too large to import
+func readZipFile(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > maxImportFileSize {
+		return nil, fmt.Errorf("larger than %d MiB", maxImportFileSize>>20)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("could not be read")
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxImportFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not be read")
+	}
+	if len(data) > maxImportFileSize {
+		return nil, fmt.Errorf("larger than %d MiB", maxImportFileSize>>20)
+	}
+	return data, nil
+}
+
+// findElement returns the first element with the given name under n
+func findElement(n *html.Node, name string) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == name {
+			return c
+		}
+		if found := findElement(c, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findByID returns the element with the given id under n
+func findByID(n *html.Node, id string) *html.Node {
+	if n.Type == html.ElementNode && attr(n, "id") == id {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findByID(c, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// parseConfluenceTime parses the timestamps in entities.xml
+func parseConfluenceTime(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04:05.000", s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// sortPages orders pages by ID so imports are repeatable
+func sortPages(pages []*cfPage) {
+	sort.Slice(pages, func(i, j int) bool {
+		a, _ := strconv.Atoi(pages[i].id)
+		b, _ := strconv.Atoi(pages[j].id)
+		if a != b {
+			return a < b
+		}
+		return pages[i].id < pages[j].id
+	})
+}
@@ -0,0 +1,260 @@
+package importer
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testLinks resolves links for storage format conversion tests
+type testLinks struct{}
+
+func (testLinks) page(title string) (string, bool) {
+	if title == "Other Page" {
+		return "space/other-page.md", true
+	}
+	return "", false
+}
+
+func (testLinks) attachment(filename string) (string, bool) {
+	if filename == "Diagram.png" {
+		return "space/home/diagram.png", true
+	}
+	return "", false
+}
+
+func (testLinks) href(ref string) (string, bool) {
+	return "", false
+}
+
+func (testLinks) children() [][2]string {
+	return [][2]string{{"space/home/child.md", "Child"}}
+}
+
+func TestConvertStorage(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		macros   string
+	}{
+		{"paragraphs", "<p>Hello <strong>bold</strong> and <em>em </em>text</p><p>2 * 3</p>", "Hello **bold** and *em* text\n\n2 \\* 3\n", ""},
+		{"headings", "<h1>Title</h1><h3>Sub</h3>", "# Title\n\n### Sub\n", ""},
+		{"lists", "<ul><li>one<ul><li>nested</li></ul></li><li>two</li></ul><ol><li>a</li><li>b</li></ol>", "- one\n  - nested\n- two\n\n1. a\n2. b\n", ""},
+		{"tasks", `<ac:task-list><ac:task><ac:task-status>complete</ac:task-status><ac:task-body>Done</ac:task-body></ac:task><ac:task><ac:task-status>incomplete</ac:task-status><ac:task-body>Todo</ac:task-body></ac:task></ac:task-list>`, "- [x] Done\n- [ ] Todo\n", ""},
+		{"code macro", `<ac:structured-macro ac:name="code"><ac:parameter ac:name="language">go</ac:parameter><ac:plain-text-body><![CDATA[if a < b {}]]></ac:plain-text-body></ac:structured-macro>`, "```go\nif a < b {}\n```\n", ""},
+		{"info macro", `<ac:structured-macro ac:name="info"><ac:parameter ac:name="title">Heads up</ac:parameter><ac:rich-text-body><p>Read this</p></ac:rich-text-body></ac:structured-macro>`, ":::info Heads up\nRead this\n:::\n", ""},
+		{"nested macros", `<ac:structured-macro ac:name="expand"><ac:rich-text-body><ac:structured-macro ac:name="tip"><ac:rich-text-body><p>x</p></ac:rich-text-body></ac:structured-macro></ac:rich-text-body></ac:structured-macro>`, "::::note-\n:::tip\nx\n:::\n::::\n", ""},
+		{"page link", `<p><ac:link><ri:page ri:content-title="Other Page" /><ac:plain-text-link-body><![CDATA[the other]]></ac:plain-text-link-body></ac:link></p>`, "[the other](../other-page.md)\n", ""},
+		{"page link with anchor", `<p><ac:link ac:anchor="Part Two"><ri:page ri:content-title="Other Page" /></ac:link></p>`, "[Other Page](../other-page.md#part-two)\n", ""},
+		{"missing page", `<p><ac:link><ri:page ri:content-title="Gone" /></ac:link></p>`, "Gone\n", ""},
+		{"image", `<p><ac:image ac:alt="A diagram"><ri:attachment ri:filename="Diagram.png" /></ac:image></p>`, "![A diagram](diagram.png)\n", ""},
+		{"external image", `<p><ac:image><ri:url ri:value="https://example.com/a.png" /></ac:image></p>`, "![](https://example.com/a.png)\n", ""},
+		{"table", "<table><tbody><tr><th>Name</th><th>Notes</th></tr><tr><td>a|b</td><td><p>one</p><p>two</p></td></tr></tbody></table>", "| Name | Notes |\n| --- | --- |\n| a\\|b | one<br>two |\n", ""},
+		{"children", `<ac:structured-macro ac:name="children" />`, "- [Child](child.md)\n", ""},
+		{"include", `<ac:structured-macro ac:name="include"><ac:parameter ac:name=""><ac:link><ri:page ri:content-title="Other Page" /></ac:link></ac:parameter></ac:structured-macro>`, "![[space/other-page]]\n", ""},
+		{"toc", `<ac:structured-macro ac:name="toc" /><p>Text</p>`, "Text\n", ""},
+		{"emoticon", `<p>Nice <ac:emoticon ac:name="tick" /></p>`, "Nice ✅\n", ""},
+		{"unknown macro", `<p>See <ac:structured-macro ac:name="jira"><ac:parameter ac:name="key">ABC-1</ac:parameter></ac:structured-macro></p><ac:structured-macro ac:name="roadmap" />`, "See *[jira macro]*\n\n*[roadmap macro]*\n", "jira,roadmap"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, macros := convertStorage(tt.input, "space/home/index.md", testLinks{})
+			if got != tt.expected {
+				t.Errorf("convertStorage(%q) =\n%q\nexpected\n%q", tt.input, got, tt.expected)
+			}
+			if strings.Join(macros, ",") != tt.macros {
+				t.Errorf("Expected unconverted macros %q, got %q", tt.macros, macros)
+			}
+		})
+	}
+}
+
+// writeZip creates a zip file from a map of names to contents
+func writeZip(t *testing.T, files map[string]string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "export.zip")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for path, content := range files {
+		w, err := zw.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+const testEntities = `<?xml version="1.0" encoding="UTF-8"?>
+<hibernate-generic datetime="2021-01-01 00:00:00">
+<object class="ConfluenceUserImpl" package="com.atlassian.confluence.user">
+  <id name="key"><![CDATA[u1]]></id>
+  <property name="name"><![CDATA[alice]]></property>
+</object>
+<object class="Page" package="com.atlassian.confluence.pages">
+  <id name="id">10</id>
+  <property name="title"><![CDATA[Home]]></property>
+  <property name="contentStatus"><![CDATA[current]]></property>
+  <property name="lastModifier" class="ConfluenceUserImpl" package="com.atlassian.confluence.user"><id name="key"><![CDATA[u1]]></id></property>
+  <property name="lastModificationDate">2020-02-03 04:05:06.000</property>
+</object>
+<object class="Page" package="com.atlassian.confluence.pages">
+  <id name="id">11</id>
+  <property name="title"><![CDATA[Second Child]]></property>
+  <property name="parent" class="Page" package="com.atlassian.confluence.pages"><id name="id">10</id></property>
+  <property name="position">1</property>
+  <property name="contentStatus"><![CDATA[current]]></property>
+</object>
+<object class="Page" package="com.atlassian.confluence.pages">
+  <id name="id">12</id>
+  <property name="title"><![CDATA[First Child]]></property>
+  <property name="parent" class="Page" package="com.atlassian.confluence.pages"><id name="id">10</id></property>
+  <property name="position">0</property>
+  <property name="contentStatus"><![CDATA[current]]></property>
+</object>
+<object class="Page" package="com.atlassian.confluence.pages">
+  <id name="id">13</id>
+  <property name="title"><![CDATA[Home]]></property>
+  <property name="originalVersion" class="Page" package="com.atlassian.confluence.pages"><id name="id">10</id></property>
+</object>
+<object class="Page" package="com.atlassian.confluence.pages">
+  <id name="id">14</id>
+  <property name="title"><![CDATA[Deleted]]></property>
+  <property name="contentStatus"><![CDATA[deleted]]></property>
+</object>
+<object class="BlogPost" package="com.atlassian.confluence.pages">
+  <id name="id">15</id>
+  <property name="title"><![CDATA[News]]></property>
+</object>
+<object class="BodyContent" package="com.atlassian.confluence.core">
+  <id name="id">100</id>
+  <property name="body"><![CDATA[<p>Welcome, see <ac:link><ri:page ri:content-title="First Child" /></ac:link>.</p><p><ac:image><ri:attachment ri:filename="Team Photo.PNG" /></ac:image></p><ac:structured-macro ac:name="jira" />]]></property>
+  <property name="content" class="Page" package="com.atlassian.confluence.pages"><id name="id">10</id></property>
+</object>
+<object class="BodyContent" package="com.atlassian.confluence.core">
+  <id name="id">101</id>
+  <property name="body"><![CDATA[<p>Back <ac:link><ri:page ri:content-title="Home" /></ac:link></p>]]></property>
+  <property name="content" class="Page" package="com.atlassian.confluence.pages"><id name="id">12</id></property>
+</object>
+<object class="Attachment" package="com.atlassian.confluence.pages">
+  <id name="id">200</id>
+  <property name="title"><![CDATA[Team Photo.PNG]]></property>
+  <property name="containerContent" class="Page" package="com.atlassian.confluence.pages"><id name="id">10</id></property>
+  <property name="version">2</property>
+  <property name="contentStatus"><![CDATA[current]]></property>
+</object>
+</hibernate-generic>`
+
+func TestImportConfluenceXML(t *testing.T) {
+	wiki, im := newTestWiki(t)
+	export := writeZip(t, map[string]string{
+		"entities.xml":                testEntities,
+		"exportDescriptor.properties": "exportType=space\n",
+		"attachments/10/200/1":        "old photo",
+		"attachments/10/200/2":        "photo",
+	})
+
+	report, err := im.ImportConfluence(export, Options{Dest: "team", Commit: CommitPerFile, UseModTimes: true})
+	if err != nil {
+		t.Fatalf("ImportConfluence failed: %v", err)
+	}
+
+	home := readWikiFile(t, wiki, "team/home/index.md")
+	for _, expected := range []string{
+		"# Home\n\nWelcome, see [First Child](first-child.md).",
+		"![Team Photo.PNG](team-photo.png)",
+		"*[jira macro]*",
+	} {
+		if !strings.Contains(home, expected) {
+			t.Errorf("Expected home page to contain %q, got %q", expected, home)
+		}
+	}
+	if got := readWikiFile(t, wiki, "team/home/first-child.md"); got != "# First Child\n\nBack [Home](index.md)\n" {
+		t.Errorf("Unexpected child page: %q", got)
+	}
+	if got := readWikiFile(t, wiki, "team/home/team-photo.png"); got != "photo" {
+		t.Errorf("Expected the latest attachment version, got %q", got)
+	}
+
+	expectedPages := "team/home/index.md,team/home/first-child.md,team/home/second-child.md"
+	if strings.Join(report.Pages, ",") != expectedPages {
+		t.Errorf("Expected pages %s, got %v", expectedPages, report.Pages)
+	}
+	reasons := make(map[string]string)
+	for _, s := range report.Skipped {
+		reasons[s.Path] = s.Reason
+	}
+	if reasons["team/home/index.md"] != "macros not converted: jira" {
+		t.Errorf("Expected unconverted macros to be reported, got %q", reasons["team/home/index.md"])
+	}
+	if reasons["News"] != "blog posts are not imported" {
+		t.Errorf("Expected blog post to be reported, got %+v", report.Skipped)
+	}
+
+	if report.Commits != 4 {
+		t.Errorf("Expected 4 commits, got %d", report.Commits)
+	}
+	log := gitLog(t, wiki, "%an|%aI|%s")
+	if last := log[len(log)-1]; last != "alice|2020-02-03T04:05:06+00:00|Import Home" {
+		t.Errorf("Expected the home page to be committed last by its author, got %q", last)
+	}
+}
+
+func TestImportConfluenceHTML(t *testing.T) {
+	wiki, im := newTestWiki(t)
+	export := writeZip(t, map[string]string{
+		"SPACE/index.html": `<html><body><div class="pageSection"><h2>Available Pages:</h2>
+<ul><li><a href="Home_10.html">Home</a>
+  <ul><li><a href="Guide_11.html">Guide</a></li></ul>
+</li></ul></div></body></html>`,
+		"SPACE/Home_10.html": `<html><head><title>Space : Home</title></head><body>
+<div id="main-content" class="wiki-content group"><p>Read the <a href="Guide_11.html">guide</a>.</p>
+<p><img class="confluence-embedded-image" src="attachments/10/300.png" alt="chart"></p>
+<div class="code panel pdl"><div class="codeContent panelContent pdl"><pre class="syntaxhighlighter-pre" data-syntaxhighlighter-params="brush: java; gutter: false">int x;</pre></div></div></div>
+<div class="pageSection group"><h2 id="attachments">Attachments:</h2>
+<img src="images/icons/bullet_blue.gif"> <a href="attachments/10/300.png">Sales Chart.png</a></div>
+</body></html>`,
+		"SPACE/Guide_11.html":          `<html><body><div id="main-content"><h2>Steps</h2><ol><li>Start</li></ol></div></body></html>`,
+		"SPACE/attachments/10/300.png": "png",
+	})
+
+	report, err := im.ImportConfluence(export, Options{})
+	if err != nil {
+		t.Fatalf("ImportConfluence failed: %v", err)
+	}
+
+	expected := "# Home\n\nRead the [guide](guide.md).\n\n![chart](sales-chart.png)\n\n```java\nint x;\n```\n"
+	if got := readWikiFile(t, wiki, "home/index.md"); got != expected {
+		t.Errorf("Unexpected home page:\n%q\nexpected\n%q", got, expected)
+	}
+	if got := readWikiFile(t, wiki, "home/guide.md"); got != "# Guide\n\n## Steps\n\n1. Start\n" {
+		t.Errorf("Unexpected guide page: %q", got)
+	}
+	if got := readWikiFile(t, wiki, "home/sales-chart.png"); got != "png" {
+		t.Errorf("Expected the attachment to be moved, got %q", got)
+	}
+	if report.Commits != 1 || len(report.Pages) != 2 || len(report.Attachments) != 1 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+}
+
+func TestImportConfluenceErrors(t *testing.T) {
+	_, im := newTestWiki(t)
+	if _, err := im.ImportConfluence(filepath.Join(t.TempDir(), "missing.zip"), Options{}); err == nil {
+		t.Errorf("Expected an error for a missing export")
+	}
+	if _, err := im.ImportConfluence(writeZip(t, map[string]string{"readme.txt": "hi"}), Options{}); err == nil {
+		t.Errorf("Expected an error for a zip that isn't a Confluence export")
+	}
+}
@@ -0,0 +1,339 @@
+// Package importer brings content from other tools into a wiki, writing it
+// as Markdown files and committing it to the wiki's git repository
+package importer
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/timhughes/fishki/internal/git"
+)
+
+// maxImportFileSize bounds the files an import will copy
+const maxImportFileSize = 100 << 20 // 100 MiB
+
+// CommitMode says how imported files are committed
+type CommitMode string
+
+const (
+	// CommitSingle commits the whole import at once
+	CommitSingle CommitMode = "single"
+
+	// CommitPerFile commits every file on its own
+	CommitPerFile CommitMode = "per-file"
+)
+
+// Options controls an import
+type Options struct {
+	// Dest is the folder inside the wiki to import into; empty means the
+	// wiki root
+	Dest string `json:"dest"`
+
+	// Commit is how the imported files are committed, CommitSingle by default
+	Commit CommitMode `json:"commit"`
+
+	// UseModTimes dates commits with the files' modification times instead
+	// of the time of the import
+	UseModTimes bool `json:"useModTimes"`
+
+	// Overwrite replaces files that already exist in the wiki instead of
+	// skipping them
+	Overwrite bool `json:"overwrite"`
+
+	// Author commits files that have no author of their own in the source,
+	// instead of the repository's configured git user
+	Author *git.Signature `json:"-"`
+}
+
+// Skipped is something an import left out, and why
+type Skipped struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Report lists what an import did
+type Report struct {
+	Pages       []string  `json:"pages"`
+	Attachments []string  `json:"attachments"`
+	Skipped     []Skipped `json:"skipped"`
+	Commits     int       `json:"commits"`
+}
+
+func (r *Report) skip(p, reason string, args ...interface{}) {
+	r.Skipped = append(r.Skipped, Skipped{Path: p, Reason: fmt.Sprintf(reason, args...)})
+}
+
+// Importer writes imported content into a wiki
+type Importer struct {
+	WikiPath string
+	Git      git.GitClient
+}
+
+// New creates an importer for the wiki at wikiPath
+func New(wikiPath string, client git.GitClient) *Importer {
+	return &Importer{WikiPath: wikiPath, Git: client}
+}
+
+// destDir validates the destination folder and returns it relative to the
+// wiki root, using forward slashes
+func (im *Importer) destDir(opts Options) (string, error) {
+	if im.WikiPath == "" {
+		return "", fmt.Errorf("wiki path not set")
+	}
+	if !im.Git.IsRepository(im.WikiPath) {
+		return "", fmt.Errorf("wiki is not a git repository")
+	}
+	switch opts.Commit {
+	case "", CommitSingle, CommitPerFile:
+	default:
+		return "", fmt.Errorf("unknown commit mode %q", opts.Commit)
+	}
+
+	dest := path.Clean("/" + filepath.ToSlash(opts.Dest))[1:]
+	if strings.Contains(opts.Dest, "..") || filepath.IsAbs(opts.Dest) || hidden(dest) {
+		return "", fmt.Errorf("destination must be a folder inside the wiki")
+	}
+	return dest, nil
+}
+
+// hidden reports whether a path relative to the wiki root is in, or is, a
+// hidden file or folder such as .git or .fishki, which imports never write to
+func hidden(rel string) bool {
+	for _, part := range strings.Split(rel, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// importedFile is a file written by an import, for committing
+type importedFile struct {
+	path    string // relative to the wiki root
+	source  string // where it came from, for commit messages
+	modTime time.Time
+	author  *git.Signature
+	message string
+}
+
+// commit commits the imported files as the options ask
+func (im *Importer) commit(files []importedFile, opts Options, summary string, report *Report) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	// Commit in chronological order so history reads the right way round
+	if opts.UseModTimes {
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].modTime.Before(files[j].modTime)
+		})
+	}
+
+	if opts.Commit == CommitPerFile {
+		for _, f := range files {
+			commitOpts := git.CommitOptions{
+				Message: f.message,
+				Paths:   []string{f.path},
+				Author:  f.author,
+			}
+			if commitOpts.Author == nil {
+				commitOpts.Author = opts.Author
+			}
+			if commitOpts.Message == "" {
+				commitOpts.Message = "Import " + f.source
+			}
+			if opts.UseModTimes {
+				commitOpts.Date = f.modTime
+			}
+			if err := im.Git.CommitWith(im.WikiPath, commitOpts); err != nil {
+				return fmt.Errorf("failed to commit %s: %v", f.path, err)
+			}
+			report.Commits++
+		}
+		return nil
+	}
+
+	commitOpts := git.CommitOptions{Message: summary, Author: opts.Author}
+	for _, f := range files {
+		commitOpts.Paths = append(commitOpts.Paths, f.path)
+		if opts.UseModTimes && f.modTime.After(commitOpts.Date) {
+			commitOpts.Date = f.modTime
+		}
+	}
+	if err := im.Git.CommitWith(im.WikiPath, commitOpts); err != nil {
+		return fmt.Errorf("failed to commit import: %v", err)
+	}
+	report.Commits++
+	return nil
+}
+
+// writeFile writes an imported file into the wiki, keeping its modification time
+func (im *Importer) writeFile(rel string, data []byte, modTime time.Time) error {
+	target, err := im.target(rel)
+	if err != nil {
+		return fmt.Errorf("refusing to write %s: %v", rel, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(target, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", rel, err)
+	}
+	if !modTime.IsZero() {
+		os.Chtimes(target, modTime, modTime)
+	}
+	return nil
+}
+
+// target returns where the file at rel is written. Paths into hidden
+// folders such as .git are refused, as are paths through symbolic links,
+// which could lead out of the wiki or into its repository
+func (im *Importer) target(rel string) (string, error) {
+	if rel == "" || path.IsAbs(rel) || path.Clean(rel) != rel || strings.HasPrefix(rel, "../") || hidden(rel) {
+		return "", fmt.Errorf("path outside the wiki")
+	}
+	root, err := filepath.EvalSymlinks(im.WikiPath)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(root, filepath.FromSlash(rel))
+	for existing := target; existing != root; existing = filepath.Dir(existing) {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if resolved != existing {
+			return "", fmt.Errorf("path is a symbolic link")
+		}
+		break
+	}
+	return target, nil
+}
+
+// exists reports whether a path relative to the wiki root already exists
+func (im *Importer) exists(rel string) bool {
+	_, err := os.Stat(filepath.Join(im.WikiPath, filepath.FromSlash(rel)))
+	return err == nil
+}
+
+// NormalizeName turns a file or folder name into the wiki's style:
+// lowercase words joined by dashes, e.g. "My Note (draft)" becomes
+// "my-note-draft". Names that have nothing left become "untitled"
+func NormalizeName(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '.':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		default:
+			dash = true
+		}
+	}
+	normalized := strings.Trim(b.String(), ".")
+	if normalized == "" {
+		return "untitled"
+	}
+	return normalized
+}
+
+// normalizePath normalises every segment of a slash-separated path. Page
+// extensions become .md
+func normalizePath(rel string, page bool) string {
+	segments := strings.Split(rel, "/")
+	for i, segment := range segments {
+		if i < len(segments)-1 {
+			segments[i] = NormalizeName(segment)
+			continue
+		}
+		ext := strings.ToLower(path.Ext(segment))
+		if page {
+			ext = ".md"
+		}
+		segments[i] = NormalizeName(strings.TrimSuffix(segment, path.Ext(segment))) + ext
+	}
+	return strings.Join(segments, "/")
+}
+
+// uniquePath returns p, or p with a -1, -2, ... suffix if it is already taken
+func uniquePath(p string, taken func(string) bool) string {
+	if !taken(p) {
+		return p
+	}
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s-%d%s", base, i, ext)
+		if !taken(candidate) {
+			return candidate
+		}
+	}
+}
+
+// relativeLink returns a link from the page at from to target, both
+// relative to the wiki root
+func relativeLink(from, target string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(path.Dir("/"+from)), filepath.FromSlash("/"+target))
+	if err != nil {
+		return target
+	}
+	return filepath.ToSlash(rel)
+}
+
+// outsideCode calls convert on every part of a markdown document that isn't
+// code, so links shown as examples in code are left alone
+func outsideCode(content string, convert func(text string) string) string {
+	var out strings.Builder
+	var fence string
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimLeft(line, " \t>")
+		if fence != "" {
+			if strings.HasPrefix(strings.TrimSpace(trimmed), fence) {
+				fence = ""
+			}
+			out.WriteString(line)
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			out.WriteString(line)
+			continue
+		}
+
+		// Split around inline code spans
+		for line != "" {
+			start := strings.IndexByte(line, '`')
+			if start < 0 {
+				out.WriteString(convert(line))
+				break
+			}
+			out.WriteString(convert(line[:start]))
+			run := start
+			for run < len(line) && line[run] == '`' {
+				run++
+			}
+			ticks := line[start:run]
+			end := strings.Index(line[run:], ticks)
+			if end < 0 {
+				out.WriteString(line[start:])
+				break
+			}
+			out.WriteString(line[start : run+end+len(ticks)])
+			line = line[run+end+len(ticks):]
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,290 @@
+package importer
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/timhughes/fishki/internal/git"
+)
+
+// newTestWiki creates a git repository for an import to write into
+func newTestWiki(t *testing.T) (string, *Importer) {
+	t.Helper()
+	wiki := t.TempDir()
+	client := git.New()
+	if err := client.Init(wiki); err != nil {
+		t.Fatalf("Failed to init wiki: %v", err)
+	}
+	for _, args := range [][]string{
+		{"config", "user.name", "Test User"},
+		{"config", "user.email", "test@example.com"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = wiki
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("git %v failed: %v", args, err)
+		}
+	}
+	return wiki, New(wiki, client)
+}
+
+// writeFiles creates files under root from a map of relative paths
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// gitLog returns the wiki's history, oldest first, in the given format
+func gitLog(t *testing.T, wiki, format string) []string {
+	t.Helper()
+	cmd := exec.Command("git", "log", "--reverse", "--format="+format)
+	cmd.Dir = wiki
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git log failed: %v", err)
+	}
+	return strings.Split(strings.TrimSpace(string(out)), "\n")
+}
+
+func readWikiFile(t *testing.T, wiki, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(wiki, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"My Note", "my-note"},
+		{"My Note (draft)", "my-note-draft"},
+		{"  spaced__out  ", "spaced-out"},
+		{"Café Menü", "café-menü"},
+		{"v1.2 notes", "v1.2-notes"},
+		{"???", "untitled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeName(tt.name); got != tt.expected {
+				t.Errorf("NormalizeName(%q) = %q, expected %q", tt.name, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConvertLinks(t *testing.T) {
+	files := []sourceFile{
+		{rel: "Daily/Today.md", target: "daily/today.md", page: true},
+		{rel: "Projects/Big Plan.md", target: "projects/big-plan.md", page: true},
+		{rel: "assets/Diagram 1.png", target: "assets/diagram-1.png"},
+	}
+	idx := newLinkIndex(files)
+	page := files[0]
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"wiki link", "See [[Big Plan]].", "See [Big Plan](../projects/big-plan.md)."},
+		{"alias", "[[Big Plan|the plan]]", "[the plan](../projects/big-plan.md)"},
+		{"heading", "[[Big Plan#Next Steps]]", "[Big Plan Next Steps](../projects/big-plan.md#next-steps)"},
+		{"same page heading", "[[#Tasks]]", "[Tasks](#tasks)"},
+		{"full path", "[[Projects/Big Plan]]", "[Projects/Big Plan](../projects/big-plan.md)"},
+		{"image embed", "![[Diagram 1.png]]", "![Diagram 1.png](../assets/diagram-1.png)"},
+		{"page embed", "![[Big Plan#Goals]]", "![[projects/big-plan#goals]]"},
+		{"markdown link", "[plan](../Projects/Big%20Plan.md#goals)", "[plan](../projects/big-plan.md#goals)"},
+		{"markdown image", "![d](../assets/Diagram%201.png)", "![d](../assets/diagram-1.png)"},
+		{"external link", "[site](https://example.com/a.md)", "[site](https://example.com/a.md)"},
+		{"inline code", "`[[Big Plan]]`", "`[[Big Plan]]`"},
+		{"fenced code", "```\n[[Big Plan]]\n```\n", "```\n[[Big Plan]]\n```\n"},
+		{"unresolved", "[[Missing|gone]]", "gone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := &Report{}
+			if got := idx.convert(tt.input, page, report); got != tt.expected {
+				t.Errorf("convert(%q) = %q, expected %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+
+	report := &Report{}
+	idx.convert("[[Missing]]", page, report)
+	if len(report.Skipped) != 1 || !strings.Contains(report.Skipped[0].Reason, "[[Missing]]") {
+		t.Errorf("Expected the unresolved link to be reported, got %+v", report.Skipped)
+	}
+}
+
+func TestImportDirectory(t *testing.T) {
+	wiki, im := newTestWiki(t)
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"Home.md":                  "# Home\n\n[[Ideas/Good Idea]] and ![[Photo.JPG]]\n",
+		"Ideas/Good Idea.md":       "# Idea\n\nBack to [[Home]].\n",
+		"Ideas/good idea.MD":       "# Clash\n",
+		"attachments/Photo.JPG":    "jpeg",
+		".obsidian/workspace.json": "{}",
+		"Existing.md":              "new content\n",
+	})
+	writeFiles(t, wiki, map[string]string{"vault/existing.md": "keep me\n"})
+	if err := os.Symlink("/etc/passwd", filepath.Join(src, "link.md")); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := im.ImportDirectory(src, Options{Dest: "vault"})
+	if err != nil {
+		t.Fatalf("ImportDirectory failed: %v", err)
+	}
+
+	home := readWikiFile(t, wiki, "vault/home.md")
+	if !strings.Contains(home, "[Ideas/Good Idea](ideas/good-idea.md)") {
+		t.Errorf("Expected converted wiki-link, got %q", home)
+	}
+	if !strings.Contains(home, "![Photo.JPG](attachments/photo.jpg)") {
+		t.Errorf("Expected converted image embed, got %q", home)
+	}
+	if got := readWikiFile(t, wiki, "vault/ideas/good-idea.md"); !strings.Contains(got, "[Home](../home.md)") {
+		t.Errorf("Expected link back to home, got %q", got)
+	}
+	if got := readWikiFile(t, wiki, "vault/ideas/good-idea-1.md"); got != "# Clash\n" {
+		t.Errorf("Expected clashing name to get a suffix, got %q", got)
+	}
+	if got := readWikiFile(t, wiki, "vault/attachments/photo.jpg"); got != "jpeg" {
+		t.Errorf("Expected copied attachment, got %q", got)
+	}
+	if got := readWikiFile(t, wiki, "vault/existing.md"); got != "keep me\n" {
+		t.Errorf("Expected existing file to be kept, got %q", got)
+	}
+
+	if len(report.Pages) != 3 || len(report.Attachments) != 1 {
+		t.Errorf("Expected 3 pages and 1 attachment, got %+v", report)
+	}
+	reasons := make(map[string]string)
+	for _, s := range report.Skipped {
+		reasons[s.Path] = s.Reason
+	}
+	for p, reason := range map[string]string{
+		".obsidian":   "hidden folder",
+		"link.md":     "symbolic link",
+		"Existing.md": "already exists",
+	} {
+		if !strings.Contains(reasons[p], reason) {
+			t.Errorf("Expected %s to be skipped as %q, got %q", p, reason, reasons[p])
+		}
+	}
+
+	if report.Commits != 1 {
+		t.Errorf("Expected a single commit, got %d", report.Commits)
+	}
+	if log := gitLog(t, wiki, "%s"); len(log) != 1 || log[0] != "Import 4 files from "+filepath.Base(src) {
+		t.Errorf("Unexpected history: %q", log)
+	}
+}
+
+func TestImportDirectoryPerFileModTimes(t *testing.T) {
+	wiki, im := newTestWiki(t)
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"a.md": "a\n", "b.md": "b\n"})
+
+	older := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	newer := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+	os.Chtimes(filepath.Join(src, "a.md"), newer, newer)
+	os.Chtimes(filepath.Join(src, "b.md"), older, older)
+
+	report, err := im.ImportDirectory(src, Options{Commit: CommitPerFile, UseModTimes: true})
+	if err != nil {
+		t.Fatalf("ImportDirectory failed: %v", err)
+	}
+	if report.Commits != 2 {
+		t.Errorf("Expected 2 commits, got %d", report.Commits)
+	}
+
+	log := gitLog(t, wiki, "%s|%aI")
+	expected := []string{
+		"Import b.md|2020-01-02T03:04:05+00:00",
+		"Import a.md|2021-06-07T08:09:10+00:00",
+	}
+	if strings.Join(log, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected history %q, got %q", expected, log)
+	}
+}
+
+func TestImportDirectoryErrors(t *testing.T) {
+	wiki, im := newTestWiki(t)
+	src := t.TempDir()
+
+	tests := []struct {
+		name string
+		src  string
+		opts Options
+	}{
+		{"missing source", filepath.Join(src, "missing"), Options{}},
+		{"source inside wiki", wiki, Options{}},
+		{"destination outside wiki", src, Options{Dest: "../elsewhere"}},
+		{"destination in the repository", src, Options{Dest: ".GIT/hooks"}},
+		{"hidden destination", src, Options{Dest: "notes/.fishki"}},
+		{"unknown commit mode", src, Options{Commit: "squash"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := im.ImportDirectory(tt.src, tt.opts); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+
+	notRepo := New(t.TempDir(), git.New())
+	if _, err := notRepo.ImportDirectory(src, Options{}); err == nil {
+		t.Errorf("Expected an error for a wiki that isn't a git repository")
+	}
+}
+
+func TestImportDirectorySymlinks(t *testing.T) {
+	wiki, im := newTestWiki(t)
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"config.md": "[core]\n"})
+
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(wiki, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := im.ImportDirectory(src, Options{Dest: "linked"}); err == nil {
+		t.Error("Expected an error importing through a symlinked folder")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "config.md")); !os.IsNotExist(err) {
+		t.Error("Expected nothing to be written outside the wiki")
+	}
+
+	if err := os.MkdirAll(filepath.Join(wiki, "notes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(wiki, ".git", "config"), filepath.Join(wiki, "notes", "config.md")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := im.ImportDirectory(src, Options{Dest: "notes", Overwrite: true}); err == nil {
+		t.Error("Expected an error overwriting a symlink")
+	}
+	if config := readWikiFile(t, wiki, ".git/config"); !strings.Contains(config, "Test User") {
+		t.Errorf("Expected the repository config to be untouched, got %q", config)
+	}
+}
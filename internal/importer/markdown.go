@@ -0,0 +1,326 @@
+package importer
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/timhughes/fishki/internal/markdown"
+)
+
+// sourceFile is a file found in a directory being imported
+type sourceFile struct {
+	rel     string // path in the source, with forward slashes
+	target  string // path in the wiki
+	page    bool
+	modTime time.Time
+}
+
+// isPageFile reports whether a file name is a Markdown page
+func isPageFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// ImportDirectory copies an Obsidian vault or a plain directory of Markdown
+// files into the wiki. File and folder names are normalised, Obsidian
+// wiki-links and embeds are converted to links the wiki understands and
+// links between imported files follow any renames
+func (im *Importer) ImportDirectory(src string, opts Options) (*Report, error) {
+	dest, err := im.destDir(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err = filepath.Abs(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source: %v", err)
+	}
+	info, err := os.Stat(src)
+	if err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("source is not a directory")
+	}
+	wiki, err := filepath.Abs(im.WikiPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wiki path: %v", err)
+	}
+	if within(src, wiki) || within(wiki, src) {
+		return nil, fmt.Errorf("source and wiki must not contain each other")
+	}
+
+	report := &Report{}
+	files, err := scanDirectory(src, report)
+	if err != nil {
+		return nil, err
+	}
+
+	// Choose names in the wiki, keeping them unique and leaving existing
+	// files alone unless asked to overwrite them
+	taken := make(map[string]bool)
+	planned := files[:0]
+	for _, f := range files {
+		target := path.Join(dest, normalizePath(f.rel, f.page))
+		if !opts.Overwrite && im.exists(target) {
+			report.skip(f.rel, "%s already exists in the wiki", target)
+			continue
+		}
+		f.target = uniquePath(target, func(p string) bool {
+			return taken[p] || (!opts.Overwrite && im.exists(p))
+		})
+		taken[f.target] = true
+		planned = append(planned, f)
+	}
+	files = planned
+
+	links := newLinkIndex(files)
+	var imported []importedFile
+	for _, f := range files {
+		data, err := os.ReadFile(filepath.Join(src, filepath.FromSlash(f.rel)))
+		if err != nil {
+			report.skip(f.rel, "could not be read")
+			continue
+		}
+		if f.page {
+			data = []byte(links.convert(string(data), f, report))
+		}
+		if err := im.writeFile(f.target, data, f.modTime); err != nil {
+			return report, err
+		}
+
+		if f.page {
+			report.Pages = append(report.Pages, f.target)
+		} else {
+			report.Attachments = append(report.Attachments, f.target)
+		}
+		imported = append(imported, importedFile{path: f.target, source: f.rel, modTime: f.modTime})
+	}
+
+	summary := fmt.Sprintf("Import %d files from %s", len(imported), filepath.Base(src))
+	if err := im.commit(imported, opts, summary, report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// within reports whether p is dir or inside it
+func within(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// scanDirectory lists the files to import, reporting everything it leaves out
+func scanDirectory(src string, report *Report) ([]sourceFile, error) {
+	var files []sourceFile
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if p == src {
+			return err
+		}
+		rel, _ := filepath.Rel(src, p)
+		rel = filepath.ToSlash(rel)
+		if err != nil {
+			report.skip(rel, "could not be read")
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Hidden entries are tool state such as .obsidian, .trash and .git
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				report.skip(rel, "hidden folder")
+				return filepath.SkipDir
+			}
+			report.skip(rel, "hidden file")
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			report.skip(rel, "symbolic link")
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			report.skip(rel, "not a regular file")
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			report.skip(rel, "could not be read")
+			return nil
+		}
+		if info.Size() > maxImportFileSize {
+			report.skip(rel, "larger than %d MiB", maxImportFileSize>>20)
+			return nil
+		}
+		files = append(files, sourceFile{rel: rel, page: isPageFile(rel), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source: %v", err)
+	}
+	return files, nil
+}
+
+// linkIndex resolves links between files being imported
+type linkIndex struct {
+	byPath map[string]*sourceFile // source path, lowercase, pages without extension
+	byName map[string]*sourceFile // base name, lowercase, pages without extension
+}
+
+func newLinkIndex(files []sourceFile) *linkIndex {
+	idx := &linkIndex{byPath: make(map[string]*sourceFile), byName: make(map[string]*sourceFile)}
+	for i := range files {
+		f := &files[i]
+		key := strings.ToLower(f.rel)
+		if f.page {
+			key = strings.TrimSuffix(key, path.Ext(key))
+		}
+		if _, ok := idx.byPath[key]; !ok {
+			idx.byPath[key] = f
+		}
+
+		// Obsidian links by the shortest unique name; the shallowest file
+		// wins when names clash, as it does in Obsidian
+		name := path.Base(key)
+		if other, ok := idx.byName[name]; !ok || strings.Count(f.rel, "/") < strings.Count(other.rel, "/") {
+			idx.byName[name] = f
+		}
+	}
+	return idx
+}
+
+// lookup finds the file a wiki-link target refers to
+func (idx *linkIndex) lookup(target string) *sourceFile {
+	key := idx.key(strings.TrimPrefix(path.Clean("/"+target), "/"))
+	if f, ok := idx.byPath[key]; ok {
+		return f
+	}
+	if f, ok := idx.byName[path.Base(key)]; ok {
+		return f
+	}
+	return nil
+}
+
+var (
+	wikiLinkPattern = regexp.MustCompile(`(!?)\[\[([^\[\]\n]+)\]\]`)
+	mdLinkPattern   = regexp.MustCompile(`(!?\[[^\[\]\n]*\]\()([^()\s]+)((?:\s+"[^"\n]*")?\))`)
+)
+
+// convert rewrites the links in an imported page
+func (idx *linkIndex) convert(content string, page sourceFile, report *Report) string {
+	return outsideCode(content, func(text string) string {
+		// Markdown links first, so the links made from wiki-links, which
+		// already use the new names, aren't rewritten again
+		text = mdLinkPattern.ReplaceAllStringFunc(text, func(match string) string {
+			return idx.convertMarkdownLink(match, page)
+		})
+		return wikiLinkPattern.ReplaceAllStringFunc(text, func(match string) string {
+			return idx.convertWikiLink(match, page, report)
+		})
+	})
+}
+
+// convertWikiLink turns [[Note#Heading|Alias]] into a Markdown link,
+// ![[image.png]] into an image and ![[Note]] into an include
+func (idx *linkIndex) convertWikiLink(match string, page sourceFile, report *Report) string {
+	parts := wikiLinkPattern.FindStringSubmatch(match)
+	embed := parts[1] == "!"
+	target, alias, _ := strings.Cut(parts[2], "|")
+	target, section, _ := strings.Cut(target, "#")
+	target = strings.TrimSpace(target)
+	section = strings.TrimSpace(section)
+
+	label := strings.TrimSpace(alias)
+	if label == "" || embed {
+		label = target
+		if section != "" {
+			label = strings.TrimSpace(target + " " + section)
+		}
+	}
+
+	// [[#Heading]] links within the same page
+	if target == "" {
+		if section == "" {
+			return match
+		}
+		return fmt.Sprintf("[%s](#%s)", label, markdown.Slugify(section))
+	}
+
+	f := idx.lookup(target)
+	if f == nil {
+		report.skip(page.rel, "unresolved link %s", match)
+		return label
+	}
+
+	if embed && f.page {
+		include := strings.TrimSuffix(f.target, ".md")
+		if section != "" {
+			include += "#" + markdown.Slugify(section)
+		}
+		return "![[" + include + "]]"
+	}
+
+	link := escapeLink(relativeLink(page.target, f.target))
+	if section != "" && f.page {
+		link += "#" + markdown.Slugify(section)
+	}
+	if embed {
+		return fmt.Sprintf("![%s](%s)", path.Base(target), link)
+	}
+	return fmt.Sprintf("[%s](%s)", label, link)
+}
+
+// convertMarkdownLink points a relative Markdown link at its target's new name
+func (idx *linkIndex) convertMarkdownLink(match string, page sourceFile) string {
+	parts := mdLinkPattern.FindStringSubmatch(match)
+	dest := parts[2]
+	if strings.Contains(dest, ":") || strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "#") {
+		return match
+	}
+
+	target, fragment, _ := strings.Cut(dest, "#")
+	unescaped, err := url.PathUnescape(target)
+	if err != nil {
+		return match
+	}
+	f, ok := idx.byPath[idx.key(path.Join(path.Dir(page.rel), unescaped))]
+	if !ok {
+		return match
+	}
+
+	link := escapeLink(relativeLink(page.target, f.target))
+	if fragment != "" {
+		link += "#" + fragment
+	}
+	return parts[1] + link + parts[3]
+}
+
+// key returns the index key for a source path
+func (idx *linkIndex) key(rel string) string {
+	key := strings.ToLower(path.Clean(rel))
+	if isPageFile(key) {
+		key = strings.TrimSuffix(key, path.Ext(key))
+	}
+	return key
+}
+
+// escapeLink escapes the characters that would break a Markdown link
+func escapeLink(link string) string {
+	segments := strings.Split(link, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
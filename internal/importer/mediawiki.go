@@ -0,0 +1,215 @@
+package importer
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/timhughes/fishki/internal/git"
+)
+
+// mediaWikiFiles is the folder, inside the import's destination, that
+// converted pages expect uploaded files to be copied into. XML dumps don't
+// include the files themselves
+const mediaWikiFiles = "files"
+
+// mwRevision is one revision of a page in a MediaWiki XML export
+type mwRevision struct {
+	Timestamp   string `xml:"timestamp"`
+	Comment     string `xml:"comment"`
+	Contributor struct {
+		Username string `xml:"username"`
+		IP       string `xml:"ip"`
+	} `xml:"contributor"`
+	Text struct {
+		Value   string `xml:",chardata"`
+		Deleted string `xml:"deleted,attr"`
+	} `xml:"text"`
+}
+
+// mwPage tracks the page whose revisions are being replayed
+type mwPage struct {
+	title     string
+	namespace int
+	target    string
+	skipped   bool
+	content   string
+	templates []string
+}
+
+// ImportMediaWikiFile imports a MediaWiki XML export from a file, which may
+// be compressed with gzip or bzip2
+func (im *Importer) ImportMediaWikiFile(name string, opts Options) (*Report, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export: %v", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch strings.ToLower(path.Ext(name)) {
+	case ".gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read export: %v", err)
+		}
+		defer gz.Close()
+		r = gz
+	case ".bz2":
+		r = bzip2.NewReader(f)
+	}
+	return im.ImportMediaWiki(r, opts)
+}
+
+// ImportMediaWiki streams a MediaWiki XML export into the wiki, converting
+// each page from wikitext to Markdown. Every revision is replayed as its own
+// commit with the original author, date and edit summary, so the page's
+// history carries over. Only articles are imported; talk, user, template and
+// other namespaces are reported as skipped
+func (im *Importer) ImportMediaWiki(r io.Reader, opts Options) (*Report, error) {
+	dest, err := im.destDir(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	host := "mediawiki.invalid"
+	var page *mwPage
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("invalid MediaWiki export: %v", err)
+		}
+
+		switch el := token.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "base":
+				var base string
+				if err := decoder.DecodeElement(&base, &el); err == nil {
+					if u, err := url.Parse(strings.TrimSpace(base)); err == nil && u.Hostname() != "" {
+						host = u.Hostname()
+					}
+				}
+			case "page":
+				page = &mwPage{}
+			case "title":
+				if page != nil {
+					decoder.DecodeElement(&page.title, &el)
+				}
+			case "ns":
+				if page != nil {
+					decoder.DecodeElement(&page.namespace, &el)
+				}
+			case "revision":
+				var rev mwRevision
+				if err := decoder.DecodeElement(&rev, &el); err != nil {
+					return report, fmt.Errorf("invalid MediaWiki export: %v", err)
+				}
+				if page == nil {
+					continue
+				}
+				if err := im.replayRevision(page, rev, dest, host, opts, report); err != nil {
+					return report, err
+				}
+			}
+
+		case xml.EndElement:
+			if el.Name.Local == "page" && page != nil {
+				if len(page.templates) > 0 {
+					report.skip(page.target, "templates left as placeholders: %s", strings.Join(page.templates, ", "))
+				}
+				page = nil
+			}
+		}
+	}
+	return report, nil
+}
+
+// replayRevision writes one revision of a page and commits it
+func (im *Importer) replayRevision(page *mwPage, rev mwRevision, dest, host string, opts Options, report *Report) error {
+	if page.skipped {
+		return nil
+	}
+
+	// The first revision decides whether the page is imported at all
+	if page.target == "" {
+		if page.namespace != 0 {
+			report.skip(page.title, "namespace %d is not imported", page.namespace)
+			page.skipped = true
+			return nil
+		}
+		page.target = mediaWikiPath(dest, page.title)
+		if !opts.Overwrite && im.exists(page.target) {
+			report.skip(page.title, "%s already exists in the wiki", page.target)
+			page.skipped = true
+			return nil
+		}
+		report.Pages = append(report.Pages, page.target)
+	}
+
+	if rev.Text.Deleted != "" {
+		report.skip(page.title, "revision from %s has hidden text", rev.Timestamp)
+		return nil
+	}
+
+	content, templates := convertWikitext(rev.Text.Value, page.target, func(title string) string {
+		return mediaWikiPath(dest, title)
+	}, path.Join(dest, mediaWikiFiles))
+	page.templates = templates
+
+	// Edits that change nothing in the converted page, such as template
+	// parameter tweaks, would be empty commits
+	if content == page.content {
+		return nil
+	}
+	page.content = content
+
+	date, _ := time.Parse(time.RFC3339, rev.Timestamp)
+	if err := im.writeFile(page.target, []byte(content), date); err != nil {
+		return err
+	}
+
+	name := rev.Contributor.Username
+	if name == "" {
+		name = rev.Contributor.IP
+	}
+	if name == "" {
+		name = "Unknown"
+	}
+	message := page.title
+	if comment := strings.TrimSpace(rev.Comment); comment != "" {
+		message += ": " + comment
+	}
+
+	err := im.Git.CommitWith(im.WikiPath, git.CommitOptions{
+		Message: message,
+		Paths:   []string{page.target},
+		Author:  &git.Signature{Name: name, Email: NormalizeName(name) + "@" + host},
+		Date:    date,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit %s: %v", page.target, err)
+	}
+	report.Commits++
+	return nil
+}
+
+// mediaWikiPath returns the wiki path for a page title. Subpages become
+// folders and, as in MediaWiki, underscores are spaces
+func mediaWikiPath(dest, title string) string {
+	title = strings.TrimSpace(strings.ReplaceAll(title, "_", " "))
+	return path.Join(dest, normalizePath(title+".md", true))
+}
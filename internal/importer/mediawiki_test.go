@@ -0,0 +1,168 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConvertWikitext(t *testing.T) {
+	title := func(t string) string { return mediaWikiPath("", t) }
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"headings", "== Intro ==\ntext\n=== Sub ===", "## Intro\ntext\n### Sub\n"},
+		{"emphasis", "'''bold''' ''italic'' '''''both'''''", "**bold** *italic* ***both***\n"},
+		{"link", "See [[Main Page]].", "See [Main Page](main-page.md).\n"},
+		{"link label and section", "[[Main_Page#Early life|early]]", "[early](main-page.md#early-life)\n"},
+		{"link trail", "[[Cat]]s", "[Cats](cat.md)\n"},
+		{"subpage", "[[Guides/Install]]", "[Guides/Install](guides/install.md)\n"},
+		{"same page section", "[[#Usage]]", "[#Usage](#usage)\n"},
+		{"external link", "[https://example.com Example] [https://example.org]", "[Example](https://example.com) <https://example.org>\n"},
+		{"bullets", "* one\n** nested\n* two", "- one\n  - nested\n- two\n"},
+		{"numbered", "# first\n#* bullet\n# second", "1. first\n   - bullet\n1. second\n"},
+		{"list then text", "* item\ntext", "- item\n\ntext\n"},
+		{"indent", ":reply\n::reply", "> reply\n> > reply\n"},
+		{"definition", "; Term : Meaning", "**Term**\n: Meaning\n"},
+		{"rule", "text\n----", "text\n\n---\n"},
+		{"template", "{{Infobox\n| name = {{PAGENAME}}\n}}\nText {{cite|x}}", "`{{Infobox}}`\nText `{{cite}}`\n"},
+		{"nowiki", "<nowiki>[[not a link]] ''x''</nowiki>", "\\[\\[not a link\\]\\] ''x''\n"},
+		{"pre", "<pre>\n[[raw]]\n</pre>", "```\n[[raw]]\n```\n"},
+		{"source", `<syntaxhighlight lang="go">fmt.Println()</syntaxhighlight>`, "```go\nfmt.Println()\n```\n"},
+		{"preformatted", " code line\n second", "```\ncode line\nsecond\n```\n"},
+		{"image", "[[File:Big Photo.JPG|thumb|200px|A caption]]", "![A caption](files/big-photo.jpg)\n"},
+		{"category", "Text\n[[Category:Animals]]", "Text\n\n*Categories: Animals*\n"},
+		{"category link", "[[:Category:Animals]]", "Category:Animals\n"},
+		{"redirect", "#REDIRECT [[Other Page]]", "Redirected to [Other Page](other-page.md)\n"},
+		{"references", "Fact<ref name=\"a\">Source</ref> again<ref name=\"a\" />\n<references />", "Fact[^1] again[^1]\n\n[^1]: Source\n"},
+		{"behaviour switch", "__NOTOC__\nText", "Text\n"},
+		{
+			"table",
+			"{| class=\"wikitable\"\n|+ Caption\n! Name !! Age\n|-\n| Alice || 30\n|-\n| style=\"color: red\" | Bob\n| [[Main Page|a|b]]\n|}",
+			"\n**Caption**\n\n| Name | Age |\n| --- | --- |\n| Alice | 30 |\n| Bob | [a\\|b](main-page.md) |\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := convertWikitext(tt.input, "index.md", title, "files")
+			expected := strings.TrimLeft(tt.expected, "\n")
+			if got != expected {
+				t.Errorf("convertWikitext(%q) =\n%q\nexpected\n%q", tt.input, got, expected)
+			}
+		})
+	}
+
+	_, templates := convertWikitext("{{b}} {{a|x}} {{b}}", "index.md", title, "files")
+	if strings.Join(templates, ",") != "a,b" {
+		t.Errorf("Expected templates a and b, got %v", templates)
+	}
+}
+
+const testMediaWikiExport = `<mediawiki xmlns="http://www.mediawiki.org/xml/export-0.10/" version="0.10">
+  <siteinfo>
+    <sitename>Old Wiki</sitename>
+    <base>https://wiki.example.com/wiki/Main_Page</base>
+  </siteinfo>
+  <page>
+    <title>Main Page</title>
+    <ns>0</ns>
+    <revision>
+      <timestamp>2010-03-04T05:06:07Z</timestamp>
+      <contributor><username>Alice Smith</username></contributor>
+      <comment>Created page</comment>
+      <text xml:space="preserve">== Welcome ==
+See [[Help/Editing]].</text>
+    </revision>
+    <revision>
+      <timestamp>2011-01-02T03:04:05Z</timestamp>
+      <contributor><ip>192.0.2.1</ip></contributor>
+      <text xml:space="preserve">== Welcome ==
+See [[Help/Editing]]. {{Stub}}</text>
+    </revision>
+    <revision>
+      <timestamp>2011-01-03T00:00:00Z</timestamp>
+      <contributor><username>Vandal</username></contributor>
+      <text deleted="deleted" />
+    </revision>
+  </page>
+  <page>
+    <title>Help/Editing</title>
+    <ns>0</ns>
+    <revision>
+      <timestamp>2010-05-06T07:08:09Z</timestamp>
+      <contributor><username>Bob</username></contributor>
+      <text xml:space="preserve">'''Edit''' pages.</text>
+    </revision>
+  </page>
+  <page>
+    <title>Talk:Main Page</title>
+    <ns>1</ns>
+    <revision>
+      <timestamp>2010-05-06T07:08:09Z</timestamp>
+      <contributor><username>Bob</username></contributor>
+      <text xml:space="preserve">Discuss.</text>
+    </revision>
+  </page>
+</mediawiki>`
+
+func TestImportMediaWiki(t *testing.T) {
+	wiki, im := newTestWiki(t)
+
+	report, err := im.ImportMediaWiki(strings.NewReader(testMediaWikiExport), Options{Dest: "old"})
+	if err != nil {
+		t.Fatalf("ImportMediaWiki failed: %v", err)
+	}
+
+	if got := readWikiFile(t, wiki, "old/main-page.md"); got != "## Welcome\nSee [Help/Editing](help/editing.md). `{{Stub}}`\n" {
+		t.Errorf("Unexpected main page: %q", got)
+	}
+	if got := readWikiFile(t, wiki, "old/help/editing.md"); got != "**Edit** pages.\n" {
+		t.Errorf("Unexpected help page: %q", got)
+	}
+
+	if strings.Join(report.Pages, ",") != "old/main-page.md,old/help/editing.md" {
+		t.Errorf("Unexpected pages: %v", report.Pages)
+	}
+	if report.Commits != 3 {
+		t.Errorf("Expected 3 commits, got %d", report.Commits)
+	}
+	reasons := make(map[string]string)
+	for _, s := range report.Skipped {
+		reasons[s.Path] += s.Reason + ";"
+	}
+	for p, reason := range map[string]string{
+		"Talk:Main Page":   "namespace 1",
+		"Main Page":        "hidden text",
+		"old/main-page.md": "templates left as placeholders: Stub",
+	} {
+		if !strings.Contains(reasons[p], reason) {
+			t.Errorf("Expected %s to be reported as %q, got %q", p, reason, reasons[p])
+		}
+	}
+
+	log := gitLog(t, wiki, "%an <%ae>|%aI|%s")
+	expected := []string{
+		"Alice Smith <alice-smith@wiki.example.com>|2010-03-04T05:06:07+00:00|Main Page: Created page",
+		"192.0.2.1 <192.0.2.1@wiki.example.com>|2011-01-02T03:04:05+00:00|Main Page",
+		"Bob <bob@wiki.example.com>|2010-05-06T07:08:09+00:00|Help/Editing",
+	}
+	if strings.Join(log, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected history\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(log, "\n"))
+	}
+
+	// Importing again leaves the existing pages alone
+	report, err = im.ImportMediaWiki(strings.NewReader(testMediaWikiExport), Options{Dest: "old"})
+	if err != nil {
+		t.Fatalf("ImportMediaWiki failed: %v", err)
+	}
+	if len(report.Pages) != 0 || report.Commits != 0 {
+		t.Errorf("Expected nothing to be imported again, got %+v", report)
+	}
+
+	if _, err := im.ImportMediaWiki(strings.NewReader("<mediawiki><page>"), Options{}); err == nil {
+		t.Errorf("Expected an error for a truncated export")
+	}
+}
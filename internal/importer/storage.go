@@ -0,0 +1,698 @@
+package importer
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/timhughes/fishki/internal/markdown"
+)
+
+// storageLinks resolves the pages and attachments a Confluence page refers to
+type storageLinks interface {
+	// page returns the wiki path of the page with the given title
+	page(title string) (string, bool)
+
+	// attachment returns the wiki path of a file attached to the page being
+	// converted
+	attachment(filename string) (string, bool)
+
+	// href returns the wiki path for a link or image source in an HTML export
+	href(ref string) (string, bool)
+
+	// children returns the wiki paths and titles of the page's child pages
+	children() [][2]string
+}
+
+// storage converts Confluence pages to Markdown. It understands both the
+// storage format of XML exports, with its ac: and ri: elements, and the
+// rendered HTML of HTML exports
+type storage struct {
+	page   string // path of the page being converted, in the wiki
+	links  storageLinks
+	macros map[string]bool // macros that couldn't be converted
+}
+
+var (
+	storageCDATA       = regexp.MustCompile(`(?s)<!\[CDATA\[(.*?)\]\]>`)
+	storageSelfClosing = regexp.MustCompile(`<((?:ac|ri):[\w-]+)((?:\s+[^<>]*?)?)\s*/>`)
+	storageBrush       = regexp.MustCompile(`brush:\s*([\w+-]+)`)
+	storageBlankLines  = regexp.MustCompile(`\n{3,}`)
+)
+
+// storageEmoticons maps Confluence emoticon names to emoji
+var storageEmoticons = map[string]string{
+	"smile":        "🙂",
+	"sad":          "🙁",
+	"cheeky":       "😛",
+	"laugh":        "😄",
+	"wink":         "😉",
+	"thumbs-up":    "👍",
+	"thumbs-down":  "👎",
+	"information":  "ℹ️",
+	"tick":         "✅",
+	"cross":        "❌",
+	"warning":      "⚠️",
+	"plus":         "➕",
+	"minus":        "➖",
+	"question":     "❓",
+	"light-on":     "💡",
+	"light-off":    "💡",
+	"yellow-star":  "⭐",
+	"red-star":     "⭐",
+	"green-star":   "⭐",
+	"blue-star":    "⭐",
+	"heart":        "❤️",
+	"broken-heart": "💔",
+}
+
+// storageAdmonitions maps Confluence panel macros to callout types
+var storageAdmonitions = map[string]string{
+	"info":    "info",
+	"tip":     "tip",
+	"note":    "note",
+	"warning": "warning",
+	"panel":   "note",
+	"expand":  "note",
+}
+
+// storageIgnored are macros whose job the wiki already does, so dropping
+// them loses nothing
+var storageIgnored = map[string]bool{
+	"toc":      true,
+	"toc-zone": true,
+	"anchor":   true,
+}
+
+// convertStorage converts a Confluence page body to Markdown, returning the
+// names of the macros it couldn't convert
+func convertStorage(body, page string, links storageLinks) (string, []string) {
+	c := &storage{page: page, links: links, macros: make(map[string]bool)}
+
+	// The HTML parser doesn't know CDATA or self-closing custom elements
+	body = storageCDATA.ReplaceAllStringFunc(body, func(m string) string {
+		return html.EscapeString(storageCDATA.FindStringSubmatch(m)[1])
+	})
+	body = storageSelfClosing.ReplaceAllString(body, "<$1$2></$1>")
+
+	nodes, err := html.ParseFragment(strings.NewReader(body), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return "", nil
+	}
+	root := &html.Node{Type: html.ElementNode, Data: "div"}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+
+	out := strings.TrimSpace(storageBlankLines.ReplaceAllString(c.blocks(root), "\n\n"))
+	if out != "" {
+		out += "\n"
+	}
+
+	var macros []string
+	for name := range c.macros {
+		macros = append(macros, name)
+	}
+	sort.Strings(macros)
+	return out, macros
+}
+
+// isBlock reports whether an element is rendered as a block of its own
+func isBlock(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	switch n.Data {
+	case "p", "h1", "h2", "h3", "h4", "h5", "h6", "ul", "ol", "blockquote", "pre",
+		"table", "hr", "div", "section", "article", "header", "footer", "dl",
+		"ac:layout", "ac:layout-section", "ac:layout-cell", "ac:task-list",
+		"ac:rich-text-body", "ac:adf-extension":
+		return true
+	case "ac:structured-macro", "ac:macro":
+		switch macroName(n) {
+		case "status", "anchor", "jira":
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// blocks renders an element's children as Markdown blocks, collecting runs
+// of inline content into paragraphs
+func (c *storage) blocks(n *html.Node) string {
+	var parts []string
+	var inline strings.Builder
+	flush := func() {
+		if text := strings.TrimSpace(inline.String()); text != "" {
+			parts = append(parts, text)
+		}
+		inline.Reset()
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if !isBlock(child) {
+			inline.WriteString(c.inline(child))
+			continue
+		}
+		flush()
+		if block := strings.TrimSpace(c.block(child)); block != "" {
+			parts = append(parts, block)
+		}
+	}
+	flush()
+	return strings.Join(parts, "\n\n")
+}
+
+// block renders a block element
+func (c *storage) block(n *html.Node) string {
+	switch n.Data {
+	case "p":
+		return strings.TrimSpace(c.inlineChildren(n))
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		return strings.Repeat("#", int(n.Data[1]-'0')) + " " + strings.TrimSpace(c.inlineChildren(n))
+	case "ul", "ol":
+		return c.list(n, n.Data == "ol")
+	case "ac:task-list":
+		return c.tasks(n)
+	case "blockquote":
+		return quote(c.blocks(n))
+	case "pre":
+		lang := ""
+		if m := storageBrush.FindStringSubmatch(attr(n, "data-syntaxhighlighter-params")); m != nil {
+			lang = m[1]
+		}
+		return fence(textContent(n), lang)
+	case "table":
+		return c.table(n)
+	case "hr":
+		return "---"
+	case "dl":
+		return c.definitions(n)
+	case "ac:structured-macro", "ac:macro":
+		return c.macro(n)
+	}
+	return c.blocks(n)
+}
+
+// inlineChildren renders an element's children as inline Markdown
+func (c *storage) inlineChildren(n *html.Node) string {
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if isBlock(child) {
+			// Blocks inside inline content, such as a list in a table cell
+			b.WriteString(" " + strings.ReplaceAll(strings.TrimSpace(c.block(child)), "\n", "<br>") + " ")
+			continue
+		}
+		b.WriteString(c.inline(child))
+	}
+	return b.String()
+}
+
+// inline renders an inline node
+func (c *storage) inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return escapeText(collapseSpace(n.Data))
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch n.Data {
+	case "strong", "b":
+		return wrap(c.inlineChildren(n), "**")
+	case "em", "i":
+		return wrap(c.inlineChildren(n), "*")
+	case "s", "del", "strike":
+		return wrap(c.inlineChildren(n), "~~")
+	case "code", "tt":
+		return codeSpan(textContent(n))
+	case "sub", "sup":
+		return "<" + n.Data + ">" + c.inlineChildren(n) + "</" + n.Data + ">"
+	case "br":
+		return "<br>"
+	case "a":
+		return c.anchor(n)
+	case "img":
+		return c.img(n)
+	case "time":
+		return attr(n, "datetime")
+	case "ac:link":
+		return c.link(n)
+	case "ac:image":
+		return c.image(n)
+	case "ac:emoticon":
+		name := attr(n, "ac:name")
+		if emoji, ok := storageEmoticons[name]; ok {
+			return emoji
+		}
+		return ":" + name + ":"
+	case "ac:placeholder", "ac:parameter", "script", "style":
+		return ""
+	case "ac:structured-macro", "ac:macro":
+		return c.macro(n)
+	}
+	return c.inlineChildren(n)
+}
+
+// list renders a bulleted or numbered list, indenting nested content under
+// each item's marker
+func (c *storage) list(n *html.Node, ordered bool) string {
+	var items []string
+	number := 1
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.Data != "li" {
+			continue
+		}
+		marker := "- "
+		if ordered {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		items = append(items, listItem(marker, c.itemContent(li)))
+	}
+	return strings.Join(items, "\n")
+}
+
+// itemContent renders a list item, keeping nested lists tight
+func (c *storage) itemContent(li *html.Node) string {
+	content := c.blocks(li)
+	return strings.ReplaceAll(content, "\n\n- ", "\n- ")
+}
+
+// tasks renders a task list as GitHub task list items
+func (c *storage) tasks(n *html.Node) string {
+	var items []string
+	for task := n.FirstChild; task != nil; task = task.NextSibling {
+		if task.Type != html.ElementNode || task.Data != "ac:task" {
+			continue
+		}
+		marker := "- [ ] "
+		var body string
+		for child := task.FirstChild; child != nil; child = child.NextSibling {
+			switch child.Data {
+			case "ac:task-status":
+				if strings.TrimSpace(textContent(child)) == "complete" {
+					marker = "- [x] "
+				}
+			case "ac:task-body":
+				body = strings.TrimSpace(c.inlineChildren(child))
+			}
+		}
+		items = append(items, marker+body)
+	}
+	return strings.Join(items, "\n")
+}
+
+// definitions renders a definition list as bold terms followed by their
+// descriptions
+func (c *storage) definitions(n *html.Node) string {
+	var parts []string
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		switch child.Data {
+		case "dt":
+			parts = append(parts, "**"+strings.TrimSpace(c.inlineChildren(child))+"**")
+		case "dd":
+			parts = append(parts, ": "+strings.TrimSpace(c.inlineChildren(child)))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// table renders a table with its first row as the header. Cell content is
+// flattened onto one line
+func (c *storage) table(n *html.Node) string {
+	var rows [][]string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			switch child.Data {
+			case "tr":
+				var row []string
+				for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Data == "td" || cell.Data == "th" {
+						text := strings.TrimSpace(strings.ReplaceAll(c.blocks(cell), "\n\n", "<br>"))
+						text = strings.ReplaceAll(strings.ReplaceAll(text, "\n", "<br>"), "|", `\|`)
+						row = append(row, text)
+					}
+				}
+				rows = append(rows, row)
+			case "thead", "tbody", "tfoot", "colgroup":
+				walk(child)
+			}
+		}
+	}
+	walk(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	if columns == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			b.WriteString("|" + strings.Repeat(" --- |", columns) + "\n")
+		}
+	}
+	return b.String()
+}
+
+// macro converts the macros that have a Markdown equivalent and leaves a
+// placeholder for the rest
+func (c *storage) macro(n *html.Node) string {
+	name := macroName(n)
+	switch name {
+	case "code", "noformat":
+		return fence(textContent(child(n, "ac:plain-text-body")), param(n, "language"))
+
+	case "info", "tip", "note", "warning", "panel", "expand":
+		kind := storageAdmonitions[name]
+		if name == "expand" {
+			kind += "-"
+		}
+		body := c.blocks(bodyOf(n))
+		colons := strings.Repeat(":", innerFence(body)+1)
+		if len(colons) < 3 {
+			colons = ":::"
+		}
+		opening := colons + kind
+		if title := param(n, "title"); title != "" {
+			opening += " " + title
+		}
+		return opening + "\n" + body + "\n" + colons
+
+	case "excerpt", "section", "column", "details", "div", "span", "center":
+		return c.blocks(bodyOf(n))
+
+	case "status":
+		return "**[" + strings.ToUpper(param(n, "title")) + "]**"
+
+	case "children", "pagetree":
+		var items []string
+		for _, child := range c.links.children() {
+			items = append(items, fmt.Sprintf("- [%s](%s)", escapeText(child[1]), escapeLink(relativeLink(c.page, child[0]))))
+		}
+		return strings.Join(items, "\n")
+
+	case "include", "excerpt-include":
+		if ref := child(child(n, "ac:parameter"), "ac:link"); ref != nil {
+			if page := child(ref, "ri:page"); page != nil {
+				if target, ok := c.links.page(attr(page, "ri:content-title")); ok {
+					return "![[" + strings.TrimSuffix(target, ".md") + "]]"
+				}
+			}
+		}
+	}
+
+	if storageIgnored[name] {
+		return ""
+	}
+
+	c.macros[name] = true
+	placeholder := "*[" + name + " macro]*"
+	if body := bodyOf(n); body != n {
+		return placeholder + "\n\n" + c.blocks(body)
+	}
+	return placeholder
+}
+
+// link converts an <ac:link> to a page or attachment
+func (c *storage) link(n *html.Node) string {
+	label := ""
+	if body := child(n, "ac:plain-text-link-body"); body != nil {
+		label = escapeText(textContent(body))
+	} else if body := child(n, "ac:link-body"); body != nil {
+		label = c.inlineChildren(body)
+	}
+	anchor := attr(n, "ac:anchor")
+
+	target := ""
+	switch {
+	case child(n, "ri:page") != nil:
+		title := attr(child(n, "ri:page"), "ri:content-title")
+		if label == "" {
+			label = escapeText(title)
+		}
+		if p, ok := c.links.page(title); ok {
+			target = escapeLink(relativeLink(c.page, p))
+		} else if title != "" {
+			return label
+		}
+	case child(n, "ri:attachment") != nil:
+		filename := attr(child(n, "ri:attachment"), "ri:filename")
+		if label == "" {
+			label = escapeText(filename)
+		}
+		p, ok := c.links.attachment(filename)
+		if !ok {
+			return label
+		}
+		target = escapeLink(relativeLink(c.page, p))
+	case child(n, "ri:user") != nil:
+		if label == "" {
+			label = "@" + attr(child(n, "ri:user"), "ri:username")
+		}
+		return label
+	case child(n, "ri:url") != nil:
+		target = attr(child(n, "ri:url"), "ri:value")
+	}
+
+	if anchor != "" {
+		target += "#" + markdown.Slugify(anchor)
+		if label == "" {
+			label = escapeText(anchor)
+		}
+	}
+	if target == "" {
+		return label
+	}
+	return "[" + label + "](" + target + ")"
+}
+
+// image converts an <ac:image> of an attachment or URL
+func (c *storage) image(n *html.Node) string {
+	alt := attr(n, "ac:alt")
+	if a := child(n, "ri:attachment"); a != nil {
+		filename := attr(a, "ri:filename")
+		if alt == "" {
+			alt = filename
+		}
+		if p, ok := c.links.attachment(filename); ok {
+			return "![" + escapeText(alt) + "](" + escapeLink(relativeLink(c.page, p)) + ")"
+		}
+		return escapeText(alt)
+	}
+	if u := child(n, "ri:url"); u != nil {
+		return "![" + escapeText(alt) + "](" + attr(u, "ri:value") + ")"
+	}
+	return ""
+}
+
+// anchor converts an <a>, pointing links within an HTML export at the
+// imported pages and attachments
+func (c *storage) anchor(n *html.Node) string {
+	label := strings.TrimSpace(c.inlineChildren(n))
+	href := attr(n, "href")
+	if href == "" {
+		return label
+	}
+	ref, fragment, _ := strings.Cut(href, "#")
+	if p, ok := c.links.href(ref); ok {
+		href = escapeLink(relativeLink(c.page, p))
+		if fragment != "" {
+			href += "#" + fragment
+		}
+	}
+	if label == "" {
+		label = escapeText(href)
+	}
+	return "[" + label + "](" + href + ")"
+}
+
+// img converts an <img>, pointing it at the imported attachment
+func (c *storage) img(n *html.Node) string {
+	src := attr(n, "src")
+	if p, ok := c.links.href(src); ok {
+		src = escapeLink(relativeLink(c.page, p))
+	} else if strings.HasPrefix(src, "images/") {
+		// Icons from the export's own theme
+		return ""
+	}
+	return "![" + escapeText(attr(n, "alt")) + "](" + src + ")"
+}
+
+// macroName returns a macro's name
+func macroName(n *html.Node) string {
+	return strings.ToLower(attr(n, "ac:name"))
+}
+
+// param returns the value of a macro parameter
+func param(n *html.Node, name string) string {
+	for p := n.FirstChild; p != nil; p = p.NextSibling {
+		if p.Data == "ac:parameter" && attr(p, "ac:name") == name {
+			return strings.TrimSpace(textContent(p))
+		}
+	}
+	return ""
+}
+
+// bodyOf returns a macro's rich text body, or the macro itself if it has none
+func bodyOf(n *html.Node) *html.Node {
+	if body := child(n, "ac:rich-text-body"); body != nil {
+		return body
+	}
+	return n
+}
+
+// child returns the first child element with the given name
+func child(n *html.Node, name string) *html.Node {
+	if n == nil {
+		return nil
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// attr returns an attribute's value
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// textContent returns the text inside a node
+func textContent(n *html.Node) string {
+	if n == nil {
+		return ""
+	}
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "br" {
+			b.WriteString("\n")
+			continue
+		}
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+// collapseSpace collapses runs of whitespace as HTML does
+func collapseSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// escapeText escapes the characters in text that Markdown would interpret
+// as inline markup
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", "&lt;").Replace(s)
+}
+
+// wrap surrounds text with an emphasis marker, keeping the surrounding
+// spaces outside it as Markdown requires
+func wrap(text, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	start := text[:strings.Index(text, trimmed)]
+	end := text[len(start)+len(trimmed):]
+	return start + marker + trimmed + marker + end
+}
+
+// codeSpan wraps text in enough backticks to hold it
+func codeSpan(text string) string {
+	text = collapseSpace(text)
+	ticks := "`"
+	for strings.Contains(text, ticks) {
+		ticks += "`"
+	}
+	if strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") {
+		text = " " + text + " "
+	}
+	return ticks + text + ticks
+}
+
+// listItem prefixes content with a list marker, indenting its other lines
+// to line up under the marker
+func listItem(marker, content string) string {
+	lines := strings.Split(content, "\n")
+	indent := strings.Repeat(" ", len(marker))
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return marker + strings.Join(lines, "\n")
+}
+
+// quote prefixes every line with "> "
+func quote(content string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// innerFence returns the length of the longest ::: fence in content
+func innerFence(content string) int {
+	longest := 0
+	for _, line := range strings.Split(content, "\n") {
+		n := len(line) - len(strings.TrimLeft(line, ":"))
+		if n >= 3 && n > longest {
+			longest = n
+		}
+	}
+	return longest
+}
+
+// attachmentName returns the name an attachment is stored under in the wiki
+func attachmentName(filename string) string {
+	return normalizePath(path.Base(filename), false)
+}
@@ -0,0 +1,478 @@
+package importer
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/timhughes/fishki/internal/markdown"
+)
+
+// wikitext converts MediaWiki markup to Markdown. Only the common constructs
+// are converted: headings, emphasis, lists, links, images, tables,
+// preformatted text and references. Templates can't be expanded without the
+// wiki that defines them, so they are left as visible placeholders
+type wikitext struct {
+	page  string                    // path of the page being converted, in the wiki
+	title func(title string) string // path in the wiki for a page title
+	files string                    // folder in the wiki that uploaded files belong in
+
+	protected  []string // text hidden from conversion, such as <nowiki> and <pre>
+	templates  map[string]bool
+	categories []string
+	refs       []string
+	refNames   map[string]int
+}
+
+var (
+	wtHeading     = regexp.MustCompile(`^(={1,6})\s*(.+?)\s*(={1,6})\s*$`)
+	wtList        = regexp.MustCompile(`^([*#:;]+)\s*(.*)$`)
+	wtRule        = regexp.MustCompile(`^-{4,}\s*$`)
+	wtRedirect    = regexp.MustCompile(`(?i)^#redirect\s*:?\s*\[\[([^\]]+)\]\]`)
+	wtInternal    = regexp.MustCompile(`\[\[([^\[\]]+?)\]\](\p{L}*)`)
+	wtExternal    = regexp.MustCompile(`\[((?:https?|ftp|mailto):[^\s\]]+)(?:\s+([^\]]+))?\]`)
+	wtBoldItalic  = regexp.MustCompile(`'''''(.+?)'''''`)
+	wtBold        = regexp.MustCompile(`'''(.+?)'''`)
+	wtItalic      = regexp.MustCompile(`''(.+?)''`)
+	wtBehaviour   = regexp.MustCompile(`__[A-Z]+__`)
+	wtNowiki      = regexp.MustCompile(`(?s)<nowiki>(.*?)</nowiki>`)
+	wtPre         = regexp.MustCompile(`(?s)<pre[^>]*>(.*?)</pre>`)
+	wtSource      = regexp.MustCompile(`(?s)<(?:syntaxhighlight|source)(?:\s+[^>]*?lang="?([\w+-]+)"?[^>]*)?>(.*?)</(?:syntaxhighlight|source)>`)
+	wtRef         = regexp.MustCompile(`(?s)<ref(\s[^>]*?)?(?:/>|>(.*?)</ref>)`)
+	wtRefName     = regexp.MustCompile(`name\s*=\s*"?([^">/]+?)"?\s*$`)
+	wtReferences  = regexp.MustCompile(`(?i)<references\s*/>|<references>.*?</references>`)
+	wtImageOption = regexp.MustCompile(`^(?:thumb|thumbnail|frame|framed|frameless|border|left|right|center|centre|none|upright(?:=.*)?|baseline|middle|sub|super|top|text-top|bottom|text-bottom|\d*x?\d+px|link=.*|page=.*|class=.*|lang=.*)$`)
+	wtProtected   = regexp.MustCompile("\x00(\\d+)\x00")
+)
+
+// convertWikitext converts a page's wikitext to Markdown, returning the
+// names of the templates that were left as placeholders
+func convertWikitext(text, page string, title func(string) string, files string) (string, []string) {
+	c := &wikitext{
+		page:      page,
+		title:     title,
+		files:     files,
+		templates: make(map[string]bool),
+		refNames:  make(map[string]int),
+	}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = c.protect(text)
+	text = c.extractRefs(text)
+	text = c.replaceTemplates(text)
+	text = wtBehaviour.ReplaceAllString(text, "")
+	text = wtReferences.ReplaceAllString(text, "")
+
+	out := c.blocks(text)
+
+	if len(c.categories) > 0 {
+		out = strings.TrimRight(out, "\n") + "\n\n*Categories: " + strings.Join(c.categories, ", ") + "*\n"
+	}
+	if len(c.refs) > 0 {
+		out = strings.TrimRight(out, "\n") + "\n\n"
+		for i, ref := range c.refs {
+			out += fmt.Sprintf("[^%d]: %s\n", i+1, c.inline(c.replaceTemplates(ref)))
+		}
+	}
+
+	out = wtProtected.ReplaceAllStringFunc(out, func(m string) string {
+		var i int
+		fmt.Sscanf(strings.Trim(m, "\x00"), "%d", &i)
+		return c.protected[i]
+	})
+
+	var templates []string
+	for name := range c.templates {
+		templates = append(templates, name)
+	}
+	sort.Strings(templates)
+	out = strings.Trim(out, "\n")
+	if out != "" {
+		out += "\n"
+	}
+	return out, templates
+}
+
+// hide stores text that must come through conversion untouched
+func (c *wikitext) hide(s string) string {
+	c.protected = append(c.protected, s)
+	return fmt.Sprintf("\x00%d\x00", len(c.protected)-1)
+}
+
+// protect hides <nowiki>, <pre> and source blocks from the rest of the conversion
+func (c *wikitext) protect(text string) string {
+	text = wtSource.ReplaceAllStringFunc(text, func(m string) string {
+		parts := wtSource.FindStringSubmatch(m)
+		return "\n" + c.hide(fence(parts[2], parts[1])) + "\n"
+	})
+	text = wtPre.ReplaceAllStringFunc(text, func(m string) string {
+		return "\n" + c.hide(fence(unescapeEntities(wtPre.FindStringSubmatch(m)[1]), "")) + "\n"
+	})
+	return wtNowiki.ReplaceAllStringFunc(text, func(m string) string {
+		return c.hide(escapeMarkdown(unescapeEntities(wtNowiki.FindStringSubmatch(m)[1])))
+	})
+}
+
+// extractRefs replaces <ref> tags with footnote references, collecting their text
+func (c *wikitext) extractRefs(text string) string {
+	return wtRef.ReplaceAllStringFunc(text, func(m string) string {
+		parts := wtRef.FindStringSubmatch(m)
+		name := ""
+		if n := wtRefName.FindStringSubmatch(strings.TrimSpace(parts[1])); n != nil {
+			name = n[1]
+		}
+		if n, ok := c.refNames[name]; ok && name != "" {
+			if c.refs[n-1] == "" {
+				c.refs[n-1] = strings.TrimSpace(parts[2])
+			}
+			return fmt.Sprintf("[^%d]", n)
+		}
+		c.refs = append(c.refs, strings.TrimSpace(parts[2]))
+		if name != "" {
+			c.refNames[name] = len(c.refs)
+		}
+		return fmt.Sprintf("[^%d]", len(c.refs))
+	})
+}
+
+// replaceTemplates replaces {{templates}}, which may nest and span lines,
+// with placeholders showing the template's name
+func (c *wikitext) replaceTemplates(text string) string {
+	var out strings.Builder
+	for {
+		start := strings.Index(text, "{{")
+		if start < 0 {
+			out.WriteString(text)
+			return out.String()
+		}
+		out.WriteString(text[:start])
+
+		depth, end := 0, -1
+		for i := start; i < len(text)-1; i++ {
+			if text[i] == '{' && text[i+1] == '{' {
+				depth++
+				i++
+			} else if text[i] == '}' && text[i+1] == '}' {
+				depth--
+				i++
+				if depth == 0 {
+					end = i + 1
+					break
+				}
+			}
+		}
+		if end < 0 {
+			out.WriteString(text[start:])
+			return out.String()
+		}
+
+		inner := strings.Trim(text[start+2:end-2], "{}")
+		name, _, _ := strings.Cut(inner, "|")
+		name = strings.TrimSpace(strings.SplitN(name, "\n", 2)[0])
+		switch {
+		case inner == "!":
+			out.WriteString("|")
+		case name != "":
+			c.templates[name] = true
+			out.WriteString(c.hide("`{{" + name + "}}`"))
+		}
+		text = text[end:]
+	}
+}
+
+// blocks converts the block structure line by line
+func (c *wikitext) blocks(text string) string {
+	var out strings.Builder
+	lines := strings.Split(text, "\n")
+	inList := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		if inList && trimmed != "" && !wtList.MatchString(line) {
+			out.WriteString("\n")
+		}
+		inList = false
+
+		heading := wtHeading.FindStringSubmatch(trimmed)
+		switch {
+		case strings.HasPrefix(trimmed, "{|"):
+			// Tables run to the matching |}, allowing for nested tables
+			depth, j := 0, i
+			for ; j < len(lines); j++ {
+				t := strings.TrimSpace(lines[j])
+				if strings.HasPrefix(t, "{|") {
+					depth++
+				} else if strings.HasPrefix(t, "|}") {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			if j == len(lines) {
+				j--
+			}
+			out.WriteString("\n" + c.table(lines[i:j+1]) + "\n")
+			i = j
+
+		case wtRedirect.MatchString(trimmed):
+			out.WriteString("Redirected to " + c.internalLink(wtRedirect.FindStringSubmatch(trimmed)[1], "") + "\n")
+
+		case heading != nil && len(heading[1]) == len(heading[3]):
+			out.WriteString(strings.Repeat("#", len(heading[1])) + " " + c.inline(heading[2]) + "\n")
+
+		case wtRule.MatchString(line):
+			out.WriteString("\n---\n")
+
+		case wtList.MatchString(line):
+			out.WriteString(c.listItem(line))
+			inList = true
+
+		case strings.HasPrefix(line, " ") && trimmed != "":
+			// Lines starting with a space are preformatted
+			var pre []string
+			for ; i < len(lines) && strings.HasPrefix(lines[i], " ") && strings.TrimSpace(lines[i]) != ""; i++ {
+				pre = append(pre, lines[i][1:])
+			}
+			i--
+			out.WriteString(fence(strings.Join(pre, "\n"), "") + "\n")
+
+		default:
+			out.WriteString(c.inline(line) + "\n")
+		}
+	}
+	return out.String()
+}
+
+// listItem converts a *, #, : or ; line. Nesting is shown by indenting each
+// level by the width of its parent's marker
+func (c *wikitext) listItem(line string) string {
+	parts := wtList.FindStringSubmatch(line)
+	markers, text := parts[1], parts[2]
+
+	// ;term : definition
+	if strings.HasSuffix(markers, ";") {
+		term, def, found := strings.Cut(text, " : ")
+		out := "**" + c.inline(strings.TrimSpace(term)) + "**\n"
+		if found {
+			out += ": " + c.inline(strings.TrimSpace(def)) + "\n"
+		}
+		return out
+	}
+
+	// Indented text outside a list is usually a threaded reply
+	if strings.Trim(markers, ":") == "" {
+		return strings.Repeat("> ", len(markers)) + c.inline(text) + "\n"
+	}
+
+	var indent strings.Builder
+	for _, m := range markers[:len(markers)-1] {
+		if m == '#' {
+			indent.WriteString("   ")
+		} else {
+			indent.WriteString("  ")
+		}
+	}
+	switch markers[len(markers)-1] {
+	case '*':
+		return indent.String() + "- " + c.inline(text) + "\n"
+	case '#':
+		return indent.String() + "1. " + c.inline(text) + "\n"
+	}
+	return indent.String() + "  " + c.inline(text) + "\n"
+}
+
+// table converts a {| ... |} table to a Markdown table. The first row is the
+// header; cell attributes are dropped
+func (c *wikitext) table(lines []string) string {
+	var caption string
+	var rows [][]string
+	var row []string
+	for _, line := range lines[1:] {
+		t := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(t, "|}"):
+		case strings.HasPrefix(t, "|-"):
+			if len(row) > 0 {
+				rows = append(rows, row)
+			}
+			row = nil
+		case strings.HasPrefix(t, "|+"):
+			caption = c.inline(cellText(t[2:]))
+		case strings.HasPrefix(t, "!"):
+			for _, cell := range splitCells(t[1:], "!!") {
+				row = append(row, c.cell(cell))
+			}
+		case strings.HasPrefix(t, "|"):
+			for _, cell := range splitCells(t[1:], "||") {
+				row = append(row, c.cell(cell))
+			}
+		case len(row) > 0 && t != "":
+			// Continuation of the previous cell
+			row[len(row)-1] = strings.TrimPrefix(row[len(row)-1]+"<br>"+c.cell(t), "<br>")
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return caption
+	}
+
+	columns := 0
+	for _, r := range rows {
+		if len(r) > columns {
+			columns = len(r)
+		}
+	}
+
+	var out strings.Builder
+	if caption != "" {
+		out.WriteString("**" + caption + "**\n\n")
+	}
+	for i, r := range rows {
+		for len(r) < columns {
+			r = append(r, "")
+		}
+		out.WriteString("| " + strings.Join(r, " | ") + " |\n")
+		if i == 0 {
+			out.WriteString("|" + strings.Repeat(" --- |", columns) + "\n")
+		}
+	}
+	return out.String()
+}
+
+// splitCells splits a table line into cells, also splitting header lines
+// on || as MediaWiki does
+func splitCells(line, sep string) []string {
+	cells := strings.Split(line, sep)
+	if sep == "!!" {
+		var all []string
+		for _, cell := range cells {
+			all = append(all, strings.Split(cell, "||")...)
+		}
+		cells = all
+	}
+	return cells
+}
+
+// cellText drops the attributes in front of a cell's content, as in
+// |style="color: red"| text
+func cellText(cell string) string {
+	attrs, content, found := strings.Cut(cell, "|")
+	if found && strings.Contains(attrs, "=") && !strings.Contains(attrs, "[[") && !strings.Contains(attrs, "\x00") {
+		return strings.TrimSpace(content)
+	}
+	return strings.TrimSpace(cell)
+}
+
+// cell converts a table cell's content for use in a Markdown table
+func (c *wikitext) cell(cell string) string {
+	return strings.ReplaceAll(c.inline(cellText(cell)), "|", `\|`)
+}
+
+// inline converts links and emphasis within a line
+func (c *wikitext) inline(text string) string {
+	text = wtInternal.ReplaceAllStringFunc(text, func(m string) string {
+		parts := wtInternal.FindStringSubmatch(m)
+		return c.internalLink(parts[1], parts[2])
+	})
+	text = wtExternal.ReplaceAllStringFunc(text, func(m string) string {
+		parts := wtExternal.FindStringSubmatch(m)
+		if parts[2] == "" {
+			return "<" + parts[1] + ">"
+		}
+		return "[" + parts[2] + "](" + parts[1] + ")"
+	})
+	text = wtBoldItalic.ReplaceAllString(text, "***$1***")
+	text = wtBold.ReplaceAllString(text, "**$1**")
+	return wtItalic.ReplaceAllString(text, "*$1*")
+}
+
+// internalLink converts [[target#section|label]]trail, along with
+// categories, files and images
+func (c *wikitext) internalLink(inner, trail string) string {
+	target, label, hasLabel := strings.Cut(inner, "|")
+	target = strings.TrimSpace(target)
+	leadingColon := strings.HasPrefix(target, ":")
+	target = strings.TrimPrefix(target, ":")
+
+	namespace, name, _ := strings.Cut(target, ":")
+	switch strings.ToLower(strings.TrimSpace(namespace)) {
+	case "category":
+		if !leadingColon {
+			c.categories = append(c.categories, strings.TrimSpace(name))
+			return ""
+		}
+		if !hasLabel {
+			label = target
+		}
+		return label + trail
+	case "file", "image", "media":
+		if !leadingColon {
+			return c.image(strings.TrimSpace(name), label) + trail
+		}
+	}
+
+	if !hasLabel || strings.TrimSpace(label) == "" {
+		label = target
+	}
+	label = strings.TrimSpace(label) + trail
+
+	page, section, _ := strings.Cut(target, "#")
+	if strings.TrimSpace(page) == "" {
+		return fmt.Sprintf("[%s](#%s)", label, markdown.Slugify(section))
+	}
+	link := escapeLink(relativeLink(c.page, c.title(page)))
+	if section != "" {
+		link += "#" + markdown.Slugify(section)
+	}
+	return fmt.Sprintf("[%s](%s)", label, link)
+}
+
+// image converts a [[File:...]] link to an image in the uploaded files folder
+func (c *wikitext) image(name, options string) string {
+	alt := name
+	for _, option := range strings.Split(options, "|") {
+		option = strings.TrimSpace(option)
+		switch {
+		case option == "" || wtImageOption.MatchString(option):
+		case strings.HasPrefix(option, "alt="):
+			alt = strings.TrimPrefix(option, "alt=")
+		default:
+			alt = c.inline(option)
+		}
+	}
+	file := path.Join(c.files, normalizePath(name, false))
+	return fmt.Sprintf("![%s](%s)", alt, escapeLink(relativeLink(c.page, file)))
+}
+
+// fence wraps text in a fenced code block, using a longer fence if the text
+// contains one
+func fence(text, lang string) string {
+	marker := "```"
+	for strings.Contains(text, marker) {
+		marker += "`"
+	}
+	return marker + lang + "\n" + strings.Trim(text, "\n") + "\n" + marker
+}
+
+// escapeMarkdown escapes the characters that Markdown would interpret
+func escapeMarkdown(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune("\\`*_{}[]()<>#+-!|~", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// unescapeEntities decodes the few HTML entities common in wikitext source
+func unescapeEntities(text string) string {
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", `"`, "&amp;", "&").Replace(text)
+}
@@ -0,0 +1,293 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Admonitions are callout boxes written either as GitHub alerts:
+//
+//	> [!WARNING] Optional title
+//	> Body text
+//
+// or as Docusaurus containers, which may hold any other blocks:
+//
+//	:::tip Optional title
+//	Body text
+//	:::
+//
+// A + or - straight after the type ("[!NOTE]-", ":::tip+") makes the callout
+// collapsible, expanded or collapsed by default. Outer containers that hold
+// another ::: container need a longer fence, e.g. "::::note".
+
+// DefaultAdmonitions maps the built-in callout types to their default titles
+var DefaultAdmonitions = map[string]string{
+	"note":      "Note",
+	"tip":       "Tip",
+	"info":      "Info",
+	"important": "Important",
+	"warning":   "Warning",
+	"caution":   "Caution",
+	"danger":    "Danger",
+}
+
+// KindAdmonition is the node kind of callouts
+var KindAdmonition = ast.NewNodeKind("Admonition")
+
+// Admonition is a callout box holding other blocks
+type Admonition struct {
+	ast.BaseBlock
+	AdmonitionType string
+	Title          string
+	Collapsible    bool
+	Open           bool
+
+	fenceLength int
+}
+
+// Kind implements ast.Node
+func (n *Admonition) Kind() ast.NodeKind {
+	return KindAdmonition
+}
+
+// Dump implements ast.Node
+func (n *Admonition) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Type":  n.AdmonitionType,
+		"Title": n.Title,
+	}, nil)
+}
+
+// admonitionTypes resolves callout types, built-in and custom, to titles
+type admonitionTypes map[string]string
+
+func newAdmonitionTypes(custom map[string]string) admonitionTypes {
+	types := make(admonitionTypes, len(DefaultAdmonitions)+len(custom))
+	for name, title := range DefaultAdmonitions {
+		types[name] = title
+	}
+	for name, title := range custom {
+		types[strings.ToLower(name)] = title
+	}
+	return types
+}
+
+// newAdmonition creates a callout for a marker's type, fold flag and title,
+// or returns nil if the type isn't known
+func (t admonitionTypes) newAdmonition(kind, fold, title string) *Admonition {
+	kind = strings.ToLower(kind)
+	defaultTitle, ok := t[kind]
+	if !ok {
+		return nil
+	}
+	if title = strings.TrimSpace(title); title == "" {
+		title = defaultTitle
+	}
+	return &Admonition{
+		AdmonitionType: kind,
+		Title:          title,
+		Collapsible:    fold != "",
+		Open:           fold == "+",
+	}
+}
+
+// alertMarker matches the first line of a GitHub alert blockquote
+var alertMarker = regexp.MustCompile(`^\s*\[!([A-Za-z][\w-]*)\]([+-]?)(.*?)\s*$`)
+
+// alertTransformer turns blockquotes that start with [!TYPE] into callouts
+type alertTransformer struct {
+	types admonitionTypes
+}
+
+func (a *alertTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	var quotes []*ast.Blockquote
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if quote, ok := node.(*ast.Blockquote); ok && entering {
+			quotes = append(quotes, quote)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, quote := range quotes {
+		para, ok := quote.FirstChild().(*ast.Paragraph)
+		if !ok || para.Lines().Len() == 0 {
+			continue
+		}
+		firstLine := para.Lines().At(0)
+		match := alertMarker.FindSubmatch(firstLine.Value(source))
+		if match == nil {
+			continue
+		}
+		admonition := a.types.newAdmonition(string(match[1]), string(match[2]), string(match[3]))
+		if admonition == nil {
+			continue
+		}
+
+		// Drop the marker line from the paragraph, and the paragraph if
+		// that was all it held
+		for child := para.FirstChild(); child != nil; {
+			if start := inlineStart(child); start < 0 || start >= firstLine.Stop {
+				break
+			}
+			next := child.NextSibling()
+			para.RemoveChild(para, child)
+			child = next
+		}
+		if para.HasChildren() {
+			lines := text.NewSegments()
+			lines.AppendAll(para.Lines().Sliced(1, para.Lines().Len()))
+			para.SetLines(lines)
+		} else {
+			quote.RemoveChild(quote, para)
+		}
+
+		for child := quote.FirstChild(); child != nil; {
+			next := child.NextSibling()
+			admonition.AppendChild(admonition, child)
+			child = next
+		}
+		quote.Parent().ReplaceChild(quote.Parent(), quote, admonition)
+	}
+}
+
+// inlineStart returns where an inline node starts in the source, or -1 if
+// it holds no text
+func inlineStart(node ast.Node) int {
+	start := -1
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if t, ok := n.(*ast.Text); ok && entering {
+			start = t.Segment.Start
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return start
+}
+
+// containerMarker matches the opening line of a ::: container after the colons
+var containerMarker = regexp.MustCompile(`^([A-Za-z][\w-]*)([+-]?)(.*?)\s*$`)
+
+// containerParser parses :::type ... ::: callouts
+type containerParser struct {
+	types admonitionTypes
+}
+
+func (p *containerParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+func (p *containerParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, _ := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || line[pos] != ':' {
+		return nil, parser.NoChildren
+	}
+	i := pos
+	for ; i < len(line) && line[i] == ':'; i++ {
+	}
+	if i-pos < 3 {
+		return nil, parser.NoChildren
+	}
+
+	match := containerMarker.FindSubmatch(util.TrimLeftSpace(line[i:]))
+	if match == nil {
+		return nil, parser.NoChildren
+	}
+	admonition := p.types.newAdmonition(string(match[1]), string(match[2]), string(match[3]))
+	if admonition == nil {
+		return nil, parser.NoChildren
+	}
+	admonition.fenceLength = i - pos
+
+	reader.AdvanceToEOL()
+	return admonition, parser.HasChildren
+}
+
+func (p *containerParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	line, _ := reader.PeekLine()
+	w, pos := util.IndentWidth(line, reader.LineOffset())
+	if w < 4 {
+		i := pos
+		for ; i < len(line) && line[i] == ':'; i++ {
+		}
+		if i-pos >= node.(*Admonition).fenceLength && util.IsBlank(line[i:]) {
+			reader.AdvanceToEOL()
+			return parser.Close
+		}
+	}
+	return parser.Continue | parser.HasChildren
+}
+
+func (p *containerParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (p *containerParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (p *containerParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// admonitionRenderer writes callouts as <aside>, or <details> when collapsible
+type admonitionRenderer struct{}
+
+func (r *admonitionRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindAdmonition, r.renderAdmonition)
+}
+
+func (r *admonitionRenderer) renderAdmonition(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*Admonition)
+	kind := util.EscapeHTML([]byte(n.AdmonitionType))
+	title := util.EscapeHTML([]byte(n.Title))
+
+	if !entering {
+		if n.Collapsible {
+			w.WriteString("</details>\n")
+		} else {
+			w.WriteString("</aside>\n")
+		}
+		return ast.WalkContinue, nil
+	}
+
+	if n.Collapsible {
+		w.WriteString(`<details class="admonition admonition-`)
+		w.Write(kind)
+		w.WriteString(`"`)
+		if n.Open {
+			w.WriteString(" open")
+		}
+		w.WriteString(">\n<summary class=\"admonition-title\">")
+		w.Write(title)
+		w.WriteString("</summary>\n")
+		return ast.WalkContinue, nil
+	}
+
+	w.WriteString(`<aside class="admonition admonition-`)
+	w.Write(kind)
+	w.WriteString("\">\n<p class=\"admonition-title\">")
+	w.Write(title)
+	w.WriteString("</p>\n")
+	return ast.WalkContinue, nil
+}
+
+// admonitionExtension adds GitHub alerts and ::: containers to goldmark
+type admonitionExtension struct {
+	types admonitionTypes
+}
+
+func (e *admonitionExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(&containerParser{types: e.types}, 150)),
+		parser.WithASTTransformers(util.Prioritized(&alertTransformer{types: e.types}, 150)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(&admonitionRenderer{}, 150)))
+}
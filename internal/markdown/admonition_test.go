@@ -0,0 +1,93 @@
+package markdown
+
+import "testing"
+
+func TestRenderAdmonitions(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{
+			name:     "GitHub alert",
+			markdown: "> [!NOTE]\n> Hello *world*\n> more",
+			want:     "<aside class=\"admonition admonition-note\">\n<p class=\"admonition-title\">Note</p>\n<p>Hello <em>world</em>\nmore</p>\n</aside>\n",
+		},
+		{
+			name:     "Alert with title only",
+			markdown: "> [!TIP]",
+			want:     "<aside class=\"admonition admonition-tip\">\n<p class=\"admonition-title\">Tip</p>\n</aside>\n",
+		},
+		{
+			name:     "Collapsed alert with title",
+			markdown: "> [!warning]- Careful now\n> body",
+			want:     "<details class=\"admonition admonition-warning\">\n<summary class=\"admonition-title\">Careful now</summary>\n<p>body</p>\n</details>\n",
+		},
+		{
+			name:     "Unknown alert type stays a blockquote",
+			markdown: "> [!UNKNOWN]\n> x",
+			want:     "<blockquote>\n<p>[!UNKNOWN]\nx</p>\n</blockquote>\n",
+		},
+		{
+			name:     "Alert in list",
+			markdown: "- item\n\n  > [!TIP] Nested\n  > inside list",
+			want:     "<ul>\n<li>\n<p>item</p>\n<aside class=\"admonition admonition-tip\">\n<p class=\"admonition-title\">Nested</p>\n<p>inside list</p>\n</aside>\n</li>\n</ul>\n",
+		},
+		{
+			name:     "Container",
+			markdown: ":::tip\nBody **bold**\n:::",
+			want:     "<aside class=\"admonition admonition-tip\">\n<p class=\"admonition-title\">Tip</p>\n<p>Body <strong>bold</strong></p>\n</aside>\n",
+		},
+		{
+			name:     "Nested expanded container",
+			markdown: "::::note Outer\n:::warning+\ninner\n:::\nafter\n::::",
+			want:     "<aside class=\"admonition admonition-note\">\n<p class=\"admonition-title\">Outer</p>\n<details class=\"admonition admonition-warning\" open=\"\">\n<summary class=\"admonition-title\">Warning</summary>\n<p>inner</p>\n</details>\n<p>after</p>\n</aside>\n",
+		},
+		{
+			name:     "Container in list",
+			markdown: "- item\n  :::danger\n  in list\n  :::\n- two",
+			want:     "<ul>\n<li>item\n<aside class=\"admonition admonition-danger\">\n<p class=\"admonition-title\">Danger</p>\n<p>in list</p>\n</aside>\n</li>\n<li>two</li>\n</ul>\n",
+		},
+		{
+			name:     "Container interrupts paragraph",
+			markdown: "para\n:::info\nx\n:::",
+			want:     "<p>para</p>\n<aside class=\"admonition admonition-info\">\n<p class=\"admonition-title\">Info</p>\n<p>x</p>\n</aside>\n",
+		},
+		{
+			name:     "Unknown container type stays text",
+			markdown: ":::bogus\nx\n:::",
+			want:     "<p>:::bogus\nx\n:::</p>\n",
+		},
+		{
+			name:     "Title is escaped",
+			markdown: ":::note <b onclick=x>Hi</b>\nx\n:::",
+			want:     "<aside class=\"admonition admonition-note\">\n<p class=\"admonition-title\">&lt;b onclick=x&gt;Hi&lt;/b&gt;</p>\n<p>x</p>\n</aside>\n",
+		},
+	}
+
+	renderer := New(Options{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(renderer.renderHTML([]byte(tt.markdown)))
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderCustomAdmonitions(t *testing.T) {
+	renderer := New(Options{Admonitions: map[string]string{"Example": "Example"}})
+
+	got := string(renderer.renderHTML([]byte(":::example\nx\n:::")))
+	want := "<aside class=\"admonition admonition-example\">\n<p class=\"admonition-title\">Example</p>\n<p>x</p>\n</aside>\n"
+	if got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	got = string(renderer.renderHTML([]byte("> [!EXAMPLE] Try it")))
+	want = "<aside class=\"admonition admonition-example\">\n<p class=\"admonition-title\">Try it</p>\n</aside>\n"
+	if got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,64 @@
+package markdown
+
+import (
+	"strings"
+	"sync"
+)
+
+// BlockRenderer renders the body of a fenced code block as HTML. info is the
+// full info string of the fence, e.g. "dot" or "mermaid title=Flow"
+type BlockRenderer interface {
+	RenderBlock(info string, source []byte) ([]byte, error)
+}
+
+// BlockRendererFunc adapts an ordinary function to a BlockRenderer
+type BlockRendererFunc func(info string, source []byte) ([]byte, error)
+
+// RenderBlock calls f(info, source)
+func (f BlockRendererFunc) RenderBlock(info string, source []byte) ([]byte, error) {
+	return f(info, source)
+}
+
+// BlockRegistry maps fenced code block languages to the renderers that
+// replace the usual highlighted code for them
+type BlockRegistry struct {
+	mu        sync.RWMutex
+	renderers map[string]BlockRenderer
+}
+
+// NewBlockRegistry creates an empty registry
+func NewBlockRegistry() *BlockRegistry {
+	return &BlockRegistry{renderers: make(map[string]BlockRenderer)}
+}
+
+// Register sets the renderer for a language, replacing any existing one
+func (r *BlockRegistry) Register(lang string, renderer BlockRenderer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.renderers[strings.ToLower(lang)] = renderer
+}
+
+// Lookup returns the renderer for a language, if one is registered
+func (r *BlockRegistry) Lookup(lang string) (BlockRenderer, bool) {
+	if r == nil {
+		return nil, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	renderer, ok := r.renderers[strings.ToLower(lang)]
+	return renderer, ok
+}
+
+// Languages returns the registered languages
+func (r *BlockRegistry) Languages() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	langs := make([]string, 0, len(r.renderers))
+	for lang := range r.renderers {
+		langs = append(langs, lang)
+	}
+	return langs
+}
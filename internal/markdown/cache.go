@@ -0,0 +1,129 @@
+package markdown
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+)
+
+// DefaultCacheSize is the size of the render cache when none is configured
+const DefaultCacheSize = 32 << 20 // 32 MiB
+
+// CacheStats reports how well a Cache is doing
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	MaxBytes  int64  `json:"maxBytes"`
+}
+
+// Cache is a least-recently-used cache of rendered output, bounded by the
+// total size of what it holds. It is safe for concurrent use and may be
+// shared by renderers with different options, since their keys differ
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	entries  map[string]*list.Element
+	stats    CacheStats
+}
+
+// cacheEntry is what each element of Cache.order holds
+type cacheEntry struct {
+	key   string
+	value any
+	size  int64
+}
+
+// NewCache creates a cache holding up to maxBytes of rendered output
+func NewCache(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Stats returns the cache's hit and miss counts and current size
+func (c *Cache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	stats.Bytes = c.size
+	stats.MaxBytes = c.maxBytes
+	return stats
+}
+
+// Purge empties the cache, keeping its statistics
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.size = 0
+}
+
+// get returns the value for key and marks it as recently used
+func (c *Cache) get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).value, true
+}
+
+// put stores value under key, evicting the least recently used entries until
+// it fits. Values larger than the whole cache aren't stored
+func (c *Cache) put(key string, value any, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if size > c.maxBytes {
+		return
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		c.size += size - entry.size
+		entry.value, entry.size = value, size
+		c.order.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value, size: size})
+		c.size += size
+	}
+
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*cacheEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.size -= entry.size
+		c.stats.Evictions++
+	}
+}
+
+// hashKey hashes the parts of a cache key. Each part is length-prefixed so
+// that ("ab", "c") and ("a", "bc") don't collide
+func hashKey(parts ...[]byte) string {
+	h := sha256.New()
+	var length [8]byte
+	for _, part := range parts {
+		binary.BigEndian.PutUint64(length[:], uint64(len(part)))
+		h.Write(length[:])
+		h.Write(part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
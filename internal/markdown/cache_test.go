@@ -0,0 +1,118 @@
+package markdown
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewCache(30)
+	cache.put("a", "a", 10)
+	cache.put("b", "b", 10)
+	cache.put("c", "c", 10)
+
+	// Touch a so b is now the oldest
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	cache.put("d", "d", 10)
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := cache.get(key); !ok {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+
+	// Too big to ever fit
+	cache.put("huge", "huge", 31)
+	if _, ok := cache.get("huge"); ok {
+		t.Error("expected oversized entry to be skipped")
+	}
+
+	stats := cache.Stats()
+	want := CacheStats{Hits: 4, Misses: 2, Evictions: 1, Entries: 3, Bytes: 30, MaxBytes: 30}
+	if stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+
+	cache.Purge()
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Bytes != 0 || stats.Hits != 4 {
+		t.Errorf("unexpected stats after purge: %+v", stats)
+	}
+}
+
+func TestCacheReplacesEntries(t *testing.T) {
+	cache := NewCache(100)
+	cache.put("a", "old", 60)
+	cache.put("a", "new", 20)
+
+	if value, _ := cache.get("a"); value != "new" {
+		t.Errorf("get() = %v, want new", value)
+	}
+	if stats := cache.Stats(); stats.Entries != 1 || stats.Bytes != 20 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestRendererUsesCache(t *testing.T) {
+	cache := NewCache(DefaultCacheSize)
+	renderer := New(Options{Cache: cache})
+	plain := New(Options{Cache: cache, DisableHighlighting: true})
+
+	src := []byte("# Title\n\n```go\nfunc main() {}\n```\n")
+	first := renderer.Render(src)
+	second := renderer.Render(src)
+	if string(first) != string(second) {
+		t.Errorf("cached render differs: %q vs %q", first, second)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("unexpected stats after repeat render: %+v", stats)
+	}
+
+	// Different options must not share cached output
+	if got := plain.Render(src); string(got) == string(first) {
+		t.Error("renderer with different options got cached output")
+	}
+	if stats := cache.Stats(); stats.Misses != 2 {
+		t.Errorf("expected a miss for different options, got %+v", stats)
+	}
+
+	// Callers may change cached pages without affecting the cache
+	page := renderer.RenderPage([]byte("No heading"))
+	page.Title = "changed"
+	if again := renderer.RenderPage([]byte("No heading")); again.Title != "" {
+		t.Errorf("cached page was modified: %+v", again)
+	}
+	if stats := cache.Stats(); stats.Hits != 2 {
+		t.Errorf("expected a page cache hit, got %+v", stats)
+	}
+}
+
+func TestCacheConcurrentRenders(t *testing.T) {
+	cache := NewCache(4096)
+	renderer := New(Options{Cache: cache})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				src := fmt.Sprintf("# Page %d\n\n`code` and *text*", j%10)
+				want := fmt.Sprintf("<h1>Page %d</h1>", j%10)
+				if got := string(renderer.Render([]byte(src))); got[:len(want)] != want {
+					t.Errorf("unexpected render %q", got)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if stats := cache.Stats(); stats.Hits+stats.Misses != 400 || stats.Bytes > stats.MaxBytes {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
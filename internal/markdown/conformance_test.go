@@ -0,0 +1,37 @@
+package markdown
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// specExample is one example from the CommonMark spec test suite
+type specExample struct {
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+	Example  int    `json:"example"`
+	Section  string `json:"section"`
+}
+
+func TestCommonMarkSpec(t *testing.T) {
+	data, err := os.ReadFile("testdata/commonmark_spec.json")
+	if err != nil {
+		t.Fatalf("Failed to read spec examples: %v", err)
+	}
+
+	var examples []specExample
+	if err := json.Unmarshal(data, &examples); err != nil {
+		t.Fatalf("Failed to parse spec examples: %v", err)
+	}
+
+	renderer := New(Options{StrictCommonMark: true, DisableHighlighting: true})
+	for _, ex := range examples {
+		t.Run(ex.Section, func(t *testing.T) {
+			got := string(renderer.convert([]byte(ex.Markdown)))
+			if got != ex.HTML {
+				t.Errorf("example %d:\nmarkdown: %q\ngot:  %q\nwant: %q", ex.Example, ex.Markdown, got, ex.HTML)
+			}
+		})
+	}
+}
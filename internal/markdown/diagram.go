@@ -0,0 +1,179 @@
+package markdown
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultDiagramTimeout bounds how long a diagram command may run
+	defaultDiagramTimeout = 10 * time.Second
+
+	// diagramCacheSize bounds the memory used by rendered diagrams
+	diagramCacheSize = 8 << 20 // 8 MiB
+)
+
+// CommandRenderer renders diagram blocks to inline SVG by running a local
+// command such as `dot -Tsvg` or `plantuml -tsvg -pipe`. The block is written
+// to the command's stdin and SVG is read from its stdout. Tools that only
+// work with files can use the {input} and {output} placeholders instead, e.g.
+// `mmdc -i {input} -o {output}`
+type CommandRenderer struct {
+	Command []string
+	Timeout time.Duration
+
+	cache *Cache
+}
+
+// NewCommandRenderer creates a renderer for the given command line. A zero
+// timeout uses the default of 10 seconds
+func NewCommandRenderer(command []string, timeout time.Duration) *CommandRenderer {
+	if timeout <= 0 {
+		timeout = defaultDiagramTimeout
+	}
+	return &CommandRenderer{
+		Command: command,
+		Timeout: timeout,
+		cache:   NewCache(diagramCacheSize),
+	}
+}
+
+// errDiagramTimeout marks a command that ran out of time. That may only be
+// because the server was busy, so it isn't cached
+var errDiagramTimeout = errors.New("timed out")
+
+// diagramSlots bounds how many diagram commands run at once across every
+// renderer, so a page full of diagrams can't start a process for each
+var diagramSlots = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// RenderBlock renders the block to SVG wrapped in a diagram container.
+// Results, including failures other than timeouts, are cached by content
+// hash, so unchanged diagrams are only rendered once
+func (c *CommandRenderer) RenderBlock(info string, source []byte) ([]byte, error) {
+	if len(c.Command) == 0 {
+		return nil, fmt.Errorf("no diagram command configured")
+	}
+
+	key := c.cacheKey(source)
+	if out, ok, err := c.cached(info, key); ok {
+		return out, err
+	}
+
+	select {
+	case diagramSlots <- struct{}{}:
+		defer func() { <-diagramSlots }()
+	case <-time.After(c.Timeout):
+		return nil, fmt.Errorf("too many diagrams are being rendered, try again later")
+	}
+
+	// The same diagram may have been rendered while waiting
+	if out, ok, err := c.cached(info, key); ok {
+		return out, err
+	}
+
+	svg, err := c.run(source)
+	if err != nil {
+		if !errors.Is(err, errDiagramTimeout) {
+			c.cache.put(key, err, int64(len(err.Error())+len(key)))
+		}
+		return nil, err
+	}
+
+	c.cache.put(key, svg, int64(len(svg)+len(key)))
+	return wrapDiagram(info, svg), nil
+}
+
+// cached returns the diagram or error cached for key
+func (c *CommandRenderer) cached(info, key string) ([]byte, bool, error) {
+	v, ok := c.cache.get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	if err, isErr := v.(error); isErr {
+		return nil, true, err
+	}
+	return wrapDiagram(info, v.([]byte)), true, nil
+}
+
+// cacheKey hashes the command together with the source so changing the
+// command doesn't serve stale diagrams
+func (c *CommandRenderer) cacheKey(source []byte) string {
+	return hashKey([]byte(strings.Join(c.Command, "\x00")), source)
+}
+
+// run executes the command and returns the SVG it produced
+func (c *CommandRenderer) run(source []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	args := make([]string, len(c.Command))
+	copy(args, c.Command)
+
+	var inputPath, outputPath string
+	for i, arg := range args {
+		if !strings.Contains(arg, "{input}") && !strings.Contains(arg, "{output}") {
+			continue
+		}
+		if inputPath == "" {
+			dir, err := os.MkdirTemp("", "fishki-diagram-*")
+			if err != nil {
+				return nil, fmt.Errorf("failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			inputPath = filepath.Join(dir, "input")
+			outputPath = filepath.Join(dir, "output.svg")
+			if err := os.WriteFile(inputPath, source, 0600); err != nil {
+				return nil, fmt.Errorf("failed to write diagram source: %v", err)
+			}
+		}
+		arg = strings.ReplaceAll(arg, "{input}", inputPath)
+		args[i] = strings.ReplaceAll(arg, "{output}", outputPath)
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(source)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s %w after %s", args[0], errDiagramTimeout, c.Timeout)
+		}
+		return nil, fmt.Errorf("%s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	output := stdout.Bytes()
+	if strings.Contains(strings.Join(c.Command, " "), "{output}") {
+		var err error
+		if output, err = os.ReadFile(outputPath); err != nil {
+			return nil, fmt.Errorf("%s wrote no output: %v", args[0], err)
+		}
+	}
+
+	// Drop the XML prolog and doctype; only the <svg> element is inlined
+	start := bytes.Index(output, []byte("<svg"))
+	if start < 0 {
+		return nil, fmt.Errorf("%s did not produce SVG", args[0])
+	}
+	return bytes.TrimSpace(output[start:]), nil
+}
+
+// wrapDiagram puts the SVG in a container classed by the block's language
+func wrapDiagram(info string, svg []byte) []byte {
+	lang := strings.Fields(info)[0]
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<div class=\"diagram diagram-%s\">", html.EscapeString(lang))
+	buf.Write(svg)
+	buf.WriteString("</div>\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,195 @@
+package markdown
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDiagramHelperProcess stands in for a diagram tool such as dot. It is
+// only active when run by helperCommand
+func TestDiagramHelperProcess(t *testing.T) {
+	if os.Getenv("FISHKI_DIAGRAM_HELPER") != "1" {
+		return
+	}
+
+	// Record each invocation so tests can check the cache
+	if counter := os.Getenv("FISHKI_DIAGRAM_COUNTER"); counter != "" {
+		f, _ := os.OpenFile(counter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		f.WriteString("x")
+		f.Close()
+	}
+
+	args := os.Args
+	for i, arg := range args {
+		if arg == "--" {
+			args = args[i+1:]
+			break
+		}
+	}
+
+	var source []byte
+	out := io.Writer(os.Stdout)
+	if len(args) == 2 {
+		source, _ = os.ReadFile(args[0])
+		f, _ := os.Create(args[1])
+		defer f.Close()
+		out = f
+	} else {
+		source, _ = io.ReadAll(os.Stdin)
+	}
+
+	if strings.Contains(string(source), "slow") {
+		time.Sleep(5 * time.Second)
+	}
+	if strings.Contains(string(source), "syntax error") {
+		fmt.Fprint(os.Stderr, "Error: syntax error in line 1")
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(out, "<?xml version=\"1.0\"?>\n<!DOCTYPE svg>\n<svg xmlns=\"http://www.w3.org/2000/svg\" viewBox=\"0 0 10 10\" onload=\"alert(1)\"><text x=\"1\" y=\"5\">%d bytes</text><script>alert(1)</script></svg>\n", len(source))
+	if f, ok := out.(*os.File); ok && f != os.Stdout {
+		f.Close()
+	}
+	os.Exit(0)
+}
+
+// helperCommand returns a command line that runs TestDiagramHelperProcess
+func helperCommand(t *testing.T, extra ...string) []string {
+	t.Setenv("FISHKI_DIAGRAM_HELPER", "1")
+	return append([]string{os.Args[0], "-test.run=TestDiagramHelperProcess", "--"}, extra...)
+}
+
+func TestCommandRendererDiagrams(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "count")
+	t.Setenv("FISHKI_DIAGRAM_COUNTER", counter)
+
+	blocks := NewBlockRegistry()
+	blocks.Register("dot", NewCommandRenderer(helperCommand(t), 0))
+	renderer := New(Options{Blocks: blocks})
+
+	source := []byte("# Architecture\n\n```dot\ndigraph { a -> b }\n```\n")
+	for i := 0; i < 3; i++ {
+		got := string(renderer.Render(source))
+		// The sanitizer lowercases attribute names; browsers restore the
+		// SVG casing (viewBox) when parsing
+		if !strings.Contains(got, `<div class="diagram diagram-dot"><svg xmlns="http://www.w3.org/2000/svg" viewbox="0 0 10 10"><text x="1" y="5">19 bytes</text></svg></div>`) {
+			t.Fatalf("expected sanitized inline SVG, got %q", got)
+		}
+		if strings.Contains(got, "<?xml") || strings.Contains(got, "script") || strings.Contains(got, "onload") {
+			t.Fatalf("expected prolog and script to be stripped, got %q", got)
+		}
+	}
+
+	runs, _ := os.ReadFile(counter)
+	if len(runs) != 1 {
+		t.Errorf("expected the diagram command to run once, ran %d times", len(runs))
+	}
+
+	// Changed content is rendered again
+	renderer.Render([]byte("```dot\ndigraph { a -> c }\n```\n"))
+	runs, _ = os.ReadFile(counter)
+	if len(runs) != 2 {
+		t.Errorf("expected the diagram command to run twice, ran %d times", len(runs))
+	}
+}
+
+func TestCommandRendererFilePlaceholders(t *testing.T) {
+	blocks := NewBlockRegistry()
+	blocks.Register("mermaid", NewCommandRenderer(helperCommand(t, "{input}", "{output}"), 0))
+
+	got := string(New(Options{Blocks: blocks}).Render([]byte("```mermaid\ngraph TD; A-->B\n```\n")))
+	if !strings.Contains(got, `<div class="diagram diagram-mermaid"><svg`) || !strings.Contains(got, "16 bytes") {
+		t.Errorf("expected SVG read from the output file, got %q", got)
+	}
+}
+
+func TestCommandRendererFailure(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "count")
+	t.Setenv("FISHKI_DIAGRAM_COUNTER", counter)
+
+	blocks := NewBlockRegistry()
+	blocks.Register("dot", NewCommandRenderer(helperCommand(t), 0))
+	renderer := New(Options{Blocks: blocks})
+
+	for i := 0; i < 2; i++ {
+		got := string(renderer.Render([]byte("```dot\nsyntax error\n```\n")))
+		if !strings.Contains(got, `<div class="block-error">`) || !strings.Contains(got, "syntax error in line 1") {
+			t.Errorf("expected the command error to be shown, got %q", got)
+		}
+		if !strings.Contains(got, `<code class="language-dot">`) {
+			t.Errorf("expected the block to fall back to code, got %q", got)
+		}
+	}
+
+	// Failures are cached too
+	if runs, _ := os.ReadFile(counter); len(runs) != 1 {
+		t.Errorf("expected the diagram command to run once, ran %d times", len(runs))
+	}
+}
+
+func TestCommandRendererTimeoutNotCached(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "count")
+	t.Setenv("FISHKI_DIAGRAM_COUNTER", counter)
+	renderer := NewCommandRenderer(helperCommand(t), 200*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		_, err := renderer.RenderBlock("dot", []byte("slow"))
+		if err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Fatalf("expected a timeout, got %v", err)
+		}
+	}
+
+	// A timeout may only mean the server was busy, so it is tried again
+	if runs, _ := os.ReadFile(counter); len(runs) != 2 {
+		t.Errorf("expected the diagram command to run twice, ran %d times", len(runs))
+	}
+}
+
+func TestCommandRendererConcurrency(t *testing.T) {
+	renderer := NewCommandRenderer(helperCommand(t), 50*time.Millisecond)
+
+	// With every slot taken, rendering gives up after the timeout
+	for i := 0; i < cap(diagramSlots); i++ {
+		diagramSlots <- struct{}{}
+	}
+	_, err := renderer.RenderBlock("dot", []byte("digraph { a }"))
+	for i := 0; i < cap(diagramSlots); i++ {
+		<-diagramSlots
+	}
+	if err == nil {
+		t.Fatal("expected an error while every slot is taken")
+	}
+
+	// That isn't cached, so it renders once a slot is free
+	renderer.Timeout = defaultDiagramTimeout
+	if _, err := renderer.RenderBlock("dot", []byte("digraph { a }")); err != nil {
+		t.Errorf("expected the diagram to render, got %v", err)
+	}
+}
+
+func TestBlockRegistry(t *testing.T) {
+	blocks := NewBlockRegistry()
+	blocks.Register("Upper", BlockRendererFunc(func(info string, source []byte) ([]byte, error) {
+		return []byte("<p>" + strings.ToUpper(string(source)) + " (" + info + ")</p>\n"), nil
+	}))
+
+	got := string(New(Options{Blocks: blocks}).Render([]byte("```upper title=x\nshout\n```\n\n```go\nx := 1\n```\n")))
+	if !strings.Contains(got, "<p>SHOUT\n (upper title=x)</p>") {
+		t.Errorf("expected the registered renderer to be used, got %q", got)
+	}
+	if !strings.Contains(got, `<pre class="chroma"><code class="language-go">`) {
+		t.Errorf("expected other languages to be highlighted, got %q", got)
+	}
+
+	if _, ok := blocks.Lookup("go"); ok {
+		t.Error("expected no renderer for go")
+	}
+	if langs := blocks.Languages(); len(langs) != 1 || langs[0] != "upper" {
+		t.Errorf("unexpected languages %v", langs)
+	}
+}
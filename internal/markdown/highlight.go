@@ -0,0 +1,130 @@
+package markdown
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// DefaultLightStyle and DefaultDarkStyle are the chroma styles used for
+// highlight CSS when none are configured
+const (
+	DefaultLightStyle = "github"
+	DefaultDarkStyle  = "monokai"
+)
+
+// ErrUnknownStyle is returned when a chroma style name doesn't exist
+var ErrUnknownStyle = errors.New("unknown syntax highlighting style")
+
+// HighlightCSS returns the stylesheet for the named chroma style. Rendered
+// code blocks only carry class names, so any style can be applied to them
+func HighlightCSS(style string) ([]byte, error) {
+	s, ok := styles.Registry[style]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStyle, style)
+	}
+
+	var buf bytes.Buffer
+	formatter := chromahtml.New(chromahtml.WithClasses(true))
+	if err := formatter.WriteCSS(&buf, s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// codeBlockRenderer renders fenced code blocks, handing them to a registered
+// BlockRenderer for their language or highlighting them with chroma
+type codeBlockRenderer struct {
+	highlight bool
+	blocks    *BlockRegistry
+}
+
+// RegisterFuncs registers the fenced code block renderer with goldmark
+func (r *codeBlockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+}
+
+func (r *codeBlockRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*ast.FencedCodeBlock)
+	lang := string(n.Language(source))
+
+	var code bytes.Buffer
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(source))
+	}
+
+	if blockRenderer, ok := r.blocks.Lookup(lang); ok {
+		info := string(n.Info.Segment.Value(source))
+		rendered, err := blockRenderer.RenderBlock(info, code.Bytes())
+		if err == nil {
+			w.Write(rendered)
+			return ast.WalkSkipChildren, nil
+		}
+		// Show why the block couldn't be rendered, then the block as code
+		w.WriteString("<div class=\"block-error\">")
+		w.WriteString(html.EscapeString(err.Error()))
+		w.WriteString("</div>\n")
+	}
+
+	// Without a language there is nothing to highlight
+	if lang == "" || !r.highlight {
+		w.WriteString("<pre><code")
+		if lang != "" {
+			w.WriteString(" class=\"language-")
+			w.Write(util.EscapeHTML([]byte(lang)))
+			w.WriteString("\"")
+		}
+		w.WriteString(">")
+		w.Write(util.EscapeHTML(code.Bytes()))
+		w.WriteString("</code></pre>\n")
+		return ast.WalkSkipChildren, nil
+	}
+
+	highlightCode(w, lang, code.String())
+	return ast.WalkSkipChildren, nil
+}
+
+// highlightCode writes code as a chroma highlighted block, falling back to
+// plain escaped text if the code can't be tokenised
+func highlightCode(w util.BufWriter, lang, code string) {
+	// Get the lexer for the language
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+
+	formatter := chromahtml.New(chromahtml.WithClasses(true), chromahtml.PreventSurroundingPre(true))
+
+	// Format into a buffer first so a failure can't leave half a block behind
+	var buf bytes.Buffer
+	iterator, err := lexer.Tokenise(nil, code)
+	if err == nil {
+		// With classes the style only matters for the stylesheet, which
+		// is served separately by HighlightCSS
+		err = formatter.Format(&buf, styles.Fallback, iterator)
+	}
+	if err != nil {
+		w.WriteString(fmt.Sprintf("<pre><code class=\"language-%s\">", html.EscapeString(lang)))
+		w.WriteString(html.EscapeString(code))
+		w.WriteString("</code></pre>\n")
+		return
+	}
+
+	w.WriteString(fmt.Sprintf("<pre class=\"chroma\"><code class=\"language-%s\">", html.EscapeString(lang)))
+	w.Write(buf.Bytes())
+	w.WriteString("</code></pre>\n")
+}
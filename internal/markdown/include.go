@@ -0,0 +1,283 @@
+package markdown
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Pages can embed other pages, or a single heading section of them, with
+// either of these directives on a line of their own:
+//
+//	![[shared/oncall]]
+//	![[shared/oncall#Contacts]]
+//	{{< include "shared/oncall#Contacts" >}}
+//
+// Targets are relative to the wiki root and ".md" is added when they have no
+// extension. Includes are expanded in the markdown source before rendering,
+// so the included page is rendered as part of the page that embeds it, and
+// directives inside lists or blockquotes keep their indentation.
+
+// MaxIncludeDepth bounds how deeply includes may nest
+const MaxIncludeDepth = 5
+
+// MaxIncludes and MaxIncludeBytes bound how many includes one page can
+// expand in total, and how much content they can add, so a page that
+// includes the same large pages many times over can't blow up
+const (
+	MaxIncludes     = 100
+	MaxIncludeBytes = 1 << 20
+)
+
+var (
+	// ErrIncludeCycle is returned when a page includes itself, directly or
+	// through other pages
+	ErrIncludeCycle = errors.New("include cycle")
+
+	// ErrIncludeDepth is returned when includes nest deeper than MaxIncludeDepth
+	ErrIncludeDepth = errors.New("includes nested too deeply")
+
+	// ErrIncludeLimit is returned once a page has used up MaxIncludes or
+	// MaxIncludeBytes
+	ErrIncludeLimit = errors.New("too many includes")
+
+	// ErrIncludePath is returned for targets outside the wiki root
+	ErrIncludePath = errors.New("include path outside of wiki")
+
+	// ErrIncludeSection is returned when the included heading doesn't exist
+	ErrIncludeSection = errors.New("include section not found")
+)
+
+// IncludeLoader returns the markdown source of a page, given its path
+// relative to the wiki root. Loaders must refuse paths outside the wiki
+type IncludeLoader func(page string) ([]byte, error)
+
+// includeDirective matches an include on its own line, keeping any list
+// indentation or blockquote markers in front of it
+var includeDirective = regexp.MustCompile(`^([ \t>]*)(?:!\[\[([^\]|]+)(?:\|[^\]]*)?\]\]|\{\{<\s*include\s+"([^"]+)"\s*>\}\})\s*$`)
+
+// atxHeading matches an ATX heading line
+var atxHeading = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+
+// headingAttributes matches a trailing {#id .class} block on a heading
+var headingAttributes = regexp.MustCompile(`\s*\{[^}]*\}$`)
+
+// ExpandIncludes replaces include directives in src with the content of the
+// pages they name. page is the path of src itself, used to detect cycles; it
+// may be empty for content that isn't stored yet. Includes that can't be
+// expanded are replaced with an include-error box explaining why
+func ExpandIncludes(src []byte, page string, load IncludeLoader) []byte {
+	e := &expansion{load: load, pages: make(map[string]loaded)}
+	var stack []string
+	if page != "" {
+		stack = append(stack, includePath(page))
+	}
+	return e.expand(src, stack, 0)
+}
+
+// expansion is the state shared by every include expanded for one page
+type expansion struct {
+	load     IncludeLoader
+	pages    map[string]loaded
+	includes int
+	bytes    int
+}
+
+// loaded is a page read during an expansion, kept so pages included many
+// times are only loaded once
+type loaded struct {
+	src []byte
+	err error
+}
+
+// expand expands the includes in src, which is depth includes down from the
+// page being rendered
+func (e *expansion) expand(src []byte, stack []string, depth int) []byte {
+	var out bytes.Buffer
+	var fence fenceTracker
+	for _, line := range splitLines(src) {
+		if fence.inCode(line) {
+			out.Write(line)
+			continue
+		}
+
+		match := includeDirective.FindSubmatch(bytes.TrimRight(line, "\r\n"))
+		if match == nil {
+			out.Write(line)
+			continue
+		}
+
+		target := string(match[2])
+		if target == "" {
+			target = string(match[3])
+		}
+		content, err := e.include(target, stack, depth)
+		if err != nil {
+			content = []byte(fmt.Sprintf("<div class=\"include-error\">%s</div>\n\n", html.EscapeString(err.Error())))
+		}
+		writeIndented(&out, string(match[1]), content)
+	}
+	return out.Bytes()
+}
+
+// include loads and expands a single include target
+func (e *expansion) include(target string, stack []string, depth int) ([]byte, error) {
+	target, section, _ := strings.Cut(strings.TrimSpace(target), "#")
+	if target == "" {
+		return nil, fmt.Errorf("%w: %q", ErrIncludePath, target)
+	}
+	page := includePath(target)
+	if page == ".." || strings.HasPrefix(page, "../") || path.IsAbs(page) {
+		return nil, fmt.Errorf("%w: %s", ErrIncludePath, target)
+	}
+
+	for _, p := range stack {
+		if p == page {
+			return nil, fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(append(stack, page), " → "))
+		}
+	}
+	if depth >= MaxIncludeDepth {
+		return nil, fmt.Errorf("%w: %s", ErrIncludeDepth, page)
+	}
+	if e.includes >= MaxIncludes {
+		return nil, fmt.Errorf("%w: %s", ErrIncludeLimit, page)
+	}
+	e.includes++
+
+	l, ok := e.pages[page]
+	if !ok {
+		l.src, l.err = e.load(page)
+		e.pages[page] = l
+	}
+	if l.err != nil {
+		return nil, fmt.Errorf("failed to include %s: %v", page, l.err)
+	}
+	src := l.src
+	if section != "" {
+		var err error
+		if src, err = headingSection(src, section); err != nil {
+			return nil, fmt.Errorf("%w: %s#%s", err, page, section)
+		}
+	}
+	if e.bytes+len(src) > MaxIncludeBytes {
+		return nil, fmt.Errorf("%w: %s", ErrIncludeLimit, page)
+	}
+	e.bytes += len(src)
+
+	// Copy the stack so sibling includes don't see each other
+	next := append(append([]string(nil), stack...), page)
+	return e.expand(src, next, depth+1), nil
+}
+
+// includePath normalises an include target to a cleaned wiki path with an
+// extension
+func includePath(target string) string {
+	p := path.Clean(strings.ReplaceAll(target, "\\", "/"))
+	if path.Ext(p) == "" {
+		p += ".md"
+	}
+	return p
+}
+
+// headingSection returns the lines under the heading whose text or slug
+// matches section, up to the next heading of the same or a higher level.
+// The heading itself is included
+func headingSection(src []byte, section string) ([]byte, error) {
+	want := Slugify(section)
+	var out bytes.Buffer
+	var fence fenceTracker
+	level := 0
+	for _, line := range splitLines(src) {
+		if fence.inCode(line) {
+			if level > 0 {
+				out.Write(line)
+			}
+			continue
+		}
+		if m := atxHeading.FindSubmatch(bytes.TrimRight(line, "\r\n")); m != nil {
+			l := len(m[1])
+			if level > 0 && l <= level {
+				break
+			}
+			if level == 0 {
+				text := headingAttributes.ReplaceAllString(string(m[2]), "")
+				if Slugify(text) == want {
+					level = l
+				}
+			}
+		}
+		if level > 0 {
+			out.Write(line)
+		}
+	}
+	if level == 0 {
+		return nil, ErrIncludeSection
+	}
+	return out.Bytes(), nil
+}
+
+// writeIndented writes content with prefix in front of every line so it sits
+// in the same list item or blockquote as the directive it replaces
+func writeIndented(out *bytes.Buffer, prefix string, content []byte) {
+	blank := strings.TrimRight(prefix, " \t")
+	for _, line := range splitLines(content) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			out.WriteString(blank)
+		} else {
+			out.WriteString(prefix)
+		}
+		out.Write(line)
+	}
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		out.WriteByte('\n')
+	}
+}
+
+// splitLines splits src into lines, keeping their line endings
+func splitLines(src []byte) [][]byte {
+	var lines [][]byte
+	for len(src) > 0 {
+		i := bytes.IndexByte(src, '\n')
+		if i < 0 {
+			lines = append(lines, src)
+			break
+		}
+		lines = append(lines, src[:i+1])
+		src = src[i+1:]
+	}
+	return lines
+}
+
+// fenceTracker follows fenced code blocks line by line so directives and
+// headings inside code are left alone
+type fenceTracker struct {
+	char   byte
+	length int
+}
+
+// inCode reports whether line opens, closes or sits inside a fenced block
+func (f *fenceTracker) inCode(line []byte) bool {
+	trimmed := bytes.TrimLeft(line, " \t>")
+	n := 0
+	if len(trimmed) > 0 && (trimmed[0] == '`' || trimmed[0] == '~') {
+		for n < len(trimmed) && trimmed[n] == trimmed[0] {
+			n++
+		}
+	}
+
+	if f.length > 0 {
+		if n >= f.length && trimmed[0] == f.char && len(bytes.TrimSpace(trimmed[n:])) == 0 {
+			f.length = 0
+		}
+		return true
+	}
+	if n >= 3 {
+		f.char, f.length = trimmed[0], n
+		return true
+	}
+	return false
+}
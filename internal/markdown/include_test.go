@@ -0,0 +1,189 @@
+package markdown
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExpandIncludes(t *testing.T) {
+	pages := map[string]string{
+		"shared/oncall.md": "# On call\n\n## Contacts\n\nCall Sam.\n\n### Backup\n\nCall Alex.\n\n## Escalation\n\nPanic.\n",
+		"shared/short.md":  "Short *note*.",
+		"loop/a.md":        "A\n\n![[loop/b]]\n",
+		"loop/b.md":        "B\n\n![[loop/a]]\n",
+		"self.md":          "![[self]]\n",
+		"fenced.md":        "```\n![[shared/short]]\n```\n",
+	}
+	for i := 0; i <= MaxIncludeDepth+1; i++ {
+		pages[fmt.Sprintf("deep/%d.md", i)] = fmt.Sprintf("level %d\n\n![[deep/%d]]\n", i, i+1)
+	}
+	load := func(page string) ([]byte, error) {
+		if content, ok := pages[page]; ok {
+			return []byte(content), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	tests := []struct {
+		name     string
+		page     string
+		markdown string
+		want     string
+		contains []string
+		missing  []string
+	}{
+		{
+			name:     "Whole page",
+			markdown: "Before\n\n![[shared/short]]\n\nAfter\n",
+			want:     "Before\n\nShort *note*.\n\nAfter\n",
+		},
+		{
+			name:     "Shortcode form",
+			markdown: "{{< include \"shared/short.md\" >}}\n",
+			want:     "Short *note*.\n",
+		},
+		{
+			name:     "Heading section",
+			markdown: "![[shared/oncall#Contacts]]\n",
+			want:     "## Contacts\n\nCall Sam.\n\n### Backup\n\nCall Alex.\n\n",
+		},
+		{
+			name:     "Heading section by slug",
+			markdown: "{{< include \"shared/oncall#escalation\" >}}\n",
+			want:     "## Escalation\n\nPanic.\n",
+		},
+		{
+			name:     "Indented in list",
+			markdown: "- item\n\n  ![[shared/oncall#Backup]]\n",
+			want:     "- item\n\n  ### Backup\n\n  Call Alex.\n\n",
+		},
+		{
+			name:     "Inside blockquote",
+			markdown: "> ![[shared/oncall#Escalation]]\n",
+			want:     "> ## Escalation\n>\n> Panic.\n",
+		},
+		{
+			name:     "Not on its own line",
+			markdown: "See ![[shared/short]] here\n",
+			want:     "See ![[shared/short]] here\n",
+		},
+		{
+			name:     "Inside code",
+			markdown: "```\n![[shared/short]]\n```\n",
+			want:     "```\n![[shared/short]]\n```\n",
+		},
+		{
+			name:     "Included code is not expanded",
+			markdown: "![[fenced]]\n",
+			want:     "```\n![[shared/short]]\n```\n",
+		},
+		{
+			name:     "Missing page",
+			markdown: "![[nope]]\n",
+			contains: []string{`class="include-error"`, "nope.md"},
+		},
+		{
+			name:     "Missing section",
+			markdown: "![[shared/oncall#Nope]]\n",
+			contains: []string{ErrIncludeSection.Error()},
+		},
+		{
+			name:     "Escaping the wiki",
+			markdown: "![[../secrets]]\n{{< include \"/etc/passwd\" >}}\n",
+			contains: []string{ErrIncludePath.Error()},
+			missing:  []string{"failed to include"},
+		},
+		{
+			name:     "Cycle",
+			page:     "loop/a.md",
+			markdown: pages["loop/a.md"],
+			contains: []string{"A\n", "B\n", ErrIncludeCycle.Error(), "loop/a.md → loop/b.md → loop/a.md"},
+		},
+		{
+			name:     "Self include",
+			page:     "self.md",
+			markdown: pages["self.md"],
+			contains: []string{ErrIncludeCycle.Error()},
+		},
+		{
+			name:     "Depth limit",
+			page:     "deep/0.md",
+			markdown: pages["deep/0.md"],
+			contains: []string{fmt.Sprintf("level %d", MaxIncludeDepth), ErrIncludeDepth.Error()},
+			missing:  []string{fmt.Sprintf("level %d", MaxIncludeDepth+1)},
+		},
+		{
+			name:     "Depth limit without a page",
+			markdown: pages["deep/0.md"],
+			contains: []string{fmt.Sprintf("level %d", MaxIncludeDepth), ErrIncludeDepth.Error()},
+			missing:  []string{fmt.Sprintf("level %d", MaxIncludeDepth+1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(ExpandIncludes([]byte(tt.markdown), tt.page, load))
+			if tt.want != "" && got != tt.want {
+				t.Errorf("ExpandIncludes() = %q, want %q", got, tt.want)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(got, s) {
+					t.Errorf("expected %q in %q", s, got)
+				}
+			}
+			for _, s := range tt.missing {
+				if strings.Contains(got, s) {
+					t.Errorf("did not expect %q in %q", s, got)
+				}
+			}
+		})
+	}
+}
+
+func TestExpandIncludesLoaderError(t *testing.T) {
+	load := func(page string) ([]byte, error) {
+		return nil, errors.New("<denied>")
+	}
+	got := string(ExpandIncludes([]byte("![[private]]\n"), "", load))
+	want := "<div class=\"include-error\">failed to include private.md: &lt;denied&gt;</div>\n\n"
+	if got != want {
+		t.Errorf("ExpandIncludes() = %q, want %q", got, want)
+	}
+}
+
+func TestExpandIncludesLimits(t *testing.T) {
+	pages := map[string]string{
+		"wide.md":  strings.Repeat("![[leaf]]\n", 20),
+		"leaf.md":  "leaf\n",
+		"large.md": strings.Repeat("x", MaxIncludeBytes/3) + "\n",
+	}
+	loads := make(map[string]int)
+	load := func(page string) ([]byte, error) {
+		loads[page]++
+		if content, ok := pages[page]; ok {
+			return []byte(content), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	// Every include counts, so fan-out is cut off without loading pages again
+	got := string(ExpandIncludes([]byte(strings.Repeat("![[wide]]\n", 10)), "", load))
+	if n := strings.Count(got, "leaf\n"); n == 0 || n >= MaxIncludes {
+		t.Errorf("Expected fewer than %d includes to be expanded, got %d", MaxIncludes, n)
+	}
+	if !strings.Contains(got, ErrIncludeLimit.Error()) {
+		t.Errorf("Expected %q once the limit is reached", ErrIncludeLimit)
+	}
+	if loads["wide.md"] != 1 || loads["leaf.md"] != 1 {
+		t.Errorf("Expected each page to be loaded once, got %v", loads)
+	}
+
+	// So does the size of what is included
+	got = string(ExpandIncludes([]byte(strings.Repeat("![[large]]\n", 4)), "", load))
+	if n := strings.Count(got, "xxx\n"); n != 2 || !strings.Contains(got, ErrIncludeLimit.Error()) {
+		t.Errorf("Expected 2 large includes and then %q, got %d", ErrIncludeLimit, n)
+	}
+}
@@ -2,117 +2,151 @@ package markdown
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"html"
-	"strings"
 
-	chromahtml "github.com/alecthomas/chroma/formatters/html"
-	"github.com/alecthomas/chroma/lexers"
-	"github.com/alecthomas/chroma/styles"
-	"github.com/russross/blackfriday/v2"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
 )
 
-// Custom renderer that extends the default HTML renderer
-type syntaxHighlightRenderer struct {
-	*blackfriday.HTMLRenderer
+// Options controls how markdown is rendered. The zero value gives the
+// default wiki rendering: CommonMark plus GitHub Flavored Markdown, with
+// syntax highlighted code blocks
+type Options struct {
+	// StrictCommonMark disables every extension so the output follows the
+	// CommonMark spec exactly
+	StrictCommonMark bool
+
+	// DisableHighlighting renders fenced code blocks as plain <pre><code>
+	// instead of running them through chroma
+	DisableHighlighting bool
+
+	// Allowlist extends the HTML that survives sanitization
+	Allowlist Allowlist
+
+	// Blocks renders fenced code blocks in registered languages, such as
+	// diagrams, instead of showing them as code
+	Blocks *BlockRegistry
+
+	// Admonitions adds callout types to the built-in ones, mapping each
+	// type to its default title
+	Admonitions map[string]string
+
+	// Cache keeps rendered output so unchanged markdown isn't rendered
+	// again. It may be shared between renderers; nil disables caching
+	Cache *Cache
 }
 
-// Override the CodeBlock method to add syntax highlighting
-func (r *syntaxHighlightRenderer) CodeBlock(out *bytes.Buffer, text []byte, info string, _ bool) {
-	// Get the language from the info string
-	lang := strings.TrimSpace(string(info))
-	
-	// If no language is specified, try to guess or use plaintext
-	if lang == "" {
-		lang = "plaintext"
-	}
+// Renderer converts markdown to HTML using a fixed set of options
+type Renderer struct {
+	md     goldmark.Markdown
+	policy *bluemonday.Policy
+
+	cache      *Cache
+	optionsKey []byte
+}
 
-	// Get the lexer for the language
-	lexer := lexers.Get(lang)
-	if lexer == nil {
-		lexer = lexers.Fallback
+// New creates a renderer with the given options
+func New(opts Options) *Renderer {
+	var extensions []goldmark.Extender
+	var parserOptions []parser.Option
+	if !opts.StrictCommonMark {
+		// Tables, task lists, strikethrough and autolinks, plus footnotes,
+		// matching remark-gfm in the frontend preview, math and callouts
+		extensions = append(extensions, extension.GFM, extension.Footnote, &mathExtension{},
+			&admonitionExtension{types: newAdmonitionTypes(opts.Admonitions)})
+		parserOptions = append(parserOptions, parser.WithHeadingAttribute())
 	}
-	
-	// Use the GitHub style for syntax highlighting
-	style := styles.Get("github")
-	if style == nil {
-		style = styles.Fallback
+
+	var nodeRenderers []util.PrioritizedValue
+	if !opts.DisableHighlighting || opts.Blocks != nil {
+		codeBlocks := &codeBlockRenderer{highlight: !opts.DisableHighlighting, blocks: opts.Blocks}
+		nodeRenderers = append(nodeRenderers, util.Prioritized(codeBlocks, 100))
 	}
-	
-	formatter := chromahtml.New(chromahtml.WithClasses(true))
-	
-	// Parse the code with the lexer
-	iterator, err := lexer.Tokenise(nil, string(text))
-	if err != nil {
-		// If there's an error, fall back to the default renderer
-		out.WriteString("<pre><code>")
-		out.Write([]byte(html.EscapeString(string(text))))
-		out.WriteString("</code></pre>\n")
-		return
+
+	return &Renderer{
+		md: goldmark.New(
+			goldmark.WithExtensions(extensions...),
+			goldmark.WithParserOptions(parserOptions...),
+			goldmark.WithRendererOptions(
+				goldmarkhtml.WithXHTML(),
+				goldmarkhtml.WithUnsafe(),
+				renderer.WithNodeRenderers(nodeRenderers...),
+			),
+		),
+		policy:     newPolicy(opts.Allowlist),
+		cache:      opts.Cache,
+		optionsKey: opts.key(),
 	}
-	
-	// Write the CSS classes for the code block
-	out.WriteString(fmt.Sprintf("<pre class=\"chroma\"><code class=\"language-%s\">", html.EscapeString(lang)))
-	
-	// Format the code with the formatter
-	err = formatter.Format(out, style, iterator)
-	if err != nil {
-		// If there's an error, fall back to the default renderer
-		out.WriteString("<pre><code>")
-		out.Write([]byte(html.EscapeString(string(text))))
-		out.WriteString("</code></pre>\n")
-		return
+}
+
+// key identifies the options in cache keys, so renderers sharing a cache
+// never see each other's output
+func (opts Options) key() []byte {
+	blocks := make(map[string]string)
+	for _, lang := range opts.Blocks.Languages() {
+		renderer, _ := opts.Blocks.Lookup(lang)
+		if c, ok := renderer.(*CommandRenderer); ok {
+			blocks[lang] = fmt.Sprintf("%q %s", c.Command, c.Timeout)
+		} else {
+			blocks[lang] = fmt.Sprintf("%T %p", renderer, renderer)
+		}
 	}
-	
-	out.WriteString("</code></pre>\n")
+
+	// Maps are marshalled with sorted keys, so equal options give equal keys
+	key, _ := json.Marshal(struct {
+		StrictCommonMark    bool
+		DisableHighlighting bool
+		Allowlist           Allowlist
+		Blocks              map[string]string
+		Admonitions         map[string]string
+	}{opts.StrictCommonMark, opts.DisableHighlighting, opts.Allowlist, blocks, opts.Admonitions})
+	return key
 }
 
+// defaultRenderer is used by the package-level Render and RenderPage
+var defaultRenderer = New(Options{})
+
 // Render converts markdown to HTML with syntax highlighting
 func Render(markdown []byte) []byte {
-	// Create a custom renderer with syntax highlighting
-	renderer := &syntaxHighlightRenderer{
-		HTMLRenderer: blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
-			Flags: blackfriday.CommonHTMLFlags | blackfriday.HrefTargetBlank, // Add target="_blank" to links
-		}),
+	return defaultRenderer.Render(markdown)
+}
+
+// Render converts markdown to sanitized HTML. Code blocks carry chroma
+// class names only; their stylesheet comes from HighlightCSS
+func (r *Renderer) Render(markdown []byte) []byte {
+	return r.renderHTML(markdown)
+}
+
+// renderHTML converts markdown to sanitized HTML without any extra markup,
+// using the cache when there is one
+func (r *Renderer) renderHTML(markdown []byte) []byte {
+	if r.cache == nil {
+		return r.policy.SanitizeBytes(r.convert(markdown))
 	}
-	
-	// Generate HTML with the custom renderer
-	renderedHTML := blackfriday.Run(markdown, 
-		blackfriday.WithRenderer(renderer),
-		blackfriday.WithExtensions(blackfriday.CommonExtensions | blackfriday.NoEmptyLineBeforeBlock),
-	)
-	
-	// For tests, we don't want to include the CSS
-	if bytes.Contains(markdown, []byte("TEST_MODE_NO_CSS")) {
-		return renderedHTML
+
+	key := hashKey([]byte("html"), r.optionsKey, markdown)
+	if cached, ok := r.cache.get(key); ok {
+		return bytes.Clone(cached.([]byte))
 	}
-	
-	// Add CSS for syntax highlighting
-	css := generateSyntaxHighlightingCSS()
-	
-	// Add Content Security Policy meta tag
-	csp := `<meta http-equiv="Content-Security-Policy" content="default-src 'self'; script-src 'none'; style-src 'unsafe-inline';">`
-	
-	// Combine the CSS, CSP, and HTML
-	return append([]byte(csp+css), renderedHTML...)
+	rendered := r.policy.SanitizeBytes(r.convert(markdown))
+	r.cache.put(key, bytes.Clone(rendered), int64(len(rendered)+len(key)))
+	return rendered
 }
 
-// generateSyntaxHighlightingCSS generates the CSS for syntax highlighting
-func generateSyntaxHighlightingCSS() string {
-	// Use the GitHub style for syntax highlighting
-	style := styles.Get("github")
-	if style == nil {
-		style = styles.Fallback
-	}
-	
-	// Format the CSS
+// convert runs the markdown through goldmark and returns the bare,
+// unsanitized HTML
+func (r *Renderer) convert(markdown []byte) []byte {
 	var buf bytes.Buffer
-	formatter := chromahtml.New(chromahtml.WithClasses(true))
-	err := formatter.WriteCSS(&buf, style)
-	if err != nil {
-		return ""
+	if err := r.md.Convert(markdown, &buf); err != nil {
+		// Fall back to showing the source rather than nothing at all
+		return []byte("<pre>" + html.EscapeString(string(markdown)) + "</pre>\n")
 	}
-	
-	// Wrap the CSS in a style tag
-	return fmt.Sprintf("<style>%s</style>", buf.String())
+	return buf.Bytes()
 }
@@ -1,6 +1,7 @@
 package markdown
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -34,17 +35,17 @@ func TestRender(t *testing.T) {
 		{
 			name:     "Link",
 			markdown: "[Test Link](https://example.com) TEST_MODE_NO_CSS",
-			want:     "<p><a href=\"https://example.com\" target=\"_blank\">Test Link</a> TEST_MODE_NO_CSS</p>\n",
+			want:     "<p><a href=\"https://example.com\">Test Link</a> TEST_MODE_NO_CSS</p>\n",
 		},
 		{
 			name:     "Code block",
 			markdown: "```\ncode block\n```\nTEST_MODE_NO_CSS",
-			want:     "<pre><code>code block\n</code></pre>\n\n<p>TEST_MODE_NO_CSS</p>\n",
+			want:     "<pre><code>code block\n</code></pre>\n<p>TEST_MODE_NO_CSS</p>\n",
 		},
 		{
 			name:     "Complex document",
 			markdown: "# Main Title\n\nThis is a paragraph with **bold** and *italic* text.\n\n## Subsection\n\n* List item 1\n* List item 2\n\n[Link](https://example.com)\n\nTEST_MODE_NO_CSS",
-			want:     "<h1>Main Title</h1>\n\n<p>This is a paragraph with <strong>bold</strong> and <em>italic</em> text.</p>\n\n<h2>Subsection</h2>\n\n<ul>\n<li>List item 1</li>\n<li>List item 2</li>\n</ul>\n\n<p><a href=\"https://example.com\" target=\"_blank\">Link</a></p>\n\n<p>TEST_MODE_NO_CSS</p>\n",
+			want:     "<h1>Main Title</h1>\n<p>This is a paragraph with <strong>bold</strong> and <em>italic</em> text.</p>\n<h2>Subsection</h2>\n<ul>\n<li>List item 1</li>\n<li>List item 2</li>\n</ul>\n<p><a href=\"https://example.com\">Link</a></p>\n<p>TEST_MODE_NO_CSS</p>\n",
 		},
 	}
 
@@ -61,3 +62,90 @@ func TestRender(t *testing.T) {
 		})
 	}
 }
+
+func TestRenderGFM(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{
+			name:     "Table",
+			markdown: "| a | b |\n| --- | :-: |\n| 1 | 2 |\n",
+			want:     "<table>\n<thead>\n<tr>\n<th>a</th>\n<th align=\"center\">b</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>1</td>\n<td align=\"center\">2</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		{
+			name:     "Task list",
+			markdown: "- [x] done\n- [ ] todo\n",
+			want:     "<ul>\n<li><input checked=\"\" disabled=\"\" type=\"checkbox\"/> done</li>\n<li><input disabled=\"\" type=\"checkbox\"/> todo</li>\n</ul>\n",
+		},
+		{
+			name:     "Strikethrough",
+			markdown: "~~gone~~\n",
+			want:     "<p><del>gone</del></p>\n",
+		},
+		{
+			name:     "Autolink",
+			markdown: "See https://example.com now\n",
+			want:     "<p>See <a href=\"https://example.com\">https://example.com</a> now</p>\n",
+		},
+		{
+			name:     "Footnote",
+			markdown: "Text[^1]\n\n[^1]: Note\n",
+			want:     "<p>Text<sup id=\"fnref:1\"><a href=\"#fn:1\" class=\"footnote-ref\" role=\"doc-noteref\">1</a></sup></p>\n<div class=\"footnotes\" role=\"doc-endnotes\">\n<hr/>\n<ol>\n<li id=\"fn:1\">\n<p>Note\u00a0<a href=\"#fnref:1\" class=\"footnote-backref\" role=\"doc-backlink\">\u21a9\ufe0e</a></p>\n</li>\n</ol>\n</div>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(New(Options{}).renderHTML([]byte(tt.markdown)))
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderHighlightsFencedCode(t *testing.T) {
+	got := string(Render([]byte("```go\nfunc main() {}\n```\nTEST_MODE_NO_CSS")))
+	if !strings.Contains(got, `<pre class="chroma"><code class="language-go">`) {
+		t.Errorf("expected chroma highlighted block, got %q", got)
+	}
+	if !strings.Contains(got, `<span class="kd">func</span>`) {
+		t.Errorf("expected highlighted tokens, got %q", got)
+	}
+
+	plain := string(New(Options{DisableHighlighting: true}).convert([]byte("```go\nfunc main() {}\n```\n")))
+	if plain != "<pre><code class=\"language-go\">func main() {}\n</code></pre>\n" {
+		t.Errorf("unexpected unhighlighted block %q", plain)
+	}
+}
+
+func TestRenderHasNoInlineCSS(t *testing.T) {
+	got := string(Render([]byte("```go\nfunc main() {}\n```\n")))
+	if strings.Contains(got, "<style") || strings.Contains(got, "<meta") {
+		t.Errorf("expected render output without inline CSS, got %q", got)
+	}
+}
+
+func TestHighlightCSS(t *testing.T) {
+	for _, style := range []string{DefaultLightStyle, DefaultDarkStyle} {
+		css, err := HighlightCSS(style)
+		if err != nil {
+			t.Fatalf("HighlightCSS(%q) error = %v", style, err)
+		}
+		if !strings.Contains(string(css), ".chroma") {
+			t.Errorf("HighlightCSS(%q) missing .chroma rules", style)
+		}
+	}
+
+	light, _ := HighlightCSS(DefaultLightStyle)
+	dark, _ := HighlightCSS(DefaultDarkStyle)
+	if string(light) == string(dark) {
+		t.Error("expected light and dark styles to differ")
+	}
+
+	if _, err := HighlightCSS("no-such-style"); !errors.Is(err, ErrUnknownStyle) {
+		t.Errorf("expected ErrUnknownStyle, got %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package markdown
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Math is written as $...$ inline and as $$ fenced blocks, following
+// remark-math. The TeX source is kept verbatim, so underscores and other
+// markdown punctuation inside formulas are never turned into emphasis. It
+// is rendered with the same markup remark-math gives the frontend:
+//
+//	<code class="language-math math-inline">...</code>
+//	<pre><code class="language-math math-display">...</code></pre>
+
+// KindMathInline is the node kind of inline math
+var KindMathInline = ast.NewNodeKind("MathInline")
+
+// MathInline is a $...$ formula inside a paragraph
+type MathInline struct {
+	ast.BaseInline
+}
+
+// Kind implements ast.Node
+func (n *MathInline) Kind() ast.NodeKind {
+	return KindMathInline
+}
+
+// Dump implements ast.Node
+func (n *MathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// KindMathBlock is the node kind of display math
+var KindMathBlock = ast.NewNodeKind("MathBlock")
+
+// MathBlock is a formula fenced by $$ lines
+type MathBlock struct {
+	ast.BaseBlock
+	fenceIndent int
+	fenceLength int
+}
+
+// Kind implements ast.Node
+func (n *MathBlock) Kind() ast.NodeKind {
+	return KindMathBlock
+}
+
+// IsRaw implements ast.Node; the block's lines are TeX, not markdown
+func (n *MathBlock) IsRaw() bool {
+	return true
+}
+
+// Dump implements ast.Node
+func (n *MathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// mathInlineParser parses $...$ the way code spans are parsed: a run of
+// dollars is closed by a run of the same length and the content is raw. A
+// single dollar only opens a formula when it is followed by a non-space, and
+// the next single dollar must follow a non-space and not be followed by a
+// digit to close it. Otherwise the opening dollar is text and the next one
+// may open a formula instead, so prices such as "$5 and $10" stay text. The
+// frontend's remarkMathDollars pairs dollars the same way
+type mathInlineParser struct{}
+
+func (p *mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (p *mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, startSegment := block.PeekLine()
+	opener := 0
+	for ; opener < len(line) && line[opener] == '$'; opener++ {
+	}
+	if opener == 1 && (len(line) < 2 || util.IsSpace(line[1])) {
+		block.Advance(opener)
+		return ast.NewTextSegment(startSegment.WithStop(startSegment.Start + opener))
+	}
+	block.Advance(opener)
+	l, pos := block.Position()
+	node := &MathInline{}
+	for {
+		line, segment := block.PeekLine()
+		if line == nil {
+			// No closing run, so the dollars are plain text
+			block.SetPosition(l, pos)
+			return ast.NewTextSegment(startSegment.WithStop(startSegment.Start + opener))
+		}
+		for i := 0; i < len(line); i++ {
+			if line[i] != '$' {
+				continue
+			}
+			start := i
+			for ; i < len(line) && line[i] == '$'; i++ {
+			}
+			if i-start != opener {
+				continue
+			}
+			if opener == 1 && (start == 0 || util.IsSpace(line[start-1]) || (i < len(line) && util.IsNumeric(line[i]))) {
+				// The next dollar can't close a formula, so this one is
+				// just a dollar sign and that one may open the next formula
+				block.SetPosition(l, pos)
+				return ast.NewTextSegment(startSegment.WithStop(startSegment.Start + opener))
+			}
+			segment = segment.WithStop(segment.Start + start)
+			if !segment.IsEmpty() {
+				node.AppendChild(node, ast.NewRawTextSegment(segment))
+			}
+			block.Advance(i)
+			if !node.HasChildren() {
+				// $$ on its own is not a formula
+				block.SetPosition(l, pos)
+				return ast.NewTextSegment(startSegment.WithStop(startSegment.Start + opener))
+			}
+			return node
+		}
+		node.AppendChild(node, ast.NewRawTextSegment(segment))
+		block.AdvanceLine()
+	}
+}
+
+// mathBlockParser parses display math fenced by lines of two or more dollars
+type mathBlockParser struct{}
+
+func (p *mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (p *mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, _ := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || line[pos] != '$' {
+		return nil, parser.NoChildren
+	}
+	i := pos
+	for ; i < len(line) && line[i] == '$'; i++ {
+	}
+	if i-pos < 2 {
+		return nil, parser.NoChildren
+	}
+	// A fence line can carry meta text, but more dollars mean this is
+	// inline math such as $$x$$ instead
+	for j := i; j < len(line); j++ {
+		if line[j] == '$' {
+			return nil, parser.NoChildren
+		}
+	}
+	reader.AdvanceToEOL()
+	return &MathBlock{fenceIndent: pos, fenceLength: i - pos}, parser.NoChildren
+}
+
+func (p *mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	n := node.(*MathBlock)
+	line, segment := reader.PeekLine()
+
+	w, pos := util.IndentWidth(line, reader.LineOffset())
+	if w < 4 {
+		i := pos
+		for ; i < len(line) && line[i] == '$'; i++ {
+		}
+		if i-pos >= n.fenceLength && util.IsBlank(line[i:]) {
+			reader.AdvanceToEOL()
+			return parser.Close
+		}
+	}
+
+	pos, padding := util.IndentPositionPadding(line, reader.LineOffset(), segment.Padding, n.fenceIndent)
+	if pos < 0 {
+		pos = max(0, util.FirstNonSpacePosition(line)) - segment.Padding
+		padding = 0
+	}
+	seg := text.NewSegmentPadding(segment.Start+pos, segment.Stop, padding)
+	seg.ForceNewline = true
+	node.Lines().Append(seg)
+	reader.AdvanceToEOL()
+	return parser.Continue | parser.NoChildren
+}
+
+func (p *mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (p *mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (p *mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// mathRenderer writes math nodes with remark-math compatible markup
+type mathRenderer struct{}
+
+func (r *mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMathInline, r.renderMathInline)
+	reg.Register(KindMathBlock, r.renderMathBlock)
+}
+
+func (r *mathRenderer) renderMathInline(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		w.WriteString("</code>")
+		return ast.WalkContinue, nil
+	}
+	w.WriteString(`<code class="language-math math-inline">`)
+	for c := node.FirstChild(); c != nil; c = c.NextSibling() {
+		value := c.(*ast.Text).Segment.Value(source)
+		w.Write(util.EscapeHTML(value))
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *mathRenderer) renderMathBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	var tex []byte
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		tex = append(tex, line.Value(source)...)
+	}
+	// remark-math drops the newline before the closing fence
+	if n := len(tex); n > 0 && tex[n-1] == '\n' {
+		tex = tex[:n-1]
+	}
+
+	w.WriteString(`<pre><code class="language-math math-display">`)
+	w.Write(util.EscapeHTML(tex))
+	w.WriteString("</code></pre>\n")
+	return ast.WalkSkipChildren, nil
+}
+
+// mathExtension adds $...$ and $$ math to goldmark
+type mathExtension struct{}
+
+func (e *mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithInlineParsers(util.Prioritized(&mathInlineParser{}, 150)),
+		parser.WithBlockParsers(util.Prioritized(&mathBlockParser{}, 150)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(&mathRenderer{}, 150)))
+}
@@ -0,0 +1,104 @@
+package markdown
+
+import "testing"
+
+func TestRenderMath(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{
+			name:     "Inline",
+			markdown: "Energy is $E = mc^2$ here.",
+			want:     "<p>Energy is <code class=\"language-math math-inline\">E = mc^2</code> here.</p>\n",
+		},
+		{
+			name:     "Underscores are not emphasis",
+			markdown: "Index $a_i + b_j$ and _this_",
+			want:     "<p>Index <code class=\"language-math math-inline\">a_i + b_j</code> and <em>this</em></p>\n",
+		},
+		{
+			name:     "Asterisks are not emphasis",
+			markdown: "$a * b * c$",
+			want:     "<p><code class=\"language-math math-inline\">a * b * c</code></p>\n",
+		},
+		{
+			name:     "Double dollars inline",
+			markdown: "Sum $$\\sum_{i=0}^n i$$ inline",
+			want:     "<p>Sum <code class=\"language-math math-inline\">\\sum_{i=0}^n i</code> inline</p>\n",
+		},
+		{
+			name:     "HTML is escaped",
+			markdown: "$a<b>c$",
+			want:     "<p><code class=\"language-math math-inline\">a&lt;b&gt;c</code></p>\n",
+		},
+		{
+			name:     "Unclosed dollar",
+			markdown: "Costs $5 today",
+			want:     "<p>Costs $5 today</p>\n",
+		},
+		{
+			name:     "Prices",
+			markdown: "Between $5 and $10, or $ 3 $ each",
+			want:     "<p>Between $5 and $10, or $ 3 $ each</p>\n",
+		},
+		{
+			name:     "Prices before a formula",
+			markdown: "Costs $5 and $10 today, $x_1$ here.",
+			want:     "<p>Costs $5 and $10 today, <code class=\"language-math math-inline\">x_1</code> here.</p>\n",
+		},
+		{
+			name:     "Dollar sign before a formula",
+			markdown: "$x $y$",
+			want:     "<p>$x <code class=\"language-math math-inline\">y</code></p>\n",
+		},
+		{
+			name:     "Price after a formula",
+			markdown: "If $x$ costs $5",
+			want:     "<p>If <code class=\"language-math math-inline\">x</code> costs $5</p>\n",
+		},
+		{
+			name:     "Escaped dollar",
+			markdown: "From \\$5 to \\$10",
+			want:     "<p>From $5 to $10</p>\n",
+		},
+		{
+			name:     "Code span wins",
+			markdown: "`$x_1$`",
+			want:     "<p><code>$x_1$</code></p>\n",
+		},
+		{
+			name:     "Display",
+			markdown: "$$\nx = \\frac{-b \\pm \\sqrt{b^2-4ac}}{2a}\n\\text{where } a_1 * b_2\n$$\n",
+			want:     "<pre><code class=\"language-math math-display\">x = \\frac{-b \\pm \\sqrt{b^2-4ac}}{2a}\n\\text{where } a_1 * b_2</code></pre>\n",
+		},
+		{
+			name:     "Display interrupts paragraph",
+			markdown: "Given\n$$\ny_i\n$$\nthen",
+			want:     "<p>Given</p>\n<pre><code class=\"language-math math-display\">y_i</code></pre>\n<p>then</p>\n",
+		},
+		{
+			name:     "Display in list",
+			markdown: "- item\n\n  $$\n  a_b\n  $$\n",
+			want:     "<ul>\n<li>\n<p>item</p>\n<pre><code class=\"language-math math-display\">a_b</code></pre>\n</li>\n</ul>\n",
+		},
+	}
+
+	renderer := New(Options{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(renderer.renderHTML([]byte(tt.markdown)))
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStrictCommonMarkHasNoMath(t *testing.T) {
+	got := string(New(Options{StrictCommonMark: true}).convert([]byte("$*a* b$")))
+	if got != "<p>$<em>a</em> b$</p>\n" {
+		t.Errorf("unexpected strict output %q", got)
+	}
+}
@@ -0,0 +1,148 @@
+package markdown
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// wordsPerMinute is the reading speed used to estimate ReadingTime
+const wordsPerMinute = 200
+
+// TOCEntry is a heading in a page's table of contents
+type TOCEntry struct {
+	Level    int         `json:"level"`
+	Text     string      `json:"text"`
+	Slug     string      `json:"slug"`
+	Children []*TOCEntry `json:"children,omitempty"`
+}
+
+// Page is a fully rendered wiki page along with metadata about it
+type Page struct {
+	HTML        string      `json:"html"`
+	TOC         []*TOCEntry `json:"toc"`
+	Title       string      `json:"title"`
+	WordCount   int         `json:"wordCount"`
+	ReadingTime int         `json:"readingTime"` // minutes
+}
+
+// RenderPage renders a stored page with the default options
+func RenderPage(markdown []byte) *Page {
+	return defaultRenderer.RenderPage(markdown)
+}
+
+// RenderPage renders a stored page, giving every heading a GitHub-compatible
+// anchor ID and collecting the table of contents, title and word count
+func (r *Renderer) RenderPage(markdown []byte) *Page {
+	if r.cache == nil {
+		return r.renderPage(markdown)
+	}
+
+	key := hashKey([]byte("page"), r.optionsKey, markdown)
+	if cached, ok := r.cache.get(key); ok {
+		// Hand out a copy so callers can fill in fields such as the title
+		page := *cached.(*Page)
+		return &page
+	}
+	page := r.renderPage(markdown)
+	copied := *page
+	r.cache.put(key, &copied, int64(len(page.HTML)+len(key)))
+	return page
+}
+
+func (r *Renderer) renderPage(markdown []byte) *Page {
+	doc := r.md.Parser().Parse(text.NewReader(markdown))
+
+	page := &Page{TOC: []*TOCEntry{}}
+	slugger := NewSlugger()
+	var stack []*TOCEntry
+
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := node.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+
+		headingText := plainText(heading, markdown)
+		slug := slugger.Slug(headingText)
+		// Keep explicit {#id} attributes, otherwise use the generated slug
+		if id, ok := heading.AttributeString("id"); ok {
+			if b, ok := id.([]byte); ok {
+				slug = string(b)
+			}
+		} else {
+			heading.SetAttributeString("id", []byte(slug))
+		}
+		if page.Title == "" && heading.Level == 1 {
+			page.Title = headingText
+		}
+
+		entry := &TOCEntry{Level: heading.Level, Text: headingText, Slug: slug}
+		for len(stack) > 0 && stack[len(stack)-1].Level >= entry.Level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			page.TOC = append(page.TOC, entry)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, entry)
+		}
+		stack = append(stack, entry)
+		return ast.WalkSkipChildren, nil
+	})
+
+	var buf bytes.Buffer
+	if err := r.md.Renderer().Render(&buf, markdown, doc); err != nil {
+		buf.Reset()
+		buf.Write(r.convert(markdown))
+	}
+
+	page.HTML = r.policy.Sanitize(buf.String())
+	page.WordCount = len(strings.Fields(plainText(doc, markdown)))
+	page.ReadingTime = readingTime(page.WordCount)
+	return page
+}
+
+// plainText returns the text content of a node and its descendants, with
+// block boundaries and line breaks turned into spaces
+func plainText(node ast.Node, source []byte) string {
+	var b strings.Builder
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Text:
+			b.Write(n.Segment.Value(source))
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(n.Value)
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			b.WriteByte(' ')
+			lines := n.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				line := lines.At(i)
+				b.Write(line.Value(source))
+			}
+			return ast.WalkSkipChildren, nil
+		default:
+			if n.Type() == ast.TypeBlock {
+				b.WriteByte(' ')
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(b.String())
+}
+
+// readingTime estimates how many minutes it takes to read the given number of words
+func readingTime(words int) int {
+	if words == 0 {
+		return 0
+	}
+	return (words + wordsPerMinute - 1) / wordsPerMinute
+}
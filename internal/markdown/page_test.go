@@ -0,0 +1,91 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Hello World", "hello-world"},
+		{"What's new in v2.0?", "whats-new-in-v20"},
+		{"snake_case and kebab-case", "snake_case-and-kebab-case"},
+		{"  Trimmed  ", "trimmed"},
+		{"Ünïcödé Heading", "ünïcödé-heading"},
+		{"C++ & Go", "c--go"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := Slugify(tt.text); got != tt.want {
+				t.Errorf("Slugify(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSluggerDeduplicates(t *testing.T) {
+	s := NewSlugger()
+	got := []string{s.Slug("Usage"), s.Slug("Usage"), s.Slug("Usage"), s.Slug("Other")}
+	want := []string{"usage", "usage-1", "usage-2", "other"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("slug %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRenderPage(t *testing.T) {
+	source := "# Getting Started\n\nSome intro text here.\n\n## Install\n\nRun it.\n\n### From source\n\nBuild it.\n\n## Install\n\nAgain.\n"
+	page := RenderPage([]byte(source))
+
+	if page.Title != "Getting Started" {
+		t.Errorf("Title = %q, want %q", page.Title, "Getting Started")
+	}
+
+	for _, id := range []string{`id="getting-started"`, `id="install"`, `id="from-source"`, `id="install-1"`} {
+		if !strings.Contains(page.HTML, id) {
+			t.Errorf("HTML missing %s: %s", id, page.HTML)
+		}
+	}
+
+	if len(page.TOC) != 1 {
+		t.Fatalf("expected 1 top-level TOC entry, got %d", len(page.TOC))
+	}
+	root := page.TOC[0]
+	if root.Slug != "getting-started" || len(root.Children) != 2 {
+		t.Fatalf("unexpected TOC root: %+v", root)
+	}
+	if root.Children[0].Slug != "install" || len(root.Children[0].Children) != 1 {
+		t.Errorf("unexpected first section: %+v", root.Children[0])
+	}
+	if root.Children[0].Children[0].Slug != "from-source" {
+		t.Errorf("unexpected subsection: %+v", root.Children[0].Children[0])
+	}
+	if root.Children[1].Slug != "install-1" {
+		t.Errorf("unexpected second section: %+v", root.Children[1])
+	}
+
+	if page.WordCount != 15 {
+		t.Errorf("WordCount = %d, want 15", page.WordCount)
+	}
+	if page.ReadingTime != 1 {
+		t.Errorf("ReadingTime = %d, want 1", page.ReadingTime)
+	}
+}
+
+func TestRenderPageWithoutHeadings(t *testing.T) {
+	page := RenderPage([]byte(""))
+	if page.Title != "" {
+		t.Errorf("expected empty title, got %q", page.Title)
+	}
+	if page.TOC == nil || len(page.TOC) != 0 {
+		t.Errorf("expected empty TOC, got %v", page.TOC)
+	}
+	if page.ReadingTime != 0 {
+		t.Errorf("expected zero reading time, got %d", page.ReadingTime)
+	}
+}
@@ -0,0 +1,149 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Allowlist extends the built-in sanitizer policy with extra HTML that pages
+// may contain. Anything not allowed here or by the defaults is stripped, and
+// elements and attributes that can run script or load other pages are
+// stripped even when listed
+type Allowlist struct {
+	// Elements are extra tag names that are allowed without attributes
+	Elements []string `json:"elements,omitempty"`
+
+	// Attributes maps a tag name to extra attributes allowed on it; the
+	// key "*" allows the attributes on every element
+	Attributes map[string][]string `json:"attributes,omitempty"`
+
+	// URLSchemes are extra URL schemes allowed in links and images, on top
+	// of http, https and mailto. javascript, vbscript and data are never allowed
+	URLSchemes []string `json:"urlSchemes,omitempty"`
+}
+
+// blockedSchemes can't be enabled through an Allowlist because they run code
+var blockedSchemes = map[string]bool{
+	"javascript": true,
+	"vbscript":   true,
+	"data":       true,
+}
+
+// svgElements are the SVG elements needed for static drawings such as
+// diagrams. Scripting, foreignObject, animation and <use> are left out
+var svgElements = []string{
+	"svg", "g", "defs", "title", "desc", "symbol", "marker", "clipPath", "mask", "pattern",
+	"path", "rect", "circle", "ellipse", "line", "polyline", "polygon",
+	"text", "tspan", "textPath",
+	"linearGradient", "radialGradient", "stop",
+}
+
+// svgAttributes are the presentational SVG attributes allowed on svgElements.
+// Event handlers and href attributes are never allowed
+var svgAttributes = []string{
+	"viewBox", "width", "height", "x", "y", "x1", "y1", "x2", "y2", "cx", "cy", "r", "rx", "ry",
+	"d", "points", "transform", "fill", "fill-opacity", "fill-rule", "stroke", "stroke-width",
+	"stroke-opacity", "stroke-dasharray", "stroke-linecap", "stroke-linejoin", "opacity",
+	"font-family", "font-size", "font-weight", "font-style", "text-anchor", "dominant-baseline",
+	"dx", "dy", "markerWidth", "markerHeight", "refX", "refY", "orient", "markerUnits",
+	"marker-start", "marker-mid", "marker-end", "clip-path", "mask", "offset", "stop-color",
+	"stop-opacity", "gradientUnits", "gradientTransform", "patternUnits", "preserveAspectRatio",
+	"version", "role", "aria-label", "aria-hidden",
+}
+
+// safeSVGValue rejects attribute values that could reference script or
+// external resources, such as url(javascript:...) or url(//host/x). The only
+// functions allowed are transforms, colours and url() pointing at an element
+// of the same drawing
+var safeSVGValue = regexp.MustCompile(`^(?:[^:<>"'()\\]|\b(?:matrix|translate|scale|rotate|skewX|skewY|rgba?|hsla?)\([^:<>"'()\\]*\)|\burl\(#[\w-]+\))*$`)
+
+// blockedElements and blockedAttributes can't be enabled through an
+// Allowlist, since they can run script, load other pages or change where
+// links and forms go
+var (
+	blockedElements = map[string]bool{
+		"script": true,
+		"iframe": true,
+		"object": true,
+		"embed":  true,
+		"style":  true,
+		"base":   true,
+		"form":   true,
+	}
+	blockedAttributes = map[string]bool{
+		"srcdoc":     true,
+		"formaction": true,
+	}
+)
+
+// newPolicy builds the sanitizer policy for rendered markdown
+func newPolicy(allow Allowlist) *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+
+	// Links point at our own pages or are written by trusted editors
+	p.RequireNoFollowOnLinks(false)
+
+	// Classes carry syntax highlighting and other renderer markup
+	p.AllowStyling()
+
+	// Task list checkboxes
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^$`)).OnElements("input")
+
+	// Footnote references and backlinks
+	p.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-[a-z]+$`)).OnElements("a", "div", "section", "sup")
+
+	// Static SVG drawings
+	p.AllowElements(svgElements...)
+	p.AllowAttrs(svgAttributes...).Matching(safeSVGValue).OnElements(svgElements...)
+	p.AllowAttrs("xmlns").Matching(regexp.MustCompile(`^http://www\.w3\.org/2000/svg$`)).OnElements("svg")
+
+	p.AllowElements(withoutBlocked(allow.Elements, blockedElements)...)
+	for element, attrs := range allow.Attributes {
+		if blockedElements[strings.ToLower(element)] {
+			continue
+		}
+		attrs = withoutEventHandlers(withoutBlocked(attrs, blockedAttributes))
+		if len(attrs) == 0 {
+			continue
+		}
+		if element == "*" {
+			p.AllowAttrs(attrs...).Globally()
+			continue
+		}
+		p.AllowAttrs(attrs...).OnElements(element)
+	}
+	for _, scheme := range allow.URLSchemes {
+		// bluemonday compares schemes in lower case
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if scheme != "" && !blockedSchemes[scheme] {
+			p.AllowURLSchemes(scheme)
+		}
+	}
+
+	return p
+}
+
+// withoutEventHandlers drops on* attributes so an Allowlist can't enable script
+func withoutEventHandlers(attrs []string) []string {
+	var safe []string
+	for _, attr := range attrs {
+		if !strings.HasPrefix(strings.ToLower(attr), "on") {
+			safe = append(safe, attr)
+		}
+	}
+	return safe
+}
+
+// withoutBlocked drops the names in blocked, whatever their case
+func withoutBlocked(names []string, blocked map[string]bool) []string {
+	var safe []string
+	for _, name := range names {
+		if !blocked[strings.ToLower(name)] {
+			safe = append(safe, name)
+		}
+	}
+	return safe
+}
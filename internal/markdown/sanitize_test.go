@@ -0,0 +1,161 @@
+package markdown
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// dangerousOutput matches markup that must never survive sanitization
+var dangerousOutput = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)<script`),
+	regexp.MustCompile(`(?i)<iframe`),
+	regexp.MustCompile(`(?i)<object`),
+	regexp.MustCompile(`(?i)<embed`),
+	regexp.MustCompile(`(?i)<form`),
+	regexp.MustCompile(`(?i)<meta`),
+	regexp.MustCompile(`(?i)<base`),
+	regexp.MustCompile(`(?i)<link`),
+	regexp.MustCompile(`(?i)<style`),
+	regexp.MustCompile(`(?i)<foreignobject`),
+	regexp.MustCompile(`(?i)<use`),
+	regexp.MustCompile(`(?i)<animate`),
+	regexp.MustCompile(`(?i)<set`),
+	regexp.MustCompile(`(?i)<[^>]*\son[a-z]+\s*=`),
+	regexp.MustCompile(`(?i)<[^>]*\s(style|srcdoc|formaction)\s*=`),
+	regexp.MustCompile(`(?i)(href|src|data|action)\s*=\s*"\s*(javascript|vbscript|data):`),
+	regexp.MustCompile(`(?i)url\(\s*javascript:`),
+	regexp.MustCompile(`(?i)url\(\s*[^#\s]`),
+}
+
+// loadXSSCorpus reads the markdown documents in testdata/xss_corpus.txt
+func loadXSSCorpus(t *testing.T) []string {
+	data, err := os.ReadFile("testdata/xss_corpus.txt")
+	if err != nil {
+		t.Fatalf("Failed to read XSS corpus: %v", err)
+	}
+
+	var entries []string
+	for i, entry := range strings.Split(string(data), "\n----\n") {
+		// The first block is the comment header
+		if i == 0 {
+			continue
+		}
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func TestSanitizeXSSCorpus(t *testing.T) {
+	corpus := loadXSSCorpus(t)
+	if len(corpus) < 40 {
+		t.Fatalf("expected the full XSS corpus, got %d entries", len(corpus))
+	}
+
+	renderer := New(Options{})
+	for _, entry := range corpus {
+		for _, got := range []string{
+			string(renderer.renderHTML([]byte(entry))),
+			renderer.RenderPage([]byte(entry)).HTML,
+		} {
+			for _, pattern := range dangerousOutput {
+				if pattern.MatchString(got) {
+					t.Errorf("sanitized output matches %s\ninput:  %q\noutput: %q", pattern, entry, got)
+				}
+			}
+		}
+	}
+}
+
+func TestSanitizeKeepsSafeMarkup(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		contains string
+	}{
+		{"Heading anchors", "# Title", `<h1 id="title">`},
+		{"Highlighted code", "```go\nvar x = 1\n```", `<pre class="chroma"><code class="language-go">`},
+		{"Relative links", "[page](other/page.md)", `<a href="other/page.md">`},
+		{"Images", "![alt](img.png)", `<img src="img.png" alt="alt"/>`},
+		{"Details", "<details><summary>More</summary>Hidden</details>", `<details><summary>More</summary>Hidden</details>`},
+		{"SVG transforms and colours", `<svg><g transform="translate(4 112) scale(1 1)"><path d="M0 0L1 1" stroke="rgb(0, 0, 0)"/></g></svg>`, `<g transform="translate(4 112) scale(1 1)"><path d="M0 0L1 1" stroke="rgb(0, 0, 0)"/></g>`},
+		{"Static SVG", `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><rect x="1" y="1" width="8" height="8" fill="url(#grad)"/></svg>`, `<rect x="1" y="1" width="8" height="8" fill="url(#grad)"/>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RenderPage([]byte(tt.markdown)).HTML
+			if !strings.Contains(got, tt.contains) {
+				t.Errorf("expected %q in %q", tt.contains, got)
+			}
+		})
+	}
+}
+
+func TestSanitizeAllowlist(t *testing.T) {
+	source := []byte(`<kbd>Ctrl</kbd> <abbr data-term="x" onclick="alert(1)">API</abbr> <a href="ssh://host">ssh</a> <a href="javascript:alert(1)">js</a>`)
+
+	strict := string(New(Options{}).renderHTML(source))
+	if strings.Contains(strict, "data-term") || strings.Contains(strict, "ssh://") {
+		t.Errorf("default policy allowed configurable markup: %q", strict)
+	}
+
+	renderer := New(Options{Allowlist: Allowlist{
+		Elements:   []string{"kbd"},
+		Attributes: map[string][]string{"abbr": {"data-term", "onclick"}},
+		URLSchemes: []string{"ssh", "javascript"},
+	}})
+	got := string(renderer.renderHTML(source))
+
+	for _, want := range []string{`<kbd>Ctrl</kbd>`, `data-term="x"`, `href="ssh://host"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in %q", want, got)
+		}
+	}
+	for _, unwanted := range []string{"onclick", "javascript:"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("allowlist must not enable %q: %q", unwanted, got)
+		}
+	}
+}
+
+func TestSanitizeAllowlistCannotEnableBlocked(t *testing.T) {
+	source := []byte(`<iframe srcdoc="x"></iframe><object data="x"></object><embed src="x"><style>a{}</style><base href="//evil.example/"><form><button formaction="//evil.example/">Go</button></form><p onclick="alert(1)" title="t">p</p>`)
+	renderer := New(Options{Allowlist: Allowlist{
+		Elements: []string{"iframe", "OBJECT", "embed", "style", "base", "form", "button"},
+		Attributes: map[string][]string{
+			"*":      {"srcdoc", "FormAction", "onclick", "title"},
+			"iframe": {"src"},
+		},
+	}})
+	got := string(renderer.renderHTML(source))
+	for _, pattern := range dangerousOutput {
+		if pattern.MatchString(got) {
+			t.Errorf("allowlist enabled markup matching %s: %q", pattern, got)
+		}
+	}
+	for _, want := range []string{"<button>Go</button>", `title="t"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in %q", want, got)
+		}
+	}
+}
+
+func TestSanitizeAllowlistCannotEnableBlockedSchemes(t *testing.T) {
+	source := []byte(`<a href="javascript:alert(1)">a</a><a href="VBScript:msgbox(1)">b</a><a href="data:text/html,x">c</a><a href="ssh://host">d</a>`)
+	renderer := New(Options{Allowlist: Allowlist{
+		URLSchemes: []string{"JavaScript", " vbscript ", "DATA", "SSH"},
+	}})
+	got := string(renderer.renderHTML(source))
+	for _, blocked := range []string{"javascript:", "VBScript:", "data:"} {
+		if strings.Contains(got, blocked) {
+			t.Errorf("allowlist enabled %s links: %q", blocked, got)
+		}
+	}
+	if !strings.Contains(got, `href="ssh://host"`) {
+		t.Errorf("expected the ssh link in %q", got)
+	}
+}
@@ -0,0 +1,51 @@
+package markdown
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Slugify converts heading text into an anchor ID using the same rules as
+// GitHub: lowercase, drop punctuation and symbols, and turn spaces into dashes
+func Slugify(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(text)) {
+		switch {
+		case r == ' ':
+			b.WriteRune('-')
+		case r == '-' || r == '_':
+			b.WriteRune(r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Slugger hands out unique slugs for a single document, appending -1, -2, ...
+// to repeated headings the way GitHub does
+type Slugger struct {
+	seen map[string]int
+}
+
+// NewSlugger creates a slugger with no slugs issued yet
+func NewSlugger() *Slugger {
+	return &Slugger{seen: make(map[string]int)}
+}
+
+// Slug returns a unique slug for the given heading text
+func (s *Slugger) Slug(text string) string {
+	base := Slugify(text)
+	slug := base
+	for {
+		count, exists := s.seen[slug]
+		if !exists {
+			break
+		}
+		s.seen[slug] = count + 1
+		slug = base + "-" + strconv.Itoa(count+1)
+	}
+	s.seen[slug] = 0
+	return slug
+}